@@ -22,9 +22,14 @@ package main
 import (
 	"github.com/canonical/iot-identity/service/factory"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/canonical/iot-identity/config"
 	"github.com/canonical/iot-identity/service"
+	"github.com/canonical/iot-identity/service/auth"
+	"github.com/canonical/iot-identity/service/trust"
 	"github.com/canonical/iot-identity/web"
 )
 
@@ -37,7 +42,44 @@ func main() {
 		log.Fatalf("Error accessing data store: %v", settings.Driver)
 	}
 
-	srv := service.NewIdentityService(settings, db)
+	// Load the trusted assertions to verify device enrollment
+	trusted, err := trust.LoadDatabase(settings.TrustedDir)
+	if err != nil {
+		log.Fatalf("Error loading trusted assertions: %v", err)
+	}
+
+	srv := service.NewIdentityService(settings, db, trusted)
+
+	// Accept JWT bearer tokens from the identity provider for the admin API
+	if len(settings.JWKS) > 0 {
+		srv.Verifier, err = auth.LoadVerifier(settings.JWKS, settings.JWTIssuer, settings.JWTAudience)
+		if err != nil {
+			log.Fatalf("Error loading JWKS: %v", err)
+		}
+	}
+
+	// Deliver the changes to devices from the outbox to the MQTT broker and the webhooks, and
+	// reissue the broker details of devices
+	dispatcher, err := factory.CreateDispatcher(settings, db, service.ReissueSink{Identity: *srv})
+	if err != nil {
+		log.Fatalf("Error creating the outbox dispatcher: %v", err)
+	}
+
+	// Regenerate the certificate revocation lists on schedule
+	go srv.PublishCRLs()
+	go dispatcher.Run()
+	go factory.CreateWebhookDispatcher(settings, db).Run()
+
+	// Save the changes that the data store holds back when the service is stopped
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		if err := factory.FlushDataStore(db); err != nil {
+			log.Fatalf("Error saving the data store: %v", err)
+		}
+		os.Exit(0)
+	}()
 
 	// Start the web service
 	w := web.NewIdentityService(settings, srv)
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore/postgres"
+)
+
+// Shows or applies the schema migrations of the PostgreSQL data store. The service
+// applies the pending migrations when it starts, so `up` is only needed to migrate ahead
+func main() {
+	settings := config.ParseArgs()
+	if settings.Driver != "postgres" {
+		log.Fatalf("Schema migrations are only needed for the postgres driver")
+	}
+
+	db := postgres.OpenDatabase(settings.Driver, settings.DataSource)
+
+	switch flag.Arg(0) {
+	case "status":
+		status, err := db.MigrationStatus()
+		if err != nil {
+			log.Fatalf("Error retrieving the migration status: %v", err)
+		}
+		for _, m := range status {
+			applied := "pending"
+			if !m.Applied.IsZero() {
+				applied = m.Applied.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-19s  %s\n", m.Version, applied, m.Description)
+		}
+	case "up":
+		count, err := db.Migrate()
+		if err != nil {
+			log.Fatalf("Error migrating the database: %v", err)
+		}
+		fmt.Printf("Applied %d schema migrations\n", count)
+	default:
+		log.Fatalf("Usage: migrate -driver postgres -datasource <data source> status|up")
+	}
+}
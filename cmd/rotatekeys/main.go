@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore/encrypted"
+	"github.com/canonical/iot-identity/service/factory"
+)
+
+// Re-encrypts the stored private keys with the current encryption secret, after it has
+// been rotated. The previous secrets are read from the -oldsecrets file
+func main() {
+	var batchSize int
+	var pause time.Duration
+	flag.IntVar(&batchSize, "batch", 100, "Number of keys to update in each batch")
+	flag.DurationVar(&pause, "pause", time.Second, "Pause between batches, to limit the load on the data store")
+	settings := config.ParseArgs()
+
+	// Open the connection to the database
+	db, err := factory.CreateDataStore(settings)
+	if err != nil {
+		log.Fatalf("Error accessing data store: %v", err)
+	}
+	store, ok := db.(*encrypted.Store)
+	if !ok {
+		log.Fatalf("Error accessing data store: encryption is not enabled")
+	}
+
+	count, err := store.RewrapAll(batchSize, pause)
+	if err != nil {
+		log.Fatalf("Error re-encrypting keys: %v", err)
+	}
+	if err := factory.FlushDataStore(db); err != nil {
+		log.Fatalf("Error saving the data store: %v", err)
+	}
+	fmt.Printf("Re-encrypted %d private keys\n", count)
+}
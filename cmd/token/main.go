@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service"
+	"github.com/canonical/iot-identity/service/factory"
+)
+
+// Creates an API token for the admin API directly in the data store, e.g. for the first superuser
+func main() {
+	var name, role, orgID string
+	flag.StringVar(&name, "name", "admin", "Name of the API token")
+	flag.StringVar(&role, "role", string(domain.RoleSuperuser), "Role of the API token: superuser, org-admin or read-only")
+	flag.StringVar(&orgID, "orgid", "", "Organization ID of an org-admin or read-only token")
+	settings := config.ParseArgs()
+
+	// Open the connection to the database
+	db, err := factory.CreateDataStore(settings)
+	if err != nil {
+		log.Fatalf("Error accessing data store: %v", settings.Driver)
+	}
+
+	srv := service.NewIdentityService(settings, db, nil)
+	cli := &domain.Caller{Subject: "cli", Role: domain.RoleSuperuser}
+	req := &service.RegisterTokenRequest{Name: name, OrganizationID: orgID, Role: domain.Role(role)}
+
+	tokenID, token, err := srv.RegisterToken(cli, req)
+	if err != nil {
+		log.Fatalf("Error creating token: %v", err)
+	}
+	if err := factory.FlushDataStore(db); err != nil {
+		log.Fatalf("Error saving the data store: %v", err)
+	}
+	fmt.Printf("Created token %s\n%s\n", tokenID, token)
+}
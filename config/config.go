@@ -26,23 +26,31 @@ import (
 	"log"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/canonical/iot-identity/service/cert"
 )
 
 // Default settings
 const (
-	DefaultPort       = "8030"
-	DefaultDriver     = "memory"
-	DefaultDataSource = ""
-	DefaultMQTTURL    = "mqtt.example.com"
-	DefaultMQTTPort   = "8883"
-	DefaultConfigPath = "."
-	keyFilename       = ".secret"
-	DefaultCertsPath  = "certs"
+	DefaultPort         = "8030"
+	DefaultDriver       = "memory"
+	DefaultDataSource   = ""
+	DefaultMQTTURL      = "mqtt.example.com"
+	DefaultMQTTPort     = "8883"
+	DefaultConfigPath   = "."
+	keyFilename         = ".secret"
+	DefaultCertsPath    = "certs"
+	DefaultTrustedPath  = "trusted"
+	DefaultCRLInterval  = 24 * time.Hour
+	DefaultOCSPValidity = time.Hour
+	DefaultKeyType      = cert.DefaultKeyType
+	DefaultDynSecRole   = "device"
+	DefaultOutboxPoll   = 10 * time.Second
+	DefaultOutboxTries  = 10
 )
 
-var drivers = []string{"memory", "postgres"}
+var drivers = []string{"memory", "postgres", "sqlite"}
 
 // Settings defines the application configuration
 type Settings struct {
@@ -52,27 +60,76 @@ type Settings struct {
 	MQTTUrl      string
 	MQTTPort     string
 	KeySecret    string
+	OldSecrets   []string
 	RootCertsDir string
+	TrustedDir   string
+	CRLInterval  time.Duration
+	OCSPValidity time.Duration
+	TLSCert      string
+	TLSKey       string
+	KeyType      string
+	JWKS         string
+	JWTIssuer    string
+	JWTAudience  string
+	DynSecURL    string
+	DynSecRole   string
+	DynSecCA     string
+	OutboxPoll   time.Duration
+	OutboxTries  int
+
+	WebhookAllowPrivate bool
 }
 
 // ParseArgs checks the command line arguments
 func ParseArgs() *Settings {
 	var (
-		port       string
-		driver     string
-		datasource string
-		mqttURL    string
-		mqttPort   string
-		configDir  string
-		certsDir   string
+		port         string
+		driver       string
+		datasource   string
+		mqttURL      string
+		mqttPort     string
+		configDir    string
+		oldSecrets   string
+		certsDir     string
+		trustedDir   string
+		crlInterval  time.Duration
+		ocspValidity time.Duration
+		tlsCert      string
+		tlsKey       string
+		keyType      string
+		jwks         string
+		jwtIssuer    string
+		jwtAudience  string
+		dynsecURL    string
+		dynsecRole   string
+		dynsecCA     string
+		outboxPoll   time.Duration
+		outboxTries  int
+		allowPrivate bool
 	)
 	flag.StringVar(&port, "port", DefaultPort, "The port the service listens on")
-	flag.StringVar(&driver, "driver", DefaultDriver, "The data repository driver")
+	flag.StringVar(&driver, "driver", DefaultDriver, "The data repository driver: memory, postgres, sqlite")
 	flag.StringVar(&datasource, "datasource", DefaultDataSource, "The data repository data source")
 	flag.StringVar(&mqttURL, "mqtturl", DefaultMQTTURL, "URL of the MQTT broker")
 	flag.StringVar(&mqttPort, "mqttport", DefaultMQTTPort, "Port of the MQTT broker")
 	flag.StringVar(&configDir, "configdir", DefaultConfigPath, "Directory path to the config file")
+	flag.StringVar(&oldSecrets, "oldsecrets", "", "Path to a file of previous encryption secrets, one per line, to read keys during key rotation")
 	flag.StringVar(&certsDir, "certsdir", DefaultCertsPath, "Directory path to the root certificate files")
+	flag.StringVar(&trustedDir, "trusteddir", DefaultTrustedPath, "Directory path to the trusted account-key assertions")
+	flag.DurationVar(&crlInterval, "crlinterval", DefaultCRLInterval, "Interval between regenerating the certificate revocation lists")
+	flag.DurationVar(&ocspValidity, "ocspvalidity", DefaultOCSPValidity, "Validity period of OCSP responses")
+	flag.StringVar(&tlsCert, "tlscert", "", "Path to the TLS certificate of the service, to accept client certificates")
+	flag.StringVar(&tlsKey, "tlskey", "", "Path to the TLS private key of the service")
+	flag.StringVar(&keyType, "keytype", DefaultKeyType, "Key algorithm of issued certificates: "+strings.Join(cert.KeyTypes, ", "))
+	flag.StringVar(&jwks, "jwks", "", "Path to the JWKS of the identity provider, to accept JWT bearer tokens")
+	flag.StringVar(&jwtIssuer, "jwtissuer", "", "Expected issuer of JWT bearer tokens")
+	flag.StringVar(&jwtAudience, "jwtaudience", "", "Expected audience of JWT bearer tokens")
+	flag.StringVar(&dynsecURL, "dynsecurl", "", "URL of the MQTT broker's dynamic security plugin, with the admin credentials, to provision device clients")
+	flag.StringVar(&dynsecRole, "dynsecrole", DefaultDynSecRole, "Role of the dynamic security plugin that is granted to device clients")
+	flag.StringVar(&dynsecCA, "dynsecca", "", "Path to the CA certificates that verify the MQTT broker's TLS certificate")
+	flag.DurationVar(&outboxPoll, "outboxinterval", DefaultOutboxPoll, "Interval between deliveries of the outbox events to the MQTT broker and other sinks, and of the webhook deliveries")
+	flag.IntVar(&outboxTries, "outboxattempts", DefaultOutboxTries, "Number of attempts to deliver an outbox event or webhook delivery before it is dead-lettered")
+	flag.BoolVar(&allowPrivate, "webhookallowprivate", false, "Allow webhooks to private, loopback and link-local addresses, for receivers on the local network")
 	flag.Parse()
 
 	// Validate the driver
@@ -87,12 +144,21 @@ func ParseArgs() *Settings {
 		log.Fatalf("The database driver must be one of: %s", strings.Join(drivers, ", "))
 	}
 
+	// Validate the key algorithm
+	if !cert.ValidKeyType(keyType) {
+		log.Fatalf("The key type must be one of: %s", strings.Join(cert.KeyTypes, ", "))
+	}
+
 	// Get/set the encryption secret
 	p := path.Join(configDir, keyFilename)
 	secret, err := getSecret(p)
 	if err != nil {
 		log.Fatalf("Error generating encryption secret: %v", err)
 	}
+	previous, err := getOldSecrets(oldSecrets)
+	if err != nil {
+		log.Fatalf("Error reading previous encryption secrets: %v", err)
+	}
 
 	return &Settings{
 		Port:         port,
@@ -101,7 +167,24 @@ func ParseArgs() *Settings {
 		MQTTUrl:      mqttURL,
 		MQTTPort:     mqttPort,
 		KeySecret:    secret,
+		OldSecrets:   previous,
 		RootCertsDir: certsDir,
+		TrustedDir:   trustedDir,
+		CRLInterval:  crlInterval,
+		OCSPValidity: ocspValidity,
+		TLSCert:      tlsCert,
+		TLSKey:       tlsKey,
+		KeyType:      keyType,
+		JWKS:         jwks,
+		JWTIssuer:    jwtIssuer,
+		JWTAudience:  jwtAudience,
+		DynSecURL:    dynsecURL,
+		DynSecRole:   dynsecRole,
+		DynSecCA:     dynsecCA,
+		OutboxPoll:   outboxPoll,
+		OutboxTries:  outboxTries,
+
+		WebhookAllowPrivate: allowPrivate,
 	}
 }
 
@@ -121,3 +204,23 @@ func getSecret(p string) (string, error) {
 	err = ioutil.WriteFile(p, []byte(s), 0600)
 	return s, err
 }
+
+// getOldSecrets reads the previous encryption secrets, which are still needed to read
+// keys until they are encrypted with the current secret
+func getOldSecrets(p string) ([]string, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	source, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []string{}
+	for _, line := range strings.Split(string(source), "\n") {
+		if s := strings.TrimSpace(line); len(s) > 0 {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets, nil
+}
@@ -20,7 +20,9 @@
 package config
 
 import (
+	"io/ioutil"
 	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,7 +45,12 @@ func TestParseArgs(t *testing.T) {
 				assert.Equal(t, DefaultMQTTURL, got.MQTTUrl, tt.name)
 				assert.Equal(t, DefaultMQTTPort, got.MQTTPort, tt.name)
 				assert.Equal(t, DefaultCertsPath, got.RootCertsDir, tt.name)
+				assert.Equal(t, DefaultTrustedPath, got.TrustedDir, tt.name)
+				assert.Equal(t, DefaultCRLInterval, got.CRLInterval, tt.name)
+				assert.Equal(t, DefaultOCSPValidity, got.OCSPValidity, tt.name)
+				assert.Equal(t, DefaultKeyType, got.KeyType, tt.name)
 				assert.True(t, len(got.KeySecret) > 0, "secret not generated")
+				assert.Empty(t, got.OldSecrets, tt.name)
 
 				_ = os.Remove(keyFilename)
 			}
@@ -51,3 +58,34 @@ func TestParseArgs(t *testing.T) {
 	}
 
 }
+
+func TestGetOldSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("cannot create config directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "old-secrets")
+	_ = ioutil.WriteFile(p, []byte("first\n\n  second  \n"), 0600)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{"valid", p, []string{"first", "second"}, false},
+		{"none", "", nil, false},
+		{"invalid-path", "invalid", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getOldSecrets(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getOldSecrets() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got, tt.name)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// ACLColumn stores topic ACLs as JSON text in a column of the SQL data stores. It is
+// scanned into, and used as a query argument for, the ACLs that it points to
+type ACLColumn struct {
+	ACLs *[]domain.TopicACL
+}
+
+// Scan decodes the ACLs from the column. Empty text is no ACLs
+func (c ACLColumn) Scan(src interface{}) error {
+	var text []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		text = []byte(v)
+	case []byte:
+		text = v
+	default:
+		return fmt.Errorf("cannot scan topic ACLs from %T", src)
+	}
+
+	*c.ACLs = nil
+	if len(text) == 0 {
+		return nil
+	}
+	return json.Unmarshal(text, c.ACLs)
+}
+
+// Value encodes the ACLs for the column
+func (c ACLColumn) Value() (driver.Value, error) {
+	if c.ACLs == nil || len(*c.ACLs) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(*c.ACLs)
+	return string(data), err
+}
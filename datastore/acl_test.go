@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestACLColumn(t *testing.T) {
+	acls := []domain.TopicACL{
+		{Topic: "devices/abc/a111/#", Access: domain.AccessReadWrite},
+		{Topic: "broadcast/abc/#", Access: domain.AccessRead},
+	}
+	tests := []struct {
+		name      string
+		acls      []domain.TopicACL
+		wantValue string
+	}{
+		{"acls", acls, `[{"topic":"devices/abc/a111/#","access":"readwrite"},{"topic":"broadcast/abc/#","access":"read"}]`},
+		{"none", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := ACLColumn{ACLs: &tt.acls}.Value()
+			if err != nil || value != tt.wantValue {
+				t.Fatalf("ACLColumn.Value() = %v, %v, want %v", value, err, tt.wantValue)
+			}
+
+			// The column is read as text or bytes, depending on the driver
+			for _, src := range []interface{}{tt.wantValue, []byte(tt.wantValue)} {
+				got := []domain.TopicACL{{Topic: "stale"}}
+				if err := (ACLColumn{ACLs: &got}).Scan(src); err != nil {
+					t.Fatalf("ACLColumn.Scan() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.acls) {
+					t.Errorf("ACLColumn.Scan() = %v, want %v", got, tt.acls)
+				}
+			}
+		})
+	}
+}
+
+func TestACLColumn_ScanInvalid(t *testing.T) {
+	for _, src := range []interface{}{int64(1), "not json"} {
+		var got []domain.TopicACL
+		if err := (ACLColumn{ACLs: &got}).Scan(src); err == nil {
+			t.Errorf("ACLColumn.Scan(%v) expected error", src)
+		}
+	}
+}
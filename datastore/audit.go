@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// AuditQuery selects a page of the audit log, with the newest events first. Empty filters
+// match every event. The time range includes the start and excludes the end
+type AuditQuery struct {
+	OrganizationID string
+	DeviceID       string
+	Actor          string
+	Action         string
+	After          time.Time
+	Before         time.Time
+	Cursor         string // the next page cursor of the previous page
+	Limit          int
+}
+
+// AuditPage is a page of the audit log. The total is the number of events that match
+// the filters, and the cursor is empty on the last page
+type AuditPage struct {
+	Events     []domain.AuditEvent
+	NextCursor string
+	Total      int
+}
+
+// AuditCursor is the position of the last event of a page
+type AuditCursor struct {
+	Time    string `json:"t"`
+	EventID string `json:"id"`
+}
+
+// Normalize checks the query, setting the default page size
+func (q *AuditQuery) Normalize() error {
+	if q.Limit < 0 || q.Limit > MaxPageLimit {
+		return fmt.Errorf("the page limit must be between 1 and %d", MaxPageLimit)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageLimit
+	}
+
+	_, err := q.Position()
+	return err
+}
+
+// Position decodes the cursor of the query, returning nil for the first page
+func (q *AuditQuery) Position() (*AuditCursor, error) {
+	if len(q.Cursor) == 0 {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("the page cursor is invalid")
+	}
+	c := AuditCursor{}
+	if err := json.Unmarshal(data, &c); err != nil || len(c.EventID) == 0 {
+		return nil, fmt.Errorf("the page cursor is invalid")
+	}
+	if _, err := c.Timestamp(); err != nil {
+		return nil, fmt.Errorf("the page cursor is invalid")
+	}
+	return &c, nil
+}
+
+// Timestamp returns the time of the event at the cursor
+func (c *AuditCursor) Timestamp() (time.Time, error) {
+	return time.Parse(cursorTimeFormat, c.Time)
+}
+
+// NextCursor returns the cursor for the page that follows the event
+func (q *AuditQuery) NextCursor(event domain.AuditEvent) string {
+	data, _ := json.Marshal(AuditCursor{Time: event.Time.UTC().Format(cursorTimeFormat), EventID: event.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Matches checks whether an event matches the filters of the query
+func (q *AuditQuery) Matches(event domain.AuditEvent) bool {
+	switch {
+	case len(q.OrganizationID) > 0 && event.OrganizationID != q.OrganizationID:
+		return false
+	case len(q.DeviceID) > 0 && event.DeviceID != q.DeviceID:
+		return false
+	case len(q.Actor) > 0 && event.Actor != q.Actor:
+		return false
+	case len(q.Action) > 0 && event.Action != q.Action:
+		return false
+	case !q.After.IsZero() && event.Time.Before(q.After):
+		return false
+	case !q.Before.IsZero() && !event.Time.Before(q.Before):
+		return false
+	}
+	return true
+}
+
+// Newer checks whether an event comes before another in the audit log, which lists the newest first
+func (q *AuditQuery) Newer(a, b domain.AuditEvent) bool {
+	if a.Time.Equal(b.Time) {
+		return a.ID > b.ID
+	}
+	return a.Time.After(b.Time)
+}
+
+// IsAfter checks whether an event comes after the cursor in the audit log
+func (q *AuditQuery) IsAfter(event domain.AuditEvent, c *AuditCursor) bool {
+	t, _ := c.Timestamp()
+	return q.Newer(domain.AuditEvent{ID: c.EventID, Time: t}, event)
+}
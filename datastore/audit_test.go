@@ -0,0 +1,108 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestAuditQuery_Normalize(t *testing.T) {
+	cursor := (&AuditQuery{}).NextCursor(domain.AuditEvent{ID: "e1", Time: time.Now()})
+
+	tests := []struct {
+		name      string
+		query     AuditQuery
+		wantLimit int
+		wantErr   bool
+	}{
+		{"defaults", AuditQuery{}, DefaultPageLimit, false},
+		{"valid", AuditQuery{Limit: 10}, 10, false},
+		{"cursor", AuditQuery{Cursor: cursor}, DefaultPageLimit, false},
+		{"invalid-limit", AuditQuery{Limit: MaxPageLimit + 1}, 0, true},
+		{"negative-limit", AuditQuery{Limit: -1}, 0, true},
+		{"invalid-cursor", AuditQuery{Cursor: "invalid"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.Normalize()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AuditQuery.Normalize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && tt.query.Limit != tt.wantLimit {
+				t.Errorf("AuditQuery.Normalize() = %v, want %v", tt.query.Limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestAuditQuery_Matches(t *testing.T) {
+	at := time.Date(2020, 3, 4, 5, 6, 7, 8, time.UTC)
+	event := domain.AuditEvent{ID: "e1", Time: at, Actor: "admin", Action: domain.ActionDeviceUpdate, OrganizationID: "abc", DeviceID: "a111"}
+
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  bool
+	}{
+		{"all", AuditQuery{}, true},
+		{"filters", AuditQuery{OrganizationID: "abc", DeviceID: "a111", Actor: "admin", Action: domain.ActionDeviceUpdate}, true},
+		{"other-org", AuditQuery{OrganizationID: "def"}, false},
+		{"other-device", AuditQuery{DeviceID: "b222"}, false},
+		{"other-actor", AuditQuery{Actor: "device"}, false},
+		{"other-action", AuditQuery{Action: domain.ActionDeviceDelete}, false},
+		{"after-start", AuditQuery{After: at}, true},
+		{"before-start", AuditQuery{After: at.Add(time.Nanosecond)}, false},
+		{"before-end", AuditQuery{Before: at.Add(time.Nanosecond)}, true},
+		{"at-end", AuditQuery{Before: at}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.Matches(event); got != tt.want {
+				t.Errorf("AuditQuery.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditQuery_Cursor(t *testing.T) {
+	at := time.Date(2020, 3, 4, 5, 6, 7, 8, time.UTC)
+	older := domain.AuditEvent{ID: "e1", Time: at}
+	newer := domain.AuditEvent{ID: "e2", Time: at.Add(time.Nanosecond)}
+	tied := domain.AuditEvent{ID: "e0", Time: at}
+
+	q := AuditQuery{Cursor: (&AuditQuery{}).NextCursor(newer)}
+	c, err := q.Position()
+	if err != nil {
+		t.Fatalf("AuditQuery.Position() error = %v", err)
+	}
+	if !q.IsAfter(older, c) || !q.IsAfter(tied, c) || q.IsAfter(newer, c) {
+		t.Error("AuditQuery.IsAfter() = unexpected order after the newer event")
+	}
+
+	q = AuditQuery{Cursor: (&AuditQuery{}).NextCursor(older)}
+	c, _ = q.Position()
+	if !q.IsAfter(tied, c) || q.IsAfter(newer, c) || q.IsAfter(older, c) {
+		t.Error("AuditQuery.IsAfter() = unexpected order after the older event")
+	}
+}
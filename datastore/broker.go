@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// BrokerColumn stores the MQTT broker of an organization as JSON text in a column of the
+// SQL data stores. It is scanned into, and used as a query argument for, the broker that
+// it points to
+type BrokerColumn struct {
+	Broker *domain.Broker
+}
+
+// Scan decodes the broker from the column. Empty text is the broker of the service
+func (c BrokerColumn) Scan(src interface{}) error {
+	var text []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		text = []byte(v)
+	case []byte:
+		text = v
+	default:
+		return fmt.Errorf("cannot scan an MQTT broker from %T", src)
+	}
+
+	*c.Broker = domain.Broker{}
+	if len(text) == 0 {
+		return nil
+	}
+	return json.Unmarshal(text, c.Broker)
+}
+
+// Value encodes the broker for the column
+func (c BrokerColumn) Value() (driver.Value, error) {
+	if c.Broker == nil || isDefaultBroker(*c.Broker) {
+		return "", nil
+	}
+	data, err := json.Marshal(*c.Broker)
+	return string(data), err
+}
+
+func isDefaultBroker(b domain.Broker) bool {
+	return len(b.Host) == 0 && len(b.Port) == 0 && len(b.Protocol) == 0 && len(b.CABundle) == 0 && len(b.ClientIDFormat) == 0
+}
@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestBrokerColumn(t *testing.T) {
+	broker := domain.Broker{Host: "mqtt.abc.example.com", Port: "443", Protocol: domain.ProtocolWSS, CABundle: []byte("ca"), ClientIDFormat: "{orgid}-{deviceid}"}
+	tests := []struct {
+		name      string
+		broker    domain.Broker
+		wantValue string
+	}{
+		{"broker", broker, `{"host":"mqtt.abc.example.com","port":"443","protocol":"wss","caBundle":"Y2E=","clientIdFormat":"{orgid}-{deviceid}"}`},
+		{"default", domain.Broker{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := BrokerColumn{Broker: &tt.broker}.Value()
+			if err != nil || value != tt.wantValue {
+				t.Fatalf("BrokerColumn.Value() = %v, %v, want %v", value, err, tt.wantValue)
+			}
+
+			// The column is read as text or bytes, depending on the driver
+			for _, src := range []interface{}{tt.wantValue, []byte(tt.wantValue)} {
+				got := domain.Broker{Host: "stale"}
+				if err := (BrokerColumn{Broker: &got}).Scan(src); err != nil {
+					t.Fatalf("BrokerColumn.Scan() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.broker) {
+					t.Errorf("BrokerColumn.Scan() = %v, want %v", got, tt.broker)
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerColumn_ScanInvalid(t *testing.T) {
+	for _, src := range []interface{}{int64(1), "not json"} {
+		var got domain.Broker
+		if err := (BrokerColumn{Broker: &got}).Scan(src); err == nil {
+			t.Errorf("BrokerColumn.Scan(%v) expected error", src)
+		}
+	}
+}
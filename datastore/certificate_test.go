@@ -0,0 +1,49 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestCertificateSerial(t *testing.T) {
+	certPEM, err := ioutil.ReadFile("test_data/ca.crt")
+	if err != nil {
+		t.Fatalf("cannot read certificate: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		certPEM []byte
+		empty   bool
+	}{
+		{"valid", certPEM, false},
+		{"none", nil, true},
+		{"invalid", []byte("-----BEGIN CERTIFICATE-----\nMIICYzCCAUsCAQAwHjEcMBoGA1UECgwT\n-----END CERTIFICATE-----\n"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CertificateSerial(tt.certPEM); (len(got) == 0) != tt.empty {
+				t.Errorf("CertificateSerial() = %v, want empty %v", got, tt.empty)
+			}
+		})
+	}
+}
@@ -20,31 +20,91 @@
 package datastore
 
 import (
+	"errors"
+	"time"
+
 	"github.com/canonical/iot-identity/domain"
 	"github.com/segmentio/ksuid"
 )
 
+// ErrNotFound is returned when a record does not exist, or does not belong to the organization
+var ErrNotFound = errors.New("the record cannot be found")
+
+// ErrLeaseLost is returned when a claimed record is updated after its lease has ended and
+// it has been claimed again, or updated, by another caller
+var ErrLeaseLost = errors.New("the claim of the record has been lost")
+
 // DataStore is the interfaces for the data repository
 type DataStore interface {
 	OrganizationNew(organization OrganizationNewRequest) (string, error)
 	OrganizationGet(id string) (*domain.Organization, error)
 	OrganizationGetByName(name string) (*domain.Organization, error)
 	OrganizationList() ([]domain.Organization, error)
+	OrganizationUpdateKey(orgID string, rootKey []byte) error
+	OrganizationUpdateBroker(orgID string, broker domain.Broker) error
+	OrganizationDelete(orgID string) error
 
 	DeviceNew(device DeviceNewRequest) (string, error)
 	DeviceGet(brand, model, serial string) (*domain.Enrollment, error)
 	DeviceGetByID(deviceID string) (*domain.Enrollment, error)
+	DeviceGetByOrgID(orgID, deviceID string) (*domain.Enrollment, error)
+	DeviceGetByCertificate(serialNumber string) (*domain.Enrollment, error)
 	DeviceEnroll(device DeviceEnrollRequest) (*domain.Enrollment, error)
 	DeviceList(orgID string) ([]domain.Enrollment, error)
+	DeviceListPage(query DeviceQuery) (*DevicePage, error)
 	DeviceUpdate(deviceID string, status domain.Status, deviceData string) error
+	DeviceRenew(deviceID string, certificate, privateKey []byte) error
+	DeviceUpdateKey(deviceID string, current, privateKey []byte) error
+	DeviceUpdateBroker(deviceID string, mqtt domain.Credentials) error
+	DeviceDelete(tombstone domain.Tombstone) error
+	DeviceHistory(deviceID string) ([]domain.StatusChange, error)
+
+	TombstoneGet(brand, model, serial string) (*domain.Tombstone, error)
+
+	NonceNew(nonce domain.Nonce) error
+	NonceUse(value, brand, model, serial string) (*domain.Nonce, error)
+
+	RevocationNew(revocation domain.Revocation) error
+	RevocationList(orgID string) ([]domain.Revocation, error)
+	RevocationGet(orgID, serialNumber string) (*domain.Revocation, error)
+
+	TokenNew(token domain.Token) (string, error)
+	TokenGetByHash(hash string) (*domain.Token, error)
+
+	AuditNew(event domain.AuditEvent) error
+	AuditList(query AuditQuery) (*AuditPage, error)
+
+	OutboxNew(eventType domain.EventType, deviceID string) error
+	OutboxList(query OutboxQuery) ([]domain.OutboxEvent, error)
+	OutboxGet(eventID string) (*domain.OutboxEvent, error)
+	OutboxUpdate(event domain.OutboxEvent) error
+	OutboxClaim(now time.Time, lease time.Duration, limit int) ([]domain.OutboxEvent, error)
+	OutboxPrune(before time.Time) (int, error)
+
+	WebhookNew(webhook domain.Webhook) (string, error)
+	WebhookGet(orgID, webhookID string) (*domain.Webhook, error)
+	WebhookList(orgID string) ([]domain.Webhook, error)
+	WebhookUpdateSecret(orgID, webhookID string, secret []byte) error
+	WebhookDelete(orgID, webhookID string) error
+
+	WebhookDeliveryNew(delivery domain.WebhookDelivery) error
+	WebhookDeliveryList(query WebhookDeliveryQuery) ([]domain.WebhookDelivery, error)
+	WebhookDeliveryUpdate(delivery domain.WebhookDelivery) error
+	WebhookDeliveryClaim(now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error)
+	WebhookDeliveryPrune(before time.Time) (int, error)
 }
 
 // OrganizationNewRequest is the request to create a new organization
 type OrganizationNewRequest struct {
-	Name        string
-	CountryName string
-	ServerKey   []byte
-	ServerCert  []byte
+	Name             string
+	CountryName      string
+	ServerKey        []byte
+	ServerCert       []byte
+	KeyMode          domain.KeyMode
+	CertValidityDays int
+	KeyType          string
+	ACLTemplates     []domain.TopicACL
+	Broker           domain.Broker
 }
 
 // DeviceNewRequest is the request to create a new device
@@ -66,6 +126,10 @@ type DeviceEnrollRequest struct {
 	SerialNumber string
 	DeviceKey    string
 	StoreID      string
+	Certificate  []byte // replaces the registered certificate, if provided
+	PrivateKey   []byte // the key for the replacement certificate, unless it was signed from a certificate request
+	ACLs         []domain.TopicACL
+	MQTT         domain.Credentials // the broker details from the organization, which replace the registered details if provided
 }
 
 // GenerateID generates a unique ID
@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package encrypted protects the private keys in a data store with envelope encryption
+package encrypted
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// prefix marks an encrypted value, so plaintext values from before encryption can still be read
+const prefix = "enc:v1:"
+
+// keySize is the size of the AES-256 data and key-encryption keys
+const keySize = 32
+
+// kdfInfo separates the key-encryption key from other uses of the secret
+const kdfInfo = "iot-identity private key encryption"
+
+// Keyring holds the key-encryption keys, derived from the service's key secrets.
+// Values are encrypted with the current key and can be decrypted with any key
+type Keyring struct {
+	id   string
+	keks map[string][]byte
+}
+
+// NewKeyring derives the current key-encryption key from the secret, and the keys
+// from previous secrets that may still be in use
+func NewKeyring(secret string, previous ...string) (*Keyring, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("the key secret must be provided")
+	}
+
+	k := &Keyring{keks: map[string][]byte{}}
+	for i, s := range append([]string{secret}, previous...) {
+		id, kek, err := deriveKey(s)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.id = id
+		}
+		k.keks[id] = kek
+	}
+	return k, nil
+}
+
+// deriveKey derives a key-encryption key and its ID from a secret
+func deriveKey(secret string) (string, []byte, error) {
+	kek := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(kdfInfo)), kek); err != nil {
+		return "", nil, fmt.Errorf("cannot derive key: %v", err)
+	}
+
+	// The key ID identifies the key without revealing it
+	sum := sha256.Sum256(kek)
+	return hex.EncodeToString(sum[:8]), kek, nil
+}
+
+// KeyID returns the ID of the key that an encrypted value was encrypted with
+func KeyID(data []byte) string {
+	if !IsEncrypted(data) {
+		return ""
+	}
+	parts := strings.SplitN(string(data[len(prefix):]), ":", 2)
+	return parts[0]
+}
+
+// IsCurrent checks whether a value is encrypted with the current key
+func (k *Keyring) IsCurrent(data []byte) bool {
+	return KeyID(data) == k.id
+}
+
+// IsEncrypted checks whether a stored value is encrypted
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(prefix))
+}
+
+// Encrypt seals the data with a new data key, which is wrapped by the key-encryption key.
+// The result is `enc:v1:<key ID>:<wrapped data key>:<ciphertext>`, base64-encoded
+func (k *Keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	if len(plaintext) == 0 || IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, fmt.Errorf("cannot generate data key: %v", err)
+	}
+	wrapped, err := seal(k.keks[k.id], dek, []byte(k.id))
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dek, plaintext, []byte(k.id))
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := prefix + strings.Join([]string{
+		k.id,
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(ciphertext),
+	}, ":")
+	return []byte(encoded), nil
+}
+
+// Decrypt opens an encrypted value. Plaintext values are returned unchanged
+func (k *Keyring) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+
+	parts := strings.Split(string(data[len(prefix):]), ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("the encrypted value is malformed")
+	}
+	kek, ok := k.keks[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("the value is encrypted with an unknown key `%s`", parts[0])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("the encrypted value is malformed: %v", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("the encrypted value is malformed: %v", err)
+	}
+
+	dek, err := open(kek, wrapped, []byte(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap data key: %v", err)
+	}
+	plaintext, err := open(dek, ciphertext, []byte(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt value: %v", err)
+	}
+	return plaintext, nil
+}
+
+// Rewrap encrypts a value with the current key, if it is plaintext or encrypted with a previous key
+func (k *Keyring) Rewrap(data []byte) ([]byte, error) {
+	if len(data) == 0 || k.IsCurrent(data) {
+		return data, nil
+	}
+	plaintext, err := k.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(plaintext)
+}
+
+// seal encrypts with AES-GCM, prepending the random nonce
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open decrypts the output of seal
+func open(key, sealed, additional []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("the ciphertext is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,188 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package encrypted
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+)
+
+// storedKey is a private key as it is stored, for an organization or a device, or the
+// secret of a webhook
+type storedKey struct {
+	orgID     string
+	deviceID  string
+	webhookID string
+}
+
+// EncryptAll encrypts the private keys that were stored before encryption was enabled.
+// Keys that are already encrypted are skipped, so it is safe to run more than once.
+// It returns the number of keys that were encrypted
+func (s *Store) EncryptAll() (int, error) {
+	return s.updateKeys(func(key []byte) bool { return !IsEncrypted(key) }, 0, 0)
+}
+
+// RewrapAll encrypts the private keys with the current key-encryption key, when they are
+// plaintext or encrypted with a previous key. The keys are updated in batches, pausing
+// between batches to limit the load on the data store. It returns the number of keys
+// that were updated
+func (s *Store) RewrapAll(batchSize int, pause time.Duration) (int, error) {
+	return s.updateKeys(func(key []byte) bool { return !s.keys.IsCurrent(key) }, batchSize, pause)
+}
+
+// updateKeys re-encrypts the stored private keys that need it
+func (s *Store) updateKeys(needed func(key []byte) bool, batchSize int, pause time.Duration) (int, error) {
+	keys, err := s.findKeys(needed)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i, k := range keys {
+		if batchSize > 0 && i > 0 && i%batchSize == 0 {
+			log.Printf("Updated %d of %d private keys\n", count, len(keys))
+			time.Sleep(pause)
+		}
+
+		updated, err := s.updateKey(k, needed)
+		if err != nil {
+			return count, err
+		}
+		if updated {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// findKeys lists the organizations and devices with stored private keys that need updating.
+// It reads from the wrapped store, to see the stored values
+func (s *Store) findKeys(needed func(key []byte) bool) ([]storedKey, error) {
+	orgs, err := s.DataStore.OrganizationList()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list organizations: %v", err)
+	}
+
+	keys := []storedKey{}
+	for _, o := range orgs {
+		org, err := s.DataStore.OrganizationGet(o.ID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get organization `%s`: %v", o.ID, err)
+		}
+		if len(org.RootKey) > 0 && needed(org.RootKey) {
+			keys = append(keys, storedKey{orgID: org.ID})
+		}
+
+		devices, err := s.DataStore.DeviceList(org.ID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list devices of organization `%s`: %v", org.ID, err)
+		}
+		for _, d := range devices {
+			device, err := s.DataStore.DeviceGetByID(d.ID)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get device `%s`: %v", d.ID, err)
+			}
+			if len(device.Credentials.PrivateKey) > 0 && needed(device.Credentials.PrivateKey) {
+				keys = append(keys, storedKey{deviceID: device.ID})
+			}
+		}
+
+		webhooks, err := s.DataStore.WebhookList(org.ID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list webhooks of organization `%s`: %v", org.ID, err)
+		}
+		for _, w := range webhooks {
+			if len(w.Secret) > 0 && needed(w.Secret) {
+				keys = append(keys, storedKey{orgID: org.ID, webhookID: w.ID})
+			}
+		}
+	}
+	return keys, nil
+}
+
+// updateKey re-encrypts one stored private key, reading it again in case it has changed
+func (s *Store) updateKey(k storedKey, needed func(key []byte) bool) (bool, error) {
+	if len(k.webhookID) > 0 {
+		return s.updateSecret(k, needed)
+	}
+	if len(k.deviceID) == 0 {
+		org, err := s.DataStore.OrganizationGet(k.orgID)
+		if err != nil {
+			return false, fmt.Errorf("cannot get organization `%s`: %v", k.orgID, err)
+		}
+		if !needed(org.RootKey) {
+			return false, nil
+		}
+		key, err := s.keys.Rewrap(org.RootKey)
+		if err != nil {
+			return false, fmt.Errorf("cannot encrypt key of organization `%s`: %v", k.orgID, err)
+		}
+		if err := s.DataStore.OrganizationUpdateKey(k.orgID, key); err != nil {
+			return false, fmt.Errorf("cannot update key of organization `%s`: %v", k.orgID, err)
+		}
+		return true, nil
+	}
+
+	device, err := s.DataStore.DeviceGetByID(k.deviceID)
+	if err != nil {
+		return false, fmt.Errorf("cannot get device `%s`: %v", k.deviceID, err)
+	}
+	current := device.Credentials.PrivateKey
+	if !needed(current) {
+		return false, nil
+	}
+	key, err := s.keys.Rewrap(current)
+	if err != nil {
+		return false, fmt.Errorf("cannot encrypt key of device `%s`: %v", k.deviceID, err)
+	}
+
+	// A device that renewed its certificate meanwhile already has a key with the current encryption
+	err = s.DataStore.DeviceUpdateKey(k.deviceID, current, key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot update key of device `%s`: %v", k.deviceID, err)
+	}
+	return true, nil
+}
+
+// updateSecret re-encrypts the stored secret of a webhook, reading it again in case it has changed
+func (s *Store) updateSecret(k storedKey, needed func(key []byte) bool) (bool, error) {
+	webhook, err := s.DataStore.WebhookGet(k.orgID, k.webhookID)
+	if err != nil {
+		return false, fmt.Errorf("cannot get webhook `%s`: %v", k.webhookID, err)
+	}
+	if !needed(webhook.Secret) {
+		return false, nil
+	}
+	secret, err := s.keys.Rewrap(webhook.Secret)
+	if err != nil {
+		return false, fmt.Errorf("cannot encrypt secret of webhook `%s`: %v", k.webhookID, err)
+	}
+	if err := s.DataStore.WebhookUpdateSecret(k.orgID, k.webhookID, secret); err != nil {
+		return false, fmt.Errorf("cannot update secret of webhook `%s`: %v", k.webhookID, err)
+	}
+	return true, nil
+}
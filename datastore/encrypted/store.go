@@ -0,0 +1,224 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package encrypted
+
+import (
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// Store wraps a data store, encrypting the private keys of organizations and devices, and
+// the secrets of webhooks, when they are written and decrypting them when they are read
+type Store struct {
+	datastore.DataStore
+	keys *Keyring
+}
+
+// NewStore wraps a data store, with a key-encryption key derived from the secret.
+// Keys encrypted with a previous secret can still be read
+func NewStore(db datastore.DataStore, secret string, previous ...string) (*Store, error) {
+	keys, err := NewKeyring(secret, previous...)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{DataStore: db, keys: keys}, nil
+}
+
+// OrganizationNew creates a new organization with an encrypted CA key
+func (s *Store) OrganizationNew(organization datastore.OrganizationNewRequest) (string, error) {
+	key, err := s.keys.Encrypt(organization.ServerKey)
+	if err != nil {
+		return "", err
+	}
+	organization.ServerKey = key
+	return s.DataStore.OrganizationNew(organization)
+}
+
+// OrganizationGet fetches an organization by ID
+func (s *Store) OrganizationGet(id string) (*domain.Organization, error) {
+	org, err := s.DataStore.OrganizationGet(id)
+	if err != nil {
+		return org, err
+	}
+	return org, s.decryptOrganization(org)
+}
+
+// OrganizationGetByName fetches an organization by name
+func (s *Store) OrganizationGetByName(name string) (*domain.Organization, error) {
+	org, err := s.DataStore.OrganizationGetByName(name)
+	if err != nil {
+		return org, err
+	}
+	return org, s.decryptOrganization(org)
+}
+
+// OrganizationUpdateKey replaces the CA key of an organization with an encrypted key
+func (s *Store) OrganizationUpdateKey(orgID string, rootKey []byte) error {
+	key, err := s.keys.Encrypt(rootKey)
+	if err != nil {
+		return err
+	}
+	return s.DataStore.OrganizationUpdateKey(orgID, key)
+}
+
+// DeviceNew creates a new device registration with an encrypted private key
+func (s *Store) DeviceNew(device datastore.DeviceNewRequest) (string, error) {
+	key, err := s.keys.Encrypt(device.Credentials.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	device.Credentials.PrivateKey = key
+	return s.DataStore.DeviceNew(device)
+}
+
+// DeviceGet fetches a device registration
+func (s *Store) DeviceGet(brand, model, serial string) (*domain.Enrollment, error) {
+	return s.decryptDevice(s.DataStore.DeviceGet(brand, model, serial))
+}
+
+// DeviceGetByID fetches a device registration by its ID
+func (s *Store) DeviceGetByID(deviceID string) (*domain.Enrollment, error) {
+	return s.decryptDevice(s.DataStore.DeviceGetByID(deviceID))
+}
+
+// DeviceGetByCertificate fetches the device with the certificate serial number
+func (s *Store) DeviceGetByCertificate(serialNumber string) (*domain.Enrollment, error) {
+	return s.decryptDevice(s.DataStore.DeviceGetByCertificate(serialNumber))
+}
+
+// DeviceGetByOrgID fetches a device registration by its ID, if it belongs to the organization
+func (s *Store) DeviceGetByOrgID(orgID, deviceID string) (*domain.Enrollment, error) {
+	return s.decryptDevice(s.DataStore.DeviceGetByOrgID(orgID, deviceID))
+}
+
+// DeviceEnroll enrolls a device, encrypting a replacement private key
+func (s *Store) DeviceEnroll(device datastore.DeviceEnrollRequest) (*domain.Enrollment, error) {
+	key, err := s.keys.Encrypt(device.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	device.PrivateKey = key
+	return s.decryptDevice(s.DataStore.DeviceEnroll(device))
+}
+
+// DeviceList fetches the device registrations of an organization
+func (s *Store) DeviceList(orgID string) ([]domain.Enrollment, error) {
+	devices, err := s.DataStore.DeviceList(orgID)
+	if err != nil {
+		return devices, err
+	}
+	for i := range devices {
+		if _, err := s.decryptDevice(&devices[i], nil); err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
+// DeviceUpdateKey replaces the private key of a device with an encrypted key, if the
+// stored key has not changed
+func (s *Store) DeviceUpdateKey(deviceID string, current, privateKey []byte) error {
+	key, err := s.keys.Encrypt(privateKey)
+	if err != nil {
+		return err
+	}
+	return s.DataStore.DeviceUpdateKey(deviceID, current, key)
+}
+
+// DeviceRenew replaces the certificate and private key of a device, encrypting the key
+func (s *Store) DeviceRenew(deviceID string, certificate, privateKey []byte) error {
+	key, err := s.keys.Encrypt(privateKey)
+	if err != nil {
+		return err
+	}
+	return s.DataStore.DeviceRenew(deviceID, certificate, key)
+}
+
+// WebhookNew creates a webhook with an encrypted secret
+func (s *Store) WebhookNew(webhook domain.Webhook) (string, error) {
+	secret, err := s.keys.Encrypt(webhook.Secret)
+	if err != nil {
+		return "", err
+	}
+	webhook.Secret = secret
+	return s.DataStore.WebhookNew(webhook)
+}
+
+// WebhookGet fetches a webhook of an organization
+func (s *Store) WebhookGet(orgID, webhookID string) (*domain.Webhook, error) {
+	webhook, err := s.DataStore.WebhookGet(orgID, webhookID)
+	if err != nil {
+		return webhook, err
+	}
+	return webhook, s.decryptWebhook(webhook)
+}
+
+// WebhookList fetches the webhooks of an organization
+func (s *Store) WebhookList(orgID string) ([]domain.Webhook, error) {
+	webhooks, err := s.DataStore.WebhookList(orgID)
+	if err != nil {
+		return webhooks, err
+	}
+	for i := range webhooks {
+		if err := s.decryptWebhook(&webhooks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return webhooks, nil
+}
+
+// WebhookUpdateSecret replaces the secret of a webhook with an encrypted secret
+func (s *Store) WebhookUpdateSecret(orgID, webhookID string, secret []byte) error {
+	encrypted, err := s.keys.Encrypt(secret)
+	if err != nil {
+		return err
+	}
+	return s.DataStore.WebhookUpdateSecret(orgID, webhookID, encrypted)
+}
+
+func (s *Store) decryptWebhook(webhook *domain.Webhook) error {
+	secret, err := s.keys.Decrypt(webhook.Secret)
+	if err != nil {
+		return err
+	}
+	webhook.Secret = secret
+	return nil
+}
+
+func (s *Store) decryptOrganization(org *domain.Organization) error {
+	key, err := s.keys.Decrypt(org.RootKey)
+	if err != nil {
+		return err
+	}
+	org.RootKey = key
+	return nil
+}
+
+func (s *Store) decryptDevice(device *domain.Enrollment, err error) (*domain.Enrollment, error) {
+	if err != nil || device == nil {
+		return device, err
+	}
+	key, err := s.keys.Decrypt(device.Credentials.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	device.Credentials.PrivateKey = key
+	return device, nil
+}
@@ -20,96 +20,259 @@
 package memory
 
 import (
+	"bytes"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/canonical/iot-identity/datastore"
 	"github.com/canonical/iot-identity/domain"
 )
 
-// Store implements an in-memory store for testing
+// Store implements an in-memory store for testing and development. The records are
+// exported so tests can inspect them, but they must only be changed through the
+// methods once the store is shared, as the methods hold the lock and keep the indexes
 type Store struct {
-	Orgs []domain.Organization
-	Roll []domain.Enrollment
+	Orgs        []domain.Organization
+	Roll        []domain.Enrollment
+	Nonces      []domain.Nonce
+	Revocations []domain.Revocation
+	Tokens      []domain.Token
+	Tombstones  []domain.Tombstone
+	AuditEvents []domain.AuditEvent
+	History     []domain.StatusChange
+	Outbox      []domain.OutboxEvent
+	Webhooks    []domain.Webhook
+	Deliveries  []domain.WebhookDelivery
+
+	lock        sync.RWMutex
+	orgIDs      map[string]int       // organization ID to its position in Orgs
+	orgNames    map[string]int       // organization name to its position in Orgs
+	deviceIDs   map[string]int       // device ID to its position in Roll
+	serials     map[serialKey]int    // brand/model/serial to its position in Roll
+	certSerials map[string]int       // certificate serial number to its position in Roll
+	tokenHashes map[string]int       // token hash to its position in Tokens
+	leases      map[string]time.Time // outbox event or webhook delivery ID to the end of its claim
+	path        string               // the snapshot file, when the store is persisted
+	pending     bool                 // the changes are not yet written to the snapshot file
+	flushLock   sync.Mutex           // held while the snapshot file is written, to keep the writes in order
 }
 
-// NewStore creates a new memory store
-func NewStore() *Store {
-	exOrg := domain.Organization{ID: "abc", Name: "Example Inc", RootKey: []byte(RootPEM), RootCert: []byte(CertPEM)}
-	dev1 := domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", StoreID: "", DeviceKey: ""}
-	dev2 := domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: "DR1000B222", StoreID: "example-store", DeviceKey: "BBBBBBBBB"}
-	dev3 := domain.Device{Brand: "canonical", Model: "ubuntu-core-18-amd64", SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490", StoreID: "example-store", DeviceKey: "CCCCCCCC"}
+// serialKey identifies a device by its brand, model and serial number
+type serialKey struct {
+	brand  string
+	model  string
+	serial string
+}
+
+// Option configures the memory store
+type Option func(mem *Store)
+
+// WithFixtures adds the example organization and devices that the tests use
+func WithFixtures() Option {
+	return func(mem *Store) {
+		exOrg := domain.Organization{ID: "abc", Name: "Example Inc", RootKey: []byte(RootPEM), RootCert: []byte(CertPEM)}
+		dev1 := domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", StoreID: "", DeviceKey: ""}
+		dev2 := domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: "DR1000B222", StoreID: "example-store", DeviceKey: "BBBBBBBBB"}
+		dev3 := domain.Device{Brand: "canonical", Model: "ubuntu-core-18-amd64", SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490", StoreID: "example-store", DeviceKey: "CCCCCCCC"}
 
-	return &Store{
-		Orgs: []domain.Organization{exOrg},
-		Roll: []domain.Enrollment{
-			{
+		mem.Orgs = append(mem.Orgs, exOrg)
+		mem.Roll = append(mem.Roll,
+			domain.Enrollment{
 				ID:           "a111",
 				Organization: exOrg,
 				Device:       dev1,
 				Status:       domain.StatusDisabled,
 			},
-			{
+			domain.Enrollment{
 				ID:           "b222",
 				Organization: exOrg,
 				Device:       dev2,
 				Status:       domain.StatusEnrolled,
 			},
-			{
+			domain.Enrollment{
 				ID:           "c333",
 				Organization: exOrg,
 				Device:       dev3,
 				Status:       domain.StatusWaiting,
 			},
-		},
+		)
+	}
+}
+
+// NewStore creates a new memory store, which is empty unless the options add records
+func NewStore(options ...Option) *Store {
+	mem := &Store{}
+	for _, option := range options {
+		option(mem)
+	}
+	mem.reindex()
+	return mem
+}
+
+// reindex rebuilds the indexes from the records
+func (mem *Store) reindex() {
+	mem.orgIDs = map[string]int{}
+	mem.orgNames = map[string]int{}
+	for i, org := range mem.Orgs {
+		mem.orgIDs[org.ID] = i
+		mem.orgNames[org.Name] = i
+	}
+
+	mem.deviceIDs = map[string]int{}
+	mem.serials = map[serialKey]int{}
+	mem.certSerials = map[string]int{}
+	for i, en := range mem.Roll {
+		mem.deviceIDs[en.ID] = i
+		mem.serials[serialKey{en.Device.Brand, en.Device.Model, en.Device.SerialNumber}] = i
+		if serial := datastore.CertificateSerial(en.Credentials.Certificate); len(serial) > 0 {
+			mem.certSerials[serial] = i
+		}
+	}
+
+	mem.tokenHashes = map[string]int{}
+	for i, t := range mem.Tokens {
+		mem.tokenHashes[t.Hash] = i
 	}
 }
 
 // OrganizationNew creates a new organization
 func (mem *Store) OrganizationNew(organization datastore.OrganizationNewRequest) (string, error) {
 	// Validate the organization
-
 	if len(organization.Name) == 0 || len(organization.ServerKey) == 0 || len(organization.ServerCert) == 0 {
 		return "", fmt.Errorf("the name and root CA details must be provided")
 	}
 
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
 	// Check we don't have it
-	for _, org := range mem.Orgs {
-		if org.Name == organization.Name {
-			return "", fmt.Errorf("the organization `%s` already exists", organization.Name)
-		}
+	if _, ok := mem.orgNames[organization.Name]; ok {
+		return "", fmt.Errorf("the organization `%s` already exists", organization.Name)
 	}
 
 	// Store it
+	keyMode := organization.KeyMode
+	if keyMode == 0 {
+		keyMode = domain.KeyModeServer
+	}
 	id := datastore.GenerateID()
 	o := domain.Organization{
-		ID:       id,
-		Name:     organization.Name,
-		RootKey:  organization.ServerKey,
-		RootCert: organization.ServerCert,
+		ID:               id,
+		Name:             organization.Name,
+		RootKey:          organization.ServerKey,
+		RootCert:         organization.ServerCert,
+		KeyMode:          keyMode,
+		CertValidityDays: organization.CertValidityDays,
+		KeyType:          organization.KeyType,
+		ACLTemplates:     organization.ACLTemplates,
+		Broker:           organization.Broker,
 	}
 	mem.Orgs = append(mem.Orgs, o)
-	return id, nil
+	mem.orgIDs[o.ID] = len(mem.Orgs) - 1
+	mem.orgNames[o.Name] = len(mem.Orgs) - 1
+	return id, mem.save()
+}
+
+// OrganizationDelete removes an organization, its API tokens and its webhooks
+func (mem *Store) OrganizationDelete(orgID string) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	i, ok := mem.orgIDs[orgID]
+	if !ok {
+		return fmt.Errorf("%w: cannot find organization with ID '%s'", datastore.ErrNotFound, orgID)
+	}
+	mem.Orgs = append(mem.Orgs[:i], mem.Orgs[i+1:]...)
+
+	tokens := []domain.Token{}
+	for _, t := range mem.Tokens {
+		if t.OrganizationID != orgID {
+			tokens = append(tokens, t)
+		}
+	}
+	mem.Tokens = tokens
+
+	webhooks := []domain.Webhook{}
+	for _, w := range mem.Webhooks {
+		if w.OrganizationID != orgID {
+			webhooks = append(webhooks, w)
+		}
+	}
+	mem.Webhooks = webhooks
+	mem.deleteDeliveries(func(d domain.WebhookDelivery) bool { return d.OrganizationID == orgID })
+	mem.reindex()
+	return mem.save()
 }
 
 // OrganizationGetByName fetches an organization by name
 func (mem *Store) OrganizationGetByName(name string) (*domain.Organization, error) {
-	for _, org := range mem.Orgs {
-		if org.Name == name {
-			return &org, nil
-		}
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	if i, ok := mem.orgNames[name]; ok {
+		org := mem.Orgs[i]
+		return &org, nil
 	}
 	return nil, fmt.Errorf("cannot find organization with name '%s'", name)
 }
 
 // OrganizationGet fetches an organization by ID
 func (mem *Store) OrganizationGet(id string) (*domain.Organization, error) {
-	for _, org := range mem.Orgs {
-		if org.ID == id {
-			return &org, nil
-		}
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+	return mem.organizationGet(id)
+}
+
+// organizationGet fetches an organization by ID, with the lock held
+func (mem *Store) organizationGet(id string) (*domain.Organization, error) {
+	if i, ok := mem.orgIDs[id]; ok {
+		org := mem.Orgs[i]
+		return &org, nil
 	}
 	return nil, fmt.Errorf("cannot find organization with ID '%s'", id)
 }
 
+// OrganizationList lists existing organizations, without their CA keys
+func (mem *Store) OrganizationList() ([]domain.Organization, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	orgs := make([]domain.Organization, 0, len(mem.Orgs))
+	for _, o := range mem.Orgs {
+		o.RootKey = nil
+		orgs = append(orgs, o)
+	}
+	return orgs, nil
+}
+
+// OrganizationUpdateKey replaces the stored private key of an organization's CA
+func (mem *Store) OrganizationUpdateKey(orgID string, rootKey []byte) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	i, ok := mem.orgIDs[orgID]
+	if !ok {
+		return fmt.Errorf("%w: cannot find organization with ID '%s'", datastore.ErrNotFound, orgID)
+	}
+	mem.Orgs[i].RootKey = rootKey
+	return mem.save()
+}
+
+// OrganizationUpdateBroker replaces the MQTT broker of an organization
+func (mem *Store) OrganizationUpdateBroker(orgID string, broker domain.Broker) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	i, ok := mem.orgIDs[orgID]
+	if !ok {
+		return fmt.Errorf("%w: cannot find organization with ID '%s'", datastore.ErrNotFound, orgID)
+	}
+	mem.Orgs[i].Broker = broker
+	return mem.save()
+}
+
 // DeviceNew creates a new device registration
 func (mem *Store) DeviceNew(device datastore.DeviceNewRequest) (string, error) {
 	// Validate
@@ -117,17 +280,19 @@ func (mem *Store) DeviceNew(device datastore.DeviceNewRequest) (string, error) {
 		return "", fmt.Errorf("the provided device details are incomplete")
 	}
 
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
 	// Get the organization
-	o, err := mem.OrganizationGet(device.OrganizationID)
+	o, err := mem.organizationGet(device.OrganizationID)
 	if err != nil {
 		return "", err
 	}
 
-	// Check for duplicate
-	for _, en := range mem.Roll {
-		if en.Organization.ID == device.OrganizationID && en.Device.Brand == device.Brand && en.Device.Model == device.Model && en.Device.SerialNumber == device.SerialNumber {
-			return "", fmt.Errorf("the device `%s/%s/%s` is already registered", device.Brand, device.Model, device.SerialNumber)
-		}
+	// Check for duplicate, as the brand, model and serial number identify the device in any organization
+	key := serialKey{device.Brand, device.Model, device.SerialNumber}
+	if _, ok := mem.serials[key]; ok {
+		return "", fmt.Errorf("the device `%s/%s/%s` is already registered", device.Brand, device.Model, device.SerialNumber)
 	}
 
 	// Store it
@@ -135,89 +300,797 @@ func (mem *Store) DeviceNew(device datastore.DeviceNewRequest) (string, error) {
 	if len(deviceID) == 0 {
 		deviceID = datastore.GenerateID()
 	}
+	if _, ok := mem.deviceIDs[deviceID]; ok {
+		return "", fmt.Errorf("the device `%s` is already registered", deviceID)
+	}
 
 	d := domain.Device{
 		Brand:        device.Brand,
 		Model:        device.Model,
 		SerialNumber: device.SerialNumber,
 	}
+	now := time.Now()
 	e := domain.Enrollment{
 		ID:           deviceID,
 		Organization: *o,
 		Device:       d,
 		Credentials:  device.Credentials,
 		Status:       domain.StatusWaiting,
+		DeviceData:   device.DeviceData,
+		Created:      now,
+		Updated:      now,
 	}
 	mem.Roll = append(mem.Roll, e)
-	return deviceID, nil
+	mem.deviceIDs[deviceID] = len(mem.Roll) - 1
+	mem.serials[key] = len(mem.Roll) - 1
+	if serial := datastore.CertificateSerial(e.Credentials.Certificate); len(serial) > 0 {
+		mem.certSerials[serial] = len(mem.Roll) - 1
+	}
+	mem.statusChange(deviceID, 0, domain.StatusWaiting, now)
+	mem.outboxNew(domain.EventDeviceRegister, len(mem.Roll)-1, now)
+	return deviceID, mem.save()
 }
 
-// OrganizationList lists existing organizations
-func (mem *Store) OrganizationList() ([]domain.Organization, error) {
-	return mem.Orgs, nil
+// statusChange records a transition in the status of a device, if the status changed,
+// with the lock held
+func (mem *Store) statusChange(deviceID string, from, to domain.Status, at time.Time) {
+	if from != to {
+		mem.History = append(mem.History, domain.StatusChange{DeviceID: deviceID, From: from, To: to, Time: at})
+	}
+}
+
+// outboxNew records an event of the change to a device in the outbox, with the lock held
+func (mem *Store) outboxNew(eventType domain.EventType, i int, at time.Time) {
+	mem.Outbox = append(mem.Outbox, datastore.NewOutboxEvent(eventType, mem.Roll[i], at))
+}
+
+// enrollment returns a copy of a device registration with the current details of its
+// organization, without the CA key, with the lock held
+func (mem *Store) enrollment(i int) *domain.Enrollment {
+	en := mem.Roll[i]
+	if j, ok := mem.orgIDs[en.Organization.ID]; ok {
+		org := mem.Orgs[j]
+		en.Organization = domain.Organization{ID: org.ID, Name: org.Name, RootCert: org.RootCert}
+	}
+	return &en
 }
 
 // DeviceGet fetches a device registration
-func (mem *Store) DeviceGet(brand, model, serial string) (*domain.Enrollment, error) { // Check for duplicate
-	for _, en := range mem.Roll {
-		if en.Device.Brand == brand && en.Device.Model == model && en.Device.SerialNumber == serial {
-			return &en, nil
-		}
+func (mem *Store) DeviceGet(brand, model, serial string) (*domain.Enrollment, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	if i, ok := mem.serials[serialKey{brand, model, serial}]; ok {
+		return mem.enrollment(i), nil
 	}
-	return nil, fmt.Errorf("the device `%s/%s/%s` is not registered", brand, model, serial)
+	return nil, fmt.Errorf("%w: the device `%s/%s/%s` is not registered", datastore.ErrNotFound, brand, model, serial)
 }
 
 // DeviceEnroll enrols a device with the IoT service
 func (mem *Store) DeviceEnroll(device datastore.DeviceEnrollRequest) (*domain.Enrollment, error) {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
 	// Get the registered device
-	reg, err := mem.DeviceGet(device.Brand, device.Model, device.SerialNumber)
-	if err != nil {
-		return nil, err
+	i, ok := mem.serials[serialKey{device.Brand, device.Model, device.SerialNumber}]
+	if !ok {
+		return nil, fmt.Errorf("%w: the device `%s/%s/%s` is not registered", datastore.ErrNotFound, device.Brand, device.Model, device.SerialNumber)
 	}
 
 	// Update the registration to enroll the device
+	reg := &mem.Roll[i]
+	now := time.Now()
+	mem.statusChange(reg.ID, reg.Status, domain.StatusEnrolled, now)
 	reg.Device.DeviceKey = device.DeviceKey
 	reg.Device.StoreID = device.StoreID
 	reg.Status = domain.StatusEnrolled
-	return reg, nil
+	reg.Updated = now
+	reg.EnrolledAt = now
+	reg.LastSeen = now
+	reg.Credentials.ACLs = device.ACLs
+	if len(device.MQTT.MQTTURL) > 0 {
+		setBroker(&reg.Credentials, device.MQTT)
+	}
+	if len(device.Certificate) > 0 {
+		mem.setCertificate(i, device.Certificate, device.PrivateKey)
+	}
+	mem.outboxNew(domain.EventDeviceEnroll, i, now)
+	return mem.enrollment(i), mem.save()
 }
 
 // DeviceList fetches the devices for an organization
 func (mem *Store) DeviceList(orgID string) ([]domain.Enrollment, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
 	devices := []domain.Enrollment{}
-	for _, en := range mem.Roll {
-		if en.Organization.ID == orgID {
-			devices = append(devices, en)
+	for i := range mem.Roll {
+		if mem.Roll[i].Organization.ID == orgID {
+			devices = append(devices, *mem.enrollment(i))
 		}
 	}
 	return devices, nil
 }
 
+// DeviceListPage fetches a page of the devices for an organization
+func (mem *Store) DeviceListPage(query datastore.DeviceQuery) (*datastore.DevicePage, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	after, _ := query.After()
+
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	matches := []domain.Enrollment{}
+	for _, en := range mem.Roll {
+		if !query.Matches(en) {
+			continue
+		}
+		en.Credentials.PrivateKey = nil
+		en.Organization = domain.Organization{ID: en.Organization.ID}
+		matches = append(matches, en)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return query.Before(matches[i], matches[j])
+	})
+
+	page := &datastore.DevicePage{Devices: []domain.Enrollment{}, Total: len(matches)}
+	for _, en := range matches {
+		if after != nil && !query.IsAfter(en, after) {
+			continue
+		}
+		if len(page.Devices) == query.Limit {
+			page.NextCursor = query.NextCursor(page.Devices[len(page.Devices)-1])
+			break
+		}
+		page.Devices = append(page.Devices, en)
+	}
+	return page, nil
+}
+
 // DeviceGetByID fetches a device by its ID
 func (mem *Store) DeviceGetByID(deviceID string) (*domain.Enrollment, error) {
-	for _, en := range mem.Roll {
-		if en.ID == deviceID {
-			return &en, nil
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	if i, ok := mem.deviceIDs[deviceID]; ok {
+		return mem.enrollment(i), nil
+	}
+	return nil, fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, deviceID)
+}
+
+// DeviceGetByOrgID fetches a device by its ID, if it belongs to the organization
+func (mem *Store) DeviceGetByOrgID(orgID, deviceID string) (*domain.Enrollment, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	if i, ok := mem.deviceIDs[deviceID]; ok && mem.Roll[i].Organization.ID == orgID {
+		return mem.enrollment(i), nil
+	}
+	return nil, fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, deviceID)
+}
+
+// DeviceGetByCertificate fetches the device with the certificate serial number
+func (mem *Store) DeviceGetByCertificate(serialNumber string) (*domain.Enrollment, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	if i, ok := mem.certSerials[serialNumber]; ok {
+		return mem.enrollment(i), nil
+	}
+	return nil, fmt.Errorf("%w: the certificate `%s` is not issued to a device", datastore.ErrNotFound, serialNumber)
+}
+
+// NonceNew stores a nonce for a device, removing any expired nonces
+func (mem *Store) NonceNew(nonce domain.Nonce) error {
+	if len(nonce.Value) == 0 {
+		return fmt.Errorf("the nonce must be provided")
+	}
+
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	nonces := []domain.Nonce{}
+	for _, n := range mem.Nonces {
+		if n.Value == nonce.Value {
+			return fmt.Errorf("the nonce already exists")
+		}
+		if n.Expires.After(time.Now()) {
+			nonces = append(nonces, n)
 		}
 	}
-	return nil, fmt.Errorf("the device `%s` is not registered", deviceID)
+	mem.Nonces = append(nonces, nonce)
+	return mem.save()
+}
+
+// NonceUse fetches and removes a nonce for a device, so it can only be used once
+func (mem *Store) NonceUse(value, brand, model, serial string) (*domain.Nonce, error) {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	for i, n := range mem.Nonces {
+		if n.Value == value && n.Brand == brand && n.Model == model && n.SerialNumber == serial {
+			mem.Nonces = append(mem.Nonces[:i], mem.Nonces[i+1:]...)
+			return &n, mem.save()
+		}
+	}
+	return nil, fmt.Errorf("the nonce for device `%s/%s/%s` is not valid", brand, model, serial)
+}
+
+// DeviceRenew replaces the certificate and private key of a device
+func (mem *Store) DeviceRenew(deviceID string, certificate, privateKey []byte) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	i, ok := mem.deviceIDs[deviceID]
+	if !ok {
+		return fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, deviceID)
+	}
+	now := time.Now()
+	mem.setCertificate(i, certificate, privateKey)
+	mem.Roll[i].Updated = now
+	mem.Roll[i].LastSeen = now
+	mem.outboxNew(domain.EventDeviceRenew, i, now)
+	return mem.save()
+}
+
+// setCertificate replaces the certificate and private key of a device, and the index of
+// its certificate serial number, with the lock held
+func (mem *Store) setCertificate(i int, certificate, privateKey []byte) {
+	delete(mem.certSerials, datastore.CertificateSerial(mem.Roll[i].Credentials.Certificate))
+	mem.Roll[i].Credentials.Certificate = certificate
+	mem.Roll[i].Credentials.PrivateKey = privateKey
+	if serial := datastore.CertificateSerial(certificate); len(serial) > 0 {
+		mem.certSerials[serial] = i
+	}
+}
+
+// DeviceUpdateKey replaces the stored private key of a device, if it has not changed from the current key
+func (mem *Store) DeviceUpdateKey(deviceID string, current, privateKey []byte) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	i, ok := mem.deviceIDs[deviceID]
+	if !ok || !bytes.Equal(mem.Roll[i].Credentials.PrivateKey, current) {
+		return fmt.Errorf("%w: the device `%s` with the current key is not registered", datastore.ErrNotFound, deviceID)
+	}
+	mem.Roll[i].Credentials.PrivateKey = privateKey
+	return mem.save()
+}
+
+// DeviceUpdateBroker replaces the broker details of the credentials of a device
+func (mem *Store) DeviceUpdateBroker(deviceID string, mqtt domain.Credentials) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	i, ok := mem.deviceIDs[deviceID]
+	if !ok {
+		return fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, deviceID)
+	}
+	setBroker(&mem.Roll[i].Credentials, mqtt)
+	mem.Roll[i].Updated = time.Now()
+	return mem.save()
+}
+
+// setBroker copies the broker details from one set of credentials to another
+func setBroker(creds *domain.Credentials, mqtt domain.Credentials) {
+	creds.MQTTURL = mqtt.MQTTURL
+	creds.MQTTPort = mqtt.MQTTPort
+	creds.MQTTProtocol = mqtt.MQTTProtocol
+	creds.MQTTCABundle = mqtt.MQTTCABundle
+	creds.ClientID = mqtt.ClientID
+}
+
+// RevocationNew records the revocation of a certificate
+func (mem *Store) RevocationNew(revocation domain.Revocation) error {
+	if len(revocation.OrganizationID) == 0 || len(revocation.SerialNumber) == 0 {
+		return fmt.Errorf("the organization and certificate serial number must be provided")
+	}
+
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	for _, r := range mem.Revocations {
+		if r.OrganizationID == revocation.OrganizationID && r.SerialNumber == revocation.SerialNumber {
+			return fmt.Errorf("the certificate `%s` is already revoked", revocation.SerialNumber)
+		}
+	}
+	mem.Revocations = append(mem.Revocations, revocation)
+	return mem.save()
+}
+
+// RevocationList fetches the revoked certificates for an organization
+func (mem *Store) RevocationList(orgID string) ([]domain.Revocation, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	revocations := []domain.Revocation{}
+	for _, r := range mem.Revocations {
+		if r.OrganizationID == orgID {
+			revocations = append(revocations, r)
+		}
+	}
+	sort.SliceStable(revocations, func(i, j int) bool {
+		return revocations[i].Revoked.Before(revocations[j].Revoked)
+	})
+	return revocations, nil
+}
+
+// RevocationGet fetches the revocation of a certificate of an organization
+func (mem *Store) RevocationGet(orgID, serialNumber string) (*domain.Revocation, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	for _, r := range mem.Revocations {
+		if r.OrganizationID == orgID && r.SerialNumber == serialNumber {
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: the certificate `%s` is not revoked", datastore.ErrNotFound, serialNumber)
 }
 
 // DeviceUpdate update a device for selected fields
 func (mem *Store) DeviceUpdate(deviceID string, status domain.Status, deviceData string) error {
-	found := false
-	roll := []domain.Enrollment{}
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
 
-	for _, en := range mem.Roll {
-		if en.ID == deviceID {
-			found = true
-			en.Status = status
+	i, ok := mem.deviceIDs[deviceID]
+	if !ok {
+		return fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, deviceID)
+	}
+	now := time.Now()
+	previous := mem.Roll[i].Status
+	mem.statusChange(deviceID, previous, status, now)
+	mem.Roll[i].Status = status
+	mem.Roll[i].DeviceData = deviceData
+	mem.Roll[i].Updated = now
+	if eventType, ok := datastore.UpdateEventType(previous, status); ok {
+		mem.outboxNew(eventType, i, now)
+	}
+	return mem.save()
+}
+
+// DeviceHistory fetches the status transitions of a device, oldest first
+func (mem *Store) DeviceHistory(deviceID string) ([]domain.StatusChange, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	history := []domain.StatusChange{}
+	for _, c := range mem.History {
+		if c.DeviceID == deviceID {
+			history = append(history, c)
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time.Before(history[j].Time)
+	})
+	return history, nil
+}
+
+// DeviceDelete removes a device, recording its tombstone
+func (mem *Store) DeviceDelete(tombstone domain.Tombstone) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	i, ok := mem.deviceIDs[tombstone.DeviceID]
+	if !ok {
+		return fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, tombstone.DeviceID)
+	}
+	mem.outboxNew(domain.EventDeviceDelete, i, time.Now())
+	mem.Roll = append(mem.Roll[:i], mem.Roll[i+1:]...)
+	mem.Tombstones = append(mem.Tombstones, tombstone)
+
+	// The history goes with the device, as the audit log keeps the record of the deletion
+	history := []domain.StatusChange{}
+	for _, c := range mem.History {
+		if c.DeviceID != tombstone.DeviceID {
+			history = append(history, c)
+		}
+	}
+	mem.History = history
+	mem.reindex()
+	return mem.save()
+}
+
+// TombstoneGet fetches the latest tombstone of a deleted device
+func (mem *Store) TombstoneGet(brand, model, serial string) (*domain.Tombstone, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	var latest *domain.Tombstone
+	for i, t := range mem.Tombstones {
+		if t.Brand != brand || t.Model != model || t.SerialNumber != serial {
+			continue
+		}
+		if latest == nil || !t.Deleted.Before(latest.Deleted) {
+			latest = &mem.Tombstones[i]
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("%w: the device `%s/%s/%s` has not been deleted", datastore.ErrNotFound, brand, model, serial)
+	}
+	t := *latest
+	return &t, nil
+}
+
+// TokenNew stores a new API token
+func (mem *Store) TokenNew(token domain.Token) (string, error) {
+	if len(token.Hash) == 0 {
+		return "", fmt.Errorf("the token hash must be provided")
+	}
+
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	if _, ok := mem.tokenHashes[token.Hash]; ok {
+		return "", fmt.Errorf("the token already exists")
+	}
+
+	token.ID = datastore.GenerateID()
+	mem.Tokens = append(mem.Tokens, token)
+	mem.tokenHashes[token.Hash] = len(mem.Tokens) - 1
+	return token.ID, mem.save()
+}
+
+// TokenGetByHash fetches an API token by the hash of its value
+func (mem *Store) TokenGetByHash(hash string) (*domain.Token, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	if i, ok := mem.tokenHashes[hash]; ok {
+		t := mem.Tokens[i]
+		return &t, nil
+	}
+	return nil, fmt.Errorf("cannot find the token")
+}
+
+// AuditNew appends an event to the audit log
+func (mem *Store) AuditNew(event domain.AuditEvent) error {
+	if len(event.ID) == 0 || len(event.Action) == 0 {
+		return fmt.Errorf("the event ID and action must be provided")
+	}
+
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	mem.AuditEvents = append(mem.AuditEvents, event)
+	return mem.save()
+}
+
+// AuditList fetches a page of the audit log
+func (mem *Store) AuditList(query datastore.AuditQuery) (*datastore.AuditPage, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	after, _ := query.Position()
+
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	matches := []domain.AuditEvent{}
+	for _, event := range mem.AuditEvents {
+		if query.Matches(event) {
+			matches = append(matches, event)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return query.Newer(matches[i], matches[j])
+	})
+
+	page := &datastore.AuditPage{Events: []domain.AuditEvent{}, Total: len(matches)}
+	for _, event := range matches {
+		if after != nil && !query.IsAfter(event, after) {
+			continue
+		}
+		if len(page.Events) == query.Limit {
+			page.NextCursor = query.NextCursor(page.Events[len(page.Events)-1])
+			break
+		}
+		page.Events = append(page.Events, event)
+	}
+	return page, nil
+}
+
+// OutboxNew records an event for a device without a change to it, such as a rejected enrollment
+func (mem *Store) OutboxNew(eventType domain.EventType, deviceID string) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	i, ok := mem.deviceIDs[deviceID]
+	if !ok {
+		return fmt.Errorf("%w: cannot find device with ID '%s'", datastore.ErrNotFound, deviceID)
+	}
+	mem.outboxNew(eventType, i, time.Now())
+	return mem.save()
+}
+
+// OutboxList fetches the outbox events that match the query, oldest first
+func (mem *Store) OutboxList(query datastore.OutboxQuery) ([]domain.OutboxEvent, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	events := []domain.OutboxEvent{}
+	for _, e := range mem.Outbox {
+		if len(events) == query.Limit {
+			break
+		}
+		if query.Matches(e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// OutboxGet fetches an outbox event
+func (mem *Store) OutboxGet(eventID string) (*domain.OutboxEvent, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	for _, e := range mem.Outbox {
+		if e.ID == eventID {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: the outbox event `%s` does not exist", datastore.ErrNotFound, eventID)
+}
+
+// OutboxUpdate records the delivery state of an outbox event
+func (mem *Store) OutboxUpdate(event domain.OutboxEvent) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	for i := range mem.Outbox {
+		if mem.Outbox[i].ID == event.ID {
+			e := &mem.Outbox[i]
+			if e.LeaseID != event.LeaseID {
+				return fmt.Errorf("%w: the outbox event `%s` is claimed by another dispatcher", datastore.ErrLeaseLost, event.ID)
+			}
+			e.Status, e.Attempts, e.NextAttempt, e.LastError, e.Delivered = event.Status, event.Attempts, event.NextAttempt, event.LastError, event.Delivered
+			e.LeaseID = ""
+			delete(mem.leases, event.ID)
+			return mem.save()
+		}
+	}
+	return fmt.Errorf("%w: the outbox event `%s` does not exist", datastore.ErrNotFound, event.ID)
+}
+
+// OutboxClaim claims the pending events that are due, oldest first, until the lease ends or
+// the event is updated. The events of a device are claimed up to the first that is not due or
+// is claimed already, so they are delivered in order
+func (mem *Store) OutboxClaim(now time.Time, lease time.Duration, limit int) ([]domain.OutboxEvent, error) {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	if mem.leases == nil {
+		mem.leases = map[string]time.Time{}
+	}
+	leaseID := datastore.GenerateID()
+	events := []domain.OutboxEvent{}
+	blocked := map[string]bool{}
+	for i := range mem.Outbox {
+		e := &mem.Outbox[i]
+		if len(events) == limit {
+			break
+		}
+		if e.Status != domain.OutboxPending || blocked[e.DeviceID] {
+			continue
+		}
+		if e.NextAttempt.After(now) || mem.leases[e.ID].After(now) {
+			blocked[e.DeviceID] = true
+			continue
+		}
+		e.LeaseID = leaseID
+		mem.leases[e.ID] = now.Add(lease)
+		events = append(events, *e)
+	}
+	return events, nil
+}
+
+// OutboxPrune removes the events that were delivered before a time, returning the number removed
+func (mem *Store) OutboxPrune(before time.Time) (int, error) {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	outbox := []domain.OutboxEvent{}
+	for _, e := range mem.Outbox {
+		if e.Status != domain.OutboxDelivered || !e.Delivered.Before(before) {
+			outbox = append(outbox, e)
+		}
+	}
+	pruned := len(mem.Outbox) - len(outbox)
+	if pruned == 0 {
+		return 0, nil
+	}
+	mem.Outbox = outbox
+	return pruned, mem.save()
+}
+
+// WebhookNew creates a webhook of an organization
+func (mem *Store) WebhookNew(webhook domain.Webhook) (string, error) {
+	if len(webhook.OrganizationID) == 0 || len(webhook.URL) == 0 {
+		return "", fmt.Errorf("the organization and URL of the webhook must be provided")
+	}
+
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	if _, err := mem.organizationGet(webhook.OrganizationID); err != nil {
+		return "", err
+	}
+
+	webhook.ID = datastore.GenerateID()
+	webhook.Events = append([]domain.EventType{}, webhook.Events...)
+	webhook.Created = time.Now()
+	mem.Webhooks = append(mem.Webhooks, webhook)
+	return webhook.ID, mem.save()
+}
+
+// WebhookGet fetches a webhook, if it belongs to the organization
+func (mem *Store) WebhookGet(orgID, webhookID string) (*domain.Webhook, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	for _, w := range mem.Webhooks {
+		if w.ID == webhookID && w.OrganizationID == orgID {
+			return &w, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: the webhook `%s` does not exist", datastore.ErrNotFound, webhookID)
+}
+
+// WebhookList fetches the webhooks of an organization, oldest first
+func (mem *Store) WebhookList(orgID string) ([]domain.Webhook, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	webhooks := []domain.Webhook{}
+	for _, w := range mem.Webhooks {
+		if w.OrganizationID == orgID {
+			webhooks = append(webhooks, w)
 		}
-		roll = append(roll, en)
 	}
-	if !found {
-		return fmt.Errorf("the device `%s` is not registered", deviceID)
+	return webhooks, nil
+}
+
+// WebhookUpdateSecret replaces the shared secret of a webhook
+func (mem *Store) WebhookUpdateSecret(orgID, webhookID string, secret []byte) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	for i := range mem.Webhooks {
+		if mem.Webhooks[i].ID == webhookID && mem.Webhooks[i].OrganizationID == orgID {
+			mem.Webhooks[i].Secret = secret
+			return mem.save()
+		}
+	}
+	return fmt.Errorf("%w: the webhook `%s` does not exist", datastore.ErrNotFound, webhookID)
+}
+
+// WebhookDelete removes a webhook and its deliveries
+func (mem *Store) WebhookDelete(orgID, webhookID string) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	for i, w := range mem.Webhooks {
+		if w.ID == webhookID && w.OrganizationID == orgID {
+			mem.Webhooks = append(mem.Webhooks[:i], mem.Webhooks[i+1:]...)
+			mem.deleteDeliveries(func(d domain.WebhookDelivery) bool { return d.WebhookID == webhookID })
+			return mem.save()
+		}
+	}
+	return fmt.Errorf("%w: the webhook `%s` does not exist", datastore.ErrNotFound, webhookID)
+}
+
+// deleteDeliveries removes the webhook deliveries that match, with the lock held. It returns
+// the number removed
+func (mem *Store) deleteDeliveries(match func(d domain.WebhookDelivery) bool) int {
+	deliveries := []domain.WebhookDelivery{}
+	for _, d := range mem.Deliveries {
+		if !match(d) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	deleted := len(mem.Deliveries) - len(deliveries)
+	mem.Deliveries = deliveries
+	return deleted
+}
+
+// WebhookDeliveryNew records a delivery of an event to a webhook
+func (mem *Store) WebhookDeliveryNew(delivery domain.WebhookDelivery) error {
+	if len(delivery.ID) == 0 || len(delivery.WebhookID) == 0 {
+		return fmt.Errorf("the delivery ID and webhook must be provided")
+	}
+
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	mem.Deliveries = append(mem.Deliveries, delivery)
+	return mem.save()
+}
+
+// WebhookDeliveryList fetches the webhook deliveries that match the query, oldest first
+func (mem *Store) WebhookDeliveryList(query datastore.WebhookDeliveryQuery) ([]domain.WebhookDelivery, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	deliveries := []domain.WebhookDelivery{}
+	for _, d := range mem.Deliveries {
+		if len(deliveries) == query.Limit {
+			break
+		}
+		if query.Matches(d) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+// WebhookDeliveryUpdate records the outcome of an attempt to deliver to a webhook
+func (mem *Store) WebhookDeliveryUpdate(delivery domain.WebhookDelivery) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	for i := range mem.Deliveries {
+		if mem.Deliveries[i].ID == delivery.ID {
+			d := &mem.Deliveries[i]
+			if d.LeaseID != delivery.LeaseID {
+				return fmt.Errorf("%w: the webhook delivery `%s` is claimed by another dispatcher", datastore.ErrLeaseLost, delivery.ID)
+			}
+			d.Status, d.Attempts, d.NextAttempt, d.ResponseCode, d.LastError, d.Delivered =
+				delivery.Status, delivery.Attempts, delivery.NextAttempt, delivery.ResponseCode, delivery.LastError, delivery.Delivered
+			d.LeaseID = ""
+			delete(mem.leases, delivery.ID)
+			return mem.save()
+		}
+	}
+	return fmt.Errorf("%w: the webhook delivery `%s` does not exist", datastore.ErrNotFound, delivery.ID)
+}
+
+// WebhookDeliveryClaim claims the pending deliveries that are due, oldest first, until the
+// lease ends or the delivery is updated
+func (mem *Store) WebhookDeliveryClaim(now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error) {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	if mem.leases == nil {
+		mem.leases = map[string]time.Time{}
+	}
+	leaseID := datastore.GenerateID()
+	deliveries := []domain.WebhookDelivery{}
+	for i := range mem.Deliveries {
+		d := &mem.Deliveries[i]
+		if len(deliveries) == limit {
+			break
+		}
+		if d.Status != domain.OutboxPending || d.NextAttempt.After(now) || mem.leases[d.ID].After(now) {
+			continue
+		}
+		d.LeaseID = leaseID
+		mem.leases[d.ID] = now.Add(lease)
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, nil
+}
+
+// WebhookDeliveryPrune removes the deliveries that were delivered before a time, returning the number removed
+func (mem *Store) WebhookDeliveryPrune(before time.Time) (int, error) {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	pruned := mem.deleteDeliveries(func(d domain.WebhookDelivery) bool {
+		return d.Status == domain.OutboxDelivered && d.Delivered.Before(before)
+	})
+	if pruned == 0 {
+		return 0, nil
 	}
-	mem.Roll = roll
-	return nil
+	return pruned, mem.save()
 }
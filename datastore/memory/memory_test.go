@@ -20,13 +20,28 @@
 package memory
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/datastoretest"
 	"github.com/canonical/iot-identity/domain"
 )
 
+func TestStore_Conformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) datastore.DataStore {
+		return NewStore()
+	})
+}
+
 func TestStore_OrganizationNew(t *testing.T) {
 	req1 := datastore.OrganizationNewRequest{
 		Name:        "Example Ltd",
@@ -56,7 +71,7 @@ func TestStore_OrganizationNew(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewStore()
+			s := NewStore(WithFixtures())
 			got, err := s.OrganizationNew(tt.args.organization)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.OrganizationNew() error = %v, wantErr %v", err, tt.wantErr)
@@ -111,7 +126,7 @@ func TestStore_DeviceNew(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewStore()
+			s := NewStore(WithFixtures())
 			got, err := s.DeviceNew(tt.args.device)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceNew() error = %v, wantErr %v", err, tt.wantErr)
@@ -145,8 +160,8 @@ func TestStore_DeviceEnroll(t *testing.T) {
 		DeviceKey:    "-----BEGIN GPG PUBLIC KEY-----\nMIIEpAIBAAKCAQ",
 	}
 
-	// Reply
-	exOrg := domain.Organization{ID: "abc", Name: "Example Inc", RootKey: []byte(RootPEM), RootCert: []byte(CertPEM)}
+	// Reply, with the organization of the device without its CA key
+	exOrg := domain.Organization{ID: "abc", Name: "Example Inc", RootCert: []byte(CertPEM)}
 	dev1 := domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", StoreID: "example-store", DeviceKey: "-----BEGIN GPG PUBLIC KEY-----\nMIIEpAIBAAKCAQ"}
 	reply1 := &domain.Enrollment{
 		ID:           "a111",
@@ -169,12 +184,18 @@ func TestStore_DeviceEnroll(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewStore()
+			s := NewStore(WithFixtures())
 			got, err := s.DeviceEnroll(tt.args.device)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceEnroll() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if got != nil {
+				if got.Updated.IsZero() || got.EnrolledAt.IsZero() || got.LastSeen.IsZero() {
+					t.Error("Store.DeviceEnroll() = updated, enrolled or last seen time not set")
+				}
+				got.Updated, got.EnrolledAt, got.LastSeen = time.Time{}, time.Time{}, time.Time{}
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Store.DeviceEnroll() = %v, want %v", got, tt.want)
 			}
@@ -199,7 +220,7 @@ func TestStore_OrganizationGetByName(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mem := NewStore()
+			mem := NewStore(WithFixtures())
 			got, err := mem.OrganizationGetByName(tt.args.name)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.OrganizationGetByName() error = %v, wantErr %v", err, tt.wantErr)
@@ -222,7 +243,7 @@ func TestStore_OrganizationList(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mem := NewStore()
+			mem := NewStore(WithFixtures())
 			got, err := mem.OrganizationList()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.OrganizationList() error = %v, wantErr %v", err, tt.wantErr)
@@ -246,7 +267,7 @@ func TestStore_DeviceGetByID(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mem := NewStore()
+			mem := NewStore(WithFixtures())
 			got, err := mem.DeviceGetByID(tt.deviceID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceGetByID() error = %v, wantErr %v", err, tt.wantErr)
@@ -262,6 +283,38 @@ func TestStore_DeviceGetByID(t *testing.T) {
 	}
 }
 
+func TestStore_DeviceGetByOrgID(t *testing.T) {
+	tests := []struct {
+		name     string
+		orgID    string
+		deviceID string
+		wantErr  bool
+	}{
+		{"valid", "abc", "a111", false},
+		{"other-org", "def", "a111", true},
+		{"invalid", "abc", "invalid", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(WithFixtures())
+			got, err := mem.DeviceGetByOrgID(tt.orgID, tt.deviceID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Store.DeviceGetByOrgID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if !errors.Is(err, datastore.ErrNotFound) {
+					t.Errorf("Store.DeviceGetByOrgID() error = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if got.ID != tt.deviceID {
+				t.Errorf("Store.DeviceGetByOrgID() = %v, want %v", got.ID, tt.deviceID)
+			}
+		})
+	}
+}
+
 func TestStore_DeviceUpdate(t *testing.T) {
 	type args struct {
 		deviceID string
@@ -277,10 +330,384 @@ func TestStore_DeviceUpdate(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mem := NewStore()
+			mem := NewStore(WithFixtures())
 			if err := mem.DeviceUpdate(tt.args.deviceID, tt.args.status, ""); (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceUpdate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestStore_NonceNew(t *testing.T) {
+	expired := domain.Nonce{Value: "expired", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", Expires: time.Now().Add(-time.Minute)}
+	valid := domain.Nonce{Value: "valid", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", Expires: time.Now().Add(time.Minute)}
+	tests := []struct {
+		name    string
+		nonce   domain.Nonce
+		count   int
+		wantErr bool
+	}{
+		{"valid", domain.Nonce{Value: "abc", Expires: time.Now().Add(time.Minute)}, 2, false},
+		{"duplicate", valid, 2, true},
+		{"empty", domain.Nonce{}, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(WithFixtures())
+			mem.Nonces = []domain.Nonce{expired, valid}
+			if err := mem.NonceNew(tt.nonce); (err != nil) != tt.wantErr {
+				t.Errorf("Store.NonceNew() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(mem.Nonces) != tt.count {
+				t.Errorf("Store.NonceNew() count = %v, want %v", len(mem.Nonces), tt.count)
+			}
+		})
+	}
+}
+
+func TestStore_NonceUse(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		serial  string
+		wantErr bool
+	}{
+		{"valid", "abc", "DR1000A111", false},
+		{"invalid-nonce", "invalid", "DR1000A111", true},
+		{"invalid-device", "abc", "DR1000B222", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(WithFixtures())
+			_ = mem.NonceNew(domain.Nonce{Value: "abc", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", Expires: time.Now().Add(time.Minute)})
+
+			got, err := mem.NonceUse(tt.value, "example", "drone-1000", tt.serial)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Store.NonceUse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Value != tt.value {
+				t.Errorf("Store.NonceUse() = %v, want %v", got.Value, tt.value)
+			}
+			if _, err := mem.NonceUse(tt.value, "example", "drone-1000", tt.serial); err == nil {
+				t.Error("Store.NonceUse() nonce was used twice")
+			}
+		})
+	}
+}
+
+func TestStore_RevocationNew(t *testing.T) {
+	existing := domain.Revocation{OrganizationID: "abc", DeviceID: "b222", SerialNumber: "1234", Reason: domain.ReasonCessationOfOperation, Revoked: time.Now()}
+	tests := []struct {
+		name       string
+		revocation domain.Revocation
+		count      int
+		wantErr    bool
+	}{
+		{"valid", domain.Revocation{OrganizationID: "abc", DeviceID: "a111", SerialNumber: "5678"}, 2, false},
+		{"other-org", domain.Revocation{OrganizationID: "def", DeviceID: "d444", SerialNumber: "1234"}, 2, false},
+		{"duplicate", existing, 1, true},
+		{"empty", domain.Revocation{}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(WithFixtures())
+			mem.Revocations = []domain.Revocation{existing}
+			if err := mem.RevocationNew(tt.revocation); (err != nil) != tt.wantErr {
+				t.Errorf("Store.RevocationNew() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(mem.Revocations) != tt.count {
+				t.Errorf("Store.RevocationNew() count = %v, want %v", len(mem.Revocations), tt.count)
+			}
+		})
+	}
+}
+
+func TestStore_RevocationList(t *testing.T) {
+	mem := NewStore(WithFixtures())
+	mem.Revocations = []domain.Revocation{
+		{OrganizationID: "abc", DeviceID: "a111", SerialNumber: "1234"},
+		{OrganizationID: "def", DeviceID: "d444", SerialNumber: "5678"},
+	}
+	tests := []struct {
+		name  string
+		orgID string
+		count int
+	}{
+		{"valid", "abc", 1},
+		{"none", "invalid", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mem.RevocationList(tt.orgID)
+			if err != nil {
+				t.Errorf("Store.RevocationList() error = %v", err)
+			}
+			if len(got) != tt.count {
+				t.Errorf("Store.RevocationList() count = %v, want %v", len(got), tt.count)
+			}
+		})
+	}
+}
+
+func TestStore_DeviceRenew(t *testing.T) {
+	tests := []struct {
+		name     string
+		deviceID string
+		wantErr  bool
+	}{
+		{"valid", "b222", false},
+		{"invalid", "invalid", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(WithFixtures())
+			if err := mem.DeviceRenew(tt.deviceID, []byte("cert"), []byte("key")); (err != nil) != tt.wantErr {
+				t.Errorf("Store.DeviceRenew() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			got, _ := mem.DeviceGetByID(tt.deviceID)
+			if string(got.Credentials.Certificate) != "cert" || string(got.Credentials.PrivateKey) != "key" {
+				t.Errorf("Store.DeviceRenew() credentials = %v", got.Credentials)
+			}
+		})
+	}
+}
+
+func TestStore_TokenNew(t *testing.T) {
+	existing := domain.Token{ID: "t111", Name: "admin", Role: domain.RoleSuperuser, Hash: "aaaa"}
+	tests := []struct {
+		name    string
+		token   domain.Token
+		count   int
+		wantErr bool
+	}{
+		{"valid", domain.Token{Name: "viewer", OrganizationID: "abc", Role: domain.RoleReadOnly, Hash: "bbbb"}, 2, false},
+		{"duplicate", domain.Token{Name: "copy", Role: domain.RoleSuperuser, Hash: "aaaa"}, 1, true},
+		{"no-hash", domain.Token{Name: "empty", Role: domain.RoleSuperuser}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(WithFixtures())
+			mem.Tokens = []domain.Token{existing}
+			mem.reindex()
+			got, err := mem.TokenNew(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Store.TokenNew() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(got) == 0 {
+				t.Error("Store.TokenNew() = no ID generated")
+			}
+			if len(mem.Tokens) != tt.count {
+				t.Errorf("Store.TokenNew() count = %v, want %v", len(mem.Tokens), tt.count)
+			}
+		})
+	}
+}
+
+func TestStore_TokenGetByHash(t *testing.T) {
+	mem := NewStore(WithFixtures())
+	mem.Tokens = []domain.Token{{ID: "t111", Name: "admin", Role: domain.RoleSuperuser, Hash: "aaaa"}}
+	mem.reindex()
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr bool
+	}{
+		{"valid", "aaaa", false},
+		{"invalid", "bbbb", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mem.TokenGetByHash(tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Store.TokenGetByHash() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.ID != "t111" {
+				t.Errorf("Store.TokenGetByHash() got = %v, want t111", got.ID)
+			}
+		})
+	}
+}
+
+func TestStore_OrganizationUpdateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		orgID   string
+		wantErr bool
+	}{
+		{"valid", "abc", false},
+		{"invalid", "invalid", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(WithFixtures())
+			if err := mem.OrganizationUpdateKey(tt.orgID, []byte("new key")); (err != nil) != tt.wantErr {
+				t.Errorf("Store.OrganizationUpdateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			org, _ := mem.OrganizationGet(tt.orgID)
+			if string(org.RootKey) != "new key" {
+				t.Errorf("Store.OrganizationUpdateKey() key = %s, want new key", org.RootKey)
+			}
+		})
+	}
+}
+
+func TestStore_DeviceUpdateKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		deviceID string
+		current  []byte
+		wantErr  bool
+	}{
+		{"valid", "b222", []byte("old key"), false},
+		{"changed", "b222", []byte("other key"), true},
+		{"invalid", "invalid", []byte("old key"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(WithFixtures())
+			mem.Roll[1].Credentials.PrivateKey = []byte("old key")
+			err := mem.DeviceUpdateKey(tt.deviceID, tt.current, []byte("new key"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Store.DeviceUpdateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			want := "new key"
+			if tt.wantErr {
+				want = "old key"
+			}
+			if string(mem.Roll[1].Credentials.PrivateKey) != want {
+				t.Errorf("Store.DeviceUpdateKey() key = %s, want %s", mem.Roll[1].Credentials.PrivateKey, want)
+			}
+		})
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		orgs    int
+		devices int
+	}{
+		{"empty", nil, 0, 0},
+		{"fixtures", []Option{WithFixtures()}, 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore(tt.options...)
+			if len(mem.Orgs) != tt.orgs || len(mem.Roll) != tt.devices {
+				t.Errorf("NewStore() = %d orgs, %d devices, want %d, %d", len(mem.Orgs), len(mem.Roll), tt.orgs, tt.devices)
+			}
+			for _, en := range mem.Roll {
+				if _, err := mem.DeviceGetByID(en.ID); err != nil {
+					t.Errorf("Store.DeviceGetByID() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestStore_Concurrent(t *testing.T) {
+	mem := NewStore(WithFixtures())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			serial := fmt.Sprintf("serial-%d", i)
+			if _, err := mem.DeviceNew(datastore.DeviceNewRequest{OrganizationID: "abc", Brand: "example", Model: "drone-1000", SerialNumber: serial}); err != nil {
+				t.Errorf("Store.DeviceNew() error = %v", err)
+			}
+			if _, err := mem.DeviceEnroll(datastore.DeviceEnrollRequest{Brand: "example", Model: "drone-1000", SerialNumber: serial}); err != nil {
+				t.Errorf("Store.DeviceEnroll() error = %v", err)
+			}
+			_, _ = mem.DeviceList("abc")
+		}(i)
+	}
+	wg.Wait()
+
+	devices, err := mem.DeviceList("abc")
+	if err != nil || len(devices) != 23 {
+		t.Errorf("Store.DeviceList() count = %v, error = %v, want 23", len(devices), err)
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memory")
+	if err != nil {
+		t.Fatalf("cannot create snapshot directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "identity.json")
+
+	mem, err := OpenStore(path, WithFixtures())
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if _, err := mem.DeviceEnroll(datastore.DeviceEnrollRequest{Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", StoreID: "example-store"}); err != nil {
+		t.Fatalf("Store.DeviceEnroll() error = %v", err)
+	}
+	if _, err := mem.TokenNew(domain.Token{Name: "admin", Role: domain.RoleSuperuser, Hash: "aaaa"}); err != nil {
+		t.Fatalf("Store.TokenNew() error = %v", err)
+	}
+	if err := mem.DeviceDelete(domain.Tombstone{DeviceID: "c333", OrganizationID: "abc", Brand: "canonical", Model: "ubuntu-core-18-amd64", SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490", Deleted: time.Now()}); err != nil {
+		t.Fatalf("Store.DeviceDelete() error = %v", err)
+	}
+
+	// The changes are written together after the delay, or when the store is flushed
+	if data, _ := ioutil.ReadFile(path); bytes.Contains(data, []byte("aaaa")) {
+		t.Error("OpenStore() wrote the snapshot before the delay")
+	}
+	if err := mem.Flush(); err != nil {
+		t.Fatalf("Store.Flush() error = %v", err)
+	}
+
+	// The snapshot is loaded instead of the fixtures
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	en, err := reopened.DeviceGetByID("a111")
+	if err != nil {
+		t.Fatalf("Store.DeviceGetByID() error = %v", err)
+	}
+	if en.Status != domain.StatusEnrolled || en.Device.StoreID != "example-store" || en.EnrolledAt.IsZero() {
+		t.Errorf("Store.DeviceGetByID() = %v", en)
+	}
+	if org, err := reopened.OrganizationGet("abc"); err != nil || string(org.RootKey) != RootPEM {
+		t.Errorf("Store.OrganizationGet() = %v, error = %v, want the CA key", org, err)
+	}
+	if history, _ := reopened.DeviceHistory("a111"); len(history) != 1 || history[0].To != domain.StatusEnrolled {
+		t.Errorf("Store.DeviceHistory() = %v, want the enrollment", history)
+	}
+	if _, err := reopened.TokenGetByHash("aaaa"); err != nil {
+		t.Errorf("Store.TokenGetByHash() error = %v", err)
+	}
+	if _, err := reopened.DeviceGetByID("c333"); err == nil {
+		t.Error("Store.DeviceGetByID() expected error for a deleted device")
+	}
+	if _, err := reopened.TombstoneGet("canonical", "ubuntu-core-18-amd64", "d75f7300-abbf-4c11-bf0a-8b7103038490"); err != nil {
+		t.Errorf("Store.TombstoneGet() error = %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStore(path); err == nil {
+		t.Error("OpenStore() expected error for an invalid snapshot")
+	}
+}
@@ -0,0 +1,273 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// snapshotDelay is how long the changes to a persisted store are gathered before the
+// snapshot is written, so that a burst of changes rewrites the file once
+const snapshotDelay = time.Second
+
+// snapshot is the content of the store that is saved to disk. It has its own records,
+// as the JSON encoding of the domain types leaves out the private keys and token hashes
+type snapshot struct {
+	Organizations []snapshotOrganization   `json:"organizations"`
+	Devices       []snapshotDevice         `json:"devices"`
+	Nonces        []domain.Nonce           `json:"nonces"`
+	Revocations   []domain.Revocation      `json:"revocations"`
+	Tokens        []snapshotToken          `json:"tokens"`
+	Tombstones    []domain.Tombstone       `json:"tombstones"`
+	AuditEvents   []domain.AuditEvent      `json:"auditEvents"`
+	History       []domain.StatusChange    `json:"history"`
+	Outbox        []domain.OutboxEvent     `json:"outbox"`
+	Webhooks      []snapshotWebhook        `json:"webhooks"`
+	Deliveries    []domain.WebhookDelivery `json:"webhookDeliveries"`
+}
+
+type snapshotOrganization struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	RootCert         []byte            `json:"rootCert"`
+	RootKey          []byte            `json:"rootKey"`
+	KeyMode          domain.KeyMode    `json:"keyMode"`
+	CertValidityDays int               `json:"certValidityDays"`
+	KeyType          string            `json:"keyType"`
+	ACLTemplates     []domain.TopicACL `json:"aclTemplates"`
+	Broker           domain.Broker     `json:"broker"`
+}
+
+type snapshotDevice struct {
+	ID             string             `json:"id"`
+	OrganizationID string             `json:"orgid"`
+	Device         domain.Device      `json:"device"`
+	Credentials    domain.Credentials `json:"credentials"`
+	Status         domain.Status      `json:"status"`
+	DeviceData     string             `json:"deviceData"`
+	Created        time.Time          `json:"created"`
+	Updated        time.Time          `json:"updated"`
+	EnrolledAt     time.Time          `json:"enrolledAt"`
+	LastSeen       time.Time          `json:"lastSeen"`
+}
+
+type snapshotToken struct {
+	ID             string      `json:"id"`
+	Name           string      `json:"name"`
+	OrganizationID string      `json:"orgid"`
+	Role           domain.Role `json:"role"`
+	Hash           string      `json:"hash"`
+}
+
+type snapshotWebhook struct {
+	ID             string             `json:"id"`
+	OrganizationID string             `json:"orgid"`
+	URL            string             `json:"url"`
+	Events         []domain.EventType `json:"events"`
+	Secret         []byte             `json:"secret"`
+	Created        time.Time          `json:"created"`
+}
+
+// OpenStore creates a memory store that is saved to the snapshot file shortly after it
+// changes, so that it survives restarts. Flush writes the changes before the process
+// exits. The store is loaded from the file if it exists, otherwise it starts with the
+// records from the options
+func OpenStore(path string, options ...Option) (*Store, error) {
+	mem := NewStore(options...)
+	mem.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		mem.pending = true
+		return mem, mem.Flush()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the memory store snapshot: %v", err)
+	}
+
+	s := snapshot{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error parsing the memory store snapshot: %v", err)
+	}
+	mem.restore(s)
+	return mem, nil
+}
+
+// restore replaces the records with the ones from the snapshot
+func (mem *Store) restore(s snapshot) {
+	mem.Orgs = []domain.Organization{}
+	for _, o := range s.Organizations {
+		mem.Orgs = append(mem.Orgs, domain.Organization{
+			ID:               o.ID,
+			Name:             o.Name,
+			RootCert:         o.RootCert,
+			RootKey:          o.RootKey,
+			KeyMode:          o.KeyMode,
+			CertValidityDays: o.CertValidityDays,
+			KeyType:          o.KeyType,
+			ACLTemplates:     o.ACLTemplates,
+			Broker:           o.Broker,
+		})
+	}
+
+	mem.Roll = []domain.Enrollment{}
+	for _, d := range s.Devices {
+		mem.Roll = append(mem.Roll, domain.Enrollment{
+			ID:           d.ID,
+			Organization: domain.Organization{ID: d.OrganizationID},
+			Device:       d.Device,
+			Credentials:  d.Credentials,
+			Status:       d.Status,
+			DeviceData:   d.DeviceData,
+			Created:      d.Created,
+			Updated:      d.Updated,
+			EnrolledAt:   d.EnrolledAt,
+			LastSeen:     d.LastSeen,
+		})
+	}
+
+	mem.Tokens = []domain.Token{}
+	for _, t := range s.Tokens {
+		mem.Tokens = append(mem.Tokens, domain.Token{ID: t.ID, Name: t.Name, OrganizationID: t.OrganizationID, Role: t.Role, Hash: t.Hash})
+	}
+
+	mem.Nonces = s.Nonces
+	mem.Revocations = s.Revocations
+	mem.Tombstones = s.Tombstones
+	mem.AuditEvents = s.AuditEvents
+	mem.History = s.History
+	mem.Outbox = s.Outbox
+
+	mem.Webhooks = []domain.Webhook{}
+	for _, w := range s.Webhooks {
+		mem.Webhooks = append(mem.Webhooks, domain.Webhook{ID: w.ID, OrganizationID: w.OrganizationID, URL: w.URL, Events: w.Events, Secret: w.Secret, Created: w.Created})
+	}
+	mem.Deliveries = s.Deliveries
+	mem.reindex()
+}
+
+// save schedules a write of the snapshot file, if the store is persisted, with the lock
+// held. The changes until the write are saved together
+func (mem *Store) save() error {
+	if len(mem.path) == 0 || mem.pending {
+		return nil
+	}
+	mem.pending = true
+	time.AfterFunc(snapshotDelay, func() {
+		if err := mem.Flush(); err != nil {
+			log.Printf("Error saving the memory store snapshot: %v\n", err)
+		}
+	})
+	return nil
+}
+
+// Flush writes the pending changes to the snapshot file now, instead of after the delay
+func (mem *Store) Flush() error {
+	mem.flushLock.Lock()
+	defer mem.flushLock.Unlock()
+
+	mem.lock.Lock()
+	if !mem.pending {
+		mem.lock.Unlock()
+		return nil
+	}
+	mem.pending = false
+	data, err := mem.encode()
+	mem.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return mem.write(data)
+}
+
+// encode returns the JSON snapshot of the records, with the lock held
+func (mem *Store) encode() ([]byte, error) {
+	s := snapshot{Nonces: mem.Nonces, Revocations: mem.Revocations, Tombstones: mem.Tombstones, AuditEvents: mem.AuditEvents, History: mem.History, Outbox: mem.Outbox, Deliveries: mem.Deliveries}
+	for _, o := range mem.Orgs {
+		s.Organizations = append(s.Organizations, snapshotOrganization{
+			ID:               o.ID,
+			Name:             o.Name,
+			RootCert:         o.RootCert,
+			RootKey:          o.RootKey,
+			KeyMode:          o.KeyMode,
+			CertValidityDays: o.CertValidityDays,
+			KeyType:          o.KeyType,
+			ACLTemplates:     o.ACLTemplates,
+			Broker:           o.Broker,
+		})
+	}
+	for _, en := range mem.Roll {
+		s.Devices = append(s.Devices, snapshotDevice{
+			ID:             en.ID,
+			OrganizationID: en.Organization.ID,
+			Device:         en.Device,
+			Credentials:    en.Credentials,
+			Status:         en.Status,
+			DeviceData:     en.DeviceData,
+			Created:        en.Created,
+			Updated:        en.Updated,
+			EnrolledAt:     en.EnrolledAt,
+			LastSeen:       en.LastSeen,
+		})
+	}
+	for _, t := range mem.Tokens {
+		s.Tokens = append(s.Tokens, snapshotToken{ID: t.ID, Name: t.Name, OrganizationID: t.OrganizationID, Role: t.Role, Hash: t.Hash})
+	}
+	for _, w := range mem.Webhooks {
+		s.Webhooks = append(s.Webhooks, snapshotWebhook{ID: w.ID, OrganizationID: w.OrganizationID, URL: w.URL, Events: w.Events, Secret: w.Secret, Created: w.Created})
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding the memory store snapshot: %v", err)
+	}
+	return data, nil
+}
+
+// write replaces the snapshot file in one step, so a failed write does not lose the
+// previous snapshot
+func (mem *Store) write(data []byte) error {
+	// The snapshot holds the private keys, so only the service can read it
+	tmp, err := ioutil.TempFile(filepath.Dir(mem.path), filepath.Base(mem.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error saving the memory store snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error saving the memory store snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving the memory store snapshot: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), mem.path); err != nil {
+		return fmt.Errorf("error saving the memory store snapshot: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// OutboxQuery selects outbox events, oldest first. Empty filters match every event
+type OutboxQuery struct {
+	Status   domain.OutboxStatus
+	DeviceID string
+	Limit    int
+}
+
+// Normalize checks the query, setting the default page size
+func (q *OutboxQuery) Normalize() error {
+	if q.Limit < 0 || q.Limit > MaxPageLimit {
+		return fmt.Errorf("the limit must be from 1 to %d", MaxPageLimit)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageLimit
+	}
+	return nil
+}
+
+// Matches checks whether an event passes the filters of the query
+func (q OutboxQuery) Matches(e domain.OutboxEvent) bool {
+	return (q.Status == 0 || e.Status == q.Status) && (len(q.DeviceID) == 0 || e.DeviceID == q.DeviceID)
+}
+
+// NewOutboxEvent creates the pending event of a change to a device, which is due straight
+// away. The private key of the device and the details of its organization are left out
+func NewOutboxEvent(eventType domain.EventType, en domain.Enrollment, now time.Time) domain.OutboxEvent {
+	en.Credentials.PrivateKey = nil
+	en.Organization = domain.Organization{ID: en.Organization.ID}
+	return domain.OutboxEvent{
+		ID:             GenerateID(),
+		Type:           eventType,
+		OrganizationID: en.Organization.ID,
+		DeviceID:       en.ID,
+		Device:         en,
+		Status:         domain.OutboxPending,
+		NextAttempt:    now,
+		Created:        now,
+	}
+}
+
+// UpdateEventType returns the type of the outbox event for a change of status by an update,
+// if it is one that the sinks are told about: when the device can no longer connect
+func UpdateEventType(from, to domain.Status) (domain.EventType, bool) {
+	if from != to && (to == domain.StatusDisabled || from == domain.StatusEnrolled) {
+		return domain.EventDeviceDisable, true
+	}
+	return "", false
+}
+
+// EnrollmentColumn stores the device of an outbox event as JSON text in a column of the
+// SQL data stores
+type EnrollmentColumn struct {
+	Enrollment *domain.Enrollment
+}
+
+// Scan decodes the device from the column
+func (c EnrollmentColumn) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), c.Enrollment)
+	case []byte:
+		return json.Unmarshal(v, c.Enrollment)
+	default:
+		return fmt.Errorf("cannot scan a device from %T", src)
+	}
+}
+
+// Value encodes the device for the column
+func (c EnrollmentColumn) Value() (driver.Value, error) {
+	data, err := json.Marshal(c.Enrollment)
+	return string(data), err
+}
@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestUpdateEventType(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   domain.Status
+		to     domain.Status
+		wantOK bool
+	}{
+		{"disable-enrolled", domain.StatusEnrolled, domain.StatusDisabled, true},
+		{"disable-waiting", domain.StatusWaiting, domain.StatusDisabled, true},
+		{"re-enroll", domain.StatusEnrolled, domain.StatusWaiting, true},
+		{"enable", domain.StatusDisabled, domain.StatusWaiting, false},
+		{"unchanged", domain.StatusEnrolled, domain.StatusEnrolled, false},
+		{"unchanged-disabled", domain.StatusDisabled, domain.StatusDisabled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := UpdateEventType(tt.from, tt.to)
+			if ok != tt.wantOK || (ok && got != domain.EventDeviceDisable) {
+				t.Errorf("UpdateEventType() = %v, %v, want %v", got, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewOutboxEvent(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	en := domain.Enrollment{
+		ID:           "a111",
+		Organization: domain.Organization{ID: "abc", Name: "Example Inc", RootKey: []byte("root key")},
+		Credentials:  domain.Credentials{PrivateKey: []byte("device key"), Certificate: []byte("device cert")},
+		Status:       domain.StatusEnrolled,
+	}
+
+	e := NewOutboxEvent(domain.EventDeviceEnroll, en, now)
+	if len(e.ID) == 0 || e.Type != domain.EventDeviceEnroll || e.OrganizationID != "abc" || e.DeviceID != "a111" {
+		t.Errorf("NewOutboxEvent() = %+v", e)
+	}
+	if e.Status != domain.OutboxPending || !e.NextAttempt.Equal(now) || !e.Created.Equal(now) || !e.Delivered.IsZero() {
+		t.Errorf("NewOutboxEvent() = %+v, want a pending event that is due now", e)
+	}
+	if e.Device.Credentials.PrivateKey != nil || e.Device.Organization.Name != "" || e.Device.Organization.ID != "abc" {
+		t.Errorf("NewOutboxEvent() device = %+v, want no private key or organization details", e.Device)
+	}
+	if string(e.Device.Credentials.Certificate) != "device cert" || len(en.Credentials.PrivateKey) == 0 {
+		t.Errorf("NewOutboxEvent() changed the certificate or the enrollment")
+	}
+}
+
+func TestEnrollmentColumn(t *testing.T) {
+	en := domain.Enrollment{ID: "a111", Organization: domain.Organization{ID: "abc"}, Status: domain.StatusEnrolled}
+	value, err := EnrollmentColumn{Enrollment: &en}.Value()
+	if err != nil {
+		t.Fatalf("EnrollmentColumn.Value() error = %v", err)
+	}
+
+	// The column is read as text or bytes, depending on the driver
+	for _, src := range []interface{}{value, []byte(value.(string))} {
+		got := domain.Enrollment{}
+		if err := (EnrollmentColumn{Enrollment: &got}).Scan(src); err != nil {
+			t.Fatalf("EnrollmentColumn.Scan() error = %v", err)
+		}
+		if got.ID != en.ID || got.Organization.ID != en.Organization.ID || got.Status != en.Status {
+			t.Errorf("EnrollmentColumn.Scan() = %+v, want %+v", got, en)
+		}
+	}
+	for _, src := range []interface{}{int64(1), "not json"} {
+		if err := (EnrollmentColumn{Enrollment: &domain.Enrollment{}}).Scan(src); err == nil {
+			t.Errorf("EnrollmentColumn.Scan(%v) expected error", src)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// AuditNew appends an event to the audit log
+func (db *Store) AuditNew(event domain.AuditEvent) error {
+	_, err := db.Exec(createAuditSQL, event.ID, event.Time, event.Actor, event.Action, event.OrganizationID, event.DeviceID,
+		event.Before, event.After, event.Success, event.Reason, event.Details)
+	if err != nil {
+		log.Printf("Error creating audit event: %v\n", err)
+	}
+	return err
+}
+
+// AuditList fetches a page of the audit log
+func (db *Store) AuditList(query datastore.AuditQuery) (*datastore.AuditPage, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	after, _ := query.Position()
+
+	// The total counts the events that match the filters on every page
+	where, args := auditFilter(query)
+	page := &datastore.AuditPage{Events: []domain.AuditEvent{}}
+	if err := db.QueryRow(countAuditSQL+where, args...).Scan(&page.Total); err != nil {
+		log.Printf("Error counting audit events: %v\n", err)
+		return nil, err
+	}
+
+	if after != nil {
+		t, _ := after.Timestamp()
+		args = append(args, t, after.EventID)
+		where += fmt.Sprintf(" and (event_time, event_id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	// Fetch an extra event to find out if there is another page
+	args = append(args, query.Limit+1)
+	statement := fmt.Sprintf("%s%s order by event_time DESC, event_id DESC limit $%d", listAuditSQL, where, len(args))
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		log.Printf("Error retrieving audit events: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := domain.AuditEvent{}
+		err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.Action, &e.OrganizationID, &e.DeviceID, &e.Before, &e.After, &e.Success, &e.Reason, &e.Details)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Events) == query.Limit {
+			page.NextCursor = query.NextCursor(page.Events[len(page.Events)-1])
+			break
+		}
+		page.Events = append(page.Events, e)
+	}
+
+	return page, rows.Err()
+}
+
+// auditFilter returns the where clause and its arguments for the filters of an audit query
+func auditFilter(query datastore.AuditQuery) (string, []interface{}) {
+	conditions := []string{"true"}
+	args := []interface{}{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if len(query.OrganizationID) > 0 {
+		add("org_id=$%d", query.OrganizationID)
+	}
+	if len(query.DeviceID) > 0 {
+		add("device_id=$%d", query.DeviceID)
+	}
+	if len(query.Actor) > 0 {
+		add("actor=$%d", query.Actor)
+	}
+	if len(query.Action) > 0 {
+		add("action=$%d", query.Action)
+	}
+	if !query.After.IsZero() {
+		add("event_time>=$%d", query.After)
+	}
+	if !query.Before.IsZero() {
+		add("event_time<$%d", query.Before)
+	}
+
+	return " where " + strings.Join(conditions, " and "), args
+}
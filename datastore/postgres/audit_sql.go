@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+// The audit table is only appended to
+const createAuditTableSQL string = `
+	CREATE TABLE IF NOT EXISTS audit (
+		id                serial primary key not null,
+		event_id          varchar(200) not null unique,
+		event_time        timestamptz not null,
+		actor             varchar(200) not null,
+		action            varchar(200) not null,
+		org_id            varchar(200) default '',
+		device_id         varchar(200) default '',
+		before_status     int default 0,
+		after_status      int default 0,
+		success           bool not null,
+		reason            text default '',
+		details           text default ''
+	)
+`
+
+const createAuditTimeIndexSQL = "CREATE INDEX IF NOT EXISTS audit_time_idx ON audit (event_time, event_id)"
+
+const createAuditOrgIndexSQL = "CREATE INDEX IF NOT EXISTS audit_org_idx ON audit (org_id, event_time, event_id)"
+
+const createAuditSQL = `
+insert into audit (event_id, event_time, actor, action, org_id, device_id, before_status, after_status, success, reason, details)
+values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`
+
+// The page queries add the filters and limit
+const listAuditSQL = `
+select event_id, event_time, actor, action, org_id, device_id, before_status, after_status, success, reason, details
+from audit`
+
+const countAuditSQL = "select count(*) from audit"
@@ -20,111 +20,167 @@
 package postgres
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/canonical/iot-identity/datastore"
 	"github.com/canonical/iot-identity/domain"
 	"log"
 )
 
-// createDeviceTable creates the database table for devices with its indexes
-func (db *Store) createDeviceTable() error {
-	_, err := db.Exec(createDeviceTableSQL)
+// DeviceNew creates a new device registration
+func (db *Store) DeviceNew(d datastore.DeviceNewRequest) (string, error) {
+	deviceID := d.ID
+	if len(deviceID) == 0 {
+		deviceID = datastore.GenerateID()
+	}
+
+	err := db.deviceNew(deviceID, d)
 	if err != nil {
-		return err
+		log.Printf("Error creating device: %v\n", err)
 	}
 
-	_, err = db.Exec(createDeviceIDIndexSQL)
+	return deviceID, err
+}
+
+// deviceNew creates the device and records its first status in a single transaction
+func (db *Store) deviceNew(deviceID string, d datastore.DeviceNewRequest) error {
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = db.Exec(createDeviceBMSIndexSQL)
+	var id int64
+	err = tx.QueryRow(createDeviceSQL, deviceID, d.OrganizationID, d.Brand, d.Model, d.SerialNumber, d.Credentials.PrivateKey, d.Credentials.Certificate, d.Credentials.MQTTURL, d.Credentials.MQTTPort,
+		d.Credentials.MQTTProtocol, d.Credentials.MQTTCABundle, d.Credentials.ClientID, d.DeviceData, datastore.CertificateSerial(d.Credentials.Certificate)).Scan(&id)
 	if err != nil {
 		return err
 	}
+	if err := statusChange(tx, deviceID, 0, domain.StatusWaiting); err != nil {
+		return err
+	}
+	if err := outboxNew(tx, domain.EventDeviceRegister, deviceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	// The alter table calls may fail if the field already exists
-	_, _ = db.Exec(alterDeviceAddDeviceData)
-	return nil
+// DeviceGet fetches a device registration
+func (db *Store) DeviceGet(brand, model, serial string) (*domain.Enrollment, error) {
+	return db.deviceGetByQuery(getDeviceSQL, brand, model, serial)
 }
 
-// DeviceNew creates a new device registration
-func (db *Store) DeviceNew(d datastore.DeviceNewRequest) (string, error) {
-	var id int64
-	var deviceID = datastore.GenerateID()
+// DeviceGetByID fetches a device registration
+func (db *Store) DeviceGetByID(deviceID string) (*domain.Enrollment, error) {
+	return db.deviceGetByQuery(getDeviceByIDSQL, deviceID)
+}
 
-	err := db.QueryRow(createDeviceSQL, deviceID, d.OrganizationID, d.Brand, d.Model, d.SerialNumber, d.Credentials.PrivateKey, d.Credentials.Certificate, d.Credentials.MQTTURL, d.Credentials.MQTTPort, d.DeviceData).Scan(&id)
-	if err != nil {
-		log.Printf("Error creating device: %v\n", err)
-	}
+// DeviceGetByOrgID fetches a device registration, if it belongs to the organization
+func (db *Store) DeviceGetByOrgID(orgID, deviceID string) (*domain.Enrollment, error) {
+	return db.deviceGetByQuery(getDeviceByOrgIDSQL, deviceID, orgID)
+}
 
-	return deviceID, err
+// DeviceGetByCertificate fetches the device with the certificate serial number
+func (db *Store) DeviceGetByCertificate(serialNumber string) (*domain.Enrollment, error) {
+	return db.deviceGetByQuery(getDeviceByCertificateSQL, serialNumber)
 }
 
-// DeviceGet fetches a device registration
-func (db *Store) DeviceGet(brand, model, serial string) (*domain.Enrollment, error) {
-	d := domain.Enrollment{
-		Device:       domain.Device{},
-		Organization: domain.Organization{},
-		Credentials:  domain.Credentials{},
+// deviceGetByQuery fetches a device registration with a query that selects one device
+func (db *Store) deviceGetByQuery(query string, args ...interface{}) (*domain.Enrollment, error) {
+	d, err := scanDeviceRow(db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return d, fmt.Errorf("%w: error retrieving device: %v", datastore.ErrNotFound, err)
 	}
-
-	err := db.QueryRow(getDeviceSQL, brand, model, serial).Scan(
-		&d.ID, &d.Organization.ID, &d.Device.Brand, &d.Device.Model, &d.Device.SerialNumber,
-		&d.Credentials.PrivateKey, &d.Credentials.Certificate, &d.Credentials.MQTTURL, &d.Credentials.MQTTPort,
-		&d.Device.StoreID, &d.Device.DeviceKey, &d.Status, &d.DeviceData)
 	if err != nil {
 		log.Printf("Error retrieving device: %v\n", err)
-		return &d, fmt.Errorf("error retrieving device: %v", err)
+		return d, fmt.Errorf("error retrieving device: %v", err)
 	}
 
-	// Get the organization details for the device
-	org, err := db.OrganizationGet(d.Organization.ID)
+	// Get the organization details for the device, without its CA key
+	err = db.QueryRow(getDeviceOrganizationSQL, d.Organization.ID).Scan(&d.Organization.ID, &d.Organization.Name, &d.Organization.RootCert)
 	if err != nil {
 		log.Printf("Error retrieving device organization: %v\n", err)
-		return &d, fmt.Errorf("error retrieving device organization: %v", err)
+		return d, fmt.Errorf("error retrieving device organization: %v", err)
 	}
-	d.Organization = *org
-
-	return &d, err
+	return d, nil
 }
 
-// DeviceGetByID fetches a device registration
-func (db *Store) DeviceGetByID(deviceID string) (*domain.Enrollment, error) {
-	d := domain.Enrollment{
-		Device:       domain.Device{},
-		Organization: domain.Organization{},
-		Credentials:  domain.Credentials{},
-	}
+// scanDeviceRow reads a device from a query that selects one device, without the details
+// of its organization
+func scanDeviceRow(row *sql.Row) (*domain.Enrollment, error) {
+	d := domain.Enrollment{}
 
-	err := db.QueryRow(getDeviceByIDSQL, deviceID).Scan(
+	// The enrollment and last seen times are null until the device enrolls
+	var enrolledAt, lastSeen sql.NullTime
+	err := row.Scan(
 		&d.ID, &d.Organization.ID, &d.Device.Brand, &d.Device.Model, &d.Device.SerialNumber,
 		&d.Credentials.PrivateKey, &d.Credentials.Certificate, &d.Credentials.MQTTURL, &d.Credentials.MQTTPort,
-		&d.Device.StoreID, &d.Device.DeviceKey, &d.Status, &d.DeviceData)
+		&d.Credentials.MQTTProtocol, &d.Credentials.MQTTCABundle, &d.Credentials.ClientID,
+		&d.Device.StoreID, &d.Device.DeviceKey, &d.Status, &d.DeviceData, &d.Created, &d.Updated, &enrolledAt, &lastSeen,
+		datastore.ACLColumn{ACLs: &d.Credentials.ACLs})
 	if err != nil {
-		log.Printf("Error retrieving device: %v\n", err)
-		return &d, fmt.Errorf("error retrieving device: %v", err)
+		return &d, err
 	}
-
-	// Get the organization details for the device
-	org, err := db.OrganizationGet(d.Organization.ID)
-	if err != nil {
-		log.Printf("Error retrieving device organization: %v\n", err)
-		return &d, fmt.Errorf("error retrieving device organization: %v", err)
-	}
-	d.Organization = *org
-
-	return &d, err
+	d.EnrolledAt, d.LastSeen = enrolledAt.Time, lastSeen.Time
+	return &d, nil
 }
 
 // DeviceEnroll enrolls a device with the IoT service
 func (db *Store) DeviceEnroll(d datastore.DeviceEnrollRequest) (*domain.Enrollment, error) {
-	_, err := db.Exec(enrollDeviceSQL, d.Brand, d.Model, d.SerialNumber, d.StoreID, d.DeviceKey, domain.StatusEnrolled)
+	if err := db.deviceEnroll(d); err != nil {
+		return nil, err
+	}
+	return db.DeviceGet(d.Brand, d.Model, d.SerialNumber)
+}
+
+// deviceEnroll updates the device details, certificate and status history in a single transaction
+func (db *Store) deviceEnroll(d datastore.DeviceEnrollRequest) error {
+	tx, err := db.Begin()
 	if err != nil {
 		log.Printf("Error updating the device: %v\n", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	return db.DeviceGet(d.Brand, d.Model, d.SerialNumber)
+	var deviceID string
+	var status domain.Status
+	err = tx.QueryRow(getDeviceStatusSQL, d.Brand, d.Model, d.SerialNumber).Scan(&deviceID, &status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: the device `%s/%s/%s` is not registered", datastore.ErrNotFound, d.Brand, d.Model, d.SerialNumber)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the device: %v\n", err)
+		return err
+	}
+
+	if _, err := tx.Exec(enrollDeviceSQL, d.Brand, d.Model, d.SerialNumber, d.StoreID, d.DeviceKey, domain.StatusEnrolled, datastore.ACLColumn{ACLs: &d.ACLs}); err != nil {
+		log.Printf("Error updating the device: %v\n", err)
+		return err
+	}
+
+	if len(d.Certificate) > 0 {
+		_, err = tx.Exec(enrollDeviceCertSQL, d.Brand, d.Model, d.SerialNumber, d.Certificate, d.PrivateKey, datastore.CertificateSerial(d.Certificate))
+		if err != nil {
+			log.Printf("Error updating the device certificate: %v\n", err)
+			return err
+		}
+	}
+
+	if len(d.MQTT.MQTTURL) > 0 {
+		_, err = tx.Exec(updateDeviceBrokerSQL, deviceID, d.MQTT.MQTTURL, d.MQTT.MQTTPort, d.MQTT.MQTTProtocol, d.MQTT.MQTTCABundle, d.MQTT.ClientID)
+		if err != nil {
+			log.Printf("Error updating the device broker: %v\n", err)
+			return err
+		}
+	}
+
+	if err := statusChange(tx, deviceID, status, domain.StatusEnrolled); err != nil {
+		return err
+	}
+	if err := outboxNew(tx, domain.EventDeviceEnroll, deviceID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // DeviceList fetches the device registrations for an organization
@@ -139,24 +195,95 @@ func (db *Store) DeviceList(orgID string) ([]domain.Enrollment, error) {
 	devices := []domain.Enrollment{}
 	for rows.Next() {
 		d := domain.Enrollment{}
+		var enrolledAt, lastSeen sql.NullTime
 		err := rows.Scan(&d.ID, &d.Organization.ID, &d.Device.Brand, &d.Device.Model, &d.Device.SerialNumber,
 			&d.Credentials.Certificate, &d.Credentials.MQTTURL, &d.Credentials.MQTTPort,
-			&d.Device.StoreID, &d.Device.DeviceKey, &d.Status, &d.DeviceData)
+			&d.Credentials.MQTTProtocol, &d.Credentials.MQTTCABundle, &d.Credentials.ClientID,
+			&d.Device.StoreID, &d.Device.DeviceKey, &d.Status, &d.DeviceData, &d.Created, &d.Updated, &enrolledAt, &lastSeen,
+			datastore.ACLColumn{ACLs: &d.Credentials.ACLs})
 		if err != nil {
 			return nil, err
 		}
+		d.EnrolledAt, d.LastSeen = enrolledAt.Time, lastSeen.Time
 		devices = append(devices, d)
 	}
 
 	return devices, nil
 }
 
-// DeviceUpdate updates a device registration
+// DeviceRenew replaces the certificate and private key of a device, recording the renewal in the outbox
+func (db *Store) DeviceRenew(deviceID string, certificate, privateKey []byte) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error renewing the device certificate: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(renewDeviceSQL, deviceID, certificate, privateKey, datastore.CertificateSerial(certificate))
+	if err != nil {
+		log.Printf("Error renewing the device certificate: %v\n", err)
+		return err
+	}
+	if err := checkUpdated(result, "the device `%s` is not registered", deviceID); err != nil {
+		return err
+	}
+	if err := outboxNew(tx, domain.EventDeviceRenew, deviceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeviceUpdateKey replaces the stored private key of a device, if it has not changed from the current key
+func (db *Store) DeviceUpdateKey(deviceID string, current, privateKey []byte) error {
+	result, err := db.Exec(updateDeviceKeySQL, deviceID, current, privateKey)
+	if err != nil {
+		log.Printf("Error updating the device key: %v\n", err)
+		return err
+	}
+	return checkUpdated(result, "the device `%s` with the current key is not registered", deviceID)
+}
+
+// DeviceUpdateBroker replaces the broker details of the credentials of a device
+func (db *Store) DeviceUpdateBroker(deviceID string, mqtt domain.Credentials) error {
+	result, err := db.Exec(updateDeviceBrokerSQL, deviceID, mqtt.MQTTURL, mqtt.MQTTPort, mqtt.MQTTProtocol, mqtt.MQTTCABundle, mqtt.ClientID)
+	if err != nil {
+		log.Printf("Error updating the device broker: %v\n", err)
+		return err
+	}
+	return checkUpdated(result, "the device `%s` is not registered", deviceID)
+}
+
+// DeviceUpdate updates a device registration, recording the change of status
 func (db *Store) DeviceUpdate(deviceID string, status domain.Status, deviceData string) error {
-	_, err := db.Exec(updateDeviceSQL, deviceID, status, deviceData)
+	tx, err := db.Begin()
 	if err != nil {
 		log.Printf("Error updating the device: %v\n", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	return err
+	var previous domain.Status
+	err = tx.QueryRow(getDeviceStatusByIDSQL, deviceID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, deviceID)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the device: %v\n", err)
+		return err
+	}
+
+	if _, err := tx.Exec(updateDeviceSQL, deviceID, status, deviceData); err != nil {
+		log.Printf("Error updating the device: %v\n", err)
+		return err
+	}
+	if err := statusChange(tx, deviceID, previous, status); err != nil {
+		return err
+	}
+	if eventType, ok := datastore.UpdateEventType(previous, status); ok {
+		if err := outboxNew(tx, eventType, deviceID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// sortColumns are the columns for the sort orders of device listings
+var sortColumns = map[string]string{
+	datastore.SortCreated: "created",
+	datastore.SortUpdated: "updated",
+	datastore.SortSerial:  "serial_number",
+}
+
+// DeviceListPage fetches a page of the devices for an organization
+func (db *Store) DeviceListPage(query datastore.DeviceQuery) (*datastore.DevicePage, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	after, _ := query.After()
+
+	// The total counts the devices that match the filters on every page
+	where, args := deviceFilter(query)
+	page := &datastore.DevicePage{Devices: []domain.Enrollment{}}
+	if err := db.QueryRow(countDevicePageSQL+where, args...).Scan(&page.Total); err != nil {
+		log.Printf("Error counting devices: %v\n", err)
+		return nil, err
+	}
+
+	column := sortColumns[query.Sort]
+	order, direction := "ASC", ">"
+	if query.Descending {
+		order, direction = "DESC", "<"
+	}
+	if after != nil {
+		var value interface{} = after.Value
+		if query.Sort != datastore.SortSerial {
+			value, _ = after.Time()
+		}
+		args = append(args, value, after.DeviceID)
+		where += fmt.Sprintf(" and (%s, device_id) %s ($%d, $%d)", column, direction, len(args)-1, len(args))
+	}
+
+	// Fetch an extra device to find out if there is another page
+	args = append(args, query.Limit+1)
+	statement := fmt.Sprintf("%s%s order by %s %s, device_id %s limit $%d", listDevicePageSQL, where, column, order, order, len(args))
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		log.Printf("Error retrieving devices: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := domain.Enrollment{}
+		var enrolledAt, lastSeen sql.NullTime
+		err := rows.Scan(&d.ID, &d.Organization.ID, &d.Device.Brand, &d.Device.Model, &d.Device.SerialNumber,
+			&d.Credentials.Certificate, &d.Credentials.MQTTURL, &d.Credentials.MQTTPort,
+			&d.Credentials.MQTTProtocol, &d.Credentials.MQTTCABundle, &d.Credentials.ClientID,
+			&d.Device.StoreID, &d.Device.DeviceKey, &d.Status, &d.DeviceData, &d.Created, &d.Updated, &enrolledAt, &lastSeen,
+			datastore.ACLColumn{ACLs: &d.Credentials.ACLs})
+		if err != nil {
+			return nil, err
+		}
+		d.EnrolledAt, d.LastSeen = enrolledAt.Time, lastSeen.Time
+		if len(page.Devices) == query.Limit {
+			page.NextCursor = query.NextCursor(page.Devices[len(page.Devices)-1])
+			break
+		}
+		page.Devices = append(page.Devices, d)
+	}
+
+	return page, rows.Err()
+}
+
+// deviceFilter returns the where clause and its arguments for the filters of a device query
+func deviceFilter(query datastore.DeviceQuery) (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	add("org_id=$%d", query.OrganizationID)
+	if query.Status != 0 {
+		add("status=$%d", query.Status)
+	}
+	if len(query.Brand) > 0 {
+		add("brand=$%d", query.Brand)
+	}
+	if len(query.Model) > 0 {
+		add("model=$%d", query.Model)
+	}
+	if len(query.SerialPrefix) > 0 {
+		add("strpos(serial_number, $%d)=1", query.SerialPrefix)
+	}
+	if !query.CreatedAfter.IsZero() {
+		add("created>=$%d", query.CreatedAfter)
+	}
+	if !query.CreatedBefore.IsZero() {
+		add("created<$%d", query.CreatedBefore)
+	}
+	if !query.UpdatedAfter.IsZero() {
+		add("updated>=$%d", query.UpdatedAfter)
+	}
+	if !query.UpdatedBefore.IsZero() {
+		add("updated<$%d", query.UpdatedBefore)
+	}
+	if len(query.DeviceData) > 0 {
+		add("strpos(device_data, $%d)>0", query.DeviceData)
+	}
+
+	return " where " + strings.Join(conditions, " and "), args
+}
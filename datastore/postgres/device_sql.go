@@ -35,7 +35,6 @@ const createDeviceTableSQL string = `
 		store_id          varchar(200) default '',
 		device_key        text default '',
 		status            int default 1,
-        device_data       text default '',
 
         UNIQUE (device_id),
         UNIQUE (brand, model, serial_number)
@@ -47,35 +46,140 @@ const createDeviceIDIndexSQL = "CREATE INDEX IF NOT EXISTS device_id_idx ON devi
 const createDeviceBMSIndexSQL = "CREATE INDEX IF NOT EXISTS bms_idx ON device (brand, model, serial_number)"
 
 const createDeviceSQL = `
-insert into device (device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, device_data)
-values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING id`
+insert into device (device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, device_data, cred_serial)
+values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING id`
 
 const getDeviceSQL = `
-select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, store_id, device_key, status, device_data
+select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
 from device
 where brand=$1 and model=$2 and serial_number=$3`
 
 const getDeviceByIDSQL = `
-select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, store_id, device_key, status, device_data
+select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
 from device
 where device_id=$1`
 
+const getDeviceByOrgIDSQL = `
+select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device
+where device_id=$1 and org_id=$2`
+
+const getDeviceByCertificateSQL = `
+select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device
+where cred_serial=$1`
+
 const enrollDeviceSQL = `
 update device
-set store_id=$4, device_key=$5, status=$6
+set store_id=$4, device_key=$5, status=$6, updated=current_timestamp, enrolled_at=current_timestamp, last_seen=current_timestamp, acls=$7
+where brand=$1 and model=$2 and serial_number=$3
+`
+
+// Replaces the credentials with a certificate signed from the device's request
+const enrollDeviceCertSQL = `
+update device
+set cred_key=$5, cred_cert=$4, cred_serial=$6, updated=current_timestamp
 where brand=$1 and model=$2 and serial_number=$3
 `
 
+const renewDeviceSQL = `
+update device
+set cred_cert=$2, cred_key=$3, cred_serial=$4, updated=current_timestamp, last_seen=current_timestamp
+where device_id=$1
+`
+
+// Replaces the broker details when a device enrolls or its credentials are reissued
+const updateDeviceBrokerSQL = `
+update device
+set cred_mqtt=$2, cred_port=$3, cred_protocol=$4, cred_ca=$5, cred_client_id=$6, updated=current_timestamp
+where device_id=$1
+`
+
+// Replaces the private key only if it is unchanged, so a concurrent renewal is not overwritten
+const updateDeviceKeySQL = `
+update device
+set cred_key=$3
+where device_id=$1 and cred_key=$2
+`
+
 const updateDeviceSQL = `
 update device
-set status=$2, device_data=$3
+set status=$2, device_data=$3, updated=current_timestamp
 where device_id=$1
 `
 
 const listDeviceSQL = `
-select device_id, org_id, brand, model, serial_number, cred_cert, cred_mqtt, cred_port, store_id, device_key, status, device_data
+select device_id, org_id, brand, model, serial_number, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
 from device
 where org_id=$1`
 
+// The page queries add the filters, sort order and limit
+const listDevicePageSQL = `
+select device_id, org_id, brand, model, serial_number, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device`
+
+const countDevicePageSQL = "select count(*) from device"
+
+// Add the created and updated fields, with the indexes for the sort orders of device listings
+const alterDeviceAddCreated = "ALTER TABLE device ADD COLUMN IF NOT EXISTS created TIMESTAMPTZ NOT NULL DEFAULT current_timestamp"
+
+const alterDeviceAddUpdated = "ALTER TABLE device ADD COLUMN IF NOT EXISTS updated TIMESTAMPTZ NOT NULL DEFAULT current_timestamp"
+
+const createDeviceCreatedIndexSQL = "CREATE INDEX IF NOT EXISTS device_created_idx ON device (org_id, created, device_id)"
+
+const createDeviceUpdatedIndexSQL = "CREATE INDEX IF NOT EXISTS device_updated_idx ON device (org_id, updated, device_id)"
+
+const createDeviceSerialIndexSQL = "CREATE INDEX IF NOT EXISTS device_serial_idx ON device (org_id, serial_number, device_id)"
+
+// Add the enrollment and last seen times, which are null until the device enrolls
+const alterDeviceAddEnrolledAt = "ALTER TABLE device ADD COLUMN IF NOT EXISTS enrolled_at TIMESTAMPTZ"
+
+const alterDeviceAddLastSeen = "ALTER TABLE device ADD COLUMN IF NOT EXISTS last_seen TIMESTAMPTZ"
+
+// Add the topic ACLs of devices, rendered when they enroll
+const alterDeviceAddACLs = "ALTER TABLE device ADD COLUMN IF NOT EXISTS acls TEXT DEFAULT ''"
+
+// Add the broker details of the credentials from the organization's broker
+const alterDeviceAddProtocol = "ALTER TABLE device ADD COLUMN IF NOT EXISTS cred_protocol VARCHAR(20) DEFAULT ''"
+
+const alterDeviceAddCA = "ALTER TABLE device ADD COLUMN IF NOT EXISTS cred_ca TEXT DEFAULT ''"
+
+const alterDeviceAddClientID = "ALTER TABLE device ADD COLUMN IF NOT EXISTS cred_client_id VARCHAR(200) DEFAULT ''"
+
+// Add the serial number of the device certificate, which is null until it is filled in
+// from the certificate of an existing device
+const alterDeviceAddCertSerial = "ALTER TABLE device ADD COLUMN IF NOT EXISTS cred_serial VARCHAR(200)"
+
+const createDeviceCertSerialIndexSQL = "CREATE INDEX IF NOT EXISTS device_cred_serial_idx ON device (cred_serial)"
+
+// The certificates of existing devices are read in batches to fill in their serial numbers
+const listDeviceCertificateSQL = `
+select device_id, cred_cert
+from device
+where cred_serial is null
+limit 1000`
+
+const updateDeviceCertSerialSQL = `
+update device
+set cred_serial=$2
+where device_id=$1`
+
+// The status is read and locked in the transaction that changes it, to record the transition
+const getDeviceStatusSQL = `
+select device_id, status
+from device
+where brand=$1 and model=$2 and serial_number=$3
+for update`
+
+const getDeviceStatusByIDSQL = `
+select status
+from device
+where device_id=$1
+for update`
+
 // Add the device_data field to store a base64-encoded file
-const alterDeviceAddDeviceData = "ALTER TABLE device ADD COLUMN device_data TEXT DEFAULT ''"
+const alterDeviceAddDeviceData = "ALTER TABLE device ADD COLUMN IF NOT EXISTS device_data TEXT DEFAULT ''"
+
+const deleteDeviceSQL = `
+delete from device
+where device_id=$1`
@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// statusChange records a transition in the status of a device in a transaction, if the status changed
+func statusChange(tx *sql.Tx, deviceID string, from, to domain.Status) error {
+	if from == to {
+		return nil
+	}
+	_, err := tx.Exec(createHistorySQL, deviceID, from, to)
+	if err != nil {
+		log.Printf("Error recording the device status change: %v\n", err)
+	}
+	return err
+}
+
+// DeviceHistory fetches the status transitions of a device, oldest first
+func (db *Store) DeviceHistory(deviceID string) ([]domain.StatusChange, error) {
+	rows, err := db.Query(listHistorySQL, deviceID)
+	if err != nil {
+		log.Printf("Error retrieving the device history: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []domain.StatusChange{}
+	for rows.Next() {
+		c := domain.StatusChange{}
+		if err := rows.Scan(&c.DeviceID, &c.From, &c.To, &c.Time); err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+const createHistoryTableSQL string = `
+	CREATE TABLE IF NOT EXISTS device_history (
+		id                serial primary key not null,
+		device_id         varchar(200) not null,
+		from_status       int not null,
+		to_status         int not null,
+		changed           timestamptz not null default current_timestamp
+	)
+`
+
+const createHistoryDeviceIndexSQL = "CREATE INDEX IF NOT EXISTS device_history_device_idx ON device_history (device_id, changed)"
+
+// The change is timestamped with the start of the transaction, the same as the device update
+const createHistorySQL = `
+insert into device_history (device_id, from_status, to_status, changed)
+values ($1,$2,$3,current_timestamp)`
+
+const listHistorySQL = `
+select device_id, from_status, to_status, changed
+from device_history
+where device_id=$1
+order by changed, id`
+
+const deleteHistorySQL = `
+delete from device_history
+where device_id=$1`
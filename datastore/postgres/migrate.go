@@ -0,0 +1,222 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+)
+
+// migration is a versioned change to the database schema. Released migrations must not
+// be changed, as they will not be applied again: schema changes need a new migration
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations is the ordered list of schema changes. The statements are idempotent,
+// so they can be applied to databases that were created before versioned migrations
+var migrations = []migration{
+	{1, "Create the organization, device and nonce tables", []string{
+		createOrganizationTableSQL, createDeviceTableSQL, createDeviceIDIndexSQL, createDeviceBMSIndexSQL, createNonceTableSQL,
+	}},
+	{2, "Add the key mode and certificate validity of organizations", []string{
+		alterOrganizationAddKeyMode, alterOrganizationAddCertValidity,
+	}},
+	{3, "Add the device data of devices", []string{alterDeviceAddDeviceData}},
+	{4, "Create the revocation table", []string{createRevocationTableSQL}},
+	{5, "Add the key type of organizations", []string{alterOrganizationAddKeyType}},
+	{6, "Create the token table", []string{createTokenTableSQL}},
+	{7, "Make organization names unique", []string{createOrganizationNameIndexSQL}},
+	{8, "Add the created and updated times of devices", []string{
+		alterDeviceAddCreated, alterDeviceAddUpdated, createDeviceCreatedIndexSQL, createDeviceUpdatedIndexSQL, createDeviceSerialIndexSQL,
+	}},
+	{9, "Create the tombstone table for deleted devices", []string{createTombstoneTableSQL, createTombstoneBMSIndexSQL}},
+	{10, "Create the audit table", []string{createAuditTableSQL, createAuditTimeIndexSQL, createAuditOrgIndexSQL}},
+	{11, "Add the enrollment and last seen times of devices, and their status history", []string{
+		alterDeviceAddEnrolledAt, alterDeviceAddLastSeen, createHistoryTableSQL, createHistoryDeviceIndexSQL,
+	}},
+	{12, "Add the topic ACL templates of organizations and the topic ACLs of devices", []string{
+		alterOrganizationAddACLTemplates, alterDeviceAddACLs,
+	}},
+	{13, "Create the outbox table", []string{createOutboxTableSQL, createOutboxStatusIndexSQL}},
+	{14, "Add the MQTT broker of organizations and the broker details of device credentials", []string{
+		alterOrganizationAddBroker, alterDeviceAddProtocol, alterDeviceAddCA, alterDeviceAddClientID,
+	}},
+	{15, "Create the webhook and webhook delivery tables", []string{
+		createWebhookTableSQL, createWebhookOrgIndexSQL,
+		createWebhookDeliveryTableSQL, createWebhookDeliveryStatusIndexSQL, createWebhookDeliveryWebhookIndexSQL,
+	}},
+	{16, "Add the serial numbers of device certificates", []string{alterDeviceAddCertSerial, createDeviceCertSerialIndexSQL}},
+	{17, "Add the claims of outbox events", []string{alterOutboxAddLeaseID, alterOutboxAddLeaseUntil, createOutboxLeaseIndexSQL}},
+	{18, "Add the index of the outbox events of devices", []string{createOutboxDeviceIndexSQL}},
+	{19, "Add the claims of webhook deliveries", []string{
+		alterWebhookDeliveryAddLeaseID, alterWebhookDeliveryAddLeaseUntil, createWebhookDeliveryLeaseIndexSQL,
+	}},
+}
+
+// backfills fill in the data of a migration that cannot be computed in SQL. They run
+// after the statements of the migration, in its transaction
+var backfills = map[int]func(tx *sql.Tx) error{
+	16: backfillCertificateSerials,
+}
+
+// MigrationStatus is the state of a schema migration in the database
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     time.Time // zero when the migration is pending
+}
+
+// Migrate applies the pending schema migrations in order, returning the number applied
+func (db *Store) Migrate() (int, error) {
+	count := 0
+	for _, m := range migrations {
+		applied, err := db.migrate(m)
+		if err != nil {
+			return count, err
+		}
+		if applied {
+			log.Printf("Applied schema migration %d: %s\n", m.version, m.description)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// migrate applies a migration in a transaction, unless it has already been applied
+func (db *Store) migrate(m migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting migration %d: %v", m.version, err)
+	}
+	defer tx.Rollback()
+
+	// The lock is released when the transaction ends
+	if _, err = tx.Exec(lockMigrationSQL, migrationLockID); err != nil {
+		return false, fmt.Errorf("error locking migration %d: %v", m.version, err)
+	}
+	if _, err = tx.Exec(createSchemaVersionTableSQL); err != nil {
+		return false, fmt.Errorf("error creating the schema version table: %v", err)
+	}
+
+	// Another replica may have applied the migration while we waited for the lock
+	var applied bool
+	if err = tx.QueryRow(isMigrationAppliedSQL, m.version).Scan(&applied); err != nil {
+		return false, fmt.Errorf("error checking migration %d: %v", m.version, err)
+	}
+	if applied {
+		return false, nil
+	}
+
+	for _, statement := range m.statements {
+		if _, err = tx.Exec(statement); err != nil {
+			return false, fmt.Errorf("error applying migration %d (%s): %v", m.version, m.description, err)
+		}
+	}
+	if backfill, ok := backfills[m.version]; ok {
+		if err = backfill(tx); err != nil {
+			return false, fmt.Errorf("error applying migration %d (%s): %v", m.version, m.description, err)
+		}
+	}
+	if _, err = tx.Exec(createSchemaVersionSQL, m.version, m.description); err != nil {
+		return false, fmt.Errorf("error recording migration %d: %v", m.version, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing migration %d: %v", m.version, err)
+	}
+	return true, nil
+}
+
+// backfillCertificateSerials stores the serial numbers of the certificates of existing devices
+func backfillCertificateSerials(tx *sql.Tx) error {
+	for {
+		certificates, err := listDeviceCertificates(tx)
+		if err != nil || len(certificates) == 0 {
+			return err
+		}
+		for deviceID, certPEM := range certificates {
+			if _, err := tx.Exec(updateDeviceCertSerialSQL, deviceID, datastore.CertificateSerial(certPEM)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// listDeviceCertificates fetches a batch of the certificates of devices without a serial number
+func listDeviceCertificates(tx *sql.Tx) (map[string][]byte, error) {
+	rows, err := tx.Query(listDeviceCertificateSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certificates := map[string][]byte{}
+	for rows.Next() {
+		var deviceID string
+		var certPEM []byte
+		if err := rows.Scan(&deviceID, &certPEM); err != nil {
+			return nil, err
+		}
+		certificates[deviceID] = certPEM
+	}
+	return certificates, rows.Err()
+}
+
+// MigrationStatus fetches the state of each schema migration
+func (db *Store) MigrationStatus() ([]MigrationStatus, error) {
+	applied := map[int]time.Time{}
+
+	var exists bool
+	if err := db.QueryRow(existsSchemaVersionTableSQL).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("error checking the schema version table: %v", err)
+	}
+	if exists {
+		rows, err := db.Query(listSchemaVersionSQL)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving schema versions: %v", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var version int
+			var when time.Time
+			if err := rows.Scan(&version, &when); err != nil {
+				return nil, fmt.Errorf("error retrieving schema versions: %v", err)
+			}
+			applied[version] = when
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error retrieving schema versions: %v", err)
+		}
+	}
+
+	status := []MigrationStatus{}
+	for _, m := range migrations {
+		status = append(status, MigrationStatus{Version: m.version, Description: m.description, Applied: applied[m.version]})
+	}
+	return status, nil
+}
@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+// migrationLockID is the key of the advisory lock held while migrating, so that
+// replicas that start at the same time do not apply the same migration
+const migrationLockID int64 = 7305231640279392
+
+const createSchemaVersionTableSQL = `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version           int primary key not null,
+		description       text not null,
+		applied           timestamptz not null default current_timestamp
+	)
+`
+
+const lockMigrationSQL = "SELECT pg_advisory_xact_lock($1)"
+
+const existsSchemaVersionTableSQL = "SELECT to_regclass('schema_version') IS NOT NULL"
+
+const isMigrationAppliedSQL = `
+select exists(select 1 from schema_version where version=$1)`
+
+const createSchemaVersionSQL = `
+insert into schema_version (version, description)
+values ($1,$2)`
+
+const listSchemaVersionSQL = `
+select version, applied
+from schema_version`
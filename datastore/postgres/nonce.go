@@ -0,0 +1,52 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// NonceNew stores a nonce for a device, removing any expired nonces
+func (db *Store) NonceNew(nonce domain.Nonce) error {
+	_, err := db.Exec(deleteExpiredNonceSQL)
+	if err != nil {
+		log.Printf("Error removing expired nonces: %v\n", err)
+	}
+
+	_, err = db.Exec(createNonceSQL, nonce.Value, nonce.Brand, nonce.Model, nonce.SerialNumber, nonce.Expires)
+	if err != nil {
+		log.Printf("Error creating nonce: %v\n", err)
+	}
+	return err
+}
+
+// NonceUse fetches and removes a nonce for a device, so it can only be used once
+func (db *Store) NonceUse(value, brand, model, serial string) (*domain.Nonce, error) {
+	n := domain.Nonce{}
+	err := db.QueryRow(useNonceSQL, value, brand, model, serial).Scan(&n.Value, &n.Brand, &n.Model, &n.SerialNumber, &n.Expires)
+	if err != nil {
+		log.Printf("Error retrieving nonce: %v\n", err)
+		return nil, fmt.Errorf("the nonce for device `%s/%s/%s` is not valid", brand, model, serial)
+	}
+	return &n, nil
+}
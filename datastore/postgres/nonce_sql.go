@@ -0,0 +1,44 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+const createNonceTableSQL string = `
+	CREATE TABLE IF NOT EXISTS nonce (
+		id                serial primary key not null,
+		nonce             varchar(200) not null unique,
+		brand             varchar(200) not null,
+		model             varchar(200) not null,
+		serial_number     varchar(200) not null,
+		expires           timestamptz not null
+	)
+`
+
+const createNonceSQL = `
+insert into nonce (nonce, brand, model, serial_number, expires)
+values ($1,$2,$3,$4,$5)`
+
+const deleteExpiredNonceSQL = `
+delete from nonce
+where expires<now()`
+
+const useNonceSQL = `
+delete from nonce
+where nonce=$1 and brand=$2 and model=$3 and serial_number=$4
+returning nonce, brand, model, serial_number, expires`
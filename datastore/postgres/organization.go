@@ -25,17 +25,15 @@ import (
 	"log"
 )
 
-// createOrganizationTable creates the database table for organizations with its indexes
-func (db *Store) createOrganizationTable() error {
-	_, err := db.Exec(createOrganizationTableSQL)
-	return err
-}
-
 // OrganizationNew creates a new organization
 func (db *Store) OrganizationNew(org datastore.OrganizationNewRequest) (string, error) {
 	var id int64
 	var orgID = datastore.GenerateID()
-	err := db.QueryRow(createOrganizationSQL, orgID, org.Name, org.CountryName, org.ServerCert, org.ServerKey).Scan(&id)
+	keyMode := org.KeyMode
+	if keyMode == 0 {
+		keyMode = domain.KeyModeServer
+	}
+	err := db.QueryRow(createOrganizationSQL, orgID, org.Name, org.CountryName, org.ServerCert, org.ServerKey, keyMode, org.CertValidityDays, org.KeyType, datastore.ACLColumn{ACLs: &org.ACLTemplates}, datastore.BrokerColumn{Broker: &org.Broker}).Scan(&id)
 	if err != nil {
 		log.Printf("Error creating organization: %v\n", err)
 	}
@@ -56,7 +54,7 @@ func (db *Store) OrganizationList() ([]domain.Organization, error) {
 	items := []domain.Organization{}
 	for rows.Next() {
 		item := domain.Organization{}
-		err := rows.Scan(&id, &item.ID, &item.Name, &item.RootCert)
+		err := rows.Scan(&id, &item.ID, &item.Name, &item.RootCert, &item.KeyMode, &item.CertValidityDays, &item.KeyType, datastore.ACLColumn{ACLs: &item.ACLTemplates}, datastore.BrokerColumn{Broker: &item.Broker})
 		if err != nil {
 			return nil, err
 		}
@@ -72,7 +70,7 @@ func (db *Store) OrganizationGet(orgID string) (*domain.Organization, error) {
 	var countryName string
 	org := domain.Organization{}
 
-	err := db.QueryRow(getOrganizationSQL, orgID).Scan(&id, &org.ID, &org.Name, &countryName, &org.RootCert, &org.RootKey)
+	err := db.QueryRow(getOrganizationSQL, orgID).Scan(&id, &org.ID, &org.Name, &countryName, &org.RootCert, &org.RootKey, &org.KeyMode, &org.CertValidityDays, &org.KeyType, datastore.ACLColumn{ACLs: &org.ACLTemplates}, datastore.BrokerColumn{Broker: &org.Broker})
 	if err != nil {
 		log.Printf("Error retrieving organization %v: %v\n", orgID, err)
 	}
@@ -85,9 +83,60 @@ func (db *Store) OrganizationGetByName(name string) (*domain.Organization, error
 	var countryName string
 	org := domain.Organization{}
 
-	err := db.QueryRow(getOrganizationByNameSQL, name).Scan(&id, &org.ID, &org.Name, &countryName, &org.RootCert, &org.RootKey)
+	err := db.QueryRow(getOrganizationByNameSQL, name).Scan(&id, &org.ID, &org.Name, &countryName, &org.RootCert, &org.RootKey, &org.KeyMode, &org.CertValidityDays, &org.KeyType, datastore.ACLColumn{ACLs: &org.ACLTemplates}, datastore.BrokerColumn{Broker: &org.Broker})
 	if err != nil {
 		log.Printf("Error retrieving organization `%v`: %v\n", name, err)
 	}
 	return &org, err
 }
+
+// OrganizationDelete removes an organization, its API tokens and its webhooks in a single transaction
+func (db *Store) OrganizationDelete(orgID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error deleting organization %v: %v\n", orgID, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(deleteOrganizationTokensSQL, orgID); err != nil {
+		log.Printf("Error deleting the tokens of organization %v: %v\n", orgID, err)
+		return err
+	}
+	for _, statement := range []string{deleteOrganizationWebhooksSQL, deleteOrganizationDeliveriesSQL} {
+		if _, err := tx.Exec(statement, orgID); err != nil {
+			log.Printf("Error deleting the webhooks of organization %v: %v\n", orgID, err)
+			return err
+		}
+	}
+
+	result, err := tx.Exec(deleteOrganizationSQL, orgID)
+	if err != nil {
+		log.Printf("Error deleting organization %v: %v\n", orgID, err)
+		return err
+	}
+	if err := checkUpdated(result, "cannot find organization with ID '%s'", orgID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// OrganizationUpdateKey replaces the stored private key of an organization's CA
+func (db *Store) OrganizationUpdateKey(orgID string, rootKey []byte) error {
+	result, err := db.Exec(updateOrganizationKeySQL, orgID, rootKey)
+	if err != nil {
+		log.Printf("Error updating organization key %v: %v\n", orgID, err)
+		return err
+	}
+	return checkUpdated(result, "cannot find organization with ID '%s'", orgID)
+}
+
+// OrganizationUpdateBroker replaces the MQTT broker of an organization
+func (db *Store) OrganizationUpdateBroker(orgID string, broker domain.Broker) error {
+	result, err := db.Exec(updateOrganizationBrokerSQL, orgID, datastore.BrokerColumn{Broker: &broker})
+	if err != nil {
+		log.Printf("Error updating organization broker %v: %v\n", orgID, err)
+		return err
+	}
+	return checkUpdated(result, "cannot find organization with ID '%s'", orgID)
+}
@@ -32,19 +32,61 @@ const createOrganizationTableSQL string = `
 `
 
 const createOrganizationSQL = `
-insert into organization (org_id, name, country_name, root_cert, root_key)
-values ($1,$2,$3,$4,$5) RETURNING id`
+insert into organization (org_id, name, country_name, root_cert, root_key, key_mode, cert_validity, key_type, acl_templates, broker)
+values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING id`
 
 const listOrganizationSQL = `
-select id, org_id, name, root_cert
+select id, org_id, name, root_cert, key_mode, cert_validity, key_type, acl_templates, broker
 from organization`
 
 const getOrganizationSQL = `
-select id, org_id, name, country_name, root_cert, root_key
+select id, org_id, name, country_name, root_cert, root_key, key_mode, cert_validity, key_type, acl_templates, broker
+from organization
+where org_id=$1`
+
+// The organization of a device leaves out the CA key
+const getDeviceOrganizationSQL = `
+select org_id, name, root_cert
 from organization
 where org_id=$1`
 
 const getOrganizationByNameSQL = `
-select id, org_id, name, country_name, root_cert, root_key
+select id, org_id, name, country_name, root_cert, root_key, key_mode, cert_validity, key_type, acl_templates, broker
 from organization
 where name=$1`
+
+const updateOrganizationKeySQL = `
+update organization
+set root_key=$2
+where org_id=$1`
+
+const updateOrganizationBrokerSQL = `
+update organization
+set broker=$2
+where org_id=$1`
+
+// The organization name identifies the organization, so it must be unique
+const createOrganizationNameIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS organization_name_idx ON organization (name)"
+
+// Add the key_mode field to select how device private keys are created
+const alterOrganizationAddKeyMode = "ALTER TABLE organization ADD COLUMN IF NOT EXISTS key_mode INT DEFAULT 1"
+
+// Add the cert_validity field for the validity of device certificates in days
+const alterOrganizationAddCertValidity = "ALTER TABLE organization ADD COLUMN IF NOT EXISTS cert_validity INT DEFAULT 0"
+
+// Add the key_type field for the key algorithm of device certificates
+const alterOrganizationAddKeyType = "ALTER TABLE organization ADD COLUMN IF NOT EXISTS key_type VARCHAR(50) DEFAULT ''"
+
+// Add the acl_templates field for the topic ACLs of the devices
+const alterOrganizationAddACLTemplates = "ALTER TABLE organization ADD COLUMN IF NOT EXISTS acl_templates TEXT DEFAULT ''"
+
+// Add the broker field for the MQTT broker of the devices
+const alterOrganizationAddBroker = "ALTER TABLE organization ADD COLUMN IF NOT EXISTS broker TEXT DEFAULT ''"
+
+const deleteOrganizationSQL = `
+delete from organization
+where org_id=$1`
+
+const deleteOrganizationTokensSQL = `
+delete from token
+where org_id=$1`
@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// outboxNew records an event of the change to a device in the transaction of the change,
+// with the device as it is after the change
+func outboxNew(tx *sql.Tx, eventType domain.EventType, deviceID string) error {
+	d, err := scanDeviceRow(tx.QueryRow(getDeviceByIDSQL, deviceID))
+	if err != nil {
+		log.Printf("Error retrieving the device for the outbox: %v\n", err)
+		return err
+	}
+
+	e := datastore.NewOutboxEvent(eventType, *d, time.Now())
+	_, err = tx.Exec(createOutboxSQL, e.ID, e.Type, e.OrganizationID, e.DeviceID, datastore.EnrollmentColumn{Enrollment: &e.Device},
+		e.Status, e.Attempts, e.NextAttempt, e.LastError, e.Created)
+	if err != nil {
+		log.Printf("Error recording the outbox event: %v\n", err)
+	}
+	return err
+}
+
+// OutboxNew records an event for a device without a change to it, such as a rejected enrollment
+func (db *Store) OutboxNew(eventType domain.EventType, deviceID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := outboxNew(tx, eventType, deviceID); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: cannot find device with ID '%s'", datastore.ErrNotFound, deviceID)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// OutboxList fetches the outbox events that match the query, oldest first
+func (db *Store) OutboxList(query datastore.OutboxQuery) ([]domain.OutboxEvent, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"true"}
+	args := []interface{}{}
+	if query.Status != 0 {
+		args = append(args, query.Status)
+		conditions = append(conditions, fmt.Sprintf("status=$%d", len(args)))
+	}
+	if len(query.DeviceID) > 0 {
+		args = append(args, query.DeviceID)
+		conditions = append(conditions, fmt.Sprintf("device_id=$%d", len(args)))
+	}
+
+	args = append(args, query.Limit)
+	statement := fmt.Sprintf("%s where %s order by id limit $%d", listOutboxSQL, strings.Join(conditions, " and "), len(args))
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		log.Printf("Error retrieving the outbox events: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []domain.OutboxEvent{}
+	for rows.Next() {
+		e, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// OutboxGet fetches an outbox event
+func (db *Store) OutboxGet(eventID string) (*domain.OutboxEvent, error) {
+	e, err := scanOutboxEvent(db.QueryRow(getOutboxSQL, eventID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: the outbox event `%s` does not exist", datastore.ErrNotFound, eventID)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the outbox event: %v\n", err)
+		return nil, err
+	}
+	return e, nil
+}
+
+// OutboxUpdate records the delivery state of an outbox event
+func (db *Store) OutboxUpdate(event domain.OutboxEvent) error {
+	delivered := sql.NullTime{Time: event.Delivered, Valid: !event.Delivered.IsZero()}
+	result, err := db.Exec(updateOutboxSQL, event.ID, event.Status, event.Attempts, event.NextAttempt, event.LastError, delivered, event.LeaseID)
+	if err != nil {
+		log.Printf("Error updating the outbox event: %v\n", err)
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return db.outboxLeaseLost(event.ID)
+	}
+	return nil
+}
+
+// outboxLeaseLost returns the error of an update that did not match an event: either the
+// event does not exist, or its claim has changed
+func (db *Store) outboxLeaseLost(eventID string) error {
+	if _, err := db.OutboxGet(eventID); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: the outbox event `%s` is claimed by another dispatcher", datastore.ErrLeaseLost, eventID)
+}
+
+// OutboxClaim claims the pending events that are due, oldest first, until the lease ends or
+// the event is updated. The claims of the dispatchers are serialized, so an event is only
+// claimed by one of them and the events of a device are delivered in order
+func (db *Store) OutboxClaim(now time.Time, lease time.Duration, limit int) ([]domain.OutboxEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error claiming the outbox events: %v\n", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(lockClaimSQL, outboxClaimLock); err != nil {
+		log.Printf("Error claiming the outbox events: %v\n", err)
+		return nil, err
+	}
+	leaseID := datastore.GenerateID()
+	if _, err := tx.Exec(claimOutboxSQL, leaseID, now.Add(lease), domain.OutboxPending, now, limit); err != nil {
+		log.Printf("Error claiming the outbox events: %v\n", err)
+		return nil, err
+	}
+
+	events, err := listOutboxClaim(tx, leaseID)
+	if err != nil {
+		log.Printf("Error retrieving the claimed outbox events: %v\n", err)
+		return nil, err
+	}
+	return events, tx.Commit()
+}
+
+// listOutboxClaim fetches the events of a claim, oldest first
+func listOutboxClaim(tx *sql.Tx, leaseID string) ([]domain.OutboxEvent, error) {
+	rows, err := tx.Query(listOutboxClaimSQL, leaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []domain.OutboxEvent{}
+	for rows.Next() {
+		e, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// OutboxPrune removes the events that were delivered before a time, returning the number removed
+func (db *Store) OutboxPrune(before time.Time) (int, error) {
+	result, err := db.Exec(pruneOutboxSQL, domain.OutboxDelivered, before)
+	if err != nil {
+		log.Printf("Error pruning the outbox: %v\n", err)
+		return 0, err
+	}
+	pruned, err := result.RowsAffected()
+	return int(pruned), err
+}
+
+// scanOutboxEvent reads an outbox event from a query row
+func scanOutboxEvent(row interface{ Scan(...interface{}) error }) (*domain.OutboxEvent, error) {
+	var delivered sql.NullTime
+	e := domain.OutboxEvent{}
+	err := row.Scan(&e.ID, &e.Type, &e.OrganizationID, &e.DeviceID, datastore.EnrollmentColumn{Enrollment: &e.Device},
+		&e.Status, &e.Attempts, &e.NextAttempt, &e.LastError, &e.Created, &delivered, &e.LeaseID)
+	if err != nil {
+		return nil, err
+	}
+	e.Delivered = delivered.Time
+	return &e, nil
+}
@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+const createOutboxTableSQL string = `
+	CREATE TABLE IF NOT EXISTS outbox (
+		id                serial primary key not null,
+		event_id          varchar(200) not null unique,
+		event_type        varchar(50) not null,
+		org_id            varchar(200) not null,
+		device_id         varchar(200) not null,
+		device            text not null,
+		status            int not null default 1,
+		attempts          int not null default 0,
+		next_attempt      timestamptz not null,
+		last_error        text not null default '',
+		created           timestamptz not null,
+		delivered         timestamptz
+	)
+`
+
+const createOutboxStatusIndexSQL = "CREATE INDEX IF NOT EXISTS outbox_status_idx ON outbox (status, id)"
+
+const createOutboxSQL = `
+insert into outbox (event_id, event_type, org_id, device_id, device, status, attempts, next_attempt, last_error, created)
+values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`
+
+// The list query adds the filters, order and limit
+const listOutboxSQL = `
+select event_id, event_type, org_id, device_id, device, status, attempts, next_attempt, last_error, created, delivered, lease_id
+from outbox`
+
+const getOutboxSQL = `
+select event_id, event_type, org_id, device_id, device, status, attempts, next_attempt, last_error, created, delivered, lease_id
+from outbox
+where event_id=$1`
+
+// Updating an event ends its claim. An event is only updated by the holder of its claim,
+// or while it is not claimed
+const updateOutboxSQL = `
+update outbox
+set status=$2, attempts=$3, next_attempt=$4, last_error=$5, delivered=$6, lease_id='', lease_until=null
+where event_id=$1 and lease_id=$7`
+
+const alterOutboxAddLeaseID = "ALTER TABLE outbox ADD COLUMN IF NOT EXISTS lease_id VARCHAR(200) NOT NULL DEFAULT ''"
+
+const alterOutboxAddLeaseUntil = "ALTER TABLE outbox ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ"
+
+const createOutboxLeaseIndexSQL = "CREATE INDEX IF NOT EXISTS outbox_lease_idx ON outbox (lease_id)"
+
+// The claims look up the earlier pending events of each device
+const createOutboxDeviceIndexSQL = "CREATE INDEX IF NOT EXISTS outbox_device_idx ON outbox (device_id, status, id)"
+
+// outboxClaimLock is the transaction advisory lock that serializes the claims of the dispatchers
+const outboxClaimLock = 7402010
+
+const lockClaimSQL = "select pg_advisory_xact_lock($1)"
+
+// The pending events that are due are claimed up to the first event of their device that
+// is not due or is claimed already, so that the events of a device are delivered in order
+const claimOutboxSQL = `
+update outbox
+set lease_id=$1, lease_until=$2
+where id in (
+	select o.id from outbox o
+	where o.status=$3 and not exists (
+		select 1 from outbox p
+		where p.device_id=o.device_id and p.status=$3 and p.id<=o.id and (p.next_attempt>$4 or p.lease_until>$4)
+	)
+	order by o.id
+	limit $5
+)`
+
+const listOutboxClaimSQL = `
+select event_id, event_type, org_id, device_id, device, status, attempts, next_attempt, last_error, created, delivered, lease_id
+from outbox
+where lease_id=$1
+order by id`
+
+const pruneOutboxSQL = `
+delete from outbox
+where status=$1 and delivered<$2`
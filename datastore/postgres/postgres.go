@@ -21,8 +21,10 @@ package postgres
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
+	"github.com/canonical/iot-identity/datastore"
 	_ "github.com/lib/pq" // postgresql driver
 )
 
@@ -41,16 +43,19 @@ func OpenStore(driver, dataSource string) *Store {
 	}
 
 	// Open the database
-	pgStore = openDatabase(driver, dataSource)
+	pgStore = OpenDatabase(driver, dataSource)
 
-	// Create the tables, if needed
-	pgStore.createTables()
+	// Bring the schema up to date, as the service cannot run against an older one
+	if _, err := pgStore.Migrate(); err != nil {
+		log.Fatalf("Error migrating the database: %v\n", err)
+	}
 
 	return pgStore
 }
 
-// openDatabase return an open database connection for a PostgreSQL database
-func openDatabase(driver, dataSource string) *Store {
+// OpenDatabase return an open database connection for a PostgreSQL database,
+// without migrating the schema
+func OpenDatabase(driver, dataSource string) *Store {
 	// Open the database connection
 	db, err := sql.Open(driver, dataSource)
 	if err != nil {
@@ -66,7 +71,10 @@ func openDatabase(driver, dataSource string) *Store {
 	return &Store{driver, db}
 }
 
-func (db *Store) createTables() {
-	_ = db.createOrganizationTable()
-	_ = db.createDeviceTable()
+// checkUpdated returns a not found error when a statement did not change any rows
+func checkUpdated(result sql.Result, format string, args ...interface{}) error {
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%w: "+format, append([]interface{}{datastore.ErrNotFound}, args...)...)
+	}
+	return nil
 }
@@ -0,0 +1,62 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"os"
+	"testing"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/datastoretest"
+)
+
+// The conformance tests need a PostgreSQL database, e.g. a local one that is started with:
+//
+//	docker run -d -p 5432:5432 -e POSTGRES_PASSWORD=secret postgres
+//	IDENTITY_TEST_POSTGRES="host=localhost user=postgres password=secret sslmode=disable" go test ./datastore/postgres
+func TestStore_Conformance(t *testing.T) {
+	dataSource := os.Getenv("IDENTITY_TEST_POSTGRES")
+	if len(dataSource) == 0 {
+		t.Skip("IDENTITY_TEST_POSTGRES is not set")
+	}
+
+	db := OpenDatabase("postgres", dataSource)
+	defer db.Close()
+
+	// Migrating twice checks that applied migrations are skipped
+	for i := 0; i < 2; i++ {
+		if _, err := db.Migrate(); err != nil {
+			t.Fatalf("Store.Migrate() error = %v", err)
+		}
+	}
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Store.MigrationStatus() error = %v", err)
+	}
+	for _, m := range status {
+		if m.Applied.IsZero() {
+			t.Errorf("Store.MigrationStatus() migration %d is pending", m.Version)
+		}
+	}
+
+	datastoretest.Run(t, func(t *testing.T) datastore.DataStore {
+		return db
+	})
+}
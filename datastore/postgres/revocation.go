@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// RevocationNew records the revocation of a certificate
+func (db *Store) RevocationNew(revocation domain.Revocation) error {
+	_, err := db.Exec(createRevocationSQL, revocation.OrganizationID, revocation.DeviceID, revocation.SerialNumber, revocation.Reason, revocation.Revoked)
+	if err != nil {
+		log.Printf("Error creating revocation: %v\n", err)
+	}
+	return err
+}
+
+// RevocationGet fetches the revocation of a certificate of an organization
+func (db *Store) RevocationGet(orgID, serialNumber string) (*domain.Revocation, error) {
+	r := domain.Revocation{}
+	err := db.QueryRow(getRevocationSQL, orgID, serialNumber).Scan(&r.OrganizationID, &r.DeviceID, &r.SerialNumber, &r.Reason, &r.Revoked)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: the certificate `%s` is not revoked", datastore.ErrNotFound, serialNumber)
+	}
+	if err != nil {
+		log.Printf("Error retrieving revocation: %v\n", err)
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RevocationList fetches the revoked certificates for an organization
+func (db *Store) RevocationList(orgID string) ([]domain.Revocation, error) {
+	rows, err := db.Query(listRevocationSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving revocations: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := []domain.Revocation{}
+	for rows.Next() {
+		r := domain.Revocation{}
+		err := rows.Scan(&r.OrganizationID, &r.DeviceID, &r.SerialNumber, &r.Reason, &r.Revoked)
+		if err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, r)
+	}
+
+	return revocations, nil
+}
@@ -0,0 +1,46 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+const createTombstoneTableSQL string = `
+	CREATE TABLE IF NOT EXISTS tombstone (
+		id                serial primary key not null,
+		device_id         varchar(200) not null,
+		org_id            varchar(200) not null,
+		brand             varchar(200) not null,
+		model             varchar(200) not null,
+		serial_number     varchar(200) not null,
+		deleted           timestamptz not null,
+		deleted_by        varchar(200) not null
+	)
+`
+
+const createTombstoneBMSIndexSQL = "CREATE INDEX IF NOT EXISTS tombstone_bms_idx ON tombstone (brand, model, serial_number)"
+
+const createTombstoneSQL = `
+insert into tombstone (device_id, org_id, brand, model, serial_number, deleted, deleted_by)
+values ($1,$2,$3,$4,$5,$6,$7)`
+
+const getTombstoneSQL = `
+select device_id, org_id, brand, model, serial_number, deleted, deleted_by
+from tombstone
+where brand=$1 and model=$2 and serial_number=$3
+order by deleted desc, id desc
+limit 1`
@@ -0,0 +1,277 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// WebhookNew creates a webhook of an organization
+func (db *Store) WebhookNew(webhook domain.Webhook) (string, error) {
+	if _, err := db.OrganizationGet(webhook.OrganizationID); err != nil {
+		return "", err
+	}
+
+	webhook.ID = datastore.GenerateID()
+	_, err := db.Exec(createWebhookSQL, webhook.ID, webhook.OrganizationID, webhook.URL, datastore.EventTypesColumn{Events: &webhook.Events}, webhook.Secret)
+	if err != nil {
+		log.Printf("Error creating webhook: %v\n", err)
+		return "", err
+	}
+	return webhook.ID, nil
+}
+
+// WebhookGet fetches a webhook, if it belongs to the organization
+func (db *Store) WebhookGet(orgID, webhookID string) (*domain.Webhook, error) {
+	w, err := scanWebhook(db.QueryRow(getWebhookSQL, orgID, webhookID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: the webhook `%s` does not exist", datastore.ErrNotFound, webhookID)
+	}
+	if err != nil {
+		log.Printf("Error retrieving webhook: %v\n", err)
+		return nil, err
+	}
+	return w, nil
+}
+
+// WebhookList fetches the webhooks of an organization, oldest first
+func (db *Store) WebhookList(orgID string) ([]domain.Webhook, error) {
+	rows, err := db.Query(listWebhookSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving webhooks: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []domain.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks, rows.Err()
+}
+
+// WebhookUpdateSecret replaces the shared secret of a webhook
+func (db *Store) WebhookUpdateSecret(orgID, webhookID string, secret []byte) error {
+	result, err := db.Exec(updateWebhookSecretSQL, orgID, webhookID, secret)
+	if err != nil {
+		log.Printf("Error updating the secret of webhook: %v\n", err)
+		return err
+	}
+	return checkUpdated(result, "the webhook `%s` does not exist", webhookID)
+}
+
+// WebhookDelete removes a webhook and its deliveries in a single transaction
+func (db *Store) WebhookDelete(orgID, webhookID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(deleteWebhookSQL, orgID, webhookID)
+	if err != nil {
+		log.Printf("Error deleting webhook: %v\n", err)
+		return err
+	}
+	if err := checkUpdated(result, "the webhook `%s` does not exist", webhookID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(deleteWebhookDeliveriesSQL, webhookID); err != nil {
+		log.Printf("Error deleting the deliveries of webhook: %v\n", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// WebhookDeliveryNew records a delivery of an event to a webhook
+func (db *Store) WebhookDeliveryNew(d domain.WebhookDelivery) error {
+	delivered := sql.NullTime{Time: d.Delivered, Valid: !d.Delivered.IsZero()}
+	_, err := db.Exec(createWebhookDeliverySQL, d.ID, d.WebhookID, d.OrganizationID, d.EventID, d.Type, d.Payload,
+		d.Status, d.Attempts, d.NextAttempt, d.ResponseCode, d.LastError, d.Created, delivered)
+	if err != nil {
+		log.Printf("Error recording the webhook delivery: %v\n", err)
+	}
+	return err
+}
+
+// WebhookDeliveryList fetches the webhook deliveries that match the query, oldest first
+func (db *Store) WebhookDeliveryList(query datastore.WebhookDeliveryQuery) ([]domain.WebhookDelivery, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"true"}
+	args := []interface{}{}
+	if len(query.OrganizationID) > 0 {
+		args = append(args, query.OrganizationID)
+		conditions = append(conditions, fmt.Sprintf("org_id=$%d", len(args)))
+	}
+	if len(query.WebhookID) > 0 {
+		args = append(args, query.WebhookID)
+		conditions = append(conditions, fmt.Sprintf("webhook_id=$%d", len(args)))
+	}
+	if len(query.EventID) > 0 {
+		args = append(args, query.EventID)
+		conditions = append(conditions, fmt.Sprintf("event_id=$%d", len(args)))
+	}
+	if query.Status != 0 {
+		args = append(args, query.Status)
+		conditions = append(conditions, fmt.Sprintf("status=$%d", len(args)))
+	}
+
+	args = append(args, query.Limit)
+	statement := fmt.Sprintf("%s where %s order by id limit $%d", listWebhookDeliverySQL, strings.Join(conditions, " and "), len(args))
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		log.Printf("Error retrieving the webhook deliveries: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []domain.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+// WebhookDeliveryUpdate records the outcome of an attempt to deliver to a webhook
+func (db *Store) WebhookDeliveryUpdate(d domain.WebhookDelivery) error {
+	delivered := sql.NullTime{Time: d.Delivered, Valid: !d.Delivered.IsZero()}
+	result, err := db.Exec(updateWebhookDeliverySQL, d.ID, d.Status, d.Attempts, d.NextAttempt, d.ResponseCode, d.LastError, delivered, d.LeaseID)
+	if err != nil {
+		log.Printf("Error updating the webhook delivery: %v\n", err)
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return db.webhookDeliveryLeaseLost(d.ID)
+	}
+	return nil
+}
+
+// webhookDeliveryLeaseLost returns the error of an update that did not match a delivery:
+// either the delivery does not exist, or its claim has changed
+func (db *Store) webhookDeliveryLeaseLost(deliveryID string) error {
+	var count int
+	if err := db.QueryRow(countWebhookDeliverySQL, deliveryID).Scan(&count); err != nil {
+		log.Printf("Error retrieving the webhook delivery: %v\n", err)
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: the webhook delivery `%s` does not exist", datastore.ErrNotFound, deliveryID)
+	}
+	return fmt.Errorf("%w: the webhook delivery `%s` is claimed by another dispatcher", datastore.ErrLeaseLost, deliveryID)
+}
+
+// WebhookDeliveryClaim claims the pending deliveries that are due, oldest first, until the
+// lease ends or the delivery is updated. The claims of the dispatchers are serialized, so a
+// delivery is only claimed by one of them
+func (db *Store) WebhookDeliveryClaim(now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error claiming the webhook deliveries: %v\n", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(lockClaimSQL, webhookClaimLock); err != nil {
+		log.Printf("Error claiming the webhook deliveries: %v\n", err)
+		return nil, err
+	}
+	leaseID := datastore.GenerateID()
+	if _, err := tx.Exec(claimWebhookDeliverySQL, leaseID, now.Add(lease), domain.OutboxPending, now, limit); err != nil {
+		log.Printf("Error claiming the webhook deliveries: %v\n", err)
+		return nil, err
+	}
+
+	deliveries, err := listWebhookDeliveryClaim(tx, leaseID)
+	if err != nil {
+		log.Printf("Error retrieving the claimed webhook deliveries: %v\n", err)
+		return nil, err
+	}
+	return deliveries, tx.Commit()
+}
+
+// listWebhookDeliveryClaim fetches the deliveries of a claim, oldest first
+func listWebhookDeliveryClaim(tx *sql.Tx, leaseID string) ([]domain.WebhookDelivery, error) {
+	rows, err := tx.Query(listWebhookDeliveryClaimSQL, leaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []domain.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+// WebhookDeliveryPrune removes the deliveries that were delivered before a time, returning the number removed
+func (db *Store) WebhookDeliveryPrune(before time.Time) (int, error) {
+	result, err := db.Exec(pruneWebhookDeliverySQL, domain.OutboxDelivered, before)
+	if err != nil {
+		log.Printf("Error pruning the webhook deliveries: %v\n", err)
+		return 0, err
+	}
+	pruned, err := result.RowsAffected()
+	return int(pruned), err
+}
+
+// scanWebhook reads a webhook from a query row
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*domain.Webhook, error) {
+	w := domain.Webhook{}
+	if err := row.Scan(&w.ID, &w.OrganizationID, &w.URL, datastore.EventTypesColumn{Events: &w.Events}, &w.Secret, &w.Created); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// scanWebhookDelivery reads a webhook delivery from a query row
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (*domain.WebhookDelivery, error) {
+	var delivered sql.NullTime
+	d := domain.WebhookDelivery{}
+	err := row.Scan(&d.ID, &d.WebhookID, &d.OrganizationID, &d.EventID, &d.Type, &d.Payload,
+		&d.Status, &d.Attempts, &d.NextAttempt, &d.ResponseCode, &d.LastError, &d.Created, &delivered, &d.LeaseID)
+	if err != nil {
+		return nil, err
+	}
+	d.Delivered = delivered.Time
+	return &d, nil
+}
@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+// The events are the JSON list of the event types, with an empty list for every event
+const createWebhookTableSQL string = `
+	CREATE TABLE IF NOT EXISTS webhook (
+		id                serial primary key not null,
+		webhook_id        varchar(200) not null unique,
+		org_id            varchar(200) not null,
+		url               text not null,
+		events            text not null default '[]',
+		secret            bytea not null,
+		created           timestamptz not null default current_timestamp
+	)
+`
+
+const createWebhookOrgIndexSQL = "CREATE INDEX IF NOT EXISTS webhook_org_idx ON webhook (org_id, id)"
+
+const createWebhookDeliveryTableSQL string = `
+	CREATE TABLE IF NOT EXISTS webhook_delivery (
+		id                serial primary key not null,
+		delivery_id       varchar(200) not null unique,
+		webhook_id        varchar(200) not null,
+		org_id            varchar(200) not null,
+		event_id          varchar(200) not null default '',
+		event_type        varchar(50) not null,
+		payload           text not null,
+		status            int not null default 1,
+		attempts          int not null default 0,
+		next_attempt      timestamptz not null,
+		response_code     int not null default 0,
+		last_error        text not null default '',
+		created           timestamptz not null,
+		delivered         timestamptz
+	)
+`
+
+const createWebhookDeliveryStatusIndexSQL = "CREATE INDEX IF NOT EXISTS webhook_delivery_status_idx ON webhook_delivery (status, id)"
+
+const createWebhookDeliveryWebhookIndexSQL = "CREATE INDEX IF NOT EXISTS webhook_delivery_webhook_idx ON webhook_delivery (webhook_id, id)"
+
+const createWebhookSQL = `
+insert into webhook (webhook_id, org_id, url, events, secret)
+values ($1,$2,$3,$4,$5)`
+
+const listWebhookSQL = `
+select webhook_id, org_id, url, events, secret, created
+from webhook
+where org_id=$1
+order by id`
+
+const getWebhookSQL = `
+select webhook_id, org_id, url, events, secret, created
+from webhook
+where org_id=$1 and webhook_id=$2`
+
+const updateWebhookSecretSQL = `
+update webhook
+set secret=$3
+where org_id=$1 and webhook_id=$2`
+
+const deleteWebhookSQL = `
+delete from webhook
+where org_id=$1 and webhook_id=$2`
+
+const deleteWebhookDeliveriesSQL = `
+delete from webhook_delivery
+where webhook_id=$1`
+
+const deleteOrganizationWebhooksSQL = `
+delete from webhook
+where org_id=$1`
+
+const deleteOrganizationDeliveriesSQL = `
+delete from webhook_delivery
+where org_id=$1`
+
+const createWebhookDeliverySQL = `
+insert into webhook_delivery (delivery_id, webhook_id, org_id, event_id, event_type, payload, status, attempts, next_attempt, response_code, last_error, created, delivered)
+values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)`
+
+// The list query adds the filters, order and limit
+const listWebhookDeliverySQL = `
+select delivery_id, webhook_id, org_id, event_id, event_type, payload, status, attempts, next_attempt, response_code, last_error, created, delivered, lease_id
+from webhook_delivery`
+
+// Updating a delivery ends its claim. A delivery is only updated by the holder of its claim,
+// or while it is not claimed
+const updateWebhookDeliverySQL = `
+update webhook_delivery
+set status=$2, attempts=$3, next_attempt=$4, response_code=$5, last_error=$6, delivered=$7, lease_id='', lease_until=null
+where delivery_id=$1 and lease_id=$8`
+
+const countWebhookDeliverySQL = "select count(*) from webhook_delivery where delivery_id=$1"
+
+const alterWebhookDeliveryAddLeaseID = "ALTER TABLE webhook_delivery ADD COLUMN IF NOT EXISTS lease_id VARCHAR(200) NOT NULL DEFAULT ''"
+
+const alterWebhookDeliveryAddLeaseUntil = "ALTER TABLE webhook_delivery ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ"
+
+const createWebhookDeliveryLeaseIndexSQL = "CREATE INDEX IF NOT EXISTS webhook_delivery_lease_idx ON webhook_delivery (lease_id)"
+
+// webhookClaimLock is the transaction advisory lock that serializes the claims of the dispatchers
+const webhookClaimLock = 7402011
+
+// The pending deliveries that are due and not claimed already are claimed, oldest first
+const claimWebhookDeliverySQL = `
+update webhook_delivery
+set lease_id=$1, lease_until=$2
+where id in (
+	select id from webhook_delivery
+	where status=$3 and next_attempt<=$4 and (lease_until is null or lease_until<=$4)
+	order by id
+	limit $5
+)`
+
+const listWebhookDeliveryClaimSQL = `
+select delivery_id, webhook_id, org_id, event_id, event_type, payload, status, attempts, next_attempt, response_code, last_error, created, delivered, lease_id
+from webhook_delivery
+where lease_id=$1
+order by id`
+
+const pruneWebhookDeliverySQL = `
+delete from webhook_delivery
+where status=$1 and delivered<$2`
@@ -0,0 +1,194 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// Sort orders of device listings. Devices with the same value are ordered by their ID
+const (
+	SortCreated = "created"
+	SortUpdated = "updated"
+	SortSerial  = "serial"
+)
+
+// Page sizes of device listings
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// cursorTimeFormat has a fixed width, so timestamps in cursors sort as text
+const cursorTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// DeviceQuery selects a page of the devices of an organization. Empty filters match
+// every device. The time ranges include the start and exclude the end
+type DeviceQuery struct {
+	OrganizationID string
+	Status         domain.Status
+	Brand          string
+	Model          string
+	SerialPrefix   string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	UpdatedAfter   time.Time
+	UpdatedBefore  time.Time
+	DeviceData     string // text that the device data contains
+	Sort           string
+	Descending     bool
+	Cursor         string // the next page cursor of the previous page
+	Limit          int
+}
+
+// DevicePage is a page of devices, without their private keys. The total is the number
+// of devices that match the filters, and the cursor is empty on the last page
+type DevicePage struct {
+	Devices    []domain.Enrollment
+	NextCursor string
+	Total      int
+}
+
+// Cursor is the position of the last device of a page in the sort order
+type Cursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"`
+	DeviceID string `json:"id"`
+}
+
+// Normalize checks the query, setting the default sort order and page size
+func (q *DeviceQuery) Normalize() error {
+	switch q.Sort {
+	case "":
+		q.Sort = SortCreated
+	case SortCreated, SortUpdated, SortSerial:
+	default:
+		return fmt.Errorf("the sort order must be one of: %s, %s, %s", SortCreated, SortUpdated, SortSerial)
+	}
+
+	if q.Limit < 0 || q.Limit > MaxPageLimit {
+		return fmt.Errorf("the page limit must be between 1 and %d", MaxPageLimit)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageLimit
+	}
+
+	_, err := q.After()
+	return err
+}
+
+// After decodes the cursor of the query, returning nil for the first page
+func (q *DeviceQuery) After() (*Cursor, error) {
+	if len(q.Cursor) == 0 {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("the page cursor is invalid")
+	}
+	c := Cursor{}
+	if err := json.Unmarshal(data, &c); err != nil || len(c.DeviceID) == 0 {
+		return nil, fmt.Errorf("the page cursor is invalid")
+	}
+	if c.Sort != q.Sort {
+		return nil, fmt.Errorf("the page cursor is for a different sort order")
+	}
+	if _, err := c.Time(); err != nil && c.Sort != SortSerial {
+		return nil, fmt.Errorf("the page cursor is invalid")
+	}
+	return &c, nil
+}
+
+// Time returns the timestamp of a cursor for a sort order by time
+func (c *Cursor) Time() (time.Time, error) {
+	return time.Parse(cursorTimeFormat, c.Value)
+}
+
+// NextCursor returns the cursor for the page that follows the device
+func (q *DeviceQuery) NextCursor(en domain.Enrollment) string {
+	data, _ := json.Marshal(Cursor{Sort: q.Sort, Value: q.SortValue(en), DeviceID: en.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// SortValue returns the value of a device for the sort order, as text that sorts in the same order
+func (q *DeviceQuery) SortValue(en domain.Enrollment) string {
+	switch q.Sort {
+	case SortUpdated:
+		return en.Updated.UTC().Format(cursorTimeFormat)
+	case SortSerial:
+		return en.Device.SerialNumber
+	default:
+		return en.Created.UTC().Format(cursorTimeFormat)
+	}
+}
+
+// Matches checks whether a device matches the filters of the query
+func (q *DeviceQuery) Matches(en domain.Enrollment) bool {
+	switch {
+	case en.Organization.ID != q.OrganizationID:
+		return false
+	case q.Status != 0 && en.Status != q.Status:
+		return false
+	case len(q.Brand) > 0 && en.Device.Brand != q.Brand:
+		return false
+	case len(q.Model) > 0 && en.Device.Model != q.Model:
+		return false
+	case !strings.HasPrefix(en.Device.SerialNumber, q.SerialPrefix):
+		return false
+	case !q.CreatedAfter.IsZero() && en.Created.Before(q.CreatedAfter):
+		return false
+	case !q.CreatedBefore.IsZero() && !en.Created.Before(q.CreatedBefore):
+		return false
+	case !q.UpdatedAfter.IsZero() && en.Updated.Before(q.UpdatedAfter):
+		return false
+	case !q.UpdatedBefore.IsZero() && !en.Updated.Before(q.UpdatedBefore):
+		return false
+	case !strings.Contains(en.DeviceData, q.DeviceData):
+		return false
+	}
+	return true
+}
+
+// Before checks whether a device comes before another in the sort order of the query
+func (q *DeviceQuery) Before(a, b domain.Enrollment) bool {
+	return q.less(q.SortValue(a), a.ID, q.SortValue(b), b.ID)
+}
+
+// IsAfter checks whether a device comes after the cursor in the sort order of the query
+func (q *DeviceQuery) IsAfter(en domain.Enrollment, c *Cursor) bool {
+	return q.less(c.Value, c.DeviceID, q.SortValue(en), en.ID)
+}
+
+func (q *DeviceQuery) less(value1, id1, value2, id2 string) bool {
+	if value1 == value2 {
+		value1, value2 = id1, id2
+	}
+	if q.Descending {
+		return value1 > value2
+	}
+	return value1 < value2
+}
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestDeviceQuery_Normalize(t *testing.T) {
+	en := domain.Enrollment{ID: "a111", Created: time.Now()}
+	createdCursor := (&DeviceQuery{Sort: SortCreated}).NextCursor(en)
+	serialCursor := (&DeviceQuery{Sort: SortSerial}).NextCursor(en)
+
+	tests := []struct {
+		name      string
+		query     DeviceQuery
+		wantSort  string
+		wantLimit int
+		wantErr   bool
+	}{
+		{"defaults", DeviceQuery{}, SortCreated, DefaultPageLimit, false},
+		{"valid", DeviceQuery{Sort: SortSerial, Limit: 10}, SortSerial, 10, false},
+		{"max-limit", DeviceQuery{Limit: MaxPageLimit}, SortCreated, MaxPageLimit, false},
+		{"cursor", DeviceQuery{Cursor: createdCursor}, SortCreated, DefaultPageLimit, false},
+		{"invalid-sort", DeviceQuery{Sort: "invalid"}, "", 0, true},
+		{"invalid-limit", DeviceQuery{Limit: MaxPageLimit + 1}, "", 0, true},
+		{"negative-limit", DeviceQuery{Limit: -1}, "", 0, true},
+		{"invalid-cursor", DeviceQuery{Cursor: "invalid"}, "", 0, true},
+		{"cursor-sort", DeviceQuery{Cursor: serialCursor}, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.Normalize()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DeviceQuery.Normalize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && (tt.query.Sort != tt.wantSort || tt.query.Limit != tt.wantLimit) {
+				t.Errorf("DeviceQuery.Normalize() = %v/%v, want %v/%v", tt.query.Sort, tt.query.Limit, tt.wantSort, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestDeviceQuery_Cursor(t *testing.T) {
+	created := time.Date(2020, 3, 4, 5, 6, 7, 8, time.UTC)
+	a := domain.Enrollment{ID: "a111", Device: domain.Device{SerialNumber: "A1"}, Created: created}
+	b := domain.Enrollment{ID: "b222", Device: domain.Device{SerialNumber: "A1"}, Created: created.Add(time.Nanosecond)}
+
+	tests := []struct {
+		name  string
+		query DeviceQuery
+		first domain.Enrollment
+		next  domain.Enrollment
+	}{
+		{"created", DeviceQuery{Sort: SortCreated}, a, b},
+		{"created-descending", DeviceQuery{Sort: SortCreated, Descending: true}, b, a},
+		{"serial-by-id", DeviceQuery{Sort: SortSerial}, a, b},
+		{"serial-descending", DeviceQuery{Sort: SortSerial, Descending: true}, b, a},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.query.Before(tt.first, tt.next) || tt.query.Before(tt.next, tt.first) {
+				t.Errorf("DeviceQuery.Before() = wrong order for %v and %v", tt.first.ID, tt.next.ID)
+			}
+
+			tt.query.Cursor = tt.query.NextCursor(tt.first)
+			c, err := tt.query.After()
+			if err != nil {
+				t.Fatalf("DeviceQuery.After() error = %v", err)
+			}
+			if c.DeviceID != tt.first.ID {
+				t.Errorf("DeviceQuery.After() = %v, want %v", c.DeviceID, tt.first.ID)
+			}
+			if tt.query.Sort == SortCreated {
+				if got, _ := c.Time(); !got.Equal(tt.first.Created) {
+					t.Errorf("Cursor.Time() = %v, want %v", got, tt.first.Created)
+				}
+			}
+			if !tt.query.IsAfter(tt.next, c) || tt.query.IsAfter(tt.first, c) {
+				t.Error("DeviceQuery.IsAfter() = wrong position for the cursor")
+			}
+		})
+	}
+}
+
+func TestDeviceQuery_Matches(t *testing.T) {
+	created := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	en := domain.Enrollment{
+		ID:           "a111",
+		Organization: domain.Organization{ID: "abc"},
+		Device:       domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111"},
+		Status:       domain.StatusEnrolled,
+		DeviceData:   "location: depot",
+		Created:      created,
+		Updated:      created.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name  string
+		query DeviceQuery
+		want  bool
+	}{
+		{"all", DeviceQuery{OrganizationID: "abc"}, true},
+		{"other-org", DeviceQuery{OrganizationID: "def"}, false},
+		{"status", DeviceQuery{OrganizationID: "abc", Status: domain.StatusEnrolled}, true},
+		{"status-other", DeviceQuery{OrganizationID: "abc", Status: domain.StatusDisabled}, false},
+		{"brand-model", DeviceQuery{OrganizationID: "abc", Brand: "example", Model: "drone-1000"}, true},
+		{"model-other", DeviceQuery{OrganizationID: "abc", Model: "drone-2000"}, false},
+		{"serial-prefix", DeviceQuery{OrganizationID: "abc", SerialPrefix: "DR1000"}, true},
+		{"serial-not-prefix", DeviceQuery{OrganizationID: "abc", SerialPrefix: "A111"}, false},
+		{"device-data", DeviceQuery{OrganizationID: "abc", DeviceData: "depot"}, true},
+		{"device-data-other", DeviceQuery{OrganizationID: "abc", DeviceData: "warehouse"}, false},
+		{"created-from", DeviceQuery{OrganizationID: "abc", CreatedAfter: created}, true},
+		{"created-until", DeviceQuery{OrganizationID: "abc", CreatedBefore: created}, false},
+		{"updated-range", DeviceQuery{OrganizationID: "abc", UpdatedAfter: created, UpdatedBefore: created.Add(2 * time.Hour)}, true},
+		{"updated-after", DeviceQuery{OrganizationID: "abc", UpdatedAfter: created.Add(2 * time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.Matches(en); got != tt.want {
+				t.Errorf("DeviceQuery.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
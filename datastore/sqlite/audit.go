@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// AuditNew appends an event to the audit log
+func (db *Store) AuditNew(event domain.AuditEvent) error {
+	_, err := db.exec(createAuditSQL, event.ID, event.Time.UnixNano(), event.Actor, event.Action, event.OrganizationID, event.DeviceID,
+		event.Before, event.After, event.Success, event.Reason, event.Details)
+	if err != nil {
+		log.Printf("Error creating audit event: %v\n", err)
+	}
+	return err
+}
+
+// AuditList fetches a page of the audit log
+func (db *Store) AuditList(query datastore.AuditQuery) (*datastore.AuditPage, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	after, _ := query.Position()
+
+	// The total counts the events that match the filters on every page
+	where, args := auditFilter(query)
+	page := &datastore.AuditPage{Events: []domain.AuditEvent{}}
+	if err := db.QueryRow(countAuditSQL+where, args...).Scan(&page.Total); err != nil {
+		log.Printf("Error counting audit events: %v\n", err)
+		return nil, err
+	}
+
+	if after != nil {
+		t, _ := after.Timestamp()
+		args = append(args, t.UnixNano(), after.EventID)
+		where += " and (event_time, event_id) < (?, ?)"
+	}
+
+	// Fetch an extra event to find out if there is another page
+	args = append(args, query.Limit+1)
+	rows, err := db.Query(listAuditSQL+where+" order by event_time DESC, event_id DESC limit ?", args...)
+	if err != nil {
+		log.Printf("Error retrieving audit events: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventTime int64
+		e := domain.AuditEvent{}
+		err := rows.Scan(&e.ID, &eventTime, &e.Actor, &e.Action, &e.OrganizationID, &e.DeviceID, &e.Before, &e.After, &e.Success, &e.Reason, &e.Details)
+		if err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, eventTime)
+		if len(page.Events) == query.Limit {
+			page.NextCursor = query.NextCursor(page.Events[len(page.Events)-1])
+			break
+		}
+		page.Events = append(page.Events, e)
+	}
+
+	return page, rows.Err()
+}
+
+// auditFilter returns the where clause and its arguments for the filters of an audit query
+func auditFilter(query datastore.AuditQuery) (string, []interface{}) {
+	conditions := []string{"1=1"}
+	args := []interface{}{}
+	add := func(condition string, value interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, value)
+	}
+
+	if len(query.OrganizationID) > 0 {
+		add("org_id=?", query.OrganizationID)
+	}
+	if len(query.DeviceID) > 0 {
+		add("device_id=?", query.DeviceID)
+	}
+	if len(query.Actor) > 0 {
+		add("actor=?", query.Actor)
+	}
+	if len(query.Action) > 0 {
+		add("action=?", query.Action)
+	}
+	if !query.After.IsZero() {
+		add("event_time>=?", query.After.UnixNano())
+	}
+	if !query.Before.IsZero() {
+		add("event_time<?", query.Before.UnixNano())
+	}
+
+	return " where " + strings.Join(conditions, " and "), args
+}
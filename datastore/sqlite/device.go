@@ -0,0 +1,340 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// DeviceNew creates a new device registration
+func (db *Store) DeviceNew(d datastore.DeviceNewRequest) (string, error) {
+	deviceID := d.ID
+	if len(deviceID) == 0 {
+		deviceID = datastore.GenerateID()
+	}
+
+	err := db.deviceNew(deviceID, d)
+	if err != nil {
+		log.Printf("Error creating device: %v\n", err)
+	}
+
+	return deviceID, err
+}
+
+// deviceNew creates the device and records its first status in a single transaction
+func (db *Store) deviceNew(deviceID string, d datastore.DeviceNewRequest) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The credentials are empty when the device will request a certificate on enrollment
+	now := time.Now().UnixNano()
+	_, err = tx.Exec(createDeviceSQL, deviceID, d.OrganizationID, d.Brand, d.Model, d.SerialNumber, nonNil(d.Credentials.PrivateKey), nonNil(d.Credentials.Certificate), d.Credentials.MQTTURL, d.Credentials.MQTTPort,
+		d.Credentials.MQTTProtocol, nonNil(d.Credentials.MQTTCABundle), d.Credentials.ClientID, d.DeviceData, now, now, datastore.CertificateSerial(d.Credentials.Certificate))
+	if err != nil {
+		return err
+	}
+	if err := statusChange(tx, deviceID, 0, domain.StatusWaiting, now); err != nil {
+		return err
+	}
+	if err := outboxNew(tx, domain.EventDeviceRegister, deviceID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeviceGet fetches a device registration
+func (db *Store) DeviceGet(brand, model, serial string) (*domain.Enrollment, error) {
+	return db.deviceGetByQuery(getDeviceSQL, brand, model, serial)
+}
+
+// DeviceGetByID fetches a device registration
+func (db *Store) DeviceGetByID(deviceID string) (*domain.Enrollment, error) {
+	return db.deviceGetByQuery(getDeviceByIDSQL, deviceID)
+}
+
+// DeviceGetByOrgID fetches a device registration, if it belongs to the organization
+func (db *Store) DeviceGetByOrgID(orgID, deviceID string) (*domain.Enrollment, error) {
+	return db.deviceGetByQuery(getDeviceByOrgIDSQL, deviceID, orgID)
+}
+
+// DeviceGetByCertificate fetches the device with the certificate serial number
+func (db *Store) DeviceGetByCertificate(serialNumber string) (*domain.Enrollment, error) {
+	return db.deviceGetByQuery(getDeviceByCertificateSQL, serialNumber)
+}
+
+// deviceGetByQuery fetches a device registration with a query that selects one device
+func (db *Store) deviceGetByQuery(query string, args ...interface{}) (*domain.Enrollment, error) {
+	d, err := scanDeviceRow(db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return d, fmt.Errorf("%w: error retrieving device: %v", datastore.ErrNotFound, err)
+	}
+	if err != nil {
+		log.Printf("Error retrieving device: %v\n", err)
+		return d, fmt.Errorf("error retrieving device: %v", err)
+	}
+
+	// Get the organization details for the device, without its CA key
+	err = db.QueryRow(getDeviceOrganizationSQL, d.Organization.ID).Scan(&d.Organization.ID, &d.Organization.Name, &d.Organization.RootCert)
+	if err != nil {
+		log.Printf("Error retrieving device organization: %v\n", err)
+		return d, fmt.Errorf("error retrieving device organization: %v", err)
+	}
+	return d, nil
+}
+
+// DeviceEnroll enrolls a device with the IoT service
+func (db *Store) DeviceEnroll(d datastore.DeviceEnrollRequest) (*domain.Enrollment, error) {
+	if err := db.deviceEnroll(d); err != nil {
+		return nil, err
+	}
+	return db.DeviceGet(d.Brand, d.Model, d.SerialNumber)
+}
+
+// deviceEnroll updates the device details, certificate and status history in a single transaction
+func (db *Store) deviceEnroll(d datastore.DeviceEnrollRequest) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error updating the device: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var deviceID string
+	var status domain.Status
+	err = tx.QueryRow(getDeviceStatusSQL, d.Brand, d.Model, d.SerialNumber).Scan(&deviceID, &status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: the device `%s/%s/%s` is not registered", datastore.ErrNotFound, d.Brand, d.Model, d.SerialNumber)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the device: %v\n", err)
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	_, err = tx.Exec(enrollDeviceSQL, d.StoreID, d.DeviceKey, domain.StatusEnrolled, now, now, now, datastore.ACLColumn{ACLs: &d.ACLs}, d.Brand, d.Model, d.SerialNumber)
+	if err != nil {
+		log.Printf("Error updating the device: %v\n", err)
+		return err
+	}
+	if err := statusChange(tx, deviceID, status, domain.StatusEnrolled, now); err != nil {
+		return err
+	}
+
+	if len(d.Certificate) > 0 {
+		_, err = tx.Exec(enrollDeviceCertSQL, d.Certificate, nonNil(d.PrivateKey), datastore.CertificateSerial(d.Certificate), now, d.Brand, d.Model, d.SerialNumber)
+		if err != nil {
+			log.Printf("Error updating the device certificate: %v\n", err)
+			return err
+		}
+	}
+
+	if len(d.MQTT.MQTTURL) > 0 {
+		_, err = tx.Exec(updateDeviceBrokerSQL, d.MQTT.MQTTURL, d.MQTT.MQTTPort, d.MQTT.MQTTProtocol, nonNil(d.MQTT.MQTTCABundle), d.MQTT.ClientID, now, deviceID)
+		if err != nil {
+			log.Printf("Error updating the device broker: %v\n", err)
+			return err
+		}
+	}
+
+	if err := outboxNew(tx, domain.EventDeviceEnroll, deviceID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeviceList fetches the device registrations for an organization
+func (db *Store) DeviceList(orgID string) ([]domain.Enrollment, error) {
+	rows, err := db.Query(listDeviceSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving devices: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []domain.Enrollment{}
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *d)
+	}
+
+	return devices, rows.Err()
+}
+
+// DeviceRenew replaces the certificate and private key of a device, recording the renewal in the outbox
+func (db *Store) DeviceRenew(deviceID string, certificate, privateKey []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error renewing the device certificate: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixNano()
+	result, err := tx.Exec(renewDeviceSQL, certificate, nonNil(privateKey), datastore.CertificateSerial(certificate), now, now, deviceID)
+	if err != nil {
+		log.Printf("Error renewing the device certificate: %v\n", err)
+		return err
+	}
+	if err := checkUpdated(result, "the device `%s` is not registered", deviceID); err != nil {
+		return err
+	}
+	if err := outboxNew(tx, domain.EventDeviceRenew, deviceID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeviceUpdateKey replaces the stored private key of a device, if it has not changed from the current key
+func (db *Store) DeviceUpdateKey(deviceID string, current, privateKey []byte) error {
+	result, err := db.exec(updateDeviceKeySQL, privateKey, deviceID, current)
+	if err != nil {
+		log.Printf("Error updating the device key: %v\n", err)
+		return err
+	}
+	return checkUpdated(result, "the device `%s` with the current key is not registered", deviceID)
+}
+
+// DeviceUpdateBroker replaces the broker details of the credentials of a device
+func (db *Store) DeviceUpdateBroker(deviceID string, mqtt domain.Credentials) error {
+	result, err := db.exec(updateDeviceBrokerSQL, mqtt.MQTTURL, mqtt.MQTTPort, mqtt.MQTTProtocol, nonNil(mqtt.MQTTCABundle), mqtt.ClientID, time.Now().UnixNano(), deviceID)
+	if err != nil {
+		log.Printf("Error updating the device broker: %v\n", err)
+		return err
+	}
+	return checkUpdated(result, "the device `%s` is not registered", deviceID)
+}
+
+// DeviceUpdate updates a device registration, recording the change of status
+func (db *Store) DeviceUpdate(deviceID string, status domain.Status, deviceData string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error updating the device: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous domain.Status
+	err = tx.QueryRow(getDeviceStatusByIDSQL, deviceID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, deviceID)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the device: %v\n", err)
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	if _, err := tx.Exec(updateDeviceSQL, status, deviceData, now, deviceID); err != nil {
+		log.Printf("Error updating the device: %v\n", err)
+		return err
+	}
+	if err := statusChange(tx, deviceID, previous, status, now); err != nil {
+		return err
+	}
+	if eventType, ok := datastore.UpdateEventType(previous, status); ok {
+		if err := outboxNew(tx, eventType, deviceID, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// nonNil returns an empty value for a nil byte slice, which would otherwise be stored as NULL
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// scanDeviceRow reads a device from a query that selects one device, with its private key
+func scanDeviceRow(row *sql.Row) (*domain.Enrollment, error) {
+	var created, updated, enrolledAt, lastSeen int64
+	d := domain.Enrollment{}
+	err := row.Scan(
+		&d.ID, &d.Organization.ID, &d.Device.Brand, &d.Device.Model, &d.Device.SerialNumber,
+		&d.Credentials.PrivateKey, &d.Credentials.Certificate, &d.Credentials.MQTTURL, &d.Credentials.MQTTPort,
+		&d.Credentials.MQTTProtocol, &d.Credentials.MQTTCABundle, &d.Credentials.ClientID,
+		&d.Device.StoreID, &d.Device.DeviceKey, &d.Status, &d.DeviceData, &created, &updated, &enrolledAt, &lastSeen,
+		datastore.ACLColumn{ACLs: &d.Credentials.ACLs})
+	if err != nil {
+		return &d, err
+	}
+	d.Created, d.Updated = time.Unix(0, created), time.Unix(0, updated)
+	d.EnrolledAt, d.LastSeen = unixTime(enrolledAt), unixTime(lastSeen)
+	return &d, nil
+}
+
+// scanDevice reads a device from a listing, which does not include the private key
+func scanDevice(rows *sql.Rows) (*domain.Enrollment, error) {
+	var created, updated, enrolledAt, lastSeen int64
+	d := domain.Enrollment{}
+	err := rows.Scan(&d.ID, &d.Organization.ID, &d.Device.Brand, &d.Device.Model, &d.Device.SerialNumber,
+		&d.Credentials.Certificate, &d.Credentials.MQTTURL, &d.Credentials.MQTTPort,
+		&d.Credentials.MQTTProtocol, &d.Credentials.MQTTCABundle, &d.Credentials.ClientID,
+		&d.Device.StoreID, &d.Device.DeviceKey, &d.Status, &d.DeviceData, &created, &updated, &enrolledAt, &lastSeen,
+		datastore.ACLColumn{ACLs: &d.Credentials.ACLs})
+	if err != nil {
+		return nil, err
+	}
+	d.Created, d.Updated = time.Unix(0, created), time.Unix(0, updated)
+	d.EnrolledAt, d.LastSeen = unixTime(enrolledAt), unixTime(lastSeen)
+	return &d, nil
+}
+
+// unixTime converts a time stored as unix nanoseconds, where zero is a time that is not set
+func unixTime(nanoseconds int64) time.Time {
+	if nanoseconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanoseconds)
+}
+
+// unixNano returns the Unix nanoseconds of a time, with zero for the zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
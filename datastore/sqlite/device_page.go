@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// sortColumns are the columns for the sort orders of device listings
+var sortColumns = map[string]string{
+	datastore.SortCreated: "created",
+	datastore.SortUpdated: "updated",
+	datastore.SortSerial:  "serial_number",
+}
+
+// DeviceListPage fetches a page of the devices for an organization
+func (db *Store) DeviceListPage(query datastore.DeviceQuery) (*datastore.DevicePage, error) {
+	if err := query.Normalize(); err != nil {
+		return nil, err
+	}
+	after, _ := query.After()
+
+	// The total counts the devices that match the filters on every page
+	where, args := deviceFilter(query)
+	page := &datastore.DevicePage{Devices: []domain.Enrollment{}}
+	if err := db.QueryRow(countDevicePageSQL+where, args...).Scan(&page.Total); err != nil {
+		log.Printf("Error counting devices: %v\n", err)
+		return nil, err
+	}
+
+	column := sortColumns[query.Sort]
+	order, direction := "ASC", ">"
+	if query.Descending {
+		order, direction = "DESC", "<"
+	}
+	if after != nil {
+		// Timestamps are stored as unix nanoseconds
+		var value interface{} = after.Value
+		if query.Sort != datastore.SortSerial {
+			t, _ := after.Time()
+			value = t.UnixNano()
+		}
+		args = append(args, value, after.DeviceID)
+		where += fmt.Sprintf(" and (%s, device_id) %s (?, ?)", column, direction)
+	}
+
+	// Fetch an extra device to find out if there is another page
+	args = append(args, query.Limit+1)
+	statement := fmt.Sprintf("%s%s order by %s %s, device_id %s limit ?", listDevicePageSQL, where, column, order, order)
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		log.Printf("Error retrieving devices: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Devices) == query.Limit {
+			page.NextCursor = query.NextCursor(page.Devices[len(page.Devices)-1])
+			break
+		}
+		page.Devices = append(page.Devices, *d)
+	}
+
+	return page, rows.Err()
+}
+
+// deviceFilter returns the where clause and its arguments for the filters of a device query
+func deviceFilter(query datastore.DeviceQuery) (string, []interface{}) {
+	conditions := []string{"org_id=?"}
+	args := []interface{}{query.OrganizationID}
+	add := func(condition string, value interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, value)
+	}
+
+	if query.Status != 0 {
+		add("status=?", query.Status)
+	}
+	if len(query.Brand) > 0 {
+		add("brand=?", query.Brand)
+	}
+	if len(query.Model) > 0 {
+		add("model=?", query.Model)
+	}
+	if len(query.SerialPrefix) > 0 {
+		add("instr(serial_number, ?)=1", query.SerialPrefix)
+	}
+	if !query.CreatedAfter.IsZero() {
+		add("created>=?", query.CreatedAfter.UnixNano())
+	}
+	if !query.CreatedBefore.IsZero() {
+		add("created<?", query.CreatedBefore.UnixNano())
+	}
+	if !query.UpdatedAfter.IsZero() {
+		add("updated>=?", query.UpdatedAfter.UnixNano())
+	}
+	if !query.UpdatedBefore.IsZero() {
+		add("updated<?", query.UpdatedBefore.UnixNano())
+	}
+	if len(query.DeviceData) > 0 {
+		add("instr(device_data, ?)>0", query.DeviceData)
+	}
+
+	return " where " + strings.Join(conditions, " and "), args
+}
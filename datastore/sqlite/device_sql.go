@@ -0,0 +1,152 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+const createDeviceTableSQL string = `
+	CREATE TABLE IF NOT EXISTS device (
+		id                integer primary key autoincrement not null,
+		device_id         varchar(200) not null unique,
+		org_id            varchar(200) not null,
+		brand             varchar(200) not null,
+		model             varchar(200) not null,
+		serial_number     varchar(200) not null,
+		cred_key          text not null,
+		cred_cert         text not null,
+		cred_mqtt         varchar(200) not null,
+		cred_port         varchar(200) not null,
+
+		store_id          varchar(200) default '',
+		device_key        text default '',
+		status            int default 1,
+		device_data       text default '',
+
+		UNIQUE (brand, model, serial_number)
+	)
+`
+
+const createDeviceCreatedIndexSQL = "CREATE INDEX IF NOT EXISTS device_created_idx ON device (org_id, created, device_id)"
+
+const createDeviceUpdatedIndexSQL = "CREATE INDEX IF NOT EXISTS device_updated_idx ON device (org_id, updated, device_id)"
+
+const createDeviceSerialIndexSQL = "CREATE INDEX IF NOT EXISTS device_serial_idx ON device (org_id, serial_number, device_id)"
+
+const createDeviceSQL = `
+insert into device (device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, device_data, created, updated, cred_serial)
+values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+
+const getDeviceSQL = `
+select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device
+where brand=? and model=? and serial_number=?`
+
+const getDeviceByIDSQL = `
+select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device
+where device_id=?`
+
+const getDeviceByOrgIDSQL = `
+select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device
+where device_id=? and org_id=?`
+
+const getDeviceByCertificateSQL = `
+select device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device
+where cred_serial=?`
+
+const enrollDeviceSQL = `
+update device
+set store_id=?, device_key=?, status=?, updated=?, enrolled_at=?, last_seen=?, acls=?
+where brand=? and model=? and serial_number=?
+`
+
+// Replaces the credentials with a certificate signed from the device's request
+const enrollDeviceCertSQL = `
+update device
+set cred_cert=?, cred_key=?, cred_serial=?, updated=?
+where brand=? and model=? and serial_number=?
+`
+
+const renewDeviceSQL = `
+update device
+set cred_cert=?, cred_key=?, cred_serial=?, updated=?, last_seen=?
+where device_id=?
+`
+
+// Replaces the broker details when a device enrolls or its credentials are reissued
+const updateDeviceBrokerSQL = `
+update device
+set cred_mqtt=?, cred_port=?, cred_protocol=?, cred_ca=?, cred_client_id=?, updated=?
+where device_id=?
+`
+
+// Replaces the private key only if it is unchanged, so a concurrent renewal is not overwritten
+const updateDeviceKeySQL = `
+update device
+set cred_key=?
+where device_id=? and cred_key=?
+`
+
+const updateDeviceSQL = `
+update device
+set status=?, device_data=?, updated=?
+where device_id=?
+`
+
+const listDeviceSQL = `
+select device_id, org_id, brand, model, serial_number, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device
+where org_id=?`
+
+// The page queries add the filters, sort order and limit
+const listDevicePageSQL = `
+select device_id, org_id, brand, model, serial_number, cred_cert, cred_mqtt, cred_port, cred_protocol, cred_ca, cred_client_id, store_id, device_key, status, device_data, created, updated, enrolled_at, last_seen, acls
+from device`
+
+const countDevicePageSQL = "select count(*) from device"
+
+const createDeviceCertSerialIndexSQL = "CREATE INDEX IF NOT EXISTS device_cred_serial_idx ON device (cred_serial)"
+
+// The certificates of existing devices are read in batches to fill in their serial numbers
+const listDeviceCertificateSQL = `
+select device_id, cred_cert
+from device
+where cred_serial is null
+limit 1000`
+
+const updateDeviceCertSerialSQL = `
+update device
+set cred_serial=?
+where device_id=?`
+
+// The status is read in the transaction that changes it, to record the transition
+const getDeviceStatusSQL = `
+select device_id, status
+from device
+where brand=? and model=? and serial_number=?`
+
+const getDeviceStatusByIDSQL = `
+select status
+from device
+where device_id=?`
+
+const deleteDeviceSQL = `
+delete from device
+where device_id=?`
@@ -0,0 +1,215 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+)
+
+// migration is a versioned change to the database schema. Released migrations must not
+// be changed, as they will not be applied again: schema changes need a new migration
+type migration struct {
+	version     int
+	description string
+	columns     []column
+	statements  []string
+}
+
+// column is added to a table by a migration. SQLite cannot add a column only if it
+// does not exist, so the column is skipped when the table already has it
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// migrations is the ordered list of schema changes. The changes are idempotent, so
+// they can be applied to databases that were created before versioned migrations
+var migrations = []migration{
+	{1, "Create the organization, device, nonce, revocation and token tables", nil, []string{
+		createOrganizationTableSQL, createDeviceTableSQL, createNonceTableSQL, createRevocationTableSQL, createTokenTableSQL,
+	}},
+	{2, "Add the created and updated times of devices", []column{
+		{"device", "created", "INTEGER NOT NULL DEFAULT 0"},
+		{"device", "updated", "INTEGER NOT NULL DEFAULT 0"},
+	}, []string{
+		createDeviceCreatedIndexSQL, createDeviceUpdatedIndexSQL, createDeviceSerialIndexSQL,
+	}},
+	{3, "Create the tombstone table for deleted devices", nil, []string{createTombstoneTableSQL, createTombstoneBMSIndexSQL}},
+	{4, "Create the audit table", nil, []string{createAuditTableSQL, createAuditTimeIndexSQL, createAuditOrgIndexSQL}},
+	{5, "Add the enrollment and last seen times of devices, and their status history", []column{
+		{"device", "enrolled_at", "INTEGER NOT NULL DEFAULT 0"},
+		{"device", "last_seen", "INTEGER NOT NULL DEFAULT 0"},
+	}, []string{
+		createHistoryTableSQL, createHistoryDeviceIndexSQL,
+	}},
+	{6, "Add the topic ACL templates of organizations and the topic ACLs of devices", []column{
+		{"organization", "acl_templates", "TEXT DEFAULT ''"},
+		{"device", "acls", "TEXT DEFAULT ''"},
+	}, nil},
+	{7, "Create the outbox table", nil, []string{createOutboxTableSQL, createOutboxStatusIndexSQL}},
+	{8, "Add the MQTT broker of organizations and the broker details of device credentials", []column{
+		{"organization", "broker", "TEXT DEFAULT ''"},
+		{"device", "cred_protocol", "VARCHAR(20) DEFAULT ''"},
+		{"device", "cred_ca", "TEXT DEFAULT ''"},
+		{"device", "cred_client_id", "VARCHAR(200) DEFAULT ''"},
+	}, nil},
+	{9, "Create the webhook and webhook delivery tables", nil, []string{
+		createWebhookTableSQL, createWebhookOrgIndexSQL,
+		createWebhookDeliveryTableSQL, createWebhookDeliveryStatusIndexSQL, createWebhookDeliveryWebhookIndexSQL,
+	}},
+	{10, "Add the serial numbers of device certificates", []column{
+		{"device", "cred_serial", "VARCHAR(200)"},
+	}, []string{
+		createDeviceCertSerialIndexSQL,
+	}},
+	{11, "Add the claims of outbox events", []column{
+		{"outbox", "lease_id", "VARCHAR(200) NOT NULL DEFAULT ''"},
+		{"outbox", "lease_until", "INTEGER NOT NULL DEFAULT 0"},
+	}, []string{
+		createOutboxLeaseIndexSQL,
+	}},
+	{12, "Add the index of the outbox events of devices", nil, []string{createOutboxDeviceIndexSQL}},
+	{13, "Add the claims of webhook deliveries", []column{
+		{"webhook_delivery", "lease_id", "VARCHAR(200) NOT NULL DEFAULT ''"},
+		{"webhook_delivery", "lease_until", "INTEGER NOT NULL DEFAULT 0"},
+	}, []string{
+		createWebhookDeliveryLeaseIndexSQL,
+	}},
+}
+
+// backfills fill in the data of a migration that cannot be computed in SQL. They run
+// after the changes of the migration, in its transaction
+var backfills = map[int]func(tx *sql.Tx) error{
+	10: backfillCertificateSerials,
+}
+
+// Migrate applies the pending schema migrations in order, returning the number applied
+func (db *Store) Migrate() (int, error) {
+	count := 0
+	for _, m := range migrations {
+		applied, err := db.migrate(m)
+		if err != nil {
+			return count, err
+		}
+		if applied {
+			log.Printf("Applied schema migration %d: %s\n", m.version, m.description)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// migrate applies a migration in a transaction, unless it has already been applied.
+// The transaction takes the write lock of the database file, so another process that
+// opens the same file waits until the migration is committed
+func (db *Store) migrate(m migration) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting migration %d: %v", m.version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(createSchemaVersionTableSQL); err != nil {
+		return false, fmt.Errorf("error creating the schema version table: %v", err)
+	}
+
+	var applied bool
+	if err = tx.QueryRow(isMigrationAppliedSQL, m.version).Scan(&applied); err != nil {
+		return false, fmt.Errorf("error checking migration %d: %v", m.version, err)
+	}
+	if applied {
+		return false, nil
+	}
+
+	for _, c := range m.columns {
+		var exists bool
+		if err = tx.QueryRow(existsColumnSQL, c.table, c.name).Scan(&exists); err != nil {
+			return false, fmt.Errorf("error checking migration %d: %v", m.version, err)
+		}
+		if exists {
+			continue
+		}
+		if _, err = tx.Exec(fmt.Sprintf(addColumnSQL, c.table, c.name, c.definition)); err != nil {
+			return false, fmt.Errorf("error applying migration %d (%s): %v", m.version, m.description, err)
+		}
+	}
+	for _, statement := range m.statements {
+		if _, err = tx.Exec(statement); err != nil {
+			return false, fmt.Errorf("error applying migration %d (%s): %v", m.version, m.description, err)
+		}
+	}
+	if backfill, ok := backfills[m.version]; ok {
+		if err = backfill(tx); err != nil {
+			return false, fmt.Errorf("error applying migration %d (%s): %v", m.version, m.description, err)
+		}
+	}
+	if _, err = tx.Exec(createSchemaVersionSQL, m.version, m.description, time.Now().UnixNano()); err != nil {
+		return false, fmt.Errorf("error recording migration %d: %v", m.version, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing migration %d: %v", m.version, err)
+	}
+	return true, nil
+}
+
+// backfillCertificateSerials stores the serial numbers of the certificates of existing devices
+func backfillCertificateSerials(tx *sql.Tx) error {
+	for {
+		certificates, err := listDeviceCertificates(tx)
+		if err != nil || len(certificates) == 0 {
+			return err
+		}
+		for deviceID, certPEM := range certificates {
+			if _, err := tx.Exec(updateDeviceCertSerialSQL, datastore.CertificateSerial(certPEM), deviceID); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// listDeviceCertificates fetches a batch of the certificates of devices without a serial number
+func listDeviceCertificates(tx *sql.Tx) (map[string][]byte, error) {
+	rows, err := tx.Query(listDeviceCertificateSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certificates := map[string][]byte{}
+	for rows.Next() {
+		var deviceID string
+		var certPEM []byte
+		if err := rows.Scan(&deviceID, &certPEM); err != nil {
+			return nil, err
+		}
+		certificates[deviceID] = certPEM
+	}
+	return certificates, rows.Err()
+}
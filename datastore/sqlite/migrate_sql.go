@@ -0,0 +1,42 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+// The applied time is stored as Unix nanoseconds
+const createSchemaVersionTableSQL = `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version           integer primary key not null,
+		description       text not null,
+		applied           integer not null
+	)
+`
+
+const isMigrationAppliedSQL = `
+select exists(select 1 from schema_version where version=?)`
+
+const createSchemaVersionSQL = `
+insert into schema_version (version, description, applied)
+values (?,?,?)`
+
+const existsColumnSQL = `
+select exists(select 1 from pragma_table_info(?) where name=?)`
+
+// The table and column names come from the migrations, as they cannot be query parameters
+const addColumnSQL = "ALTER TABLE %s ADD COLUMN %s %s"
@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/canonical/iot-identity/datastore"
+)
+
+func TestMigrations_Ordered(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, m.version, i+1)
+		}
+		if len(m.description) == 0 || len(m.columns)+len(m.statements) == 0 {
+			t.Errorf("migration %d is incomplete", m.version)
+		}
+	}
+}
+
+func TestStore_Migrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		wantErr  bool
+	}{
+		{"new", nil, false},
+		// A database created before versioned migrations, which already has some of the columns
+		{"unversioned", []string{
+			createOrganizationTableSQL, createDeviceTableSQL,
+			"ALTER TABLE device ADD COLUMN created INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE organization ADD COLUMN acl_templates TEXT DEFAULT ''",
+		}, false},
+		{"invalid-schema-version", []string{"CREATE TABLE schema_version (version integer primary key not null)"}, true},
+		{"invalid-table", []string{"CREATE TABLE device (id integer primary key, created text not null)"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "sqlite")
+			if err != nil {
+				t.Fatalf("cannot create database directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "identity.db")
+
+			existing, err := openDatabase(path)
+			if err != nil {
+				t.Fatalf("openDatabase() error = %v", err)
+			}
+			for _, statement := range tt.existing {
+				if _, err := existing.Exec(statement); err != nil {
+					t.Fatalf("cannot create the existing schema: %v", err)
+				}
+			}
+			_ = existing.Close()
+
+			db, err := OpenStore(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OpenStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer db.Close()
+
+			var count int
+			if err := db.QueryRow("select count(*) from schema_version").Scan(&count); err != nil || count != len(migrations) {
+				t.Errorf("schema versions = %d, error = %v, want %d", count, err, len(migrations))
+			}
+
+			// The migrations are not applied again
+			if applied, err := db.Migrate(); err != nil || applied != 0 {
+				t.Errorf("Store.Migrate() = %d, error = %v, want none", applied, err)
+			}
+		})
+	}
+}
+
+func TestStore_MigrateCertificateSerials(t *testing.T) {
+	certPEM, err := ioutil.ReadFile("../test_data/ca.crt")
+	if err != nil {
+		t.Fatalf("cannot read certificate: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatalf("cannot create database directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "identity.db")
+
+	// A device created before the serial numbers of certificates were stored
+	existing, err := openDatabase(path)
+	if err != nil {
+		t.Fatalf("openDatabase() error = %v", err)
+	}
+	for _, m := range migrations[:9] {
+		if _, err := existing.migrate(m); err != nil {
+			t.Fatalf("Store.migrate() error = %v", err)
+		}
+	}
+	if _, err := existing.Exec("insert into organization (org_id, name, root_cert, root_key) values ('abc','Example Inc','','')"); err != nil {
+		t.Fatalf("cannot create organization: %v", err)
+	}
+	if _, err := existing.Exec("insert into device (device_id, org_id, brand, model, serial_number, cred_key, cred_cert, cred_mqtt, cred_port) values ('a111','abc','example','drone-1000','a111','',?,'','')", certPEM); err != nil {
+		t.Fatalf("cannot create device: %v", err)
+	}
+	_ = existing.Close()
+
+	db, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	defer db.Close()
+
+	serial := datastore.CertificateSerial(certPEM)
+	en, err := db.DeviceGetByCertificate(serial)
+	if err != nil {
+		t.Fatalf("Store.DeviceGetByCertificate() error = %v", err)
+	}
+	if en.ID != "a111" {
+		t.Errorf("Store.DeviceGetByCertificate() = %v, want a111", en.ID)
+	}
+}
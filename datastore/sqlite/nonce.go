@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// NonceNew stores a nonce for a device, removing any expired nonces
+func (db *Store) NonceNew(nonce domain.Nonce) error {
+	_, err := db.exec(deleteExpiredNonceSQL, time.Now().UnixNano())
+	if err != nil {
+		log.Printf("Error removing expired nonces: %v\n", err)
+	}
+
+	_, err = db.exec(createNonceSQL, nonce.Value, nonce.Brand, nonce.Model, nonce.SerialNumber, nonce.Expires.UnixNano())
+	if err != nil {
+		log.Printf("Error creating nonce: %v\n", err)
+	}
+	return err
+}
+
+// NonceUse fetches and removes a nonce for a device, so it can only be used once
+func (db *Store) NonceUse(value, brand, model, serial string) (*domain.Nonce, error) {
+	n, err := db.nonceUse(value, brand, model, serial)
+	if err != nil {
+		log.Printf("Error retrieving nonce: %v\n", err)
+		return nil, fmt.Errorf("the nonce for device `%s/%s/%s` is not valid", brand, model, serial)
+	}
+	return n, nil
+}
+
+// nonceUse fetches and removes a nonce in a single transaction
+func (db *Store) nonceUse(value, brand, model, serial string) (*domain.Nonce, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var expires int64
+	n := domain.Nonce{}
+	err = tx.QueryRow(getNonceSQL, value, brand, model, serial).Scan(&n.Value, &n.Brand, &n.Model, &n.SerialNumber, &expires)
+	if err != nil {
+		return nil, err
+	}
+	n.Expires = time.Unix(0, expires)
+
+	if _, err = tx.Exec(deleteNonceSQL, value); err != nil {
+		return nil, err
+	}
+	return &n, tx.Commit()
+}
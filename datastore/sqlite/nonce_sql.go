@@ -0,0 +1,49 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+// Timestamps are stored as Unix nanoseconds, so they compare and sort correctly
+const createNonceTableSQL string = `
+	CREATE TABLE IF NOT EXISTS nonce (
+		id                integer primary key autoincrement not null,
+		nonce             varchar(200) not null unique,
+		brand             varchar(200) not null,
+		model             varchar(200) not null,
+		serial_number     varchar(200) not null,
+		expires           integer not null
+	)
+`
+
+const createNonceSQL = `
+insert into nonce (nonce, brand, model, serial_number, expires)
+values (?,?,?,?,?)`
+
+const deleteExpiredNonceSQL = `
+delete from nonce
+where expires<?`
+
+const getNonceSQL = `
+select nonce, brand, model, serial_number, expires
+from nonce
+where nonce=? and brand=? and model=? and serial_number=?`
+
+const deleteNonceSQL = `
+delete from nonce
+where nonce=?`
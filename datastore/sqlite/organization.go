@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"log"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// OrganizationNew creates a new organization
+func (db *Store) OrganizationNew(org datastore.OrganizationNewRequest) (string, error) {
+	var orgID = datastore.GenerateID()
+	keyMode := org.KeyMode
+	if keyMode == 0 {
+		keyMode = domain.KeyModeServer
+	}
+	_, err := db.exec(createOrganizationSQL, orgID, org.Name, org.CountryName, org.ServerCert, org.ServerKey, keyMode, org.CertValidityDays, org.KeyType, datastore.ACLColumn{ACLs: &org.ACLTemplates}, datastore.BrokerColumn{Broker: &org.Broker})
+	if err != nil {
+		log.Printf("Error creating organization: %v\n", err)
+	}
+
+	return orgID, err
+}
+
+// OrganizationList fetches existing organizations
+func (db *Store) OrganizationList() ([]domain.Organization, error) {
+	var id int64
+	rows, err := db.Query(listOrganizationSQL)
+	if err != nil {
+		log.Printf("Error retrieving organizations: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []domain.Organization{}
+	for rows.Next() {
+		item := domain.Organization{}
+		err := rows.Scan(&id, &item.ID, &item.Name, &item.RootCert, &item.KeyMode, &item.CertValidityDays, &item.KeyType, datastore.ACLColumn{ACLs: &item.ACLTemplates}, datastore.BrokerColumn{Broker: &item.Broker})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// OrganizationGet fetches an organization by ID
+func (db *Store) OrganizationGet(orgID string) (*domain.Organization, error) {
+	var id int64
+	var countryName string
+	org := domain.Organization{}
+
+	err := db.QueryRow(getOrganizationSQL, orgID).Scan(&id, &org.ID, &org.Name, &countryName, &org.RootCert, &org.RootKey, &org.KeyMode, &org.CertValidityDays, &org.KeyType, datastore.ACLColumn{ACLs: &org.ACLTemplates}, datastore.BrokerColumn{Broker: &org.Broker})
+	if err != nil {
+		log.Printf("Error retrieving organization %v: %v\n", orgID, err)
+	}
+	return &org, err
+}
+
+// OrganizationGetByName fetches an organization by name
+func (db *Store) OrganizationGetByName(name string) (*domain.Organization, error) {
+	var id int64
+	var countryName string
+	org := domain.Organization{}
+
+	err := db.QueryRow(getOrganizationByNameSQL, name).Scan(&id, &org.ID, &org.Name, &countryName, &org.RootCert, &org.RootKey, &org.KeyMode, &org.CertValidityDays, &org.KeyType, datastore.ACLColumn{ACLs: &org.ACLTemplates}, datastore.BrokerColumn{Broker: &org.Broker})
+	if err != nil {
+		log.Printf("Error retrieving organization `%v`: %v\n", name, err)
+	}
+	return &org, err
+}
+
+// OrganizationDelete removes an organization, its API tokens and its webhooks in a single transaction
+func (db *Store) OrganizationDelete(orgID string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error deleting organization %v: %v\n", orgID, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(deleteOrganizationTokensSQL, orgID); err != nil {
+		log.Printf("Error deleting the tokens of organization %v: %v\n", orgID, err)
+		return err
+	}
+	for _, statement := range []string{deleteOrganizationWebhooksSQL, deleteOrganizationDeliveriesSQL} {
+		if _, err := tx.Exec(statement, orgID); err != nil {
+			log.Printf("Error deleting the webhooks of organization %v: %v\n", orgID, err)
+			return err
+		}
+	}
+
+	result, err := tx.Exec(deleteOrganizationSQL, orgID)
+	if err != nil {
+		log.Printf("Error deleting organization %v: %v\n", orgID, err)
+		return err
+	}
+	if err := checkUpdated(result, "cannot find organization with ID '%s'", orgID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// OrganizationUpdateKey replaces the stored private key of an organization's CA
+func (db *Store) OrganizationUpdateKey(orgID string, rootKey []byte) error {
+	result, err := db.exec(updateOrganizationKeySQL, rootKey, orgID)
+	if err != nil {
+		log.Printf("Error updating organization key %v: %v\n", orgID, err)
+		return err
+	}
+	return checkUpdated(result, "cannot find organization with ID '%s'", orgID)
+}
+
+// OrganizationUpdateBroker replaces the MQTT broker of an organization
+func (db *Store) OrganizationUpdateBroker(orgID string, broker domain.Broker) error {
+	result, err := db.exec(updateOrganizationBrokerSQL, datastore.BrokerColumn{Broker: &broker}, orgID)
+	if err != nil {
+		log.Printf("Error updating organization broker %v: %v\n", orgID, err)
+		return err
+	}
+	return checkUpdated(result, "cannot find organization with ID '%s'", orgID)
+}
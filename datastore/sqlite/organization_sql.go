@@ -0,0 +1,76 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+const createOrganizationTableSQL string = `
+	CREATE TABLE IF NOT EXISTS organization (
+		id                integer primary key autoincrement not null,
+		org_id            varchar(200) not null unique,
+		name              varchar(200) not null unique,
+		country_name      varchar(200) default '',
+		root_cert         text not null,
+		root_key          text not null,
+		key_mode          int default 1,
+		cert_validity     int default 0,
+		key_type          varchar(50) default ''
+	)
+`
+
+const createOrganizationSQL = `
+insert into organization (org_id, name, country_name, root_cert, root_key, key_mode, cert_validity, key_type, acl_templates, broker)
+values (?,?,?,?,?,?,?,?,?,?)`
+
+const listOrganizationSQL = `
+select id, org_id, name, root_cert, key_mode, cert_validity, key_type, acl_templates, broker
+from organization`
+
+const getOrganizationSQL = `
+select id, org_id, name, country_name, root_cert, root_key, key_mode, cert_validity, key_type, acl_templates, broker
+from organization
+where org_id=?`
+
+// The organization of a device leaves out the CA key
+const getDeviceOrganizationSQL = `
+select org_id, name, root_cert
+from organization
+where org_id=?`
+
+const getOrganizationByNameSQL = `
+select id, org_id, name, country_name, root_cert, root_key, key_mode, cert_validity, key_type, acl_templates, broker
+from organization
+where name=?`
+
+const updateOrganizationKeySQL = `
+update organization
+set root_key=?
+where org_id=?`
+
+const updateOrganizationBrokerSQL = `
+update organization
+set broker=?
+where org_id=?`
+
+const deleteOrganizationSQL = `
+delete from organization
+where org_id=?`
+
+const deleteOrganizationTokensSQL = `
+delete from token
+where org_id=?`
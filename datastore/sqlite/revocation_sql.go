@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+// The revoked timestamp is stored as Unix nanoseconds
+const createRevocationTableSQL string = `
+	CREATE TABLE IF NOT EXISTS revocation (
+		id                integer primary key autoincrement not null,
+		org_id            varchar(200) not null,
+		device_id         varchar(200) not null,
+		serial_number     varchar(200) not null,
+		reason            int not null,
+		revoked           integer not null,
+		UNIQUE (org_id, serial_number)
+	)
+`
+
+const createRevocationSQL = `
+insert into revocation (org_id, device_id, serial_number, reason, revoked)
+values (?,?,?,?,?)`
+
+const getRevocationSQL = `
+select org_id, device_id, serial_number, reason, revoked
+from revocation
+where org_id=? and serial_number=?`
+
+const listRevocationSQL = `
+select org_id, device_id, serial_number, reason, revoked
+from revocation
+where org_id=?
+order by revoked`
@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/canonical/iot-identity/datastore"
+	_ "github.com/mattn/go-sqlite3" // sqlite3 driver
+)
+
+// connectionParams enables write-ahead logging, so readers do not block the writer, and waits
+// for a locked database instead of failing straight away
+const connectionParams = "_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// Store implements a SQLite data store
+type Store struct {
+	*sql.DB
+
+	// writes are serialized, as SQLite only allows a single writer
+	lock sync.Mutex
+}
+
+// OpenStore returns an open database connection to the database file
+func OpenStore(dataSource string) (*Store, error) {
+	db, err := openDatabase(dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bring the schema up to date, as the store cannot run against an older one
+	if _, err := db.Migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error migrating the database: %v", err)
+	}
+	return db, nil
+}
+
+// openDatabase return an open database connection for a SQLite database
+func openDatabase(dataSource string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dataSourceName(dataSource))
+	if err != nil {
+		return nil, fmt.Errorf("error opening the database: %v", err)
+	}
+
+	// Check that we have a valid database connection
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error accessing the database: %v", err)
+	}
+
+	return &Store{DB: db}, nil
+}
+
+// dataSourceName adds the connection parameters to the database file path
+func dataSourceName(dataSource string) string {
+	if strings.Contains(dataSource, "?") {
+		return dataSource + "&" + connectionParams
+	}
+	return "file:" + strings.TrimPrefix(dataSource, "file:") + "?" + connectionParams
+}
+
+// exec runs a statement that modifies the database, holding the write lock
+func (db *Store) exec(query string, args ...interface{}) (sql.Result, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.Exec(query, args...)
+}
+
+// checkUpdated returns a not found error when a statement did not change any rows
+func checkUpdated(result sql.Result, format string, args ...interface{}) error {
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%w: "+format, append([]interface{}{datastore.ErrNotFound}, args...)...)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"log"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// TokenNew stores a new API token
+func (db *Store) TokenNew(token domain.Token) (string, error) {
+	tokenID := datastore.GenerateID()
+	_, err := db.exec(createTokenSQL, tokenID, token.Name, token.OrganizationID, token.Role, token.Hash)
+	if err != nil {
+		log.Printf("Error creating token: %v\n", err)
+	}
+	return tokenID, err
+}
+
+// TokenGetByHash fetches an API token by the hash of its value
+func (db *Store) TokenGetByHash(hash string) (*domain.Token, error) {
+	token := domain.Token{}
+	err := db.QueryRow(getTokenByHashSQL, hash).Scan(&token.ID, &token.Name, &token.OrganizationID, &token.Role, &token.Hash)
+	if err != nil {
+		log.Printf("Error retrieving token: %v\n", err)
+	}
+	return &token, err
+}
@@ -0,0 +1,41 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+const createTokenTableSQL string = `
+	CREATE TABLE IF NOT EXISTS token (
+		id                integer primary key autoincrement not null,
+		token_id          varchar(200) not null unique,
+		name              varchar(200) not null,
+		org_id            varchar(200) default '',
+		role              varchar(50) not null,
+		hash              varchar(200) not null unique,
+		created           timestamp default current_timestamp
+	)
+`
+
+const createTokenSQL = `
+insert into token (token_id, name, org_id, role, hash)
+values (?,?,?,?,?)`
+
+const getTokenByHashSQL = `
+select token_id, name, org_id, role, hash
+from token
+where hash=?`
@@ -0,0 +1,90 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// DeviceDelete removes a device and records its tombstone in a single transaction
+func (db *Store) DeviceDelete(tombstone domain.Tombstone) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error deleting the device: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// The event has the device as it was before the deletion
+	err = outboxNew(tx, domain.EventDeviceDelete, tombstone.DeviceID, time.Now().UnixNano())
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: the device `%s` is not registered", datastore.ErrNotFound, tombstone.DeviceID)
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(deleteDeviceSQL, tombstone.DeviceID)
+	if err != nil {
+		log.Printf("Error deleting the device: %v\n", err)
+		return err
+	}
+	if err := checkUpdated(result, "the device `%s` is not registered", tombstone.DeviceID); err != nil {
+		return err
+	}
+
+	// The history goes with the device, as the audit log keeps the record of the deletion
+	if _, err := tx.Exec(deleteHistorySQL, tombstone.DeviceID); err != nil {
+		log.Printf("Error deleting the device history: %v\n", err)
+		return err
+	}
+
+	_, err = tx.Exec(createTombstoneSQL, tombstone.DeviceID, tombstone.OrganizationID, tombstone.Brand, tombstone.Model, tombstone.SerialNumber, tombstone.Deleted.UnixNano(), tombstone.DeletedBy)
+	if err != nil {
+		log.Printf("Error creating the device tombstone: %v\n", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// TombstoneGet fetches the latest tombstone of a deleted device
+func (db *Store) TombstoneGet(brand, model, serial string) (*domain.Tombstone, error) {
+	var deleted int64
+	t := domain.Tombstone{}
+	err := db.QueryRow(getTombstoneSQL, brand, model, serial).Scan(&t.DeviceID, &t.OrganizationID, &t.Brand, &t.Model, &t.SerialNumber, &deleted, &t.DeletedBy)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: the device `%s/%s/%s` has not been deleted", datastore.ErrNotFound, brand, model, serial)
+	}
+	if err != nil {
+		log.Printf("Error retrieving the device tombstone: %v\n", err)
+		return nil, err
+	}
+	t.Deleted = time.Unix(0, deleted)
+	return &t, nil
+}
@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+// The events are the JSON list of the event types, with an empty list for every event
+const createWebhookTableSQL string = `
+	CREATE TABLE IF NOT EXISTS webhook (
+		id                integer primary key autoincrement not null,
+		webhook_id        varchar(200) not null unique,
+		org_id            varchar(200) not null,
+		url               text not null,
+		events            text not null default '[]',
+		secret            blob not null,
+		created           integer not null
+	)
+`
+
+const createWebhookOrgIndexSQL = "CREATE INDEX IF NOT EXISTS webhook_org_idx ON webhook (org_id, id)"
+
+// The times are stored as Unix nanoseconds, with zero for a delivery that is not delivered
+const createWebhookDeliveryTableSQL string = `
+	CREATE TABLE IF NOT EXISTS webhook_delivery (
+		id                integer primary key autoincrement not null,
+		delivery_id       varchar(200) not null unique,
+		webhook_id        varchar(200) not null,
+		org_id            varchar(200) not null,
+		event_id          varchar(200) not null default '',
+		event_type        varchar(50) not null,
+		payload           text not null,
+		status            int not null default 1,
+		attempts          int not null default 0,
+		next_attempt      integer not null default 0,
+		response_code     int not null default 0,
+		last_error        text not null default '',
+		created           integer not null,
+		delivered         integer not null default 0
+	)
+`
+
+const createWebhookDeliveryStatusIndexSQL = "CREATE INDEX IF NOT EXISTS webhook_delivery_status_idx ON webhook_delivery (status, id)"
+
+const createWebhookDeliveryWebhookIndexSQL = "CREATE INDEX IF NOT EXISTS webhook_delivery_webhook_idx ON webhook_delivery (webhook_id, id)"
+
+const createWebhookSQL = `
+insert into webhook (webhook_id, org_id, url, events, secret, created)
+values (?,?,?,?,?,?)`
+
+const listWebhookSQL = `
+select webhook_id, org_id, url, events, secret, created
+from webhook
+where org_id=?
+order by id`
+
+const getWebhookSQL = `
+select webhook_id, org_id, url, events, secret, created
+from webhook
+where org_id=? and webhook_id=?`
+
+const updateWebhookSecretSQL = `
+update webhook
+set secret=?
+where org_id=? and webhook_id=?`
+
+const deleteWebhookSQL = `
+delete from webhook
+where org_id=? and webhook_id=?`
+
+const deleteWebhookDeliveriesSQL = `
+delete from webhook_delivery
+where webhook_id=?`
+
+const deleteOrganizationWebhooksSQL = `
+delete from webhook
+where org_id=?`
+
+const deleteOrganizationDeliveriesSQL = `
+delete from webhook_delivery
+where org_id=?`
+
+const createWebhookDeliverySQL = `
+insert into webhook_delivery (delivery_id, webhook_id, org_id, event_id, event_type, payload, status, attempts, next_attempt, response_code, last_error, created, delivered)
+values (?,?,?,?,?,?,?,?,?,?,?,?,?)`
+
+// The list query adds the filters, order and limit
+const listWebhookDeliverySQL = `
+select delivery_id, webhook_id, org_id, event_id, event_type, payload, status, attempts, next_attempt, response_code, last_error, created, delivered, lease_id
+from webhook_delivery`
+
+// Updating a delivery ends its claim. A delivery is only updated by the holder of its claim,
+// or while it is not claimed
+const updateWebhookDeliverySQL = `
+update webhook_delivery
+set status=?, attempts=?, next_attempt=?, response_code=?, last_error=?, delivered=?, lease_id='', lease_until=0
+where delivery_id=? and lease_id=?`
+
+const countWebhookDeliverySQL = "select count(*) from webhook_delivery where delivery_id=?"
+
+const createWebhookDeliveryLeaseIndexSQL = "CREATE INDEX IF NOT EXISTS webhook_delivery_lease_idx ON webhook_delivery (lease_id)"
+
+// The pending deliveries that are due and not claimed already are claimed, oldest first
+const claimWebhookDeliverySQL = `
+update webhook_delivery
+set lease_id=?1, lease_until=?2
+where id in (
+	select id from webhook_delivery
+	where status=?3 and next_attempt<=?4 and lease_until<=?4
+	order by id
+	limit ?5
+)`
+
+const listWebhookDeliveryClaimSQL = `
+select delivery_id, webhook_id, org_id, event_id, event_type, payload, status, attempts, next_attempt, response_code, last_error, created, delivered, lease_id
+from webhook_delivery
+where lease_id=?
+order by id`
+
+const pruneWebhookDeliverySQL = `
+delete from webhook_delivery
+where status=? and delivered<?`
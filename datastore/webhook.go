@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// WebhookDeliveryQuery selects webhook deliveries, oldest first. Empty filters match every delivery
+type WebhookDeliveryQuery struct {
+	OrganizationID string
+	WebhookID      string
+	EventID        string
+	Status         domain.OutboxStatus
+	Limit          int
+}
+
+// Normalize checks the query, setting the default page size
+func (q *WebhookDeliveryQuery) Normalize() error {
+	if q.Limit < 0 || q.Limit > MaxPageLimit {
+		return fmt.Errorf("the limit must be from 1 to %d", MaxPageLimit)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageLimit
+	}
+	return nil
+}
+
+// Matches checks whether a delivery passes the filters of the query
+func (q WebhookDeliveryQuery) Matches(d domain.WebhookDelivery) bool {
+	return (len(q.OrganizationID) == 0 || d.OrganizationID == q.OrganizationID) &&
+		(len(q.WebhookID) == 0 || d.WebhookID == q.WebhookID) &&
+		(len(q.EventID) == 0 || d.EventID == q.EventID) &&
+		(q.Status == 0 || d.Status == q.Status)
+}
+
+// EventTypesColumn stores the event types of a webhook as JSON text in a column of the SQL
+// data stores, with an empty list for every event
+type EventTypesColumn struct {
+	Events *[]domain.EventType
+}
+
+// Scan decodes the event types from the column
+func (c EventTypesColumn) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan event types from %T", src)
+	}
+
+	events := []domain.EventType{}
+	if err := json.Unmarshal(data, &events); err != nil {
+		return err
+	}
+	*c.Events = events
+	return nil
+}
+
+// Value encodes the event types for the column
+func (c EventTypesColumn) Value() (driver.Value, error) {
+	events := *c.Events
+	if events == nil {
+		events = []domain.EventType{}
+	}
+	data, err := json.Marshal(events)
+	return string(data), err
+}
@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestEventTypesColumn(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    []domain.EventType
+		want      []domain.EventType
+		wantValue string
+	}{
+		{"events", []domain.EventType{domain.EventDeviceEnroll, domain.EventDeviceEnrollFailed}, []domain.EventType{domain.EventDeviceEnroll, domain.EventDeviceEnrollFailed}, `["device-enroll","device-enroll-failed"]`},
+		{"every-event", nil, []domain.EventType{}, `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := EventTypesColumn{Events: &tt.events}.Value()
+			if err != nil || value != tt.wantValue {
+				t.Fatalf("EventTypesColumn.Value() = %v, %v, want %v", value, err, tt.wantValue)
+			}
+
+			// The column is read as text or bytes, depending on the driver
+			for _, src := range []interface{}{tt.wantValue, []byte(tt.wantValue)} {
+				var got []domain.EventType
+				if err := (EventTypesColumn{Events: &got}).Scan(src); err != nil {
+					t.Fatalf("EventTypesColumn.Scan() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("EventTypesColumn.Scan() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	for _, src := range []interface{}{int64(1), "not json"} {
+		var got []domain.EventType
+		if err := (EventTypesColumn{Events: &got}).Scan(src); err == nil {
+			t.Errorf("EventTypesColumn.Scan(%v) expected error", src)
+		}
+	}
+}
+
+func TestWebhookDeliveryQuery(t *testing.T) {
+	d := domain.WebhookDelivery{ID: "1", WebhookID: "w1", OrganizationID: "abc", EventID: "e1", Status: domain.OutboxPending}
+	tests := []struct {
+		name    string
+		query   WebhookDeliveryQuery
+		matches bool
+		wantErr bool
+	}{
+		{"every-delivery", WebhookDeliveryQuery{}, true, false},
+		{"filters", WebhookDeliveryQuery{OrganizationID: "abc", WebhookID: "w1", EventID: "e1", Status: domain.OutboxPending}, true, false},
+		{"other-organization", WebhookDeliveryQuery{OrganizationID: "def"}, false, false},
+		{"other-webhook", WebhookDeliveryQuery{WebhookID: "w2"}, false, false},
+		{"other-event", WebhookDeliveryQuery{EventID: "e2"}, false, false},
+		{"other-status", WebhookDeliveryQuery{Status: domain.OutboxFailed}, false, false},
+		{"invalid-limit", WebhookDeliveryQuery{Limit: MaxPageLimit + 1}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.query.Normalize(); (err != nil) != tt.wantErr {
+				t.Fatalf("WebhookDeliveryQuery.Normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.query.Limit != DefaultPageLimit {
+				t.Errorf("WebhookDeliveryQuery.Normalize() limit = %v, want %v", tt.query.Limit, DefaultPageLimit)
+			}
+			if got := tt.query.Matches(d); got != tt.matches {
+				t.Errorf("WebhookDeliveryQuery.Matches() = %v, want %v", got, tt.matches)
+			}
+		})
+	}
+}
@@ -19,6 +19,8 @@
 
 package domain
 
+import "time"
+
 // Status is a top-level enrollment status classification
 type Status int
 
@@ -29,12 +31,68 @@ const (
 	StatusDisabled
 )
 
-// Organization details for an account
+// KeyMode is how the private key for a device certificate is created
+type KeyMode int
+
+// Key modes for the device credentials of an organization
+const (
+	KeyModeServer KeyMode = iota + 1 // the service generates the private key
+	KeyModeCSR                       // the device signs a certificate request with its own key
+)
+
+// Protocol is the transport that devices use to connect to the MQTT broker
+type Protocol string
+
+// Transports of the MQTT broker
+const (
+	ProtocolMQTTS Protocol = "mqtts" // MQTT over TLS
+	ProtocolWSS   Protocol = "wss"   // MQTT over secure websockets
+)
+
+// Broker is the MQTT broker of an organization's devices. Empty fields use the broker of
+// the service. The CA bundle verifies the TLS certificate of the broker, and the client
+// ID format has the same placeholders as the ACL templates, with the device ID by default
+type Broker struct {
+	Host           string   `json:"host,omitempty"`
+	Port           string   `json:"port,omitempty"`
+	Protocol       Protocol `json:"protocol,omitempty"`
+	CABundle       []byte   `json:"caBundle,omitempty"`
+	ClientIDFormat string   `json:"clientIdFormat,omitempty"`
+}
+
+// Access is the access to an MQTT topic that an ACL grants
+type Access string
+
+// Access to the topics of an ACL
+const (
+	AccessRead      Access = "read"      // subscribe to the topic
+	AccessWrite     Access = "write"     // publish to the topic
+	AccessReadWrite Access = "readwrite" // subscribe and publish
+)
+
+// TopicACL grants access to an MQTT topic filter. In the ACL templates of an organization,
+// the topic has placeholders for the device: {orgid}, {deviceid}, {brand}, {model} and {serial}
+type TopicACL struct {
+	Topic  string `json:"topic"`
+	Access Access `json:"access"`
+}
+
+// Organization details for an account.
+// The validity of device certificates is in days, with zero meaning the default.
+// The key type selects the algorithm of device keys, with empty meaning the service default.
+// The ACL templates are rendered into the topic ACLs of each device when it enrolls.
+// The broker is the MQTT broker that the credentials of the devices connect to.
+// The CA private key is never encoded, and is only loaded when the organization is fetched by itself
 type Organization struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	RootCert []byte `json:"rootcert"`
-	RootKey  []byte `json:"-,omitempty"`
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	RootCert         []byte     `json:"rootcert"`
+	RootKey          []byte     `json:"-"`
+	KeyMode          KeyMode    `json:"keyMode"`
+	CertValidityDays int        `json:"certValidityDays"`
+	KeyType          string     `json:"keyType"`
+	ACLTemplates     []TopicACL `json:"aclTemplates,omitempty"`
+	Broker           Broker     `json:"broker"`
 }
 
 // Device details
@@ -46,15 +104,53 @@ type Device struct {
 	DeviceKey    string `json:"deviceKey,omitempty"`
 }
 
-// Credentials for accessing the MQTT broker
+// Credentials for accessing the MQTT broker. The certificate chain is the device
+// certificate followed by the CA certificates that issued it. The ACLs are the topics
+// that the device may use, rendered from the organization's templates when it enrolled.
+// The broker details come from the organization when the device is registered, enrolls
+// or has its credentials reissued
 type Credentials struct {
-	PrivateKey  []byte `json:"privateKey,omitempty"`
-	Certificate []byte `json:"certificate"`
-	MQTTURL     string `json:"mqttUrl"`
-	MQTTPort    string `json:"mqttPort"`
+	PrivateKey       []byte     `json:"privateKey,omitempty"`
+	Certificate      []byte     `json:"certificate"`
+	CertificateChain []byte     `json:"certificateChain,omitempty"`
+	MQTTURL          string     `json:"mqttUrl"`
+	MQTTPort         string     `json:"mqttPort"`
+	MQTTProtocol     Protocol   `json:"mqttProtocol,omitempty"`
+	MQTTCABundle     []byte     `json:"mqttCaBundle,omitempty"`
+	ClientID         string     `json:"clientId,omitempty"`
+	ACLs             []TopicACL `json:"acls,omitempty"`
+}
+
+// Nonce is a single-use challenge that a device signs with its device-key to
+// prove that it holds the private key
+type Nonce struct {
+	Value        string    `json:"nonce"`
+	Brand        string    `json:"brand"`
+	Model        string    `json:"model"`
+	SerialNumber string    `json:"serial"`
+	Expires      time.Time `json:"expires"`
 }
 
-// Enrollment details for a device
+// RevocationReason is the CRL reason code for revoking a certificate (RFC 5280)
+type RevocationReason int
+
+// Revocation reasons used by the service
+const (
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+)
+
+// Revocation of a device certificate, identified by the serial number of the certificate
+type Revocation struct {
+	OrganizationID string           `json:"orgid"`
+	DeviceID       string           `json:"deviceid"`
+	SerialNumber   string           `json:"serialNumber"`
+	Reason         RevocationReason `json:"reason"`
+	Revoked        time.Time        `json:"revoked"`
+}
+
+// Enrollment details for a device, with the times that it was registered and last changed
 type Enrollment struct {
 	ID           string       `json:"id"`
 	Device       Device       `json:"device"`
@@ -62,4 +158,193 @@ type Enrollment struct {
 	Organization Organization `json:"organization"`
 	Status       Status       `json:"status"`
 	DeviceData   string       `json:"deviceData"`
+	Created      time.Time    `json:"created"`
+	Updated      time.Time    `json:"updated"`
+	EnrolledAt   time.Time    `json:"enrolledAt"`
+	LastSeen     time.Time    `json:"lastSeen"`
+}
+
+// StatusChange is a transition in the status of a device. The status before a device
+// is registered is zero
+type StatusChange struct {
+	DeviceID string    `json:"deviceid"`
+	From     Status    `json:"from"`
+	To       Status    `json:"to"`
+	Time     time.Time `json:"time"`
+}
+
+// Tombstone records a deleted device, so that its serial number is only registered
+// again deliberately. The deleted-by field is the subject of the admin API caller
+type Tombstone struct {
+	DeviceID       string    `json:"id"`
+	OrganizationID string    `json:"orgid"`
+	Brand          string    `json:"brand"`
+	Model          string    `json:"model"`
+	SerialNumber   string    `json:"serial"`
+	Deleted        time.Time `json:"deleted"`
+	DeletedBy      string    `json:"deletedBy"`
+}
+
+// Actions recorded in the audit log
+const (
+	ActionOrganizationRegister = "organization-register"
+	ActionOrganizationDelete   = "organization-delete"
+	ActionOrganizationUpdate   = "organization-update"
+	ActionOrganizationReissue  = "organization-reissue"
+	ActionDeviceRegister       = "device-register"
+	ActionDeviceUpdate         = "device-update"
+	ActionDeviceDelete         = "device-delete"
+	ActionDeviceEnroll         = "device-enroll"
+	ActionDeviceRenew          = "device-renew"
+	ActionTokenRegister        = "token-register"
+	ActionOutboxReplay         = "outbox-replay"
+	ActionWebhookRegister      = "webhook-register"
+	ActionWebhookDelete        = "webhook-delete"
+	ActionWebhookTest          = "webhook-test"
+)
+
+// ActorDevice is the actor of the audit events that devices cause, by enrolling or renewing their certificate
+const ActorDevice = "device"
+
+// AuditEvent is an entry in the append-only audit log. The actor is the subject of the
+// admin API caller, or the device. The statuses of the device before and after the action
+// are zero when they do not apply. A failed action is recorded with the reason
+type AuditEvent struct {
+	ID             string    `json:"id"`
+	Time           time.Time `json:"time"`
+	Actor          string    `json:"actor"`
+	Action         string    `json:"action"`
+	OrganizationID string    `json:"orgid,omitempty"`
+	DeviceID       string    `json:"deviceid,omitempty"`
+	Before         Status    `json:"before,omitempty"`
+	After          Status    `json:"after,omitempty"`
+	Success        bool      `json:"success"`
+	Reason         string    `json:"reason,omitempty"`
+	Details        string    `json:"details,omitempty"`
+}
+
+// EventType is the kind of change to a device that an outbox event records
+type EventType string
+
+// Types of outbox events
+const (
+	EventDeviceRegister     EventType = "device-register"
+	EventDeviceEnroll       EventType = "device-enroll"
+	EventDeviceEnrollFailed EventType = "device-enroll-failed" // an enrollment of the registered device was rejected
+	EventDeviceDisable      EventType = "device-disable"       // the device can no longer connect: it is disabled, or must enroll again
+	EventDeviceDelete       EventType = "device-delete"
+	EventDeviceRenew        EventType = "device-renew"
+	EventDeviceReissue      EventType = "device-reissue" // the broker details of the device are to be reissued
+)
+
+// EventTypes are the types of outbox events that webhooks subscribe to, in the order of the
+// life of a device. Reissue events are only applied by the service
+var EventTypes = []EventType{
+	EventDeviceRegister, EventDeviceEnroll, EventDeviceEnrollFailed, EventDeviceDisable, EventDeviceDelete, EventDeviceRenew,
+}
+
+// OutboxStatus is the delivery state of an outbox event
+type OutboxStatus int
+
+// Delivery states of outbox events
+const (
+	OutboxPending   OutboxStatus = iota + 1
+	OutboxDelivered              // every sink has accepted the event
+	OutboxFailed                 // dead-lettered after the last attempt, until it is replayed
+)
+
+// OutboxEvent is a change to a device that is delivered to the sinks of the outbox, such as
+// the MQTT broker. It is recorded in the same transaction as the change, with the device as
+// it was after the change, without its private key. Failed deliveries are retried from the
+// next attempt time, with the error of the last attempt. An update of the event must match
+// its lease ID, so only the holder of a claim records the delivery of the event
+type OutboxEvent struct {
+	ID             string       `json:"id"`
+	Type           EventType    `json:"type"`
+	OrganizationID string       `json:"orgid"`
+	DeviceID       string       `json:"deviceid"`
+	Device         Enrollment   `json:"device"`
+	Status         OutboxStatus `json:"status"`
+	Attempts       int          `json:"attempts"`
+	NextAttempt    time.Time    `json:"nextAttempt"`
+	LastError      string       `json:"lastError,omitempty"`
+	Created        time.Time    `json:"created"`
+	Delivered      time.Time    `json:"delivered"`
+	LeaseID        string       `json:"-"`
+}
+
+// EventWebhookTest is the type of the test deliveries of webhooks, which are not outbox events
+const EventWebhookTest EventType = "webhook-test"
+
+// Webhook is a subscription of an organization to the outbox events of its devices, which are
+// posted as JSON to the URL and signed with the shared secret. A webhook without event types
+// receives every event that webhooks subscribe to
+type Webhook struct {
+	ID             string      `json:"id"`
+	OrganizationID string      `json:"orgid"`
+	URL            string      `json:"url"`
+	Events         []EventType `json:"events"`
+	Secret         []byte      `json:"-"`
+	Created        time.Time   `json:"created"`
+}
+
+// Subscribed checks whether the webhook receives the events of a type
+func (w Webhook) Subscribed(eventType EventType) bool {
+	events := w.Events
+	if len(events) == 0 {
+		events = EventTypes
+	}
+	for _, t := range events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the delivery of an event to a webhook, with the JSON payload that is
+// posted. It has the delivery states of outbox events, and records the HTTP status and
+// error of the last attempt. A test delivery has no outbox event. As for outbox events, an
+// update of the delivery must match its lease ID
+type WebhookDelivery struct {
+	ID             string       `json:"id"`
+	WebhookID      string       `json:"webhookId"`
+	OrganizationID string       `json:"orgid"`
+	EventID        string       `json:"eventId,omitempty"`
+	Type           EventType    `json:"type"`
+	Payload        string       `json:"payload"`
+	Status         OutboxStatus `json:"status"`
+	Attempts       int          `json:"attempts"`
+	NextAttempt    time.Time    `json:"nextAttempt"`
+	ResponseCode   int          `json:"responseCode,omitempty"`
+	LastError      string       `json:"lastError,omitempty"`
+	Created        time.Time    `json:"created"`
+	Delivered      time.Time    `json:"delivered"`
+	LeaseID        string       `json:"-"`
+}
+
+// Role is the access level of an admin API caller
+type Role string
+
+// Roles of admin API callers
+const (
+	RoleSuperuser Role = "superuser" // manages all organizations and API tokens
+	RoleOrgAdmin  Role = "org-admin" // manages the devices and API tokens of one organization
+	RoleReadOnly  Role = "read-only" // views the devices of one organization, without private keys
+)
+
+// Token is an API token for the admin API. Only the hash of the token is stored
+type Token struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	OrganizationID string `json:"orgid,omitempty"`
+	Role           Role   `json:"role"`
+	Hash           string `json:"-"`
+}
+
+// Caller is the authenticated identity of an admin API request
+type Caller struct {
+	Subject        string `json:"subject"`
+	OrganizationID string `json:"orgid,omitempty"`
+	Role           Role   `json:"role"`
 }
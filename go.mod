@@ -5,7 +5,10 @@ go 1.13
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.6
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/segmentio/ksuid v1.0.4
 	github.com/snapcore/snapd v0.0.0-20220527082049-adf1d9328a25
 	github.com/stretchr/testify v1.7.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	gopkg.in/square/go-jose.v2 v2.6.0
 )
@@ -0,0 +1,236 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// Formats of the exported topic ACLs of an organization
+const (
+	ACLFormatMosquitto = "mosquitto" // a mosquitto acl_file
+	ACLFormatEMQX      = "emqx"      // the rules of an EMQX acl.conf
+)
+
+// DefaultACLTemplates are the topic ACL templates of an organization that is registered
+// without its own: each device uses its own topics and receives the broadcasts to its organization
+var DefaultACLTemplates = []domain.TopicACL{
+	{Topic: "devices/{orgid}/{deviceid}/#", Access: domain.AccessReadWrite},
+	{Topic: "broadcast/{orgid}/#", Access: domain.AccessRead},
+}
+
+// aclPlaceholder matches the placeholders in the topic of an ACL template
+var aclPlaceholder = regexp.MustCompile(`\{[^{}/]*\}`)
+
+// validateACLTemplates checks the access and topic filters of ACL templates
+func validateACLTemplates(templates []domain.TopicACL) error {
+	known := aclValues(&domain.Organization{}, &domain.Enrollment{})
+	for _, tpl := range templates {
+		switch tpl.Access {
+		case domain.AccessRead, domain.AccessWrite, domain.AccessReadWrite:
+		default:
+			return fmt.Errorf("the access `%s` of topic `%s` must be one of: read, write, readwrite", tpl.Access, tpl.Topic)
+		}
+
+		for _, p := range aclPlaceholder.FindAllString(tpl.Topic, -1) {
+			if _, ok := known[p]; !ok {
+				return fmt.Errorf("the topic `%s` has an unknown placeholder `%s`", tpl.Topic, p)
+			}
+		}
+		if err := validateTopicFilter(aclPlaceholder.ReplaceAllString(tpl.Topic, "x")); err != nil {
+			return fmt.Errorf("the topic `%s` is invalid: %v", tpl.Topic, err)
+		}
+	}
+	return nil
+}
+
+// aclControlChars are the characters that would end a line or a string of an exported ACL file
+const aclControlChars = "\x00\r\n"
+
+// validateTopicFilter checks that the wildcards of an MQTT topic filter fill a level, with the
+// multi-level wildcard only as the last level
+func validateTopicFilter(topic string) error {
+	if len(topic) == 0 {
+		return fmt.Errorf("the topic is empty")
+	}
+	if strings.ContainsAny(topic, aclControlChars) {
+		return fmt.Errorf("the topic has control characters")
+	}
+
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return fmt.Errorf("the multi-level wildcard must be the last level")
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return fmt.Errorf("the single-level wildcard must fill a level")
+		}
+	}
+	return nil
+}
+
+// aclValues returns the values of the placeholders of ACL templates for a device
+func aclValues(org *domain.Organization, dev *domain.Enrollment) map[string]string {
+	return map[string]string{
+		"{orgid}":    org.ID,
+		"{deviceid}": dev.ID,
+		"{brand}":    dev.Device.Brand,
+		"{model}":    dev.Device.Model,
+		"{serial}":   dev.Device.SerialNumber,
+	}
+}
+
+// renderACLs renders the topic ACLs of a device from the templates of its organization. A value
+// that would change the levels of a topic, add a wildcard or a control character, cannot be used
+func renderACLs(org *domain.Organization, dev *domain.Enrollment) ([]domain.TopicACL, error) {
+	templates := org.ACLTemplates
+	if len(templates) == 0 {
+		templates = DefaultACLTemplates
+	}
+
+	values := aclValues(org, dev)
+	acls := []domain.TopicACL{}
+	for _, tpl := range templates {
+		topic := aclPlaceholder.ReplaceAllStringFunc(tpl.Topic, func(p string) string {
+			return values[p]
+		})
+		for _, p := range aclPlaceholder.FindAllString(tpl.Topic, -1) {
+			if v := values[p]; len(v) == 0 || strings.ContainsAny(v, "/+#"+aclControlChars) {
+				return nil, fmt.Errorf("the %s `%s` cannot be used in the topic `%s`", strings.Trim(p, "{}"), v, tpl.Topic)
+			}
+		}
+		acls = append(acls, domain.TopicACL{Topic: topic, Access: tpl.Access})
+	}
+	return acls, nil
+}
+
+// OrganizationACL exports the topic ACLs of the enrolled devices of an organization, as a
+// mosquitto acl_file or the rules of an EMQX acl.conf. The broker username of a device is
+// its ID, which is the common name of its certificate
+func (id IdentityService) OrganizationACL(orgID, format string) ([]byte, error) {
+	var write func(buf *bytes.Buffer, deviceID string, acls []domain.TopicACL)
+	switch format {
+	case "", ACLFormatMosquitto:
+		write = writeMosquittoACL
+	case ACLFormatEMQX:
+		write = writeEMQXACL
+	default:
+		return nil, fmt.Errorf("the ACL format must be one of: %s, %s", ACLFormatMosquitto, ACLFormatEMQX)
+	}
+
+	org, err := id.DB.OrganizationGet(orgID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: `%s`", ErrOrganizationNotFound, orgID)
+	}
+
+	buf := &bytes.Buffer{}
+	query := datastore.DeviceQuery{OrganizationID: orgID, Status: domain.StatusEnrolled, Sort: datastore.SortSerial, Limit: datastore.MaxPageLimit}
+	for {
+		page, err := id.DB.DeviceListPage(query)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Devices {
+			// Devices that enrolled before the organization had templates use the current ones
+			dev := &page.Devices[i]
+			acls := dev.Credentials.ACLs
+			if len(acls) == 0 {
+				if acls, err = renderACLs(org, dev); err != nil {
+					return nil, err
+				}
+			}
+			if err := validateDeviceACLs(dev.ID, acls); err != nil {
+				return nil, err
+			}
+			write(buf, dev.ID, acls)
+		}
+		if len(page.NextCursor) == 0 {
+			return buf.Bytes(), nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// validateDeviceACLs checks that the username and topics of a device can be written to an
+// ACL file without starting a new rule
+func validateDeviceACLs(deviceID string, acls []domain.TopicACL) error {
+	if strings.ContainsAny(deviceID, aclControlChars) {
+		return fmt.Errorf("the device ID %q has control characters", deviceID)
+	}
+	for _, acl := range acls {
+		if err := validateTopicFilter(acl.Topic); err != nil {
+			return fmt.Errorf("the topic %q of device `%s` is invalid: %v", acl.Topic, deviceID, err)
+		}
+	}
+	return nil
+}
+
+// writeMosquittoACL writes the ACLs of a device in the format of a mosquitto acl_file
+func writeMosquittoACL(buf *bytes.Buffer, deviceID string, acls []domain.TopicACL) {
+	fmt.Fprintf(buf, "user %s\n", deviceID)
+	for _, acl := range acls {
+		fmt.Fprintf(buf, "topic %s %s\n", acl.Access, acl.Topic)
+	}
+	buf.WriteString("\n")
+}
+
+// emqxActions are the EMQX actions for the access of an ACL
+var emqxActions = map[domain.Access]string{
+	domain.AccessRead:      "subscribe",
+	domain.AccessWrite:     "publish",
+	domain.AccessReadWrite: "all",
+}
+
+// writeEMQXACL writes the ACLs of a device as the rules of an EMQX acl.conf
+func writeEMQXACL(buf *bytes.Buffer, deviceID string, acls []domain.TopicACL) {
+	for _, acl := range acls {
+		fmt.Fprintf(buf, "{allow, {username, %s}, %s, [%s]}.\n", erlangString(deviceID), emqxActions[acl.Access], erlangString(acl.Topic))
+	}
+}
+
+// erlangString quotes a string for an Erlang configuration file, escaping the control characters
+func erlangString(s string) string {
+	var b strings.Builder
+	b.WriteString(`"`)
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '"':
+			b.WriteString(`\` + string(r))
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x{%X}`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(`"`)
+	return b.String()
+}
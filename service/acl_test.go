@@ -0,0 +1,233 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestValidateACLTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		access  domain.Access
+		wantErr bool
+	}{
+		{"valid", "devices/{orgid}/{deviceid}/#", domain.AccessReadWrite, false},
+		{"valid-single-level", "telemetry/{brand}/{model}/+/{serial}", domain.AccessWrite, false},
+		{"valid-root", "#", domain.AccessRead, false},
+		{"invalid-access", "devices/{deviceid}", "subscribe", true},
+		{"invalid-empty", "", domain.AccessRead, true},
+		{"invalid-placeholder", "devices/{device}/#", domain.AccessRead, true},
+		{"invalid-multi-level", "devices/#/{deviceid}", domain.AccessRead, true},
+		{"invalid-multi-level-partial", "devices/{deviceid}#", domain.AccessRead, true},
+		{"invalid-single-level", "devices/{deviceid}+", domain.AccessRead, true},
+		{"invalid-newline", "devices/{deviceid}\ntopic readwrite #", domain.AccessRead, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateACLTemplates([]domain.TopicACL{{Topic: tt.topic, Access: tt.access}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateACLTemplates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderACLs(t *testing.T) {
+	custom := []domain.TopicACL{
+		{Topic: "telemetry/{brand}/{model}/{serial}", Access: domain.AccessWrite},
+		{Topic: "commands/{deviceid}", Access: domain.AccessRead},
+	}
+	tests := []struct {
+		name      string
+		templates []domain.TopicACL
+		serial    string
+		want      []domain.TopicACL
+		wantErr   bool
+	}{
+		{"default", nil, "DR1000A111", []domain.TopicACL{
+			{Topic: "devices/abc/a111/#", Access: domain.AccessReadWrite},
+			{Topic: "broadcast/abc/#", Access: domain.AccessRead},
+		}, false},
+		{"custom", custom, "DR1000A111", []domain.TopicACL{
+			{Topic: "telemetry/example/drone-1000/DR1000A111", Access: domain.AccessWrite},
+			{Topic: "commands/a111", Access: domain.AccessRead},
+		}, false},
+		{"unused-value", nil, "DR1000/A111", []domain.TopicACL{
+			{Topic: "devices/abc/a111/#", Access: domain.AccessReadWrite},
+			{Topic: "broadcast/abc/#", Access: domain.AccessRead},
+		}, false},
+		{"invalid-level", custom, "DR1000/A111", nil, true},
+		{"invalid-wildcard", custom, "DR1000#", nil, true},
+		{"invalid-empty", custom, "", nil, true},
+		{"invalid-newline", custom, "DR1000\ntopic readwrite #", nil, true},
+		{"invalid-null", custom, "DR1000\x00", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org := &domain.Organization{ID: "abc", ACLTemplates: tt.templates}
+			dev := &domain.Enrollment{ID: "a111", Device: domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: tt.serial}}
+
+			got, err := renderACLs(org, dev)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderACLs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("renderACLs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDeviceACLs(t *testing.T) {
+	tests := []struct {
+		name     string
+		deviceID string
+		topic    string
+		wantErr  bool
+	}{
+		{"valid", "a111", "devices/abc/a111/#", false},
+		{"invalid-device-id", "a111\nuser b222", "devices/abc/a111/#", true},
+		{"invalid-topic", "a111", "devices/abc\r\ntopic readwrite #", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDeviceACLs(tt.deviceID, []domain.TopicACL{{Topic: tt.topic, Access: domain.AccessRead}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateDeviceACLs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestErlangString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "devices/abc/#", `"devices/abc/#"`},
+		{"quotes", `a"b\c`, `"a\"b\\c"`},
+		{"newline", "a\r\nb\tc", `"a\r\nb\tc"`},
+		{"control", "a\x00b\x1bc\x7f", `"a\x{0}b\x{1B}c\x{7F}"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := erlangString(tt.s); got != tt.want {
+				t.Errorf("erlangString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OrganizationACL(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	db := memory.NewStore(memory.WithFixtures())
+	id := NewIdentityService(settings, db, nil)
+
+	// Register an organization with the default templates, and enroll one of its devices
+	orgID, err := id.RegisterOrganization(nil, &RegisterOrganizationRequest{Name: "Example PLC"})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterOrganization() error = %v", err)
+	}
+	org, _ := db.OrganizationGet(orgID)
+	if !reflect.DeepEqual(org.ACLTemplates, DefaultACLTemplates) {
+		t.Errorf("IdentityService.RegisterOrganization() ACL templates = %v, want the defaults", org.ACLTemplates)
+	}
+	for _, serial := range []string{"DR2000E555", "DR2000F666"} {
+		if _, err := id.RegisterDevice(nil, &RegisterDeviceRequest{OrganizationID: orgID, Brand: "example", Model: "drone-2000", SerialNumber: serial}); err != nil {
+			t.Fatalf("IdentityService.RegisterDevice() error = %v", err)
+		}
+	}
+	en, err := id.enroll(&datastore.DeviceEnrollRequest{Brand: "example", Model: "drone-2000", SerialNumber: "DR2000E555", StoreID: "example-store", DeviceKey: "AAAAAAAA"}, nil)
+	if err != nil {
+		t.Fatalf("IdentityService.enroll() error = %v", err)
+	}
+	want := []domain.TopicACL{
+		{Topic: "devices/" + orgID + "/" + en.ID + "/#", Access: domain.AccessReadWrite},
+		{Topic: "broadcast/" + orgID + "/#", Access: domain.AccessRead},
+	}
+	if !reflect.DeepEqual(en.Credentials.ACLs, want) {
+		t.Errorf("IdentityService.enroll() ACLs = %v, want %v", en.Credentials.ACLs, want)
+	}
+
+	tests := []struct {
+		name    string
+		orgID   string
+		format  string
+		want    string
+		wantErr error
+	}{
+		{"mosquitto", orgID, "", "user " + en.ID + "\ntopic readwrite devices/" + orgID + "/" + en.ID + "/#\ntopic read broadcast/" + orgID + "/#\n\n", nil},
+		{"emqx", orgID, ACLFormatEMQX, `{allow, {username, "` + en.ID + `"}, all, ["devices/` + orgID + "/" + en.ID + `/#"]}.` + "\n" +
+			`{allow, {username, "` + en.ID + `"}, subscribe, ["broadcast/` + orgID + `/#"]}.` + "\n", nil},
+		{"enrolled-without-acls", "abc", ACLFormatMosquitto, "user b222\ntopic readwrite devices/abc/b222/#\ntopic read broadcast/abc/#\n\n", nil},
+		{"unknown-org", "unknown", ACLFormatMosquitto, "", ErrOrganizationNotFound},
+		{"invalid-format", orgID, "invalid", "", errors.New("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := id.OrganizationACL(tt.orgID, tt.format)
+			if (err != nil) != (tt.wantErr != nil) || (errors.Is(tt.wantErr, ErrOrganizationNotFound) && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("IdentityService.OrganizationACL() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("IdentityService.OrganizationACL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityService_RegisterOrganizationACL(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	tests := []struct {
+		name      string
+		templates []domain.TopicACL
+		wantErr   bool
+	}{
+		{"valid", []domain.TopicACL{{Topic: "fleet/{model}/{deviceid}/#", Access: domain.AccessReadWrite}}, false},
+		{"invalid", []domain.TopicACL{{Topic: "fleet/{unknown}/#", Access: domain.AccessReadWrite}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(settings, db, nil)
+			orgID, err := id.RegisterOrganization(nil, &RegisterOrganizationRequest{Name: "Example PLC", ACLTemplates: tt.templates})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IdentityService.RegisterOrganization() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			org, _ := db.OrganizationGet(orgID)
+			if !reflect.DeepEqual(org.ACLTemplates, tt.templates) {
+				t.Errorf("IdentityService.RegisterOrganization() ACL templates = %v, want %v", org.ACLTemplates, tt.templates)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// AuditList fetches a page of the audit log
+func (id IdentityService) AuditList(query datastore.AuditQuery) (*datastore.AuditPage, error) {
+	return id.DB.AuditList(query)
+}
+
+// audit records an event of an admin API caller in the audit log
+func (id IdentityService) audit(caller *domain.Caller, event domain.AuditEvent, err error) {
+	event.Actor = actor(caller)
+	id.recordEvent(event, err)
+}
+
+// recordEvent records an event in the audit log, with the reason when the action failed. A failure
+// to record the event is logged rather than returned, so the audit log does not block the service
+func (id IdentityService) recordEvent(event domain.AuditEvent, err error) {
+	event.ID = datastore.GenerateID()
+	event.Time = time.Now()
+	event.Success = err == nil
+	if err != nil {
+		event.Reason = err.Error()
+	}
+
+	if err := id.DB.AuditNew(event); err != nil {
+		log.Printf("Error recording the audit event `%s` for `%s`: %v\n", event.Action, event.Actor, err)
+	}
+}
+
+// actor returns the subject of an admin API caller, for recording who made a change
+func actor(caller *domain.Caller) string {
+	if caller == nil {
+		return "unknown"
+	}
+	return caller.Subject
+}
+
+// deviceStatus returns the status of a device of an organization, or zero if it is not registered
+func (id IdentityService) deviceStatus(orgID, deviceID string) domain.Status {
+	device, err := id.DB.DeviceGetByOrgID(orgID, deviceID)
+	if err != nil {
+		return 0
+	}
+	return device.Status
+}
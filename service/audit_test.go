@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"testing"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/snapcore/snapd/asserts"
+)
+
+func TestIdentityService_Audit(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	caller := &domain.Caller{Subject: "admin-test", Role: domain.RoleSuperuser}
+	db := memory.NewStore(memory.WithFixtures())
+	id := NewIdentityService(settings, db, nil)
+
+	orgID, err := id.RegisterOrganization(caller, &RegisterOrganizationRequest{Name: "Example PLC"})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterOrganization() error = %v", err)
+	}
+	deviceID, err := id.RegisterDevice(caller, &RegisterDeviceRequest{OrganizationID: orgID, Brand: "example", Model: "drone-2000", SerialNumber: "DR2000F666"})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterDevice() error = %v", err)
+	}
+	if _, err := id.RegisterDevice(caller, &RegisterDeviceRequest{OrganizationID: orgID, Brand: "example", Model: "drone-2000", SerialNumber: "DR2000F666"}); err == nil {
+		t.Fatal("IdentityService.RegisterDevice() expected error for a duplicate device")
+	}
+	if err := id.DeviceUpdate(caller, orgID, deviceID, &DeviceUpdateRequest{Status: int(domain.StatusDisabled)}); err != nil {
+		t.Fatalf("IdentityService.DeviceUpdate() error = %v", err)
+	}
+	if err := id.DeviceDelete(caller, orgID, deviceID); err != nil {
+		t.Fatalf("IdentityService.DeviceDelete() error = %v", err)
+	}
+
+	page, err := id.AuditList(datastore.AuditQuery{OrganizationID: orgID})
+	if err != nil {
+		t.Fatalf("IdentityService.AuditList() error = %v", err)
+	}
+
+	// The events are listed newest first
+	want := []struct {
+		action  string
+		success bool
+		before  domain.Status
+		after   domain.Status
+	}{
+		{domain.ActionDeviceDelete, true, domain.StatusDisabled, 0},
+		{domain.ActionDeviceUpdate, true, domain.StatusWaiting, domain.StatusDisabled},
+		{domain.ActionDeviceRegister, false, 0, 0},
+		{domain.ActionDeviceRegister, true, 0, domain.StatusWaiting},
+		{domain.ActionOrganizationRegister, true, 0, 0},
+	}
+	if len(page.Events) != len(want) {
+		t.Fatalf("IdentityService.AuditList() = %d events, want %d: %v", len(page.Events), len(want), page.Events)
+	}
+	for i, w := range want {
+		got := page.Events[i]
+		if got.Action != w.action || got.Success != w.success || got.Before != w.before || got.After != w.after {
+			t.Errorf("IdentityService.AuditList() event %d = %v/%v/%v/%v, want %v", i, got.Action, got.Success, got.Before, got.After, w)
+		}
+		if got.Actor != caller.Subject {
+			t.Errorf("IdentityService.AuditList() event %d actor = %v, want %v", i, got.Actor, caller.Subject)
+		}
+		if !got.Success && len(got.Reason) == 0 {
+			t.Errorf("IdentityService.AuditList() event %d = no reason for a failed action", i)
+		}
+	}
+}
+
+func TestIdentityService_AuditEnrollment(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	dev := signedAssertions("canonical", "ubuntu-core-18-amd64", "d75f7300-abbf-4c11-bf0a-8b7103038490")
+	m, _ := asserts.Decode([]byte(dev.model))
+	s, _ := asserts.Decode([]byte(dev.serial))
+
+	tests := []struct {
+		name        string
+		untrusted   bool
+		nonce       bool
+		wantSuccess bool
+		wantAfter   domain.Status
+		wantEvent   domain.EventType
+	}{
+		{"valid", false, true, true, domain.StatusEnrolled, domain.EventDeviceEnroll},
+		{"rejected", false, false, false, 0, domain.EventDeviceEnrollFailed},
+		{"untrusted", true, true, false, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(settings, db, dev.trusted)
+			if tt.untrusted {
+				id.Trusted = nil
+			}
+			nonce := "invalid"
+			if tt.nonce {
+				n, err := id.DeviceNonce(&DeviceNonceRequest{Brand: "canonical", Model: "ubuntu-core-18-amd64", SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490"})
+				if err != nil {
+					t.Fatalf("IdentityService.DeviceNonce() error = %v", err)
+				}
+				nonce = n.Value
+			}
+
+			_, err := id.EnrollDevice(&EnrollDeviceRequest{Model: m, Serial: s, SessionRequest: dev.sessionRequest(nonce, dev.deviceKey)})
+			if (err == nil) != tt.wantSuccess {
+				t.Fatalf("IdentityService.EnrollDevice() error = %v, want success %v", err, tt.wantSuccess)
+			}
+
+			page, err := id.AuditList(datastore.AuditQuery{Action: domain.ActionDeviceEnroll})
+			if err != nil || len(page.Events) != 1 {
+				t.Fatalf("IdentityService.AuditList() = %v, error = %v, want one event", page, err)
+			}
+			got := page.Events[0]
+			if got.Actor != domain.ActorDevice || got.Success != tt.wantSuccess || got.After != tt.wantAfter {
+				t.Errorf("IdentityService.AuditList() = %v/%v/%v, want %v/%v/%v", got.Actor, got.Success, got.After, domain.ActorDevice, tt.wantSuccess, tt.wantAfter)
+			}
+			if len(got.DeviceID) == 0 || len(got.OrganizationID) == 0 {
+				t.Errorf("IdentityService.AuditList() = device not recorded: %v", got)
+			}
+
+			// The outcome is also an event for the webhooks, unless the assertions are not trusted
+			events, err := id.OutboxList(datastore.OutboxQuery{DeviceID: got.DeviceID})
+			if len(tt.wantEvent) == 0 {
+				if err != nil || len(events) > 0 {
+					t.Errorf("IdentityService.OutboxList() = %v, error = %v, want no events", events, err)
+				}
+				return
+			}
+			if err != nil || len(events) == 0 || events[len(events)-1].Type != tt.wantEvent {
+				t.Errorf("IdentityService.OutboxList() = %v, error = %v, want a %s event", events, err, tt.wantEvent)
+			}
+		})
+	}
+}
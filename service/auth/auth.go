@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package auth authenticates and authorizes the callers of the admin API
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+)
+
+// tokenLength is the number of random bytes in an API token
+const tokenLength = 32
+
+// ranks orders the roles, so a role includes the access of the roles below it
+var ranks = map[domain.Role]int{
+	domain.RoleReadOnly:  1,
+	domain.RoleOrgAdmin:  2,
+	domain.RoleSuperuser: 3,
+}
+
+// NewToken generates the value of an API token
+func NewToken() (string, error) {
+	return cert.CreateSecret(tokenLength)
+}
+
+// HashToken hashes the value of an API token for storage and lookup.
+// Tokens are random, so a fast hash is sufficient
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// ValidRole checks that the role is known
+func ValidRole(role domain.Role) bool {
+	_, ok := ranks[role]
+	return ok
+}
+
+// Allowed checks that the caller has at least the role for the organization.
+// A superuser may act on any organization
+func Allowed(caller *domain.Caller, orgID string, role domain.Role) bool {
+	if caller == nil || !ValidRole(caller.Role) {
+		return false
+	}
+	if caller.Role == domain.RoleSuperuser {
+		return true
+	}
+	if role == domain.RoleSuperuser || len(orgID) == 0 || caller.OrganizationID != orgID {
+		return false
+	}
+	return ranks[caller.Role] >= ranks[role]
+}
@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestHashToken(t *testing.T) {
+	token, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+	if HashToken(token) != HashToken(token) {
+		t.Error("HashToken() = not deterministic")
+	}
+	if HashToken(token) == token || len(HashToken(token)) != 64 {
+		t.Errorf("HashToken() = %v, want a SHA-256 hash", HashToken(token))
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	superuser := &domain.Caller{Subject: "admin", Role: domain.RoleSuperuser}
+	admin := &domain.Caller{Subject: "admin-abc", OrganizationID: "abc", Role: domain.RoleOrgAdmin}
+	viewer := &domain.Caller{Subject: "viewer-abc", OrganizationID: "abc", Role: domain.RoleReadOnly}
+	unknown := &domain.Caller{Subject: "unknown", OrganizationID: "abc", Role: "owner"}
+
+	tests := []struct {
+		name   string
+		caller *domain.Caller
+		orgID  string
+		role   domain.Role
+		want   bool
+	}{
+		{"superuser-any-org", superuser, "def", domain.RoleOrgAdmin, true},
+		{"superuser-global", superuser, "", domain.RoleSuperuser, true},
+		{"org-admin-own-org", admin, "abc", domain.RoleOrgAdmin, true},
+		{"org-admin-read", admin, "abc", domain.RoleReadOnly, true},
+		{"org-admin-other-org", admin, "def", domain.RoleReadOnly, false},
+		{"org-admin-global", admin, "", domain.RoleSuperuser, false},
+		{"read-only-read", viewer, "abc", domain.RoleReadOnly, true},
+		{"read-only-write", viewer, "abc", domain.RoleOrgAdmin, false},
+		{"unknown-role", unknown, "abc", domain.RoleReadOnly, false},
+		{"anonymous", nil, "abc", domain.RoleReadOnly, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Allowed(tt.caller, tt.orgID, tt.role); got != tt.want {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
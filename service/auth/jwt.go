@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// claims are the private claims of a JWT that grant access to the admin API
+type claims struct {
+	Role           domain.Role `json:"role"`
+	OrganizationID string      `json:"orgid"`
+}
+
+// Verifier validates JWT bearer tokens against a locally configured JWKS
+type Verifier struct {
+	keys     jose.JSONWebKeySet
+	issuer   string
+	audience string
+}
+
+// LoadVerifier reads the JWKS file of the identity provider.
+// An empty issuer or audience is not checked
+func LoadVerifier(jwksPath, issuer, audience string) (*Verifier, error) {
+	data, err := ioutil.ReadFile(jwksPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read JWKS: %v", err)
+	}
+
+	v := &Verifier{issuer: issuer, audience: audience}
+	if err := json.Unmarshal(data, &v.keys); err != nil {
+		return nil, fmt.Errorf("cannot parse JWKS: %v", err)
+	}
+	if len(v.keys.Keys) == 0 {
+		return nil, fmt.Errorf("the JWKS has no keys")
+	}
+	return v, nil
+}
+
+// IsJWT checks whether a bearer token is a compact JWT, rather than an API token
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Verify validates the signature and claims of a JWT and returns the caller
+func (v *Verifier) Verify(raw string) (*domain.Caller, error) {
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse JWT: %v", err)
+	}
+	key, err := v.key(token)
+	if err != nil {
+		return nil, err
+	}
+
+	std := jwt.Claims{}
+	private := claims{}
+	if err := token.Claims(key.Public(), &std, &private); err != nil {
+		return nil, fmt.Errorf("invalid JWT: %v", err)
+	}
+
+	expected := jwt.Expected{Issuer: v.issuer, Time: time.Now()}
+	if len(v.audience) > 0 {
+		expected.Audience = jwt.Audience{v.audience}
+	}
+	if err := std.ValidateWithLeeway(expected, jwt.DefaultLeeway); err != nil {
+		return nil, fmt.Errorf("invalid JWT: %v", err)
+	}
+	if std.Expiry == nil {
+		return nil, fmt.Errorf("invalid JWT: the token must expire")
+	}
+
+	if !ValidRole(private.Role) {
+		return nil, fmt.Errorf("invalid JWT: unknown role `%s`", private.Role)
+	}
+	if private.Role != domain.RoleSuperuser && len(private.OrganizationID) == 0 {
+		return nil, fmt.Errorf("invalid JWT: the role `%s` needs an organization", private.Role)
+	}
+
+	return &domain.Caller{
+		Subject:        std.Subject,
+		OrganizationID: private.OrganizationID,
+		Role:           private.Role,
+	}, nil
+}
+
+// key selects the key that signed the token, by its key ID
+func (v *Verifier) key(token *jwt.JSONWebToken) (*jose.JSONWebKey, error) {
+	if len(token.Headers) != 1 {
+		return nil, fmt.Errorf("invalid JWT: expected one signature")
+	}
+
+	kid := token.Headers[0].KeyID
+	keys := v.keys.Key(kid)
+	if len(kid) == 0 && len(v.keys.Keys) == 1 {
+		keys = v.keys.Keys
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("invalid JWT: unknown key `%s`", kid)
+	}
+	return &keys[0], nil
+}
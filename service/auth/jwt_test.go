@@ -0,0 +1,153 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testIssuer   = "https://login.example.com"
+	testAudience = "iot-identity"
+)
+
+// testJWKS writes a JWKS with the public key of the signing key to the directory
+func testJWKS(t *testing.T, dir string, key *ecdsa.PrivateKey) string {
+	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: "k1", Algorithm: string(jose.ES256), Use: "sig"}}}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("cannot encode JWKS: %v", err)
+	}
+	p := path.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(p, data, 0600); err != nil {
+		t.Fatalf("cannot write JWKS: %v", err)
+	}
+	return p
+}
+
+func signJWT(t *testing.T, key *ecdsa.PrivateKey, kid string, std jwt.Claims, private claims) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, opts)
+	if err != nil {
+		t.Fatalf("cannot create signer: %v", err)
+	}
+	raw, err := jwt.Signed(signer).Claims(std).Claims(private).CompactSerialize()
+	if err != nil {
+		t.Fatalf("cannot sign JWT: %v", err)
+	}
+	return raw
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatalf("cannot create JWKS directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	v, err := LoadVerifier(testJWKS(t, dir, key), testIssuer, testAudience)
+	if err != nil {
+		t.Fatalf("LoadVerifier() error = %v", err)
+	}
+
+	now := time.Now()
+	valid := jwt.Claims{Subject: "jamie", Issuer: testIssuer, Audience: jwt.Audience{testAudience}, Expiry: jwt.NewNumericDate(now.Add(time.Hour))}
+	expired := valid
+	expired.Expiry = jwt.NewNumericDate(now.Add(-time.Hour))
+	noExpiry := valid
+	noExpiry.Expiry = nil
+	wrongIssuer := valid
+	wrongIssuer.Issuer = "https://evil.example.com"
+	wrongAudience := valid
+	wrongAudience.Audience = jwt.Audience{"other"}
+
+	orgAdmin := claims{Role: domain.RoleOrgAdmin, OrganizationID: "abc"}
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    *domain.Caller
+		wantErr bool
+	}{
+		{"valid", signJWT(t, key, "k1", valid, orgAdmin), &domain.Caller{Subject: "jamie", OrganizationID: "abc", Role: domain.RoleOrgAdmin}, false},
+		{"valid-superuser", signJWT(t, key, "k1", valid, claims{Role: domain.RoleSuperuser}), &domain.Caller{Subject: "jamie", Role: domain.RoleSuperuser}, false},
+		{"expired", signJWT(t, key, "k1", expired, orgAdmin), nil, true},
+		{"no-expiry", signJWT(t, key, "k1", noExpiry, orgAdmin), nil, true},
+		{"wrong-issuer", signJWT(t, key, "k1", wrongIssuer, orgAdmin), nil, true},
+		{"wrong-audience", signJWT(t, key, "k1", wrongAudience, orgAdmin), nil, true},
+		{"unknown-key", signJWT(t, key, "k2", valid, orgAdmin), nil, true},
+		{"wrong-signature", signJWT(t, other, "k1", valid, orgAdmin), nil, true},
+		{"unknown-role", signJWT(t, key, "k1", valid, claims{Role: "owner", OrganizationID: "abc"}), nil, true},
+		{"no-organization", signJWT(t, key, "k1", valid, claims{Role: domain.RoleReadOnly}), nil, true},
+		{"invalid", "not.a.jwt", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verifier.Verify() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && *got != *tt.want {
+				t.Errorf("Verifier.Verify() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadVerifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatalf("cannot create JWKS directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	empty := path.Join(dir, "empty.json")
+	_ = ioutil.WriteFile(empty, []byte(`{"keys":[]}`), 0600)
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"no-keys", empty, true},
+		{"invalid-path", "invalid", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadVerifier(tt.path, "", ""); (err != nil) != tt.wantErr {
+				t.Errorf("LoadVerifier() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package broker provisions the clients of enrolled devices in the MQTT broker, so
+// that a device can only connect while it is enrolled
+package broker
+
+import (
+	"github.com/canonical/iot-identity/domain"
+)
+
+// Provisioner manages the client of a device in an MQTT broker. The client is bound to
+// the common name of the device certificate, which is the device ID. The methods are
+// idempotent, so a failed change can be applied again
+type Provisioner interface {
+	// Register creates the client of an enrolled device, or enables it if it exists
+	Register(en domain.Enrollment) error
+
+	// Disable stops a device from connecting, keeping its client
+	Disable(en domain.Enrollment) error
+
+	// Remove deletes the client of a device
+	Remove(en domain.Enrollment) error
+}
@@ -0,0 +1,179 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package dynsec provisions device clients in Mosquitto with its dynamic security plugin.
+// The plugin is managed with JSON commands, which are published to its control topic
+package dynsec
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// Topics of the dynamic security plugin
+const (
+	ControlTopic  = "$CONTROL/dynamic-security/v1"
+	ResponseTopic = ControlTopic + "/response"
+)
+
+// DefaultTimeout is the time allowed to connect to the broker and receive the responses
+const DefaultTimeout = 10 * time.Second
+
+// Provisioner creates the clients of devices in Mosquitto. The client username is the device
+// ID, which is the common name of the device certificate, so the listener must be configured
+// with `use_identity_as_username true`. The client has no password, so it can only connect
+// with its certificate
+type Provisioner struct {
+	URL     string      // the broker URL, with the credentials of a dynamic security admin
+	Role    string      // the role that is assigned to device clients
+	TLS     *tls.Config // the TLS configuration for mqtts URLs, or nil for the defaults
+	Timeout time.Duration
+}
+
+// command is a dynamic security command
+type command struct {
+	Command         string `json:"command"`
+	Username        string `json:"username,omitempty"`
+	TextName        string `json:"textname,omitempty"`
+	RoleName        string `json:"rolename,omitempty"`
+	Roles           []role `json:"roles,omitempty"`
+	CorrelationData string `json:"correlationData"`
+}
+
+// role is the assignment of a role to a client
+type role struct {
+	RoleName string `json:"rolename"`
+}
+
+// response is the result of a dynamic security command, with an error if it failed
+type response struct {
+	Command         string `json:"command"`
+	Error           string `json:"error"`
+	CorrelationData string `json:"correlationData"`
+}
+
+// NewProvisioner creates a provisioner for the broker at the URL
+func NewProvisioner(url, role string, tlsConfig *tls.Config) *Provisioner {
+	return &Provisioner{URL: url, Role: role, TLS: tlsConfig, Timeout: DefaultTimeout}
+}
+
+// Register creates the client of an enrolled device with its role, or enables the client
+// if it exists. The role is created if it is missing, without any access, so the broker
+// admin grants the access of devices to the role
+func (p *Provisioner) Register(en domain.Enrollment) error {
+	commands := []command{
+		{Command: "createRole", RoleName: p.Role},
+		{Command: "createClient", Username: en.ID, TextName: textName(en), Roles: []role{{RoleName: p.Role}}},
+		{Command: "enableClient", Username: en.ID},
+		{Command: "addClientRole", Username: en.ID, RoleName: p.Role},
+	}
+	return p.send(commands, alreadyExists)
+}
+
+// Disable stops the client of a device from connecting. A device without a client is
+// already unable to connect
+func (p *Provisioner) Disable(en domain.Enrollment) error {
+	return p.send([]command{{Command: "disableClient", Username: en.ID}}, notFound)
+}
+
+// Remove deletes the client of a device, if it exists
+func (p *Provisioner) Remove(en domain.Enrollment) error {
+	return p.send([]command{{Command: "deleteClient", Username: en.ID}}, notFound)
+}
+
+// send publishes the commands and waits for their responses. Errors that match the
+// ignore function are expected when the change has already been made
+func (p *Provisioner) send(commands []command, ignore func(string) bool) error {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	c, err := dial(p.URL, p.TLS, "iot-identity-"+datastore.GenerateID(), timeout)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.subscribe(ResponseTopic); err != nil {
+		return err
+	}
+
+	// The correlation data matches the responses to the commands, as other admins
+	// may be sending commands at the same time
+	pending := map[string]string{}
+	for i := range commands {
+		commands[i].CorrelationData = datastore.GenerateID()
+		pending[commands[i].CorrelationData] = commands[i].Command
+	}
+	payload, err := json.Marshal(map[string][]command{"commands": commands})
+	if err != nil {
+		return err
+	}
+	if err := c.publish(ControlTopic, payload); err != nil {
+		return fmt.Errorf("error sending the dynamic security commands: %v", err)
+	}
+
+	for len(pending) > 0 {
+		topic, message, err := c.receive()
+		if err != nil {
+			return fmt.Errorf("error receiving the dynamic security responses: %v", err)
+		}
+		if topic != ResponseTopic {
+			continue
+		}
+
+		result := struct {
+			Responses []response `json:"responses"`
+		}{}
+		if err := json.Unmarshal(message, &result); err != nil {
+			return fmt.Errorf("error parsing the dynamic security responses: %v", err)
+		}
+		for _, r := range result.Responses {
+			if _, ok := pending[r.CorrelationData]; !ok {
+				continue
+			}
+			delete(pending, r.CorrelationData)
+			if len(r.Error) > 0 && !ignore(r.Error) {
+				return fmt.Errorf("the dynamic security command `%s` failed: %s", r.Command, r.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// textName describes the device of a client
+func textName(en domain.Enrollment) string {
+	return fmt.Sprintf("%s/%s/%s", en.Device.Brand, en.Device.Model, en.Device.SerialNumber)
+}
+
+// alreadyExists checks for the error of a client, role or role assignment that exists
+func alreadyExists(err string) bool {
+	return strings.Contains(strings.ToLower(err), "already")
+}
+
+// notFound checks for the error of a client that does not exist
+func notFound(err string) bool {
+	return strings.Contains(strings.ToLower(err), "not found")
+}
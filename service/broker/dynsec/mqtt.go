@@ -0,0 +1,286 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dynsec
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// MQTT 3.1.1 packet types. The provisioner only needs a short-lived connection that
+// subscribes and publishes with QoS 0, so this is a minimal client rather than a dependency
+const (
+	packetConnect     byte = 1
+	packetConnAck     byte = 2
+	packetPublish     byte = 3
+	packetSubscribe   byte = 8
+	packetSubAck      byte = 9
+	packetDisconnect  byte = 14
+	protocolLevel     byte = 4
+	connectCleanFlag  byte = 0x02
+	connectPassFlag   byte = 0x40
+	connectUserFlag   byte = 0x80
+	subscribeFlags    byte = 0x02
+	subAckFailure     byte = 0x80
+	keepAliveSeconds       = 60
+	maxRemainingBytes      = 268435455
+)
+
+// packet is an MQTT control packet
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// conn is a connection to an MQTT broker
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// dial connects to the broker at the URL, authenticating with the username and password
+// of the URL. The mqtts scheme connects with TLS
+func dial(address string, tlsConfig *tls.Config, clientID string, timeout time.Duration) (*conn, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("the broker URL is invalid: %v", err)
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	var nc net.Conn
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if len(u.Port()) == 0 {
+			host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+		nc, err = dialer.Dial("tcp", host)
+	case "mqtts", "ssl", "tls":
+		if len(u.Port()) == 0 {
+			host = net.JoinHostPort(u.Hostname(), "8883")
+		}
+		config := &tls.Config{}
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+		}
+		if len(config.ServerName) == 0 {
+			config.ServerName = u.Hostname()
+		}
+		nc, err = tls.DialWithDialer(dialer, "tcp", host, config)
+	default:
+		return nil, fmt.Errorf("the broker URL scheme `%s` is not supported: use mqtt or mqtts", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the broker: %v", err)
+	}
+
+	c := &conn{Conn: nc, reader: bufio.NewReader(nc)}
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	password, hasPassword := u.User.Password()
+	if err := c.connect(clientID, u.User.Username(), password, hasPassword); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect opens the MQTT session with a clean session
+func (c *conn) connect(clientID, username, password string, hasPassword bool) error {
+	flags := connectCleanFlag
+	if len(username) > 0 {
+		flags |= connectUserFlag
+	}
+	if hasPassword {
+		flags |= connectPassFlag
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel, flags, 0, 0)
+	binary.BigEndian.PutUint16(body[len(body)-2:], keepAliveSeconds)
+	body = appendString(body, clientID)
+	if len(username) > 0 {
+		body = appendString(body, username)
+	}
+	if hasPassword {
+		body = appendString(body, password)
+	}
+	if err := writePacket(c, packet{kind: packetConnect, body: body}); err != nil {
+		return err
+	}
+
+	p, err := readPacket(c.reader)
+	if err != nil {
+		return fmt.Errorf("error connecting to the broker: %v", err)
+	}
+	if p.kind != packetConnAck || len(p.body) != 2 {
+		return fmt.Errorf("error connecting to the broker: unexpected packet type %d", p.kind)
+	}
+	if p.body[1] != 0 {
+		return fmt.Errorf("the broker refused the connection with return code %d", p.body[1])
+	}
+	return nil
+}
+
+// subscribe subscribes to a topic with QoS 0
+func (c *conn) subscribe(topic string) error {
+	body := []byte{0, 1}
+	body = appendString(body, topic)
+	body = append(body, 0)
+	if err := writePacket(c, packet{kind: packetSubscribe, flags: subscribeFlags, body: body}); err != nil {
+		return err
+	}
+
+	for {
+		p, err := readPacket(c.reader)
+		if err != nil {
+			return fmt.Errorf("error subscribing to `%s`: %v", topic, err)
+		}
+		if p.kind != packetSubAck {
+			continue
+		}
+		if len(p.body) != 3 || p.body[2] == subAckFailure {
+			return fmt.Errorf("the broker refused the subscription to `%s`", topic)
+		}
+		return nil
+	}
+}
+
+// publish publishes a message with QoS 0
+func (c *conn) publish(topic string, payload []byte) error {
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	return writePacket(c, packet{kind: packetPublish, body: body})
+}
+
+// receive waits for a message on the subscribed topics
+func (c *conn) receive() (string, []byte, error) {
+	for {
+		p, err := readPacket(c.reader)
+		if err != nil {
+			return "", nil, err
+		}
+		if p.kind != packetPublish {
+			continue
+		}
+		return parsePublish(p)
+	}
+}
+
+// close ends the session and closes the connection
+func (c *conn) close() error {
+	_ = writePacket(c, packet{kind: packetDisconnect})
+	return c.Close()
+}
+
+// parsePublish returns the topic and payload of a publish packet. Messages are
+// subscribed with QoS 0, but the packet ID is skipped if the broker sends a higher QoS
+func parsePublish(p packet) (string, []byte, error) {
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return "", nil, err
+	}
+	if (p.flags>>1)&0x03 > 0 {
+		if len(rest) < 2 {
+			return "", nil, errors.New("the publish packet is truncated")
+		}
+		rest = rest[2:]
+	}
+	return topic, rest, nil
+}
+
+// writePacket writes a packet with its fixed header
+func writePacket(w io.Writer, p packet) error {
+	if len(p.body) > maxRemainingBytes {
+		return errors.New("the packet is too large")
+	}
+	header := []byte{p.kind<<4 | p.flags}
+	for n := len(p.body); ; {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		header = append(header, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(header, p.body...))
+	return err
+}
+
+// readPacket reads a packet with its fixed header
+func readPacket(r *bufio.Reader) (packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errors.New("the packet length is invalid")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+// appendString appends a length-prefixed UTF-8 string
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// readString reads a length-prefixed UTF-8 string, returning the remaining bytes
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("the string is truncated")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("the string is truncated")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
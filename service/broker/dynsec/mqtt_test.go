@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package dynsec
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestPacket_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		header int
+	}{
+		{"empty", 0, 2},
+		{"one-byte", 127, 2},
+		{"two-bytes", 128, 3},
+		{"three-bytes", 16384, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := packet{kind: packetPublish, flags: 0x02, body: bytes.Repeat([]byte("a"), tt.length)}
+			buf := &bytes.Buffer{}
+			if err := writePacket(buf, p); err != nil {
+				t.Fatalf("writePacket() error = %v", err)
+			}
+			if buf.Len() != tt.length+tt.header {
+				t.Errorf("writePacket() size = %v, want %v", buf.Len(), tt.length+tt.header)
+			}
+
+			got, err := readPacket(bufio.NewReader(buf))
+			if err != nil {
+				t.Fatalf("readPacket() error = %v", err)
+			}
+			if got.kind != p.kind || got.flags != p.flags || !bytes.Equal(got.body, p.body) {
+				t.Errorf("readPacket() = %v/%v/%d bytes, want %v/%v/%d bytes", got.kind, got.flags, len(got.body), p.kind, p.flags, len(p.body))
+			}
+		})
+	}
+}
+
+func TestParsePublish(t *testing.T) {
+	body := appendString(nil, "a/b")
+	tests := []struct {
+		name    string
+		p       packet
+		want    string
+		wantErr bool
+	}{
+		{"qos0", packet{kind: packetPublish, body: append(body, "hello"...)}, "hello", false},
+		{"qos1", packet{kind: packetPublish, flags: 0x02, body: append(append(body, 0, 7), "hello"...)}, "hello", false},
+		{"truncated-topic", packet{kind: packetPublish, body: []byte{0, 9, 'a'}}, "", true},
+		{"truncated-id", packet{kind: packetPublish, flags: 0x02, body: append(body, 0)}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic, payload, err := parsePublish(tt.p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePublish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (topic != "a/b" || string(payload) != tt.want) {
+				t.Errorf("parsePublish() = %v/%s, want a/b/%s", topic, payload, tt.want)
+			}
+		})
+	}
+}
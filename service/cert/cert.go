@@ -21,7 +21,6 @@ package cert
 
 import (
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -55,8 +54,26 @@ func getCertificateAuthority(certsPath string) (tls.Certificate, *x509.Certifica
 	return caKeyPair, caTemplate, err
 }
 
+// RootCertPool returns a pool with the root CA, to verify device certificates
+func RootCertPool(certsPath string) (*x509.CertPool, error) {
+	_, ca, err := getCertificateAuthority(certsPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, nil
+}
+
+// randomNumber generates a certificate serial number. Serial numbers identify revoked
+// certificates, so they must be unique for each CA
 func randomNumber() (*big.Int, error) {
-	return rand.Int(rand.Reader, big.NewInt(8594))
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// ParseCertificate decodes a PEM-encoded certificate
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	return parseRootCertificate(certPEM)
 }
 
 func parseRootCertificate(rootCert []byte) (*x509.Certificate, error) {
@@ -80,11 +97,3 @@ func certToPEM(c []byte) []byte {
 	}
 	return pem.EncodeToMemory(pemCA)
 }
-
-func keyToPEM(key *rsa.PrivateKey) []byte {
-	pemKey := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	}
-	return pem.EncodeToMemory(pemKey)
-}
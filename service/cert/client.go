@@ -20,55 +20,159 @@
 package cert
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/canonical/iot-identity/domain"
 )
 
-// CreateClientCert creates a signed client certificate
-func CreateClientCert(org *domain.Organization, certsPath, deviceID string) ([]byte, []byte, error) {
-	// Get the parsed CA from the filesystem
-	caKeyPair, caTemplate, err := getCertificateAuthority(certsPath)
+// minimum RSA key size that is accepted in a certificate signing request
+const minRSAKeySize = 2048
+
+// DefaultValidityDays is the validity of device certificates, unless the organization sets it
+const DefaultValidityDays = 3650
+
+// CreateClientCert creates a client certificate, signed by the organization's CA, with a
+// private key of the selected algorithm
+func CreateClientCert(org *domain.Organization, certsPath, deviceID, keyType string) ([]byte, []byte, error) {
+	// Get the organization's CA
+	caKeyPair, caTemplate, err := getOrganizationAuthority(org, certsPath)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	template := clientTemplate(org.Name, deviceID)
-	privateKey, cert, err := createCertificate(template, caTemplate, caKeyPair)
+	template, err := clientTemplate(org, deviceID, caTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey, cert, err := createCertificate(template, caTemplate, caKeyPair, keyType)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Create plain text PEM for certificate
 	certPEM := certToPEM(cert)
 
 	// Create plain text PEM for key
-	keyPEM := keyToPEM(privateKey)
+	keyPEM, err := keyToPEM(privateKey)
 
 	return keyPEM, certPEM, err
 }
 
-func createCertificate(template, parentTemplate *x509.Certificate, keyPair tls.Certificate) (*rsa.PrivateKey, []byte, error) {
+// CreateClientCertFromCSR signs the public key of a certificate signing request, so
+// the private key of the device is never seen by the service
+func CreateClientCertFromCSR(org *domain.Organization, certsPath, deviceID string, csrPEM []byte) ([]byte, error) {
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the organization's CA
+	caKeyPair, caTemplate, err := getOrganizationAuthority(org, certsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The subject is always set by the service, not the device
+	template, err := clientTemplate(org, deviceID, caTemplate)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := signCertificate(template, caTemplate, csr.PublicKey, caKeyPair)
+	if err != nil {
+		return nil, err
+	}
+
+	return certToPEM(cert), nil
+}
+
+// ParseCSR decodes a PEM-encoded certificate signing request, checking its signature
+// and that its public key is acceptable
+func ParseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("failed to parse certificate request PEM")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse certificate request: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %v", err)
+	}
+
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeySize {
+			return nil, fmt.Errorf("the RSA key must be at least %d bits", minRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() && pub.Curve != elliptic.P384() {
+			return nil, fmt.Errorf("the ECDSA key must use the P-256 or P-384 curve")
+		}
+	case ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type in certificate request")
+	}
+	return csr, nil
+}
+
+// validityDays is the number of days that device certificates of an organization are valid for
+func validityDays(org *domain.Organization) int {
+	if org.CertValidityDays > 0 {
+		return org.CertValidityDays
+	}
+	return DefaultValidityDays
+}
+
+func createCertificate(template, parentTemplate *x509.Certificate, keyPair tls.Certificate, keyType string) (crypto.Signer, []byte, error) {
 	// Generate a private key
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	pub := &privateKey.PublicKey
+	privateKey, err := generateKey(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Sign the certificate
-	cert, err := x509.CreateCertificate(rand.Reader, template, parentTemplate, pub, keyPair.PrivateKey)
+	cert, err := signCertificate(template, parentTemplate, privateKey.Public(), keyPair)
 	if err != nil {
-		log.Println("Error creating client certificate:", err)
 		return nil, nil, err
 	}
 	return privateKey, cert, nil
 }
 
-func clientTemplate(name, deviceID string) *x509.Certificate {
+func signCertificate(template, parentTemplate *x509.Certificate, pub crypto.PublicKey, keyPair tls.Certificate) ([]byte, error) {
+	cert, err := x509.CreateCertificate(rand.Reader, template, parentTemplate, pub, keyPair.PrivateKey)
+	if err != nil {
+		log.Println("Error creating client certificate:", err)
+		return nil, err
+	}
+	return cert, nil
+}
+
+// clientTemplate prepares the certificate of a device. It expires after the validity days of
+// the organization, or with the CA that signs it when that is sooner
+func clientTemplate(org *domain.Organization, deviceID string, ca *x509.Certificate) (*x509.Certificate, error) {
 	serial, err := randomNumber()
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("cannot generate certificate serial number: %v", err)
+	}
+
+	now := time.Now()
+	notAfter := now.AddDate(0, 0, validityDays(org))
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
 	}
 
 	// Prepare certificate
@@ -76,12 +180,11 @@ func clientTemplate(name, deviceID string) *x509.Certificate {
 		SerialNumber: serial,
 		Subject: pkix.Name{
 			CommonName:   deviceID,
-			Organization: []string{name},
+			Organization: []string{org.Name},
 		},
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(10, 0, 0),
-		SubjectKeyId: []byte{1, 2, 3},
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:     x509.KeyUsageDigitalSignature,
-	}
+		NotBefore:   now,
+		NotAfter:    notAfter,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, nil
 }
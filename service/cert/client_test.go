@@ -20,7 +20,17 @@
 package cert
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"testing"
+	"time"
 
 	"github.com/canonical/iot-identity/domain"
 )
@@ -30,18 +40,22 @@ func TestCreateClientCert(t *testing.T) {
 		org       *domain.Organization
 		certsPath string
 		deviceID  string
+		keyType   string
 	}
 	tests := []struct {
 		name    string
 		args    args
 		wantErr bool
 	}{
-		{"valid", args{&domain.Organization{Name: "Example PLC"}, "../../datastore/test_data", "abc123"}, false},
-		{"invalid-path", args{&domain.Organization{Name: "Example PLC"}, "invalid", "abc123"}, true},
+		{"valid", args{&domain.Organization{Name: "Example PLC"}, "../../datastore/test_data", "abc123", ""}, false},
+		{"valid-ecdsa", args{&domain.Organization{Name: "Example PLC"}, "../../datastore/test_data", "abc123", KeyTypeECDSAP256}, false},
+		{"valid-ed25519", args{&domain.Organization{Name: "Example PLC"}, "../../datastore/test_data", "abc123", KeyTypeEd25519}, false},
+		{"invalid-path", args{&domain.Organization{Name: "Example PLC"}, "invalid", "abc123", ""}, true},
+		{"invalid-key-type", args{&domain.Organization{Name: "Example PLC"}, "../../datastore/test_data", "abc123", "dsa1024"}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, err := CreateClientCert(tt.args.org, tt.args.certsPath, tt.args.deviceID)
+			got, got1, err := CreateClientCert(tt.args.org, tt.args.certsPath, tt.args.deviceID, tt.args.keyType)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CreateClientCert() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -55,3 +69,184 @@ func TestCreateClientCert(t *testing.T) {
 		})
 	}
 }
+
+func testCSR(t *testing.T, key crypto.Signer) []byte {
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "device"}}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatalf("error creating certificate request: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestParseCSR(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaWeak, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tampered := testCSR(t, p256)
+	block, _ := pem.Decode(tampered)
+	block.Bytes[len(block.Bytes)-1] ^= 0xff
+	tampered = pem.EncodeToMemory(block)
+
+	tests := []struct {
+		name    string
+		csr     []byte
+		wantErr bool
+	}{
+		{"valid-rsa", testCSR(t, rsaKey), false},
+		{"valid-ecdsa", testCSR(t, p256), false},
+		{"valid-ed25519", testCSR(t, edKey), false},
+		{"weak-rsa", testCSR(t, rsaWeak), true},
+		{"invalid-curve", testCSR(t, p224), true},
+		{"bad-signature", tampered, true},
+		{"not-pem", []byte("invalid"), true},
+		{"wrong-type", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("invalid")}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCSR(tt.csr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCSR() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateClientCertFromCSR(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr := testCSR(t, key)
+
+	type args struct {
+		org       *domain.Organization
+		certsPath string
+		deviceID  string
+		csr       []byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"valid", args{&domain.Organization{Name: "Example PLC"}, "../../datastore/test_data", "abc123", csr}, false},
+		{"invalid-path", args{&domain.Organization{Name: "Example PLC"}, "invalid", "abc123", csr}, true},
+		{"invalid-csr", args{&domain.Organization{Name: "Example PLC"}, "../../datastore/test_data", "abc123", []byte("invalid")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateClientCertFromCSR(tt.args.org, tt.args.certsPath, tt.args.deviceID, tt.args.csr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateClientCertFromCSR() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			block, _ := pem.Decode(got)
+			if block == nil {
+				t.Fatalf("CreateClientCertFromCSR() got = %v, want cert", got)
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatalf("CreateClientCertFromCSR() parse error = %v", err)
+			}
+			if c.Subject.CommonName != tt.args.deviceID {
+				t.Errorf("CreateClientCertFromCSR() common name = %v, want %v", c.Subject.CommonName, tt.args.deviceID)
+			}
+			if pub, ok := c.PublicKey.(*ecdsa.PublicKey); !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+				t.Errorf("CreateClientCertFromCSR() public key does not match the request")
+			}
+		})
+	}
+}
+
+func TestCreateClientCert_Validity(t *testing.T) {
+	tests := []struct {
+		name string
+		days int
+		want int
+	}{
+		{"default", 0, DefaultValidityDays},
+		{"short-lived", 7, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org := testOrganization(t)
+			org.CertValidityDays = tt.days
+			_, certPEM, err := CreateClientCert(org, "../../datastore/test_data", "abc123", "")
+			if err != nil {
+				t.Fatalf("CreateClientCert() error = %v", err)
+			}
+			c, _ := ParseCertificate(certPEM)
+			if got := int(c.NotAfter.Sub(c.NotBefore).Hours() / 24); got != tt.want {
+				t.Errorf("CreateClientCert() validity = %v days, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateClientCert_OrganizationCA(t *testing.T) {
+	org := testOrganization(t)
+	other := testOrganization(t)
+	_, rootCA, err := getCertificateAuthority("../../datastore/test_data")
+	if err != nil {
+		t.Fatalf("error reading root CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCA)
+
+	tests := []struct {
+		name    string
+		org     *domain.Organization
+		wantErr bool
+	}{
+		{"valid", org, false},
+		{"other-org", other, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, certPEM, err := CreateClientCert(tt.org, "../../datastore/test_data", "abc123", "")
+			if err != nil {
+				t.Fatalf("CreateClientCert() error = %v", err)
+			}
+			deviceCert, err := parseRootCertificate(certPEM)
+			if err != nil {
+				t.Fatalf("CreateClientCert() parse error = %v", err)
+			}
+
+			// The device certificate must only verify via the CA of its own organization
+			intermediates := x509.NewCertPool()
+			intermediates.AppendCertsFromPEM(org.RootCert)
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+			if _, err := deviceCert.Verify(opts); (err != nil) != tt.wantErr {
+				t.Errorf("CreateClientCert() verify error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientTemplate_CAExpiry(t *testing.T) {
+	org := &domain.Organization{Name: "Example PLC", CertValidityDays: 365}
+	tests := []struct {
+		name string
+		ca   time.Time
+		want int
+	}{
+		{"organization-validity", time.Now().AddDate(2, 0, 0), 365},
+		{"ca-expiry", time.Now().AddDate(0, 0, 30), 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := clientTemplate(org, "abc123", &x509.Certificate{NotAfter: tt.ca})
+			if err != nil {
+				t.Fatalf("clientTemplate() error = %v", err)
+			}
+			if days := int(got.NotAfter.Sub(got.NotBefore).Hours()/24 + 0.5); days != tt.want || got.NotAfter.After(tt.ca) {
+				t.Errorf("clientTemplate() validity = %v days, want %v", days, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// oidReasonCode is the CRL entry extension holding the revocation reason (RFC 5280)
+var oidReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// CreateCRL creates a DER-encoded certificate revocation list of the revoked device
+// certificates, signed by the organization's CA
+func CreateCRL(org *domain.Organization, certsPath string, revoked []domain.Revocation, nextUpdate time.Time) ([]byte, error) {
+	caKeyPair, caTemplate, err := getOrganizationAuthority(org, certsPath)
+	if err != nil {
+		return nil, err
+	}
+	if !caTemplate.IsCA || caTemplate.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return nil, fmt.Errorf("the organization does not have a CA that can sign a revocation list")
+	}
+
+	entries := []pkix.RevokedCertificate{}
+	for _, r := range revoked {
+		serial, ok := new(big.Int).SetString(r.SerialNumber, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid certificate serial number `%s`", r.SerialNumber)
+		}
+		entry := pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: r.Revoked.UTC()}
+
+		// The reason code is omitted when it is unspecified (RFC 5280, 5.3.1)
+		if r.Reason != 0 {
+			reason, err := asn1.Marshal(asn1.Enumerated(r.Reason))
+			if err != nil {
+				return nil, err
+			}
+			entry.Extensions = []pkix.Extension{{Id: oidReasonCode, Value: reason}}
+		}
+		entries = append(entries, entry)
+	}
+
+	signer, ok := caKeyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("the organization CA key cannot sign a revocation list")
+	}
+	return caTemplate.CreateCRL(rand.Reader, signer, entries, time.Now(), nextUpdate)
+}
+
+// RevocationReason returns the reason code of a revocation list entry
+func RevocationReason(entry pkix.RevokedCertificate) domain.RevocationReason {
+	for _, ext := range entry.Extensions {
+		if !ext.Id.Equal(oidReasonCode) {
+			continue
+		}
+		var reason asn1.Enumerated
+		if _, err := asn1.Unmarshal(ext.Value, &reason); err == nil {
+			return domain.RevocationReason(reason)
+		}
+	}
+	return domain.RevocationReason(0)
+}
+
+// SerialNumber returns the serial number of a PEM-encoded certificate
+func SerialNumber(certPEM []byte) (string, error) {
+	c, err := parseRootCertificate(certPEM)
+	if err != nil {
+		return "", fmt.Errorf("cannot read certificate: %v", err)
+	}
+	return c.SerialNumber.String(), nil
+}
@@ -0,0 +1,111 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cert
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestCreateCRL(t *testing.T) {
+	org := testOrganization(t)
+	_, certPEM, err := CreateClientCert(org, "../../datastore/test_data", "abc123", "")
+	if err != nil {
+		t.Fatalf("error creating client certificate: %v", err)
+	}
+	serial, err := SerialNumber(certPEM)
+	if err != nil {
+		t.Fatalf("SerialNumber() error = %v", err)
+	}
+	revoked := []domain.Revocation{{OrganizationID: "abc", DeviceID: "abc123", SerialNumber: serial, Reason: domain.ReasonCessationOfOperation, Revoked: time.Now()}}
+
+	tests := []struct {
+		name    string
+		org     *domain.Organization
+		revoked []domain.Revocation
+		wantErr bool
+	}{
+		{"valid", org, revoked, false},
+		{"empty", org, nil, false},
+		{"unspecified", org, []domain.Revocation{{OrganizationID: "abc", DeviceID: "abc123", SerialNumber: serial, Revoked: time.Now()}}, false},
+		{"invalid-serial", org, []domain.Revocation{{SerialNumber: "invalid"}}, true},
+		{"legacy-org", &domain.Organization{Name: "Example Inc"}, revoked, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateCRL(tt.org, "../../datastore/test_data", tt.revoked, time.Now().Add(time.Hour))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateCRL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			crl, err := x509.ParseDERCRL(got)
+			if err != nil {
+				t.Fatalf("CreateCRL() parse error = %v", err)
+			}
+			orgCA, _ := parseRootCertificate(tt.org.RootCert)
+			if err := orgCA.CheckCRLSignature(crl); err != nil {
+				t.Errorf("CreateCRL() signature error = %v", err)
+			}
+			entries := crl.TBSCertList.RevokedCertificates
+			if len(entries) != len(tt.revoked) {
+				t.Fatalf("CreateCRL() got %d entries, want %d", len(entries), len(tt.revoked))
+			}
+			for i, r := range tt.revoked {
+				entry := entries[i]
+				if entry.SerialNumber.String() != r.SerialNumber || RevocationReason(entry) != r.Reason {
+					t.Errorf("CreateCRL() entry = %v/%v, want %v/%v", entry.SerialNumber, RevocationReason(entry), r.SerialNumber, r.Reason)
+				}
+				if r.Reason == 0 && len(entry.Extensions) > 0 {
+					t.Errorf("CreateCRL() entry extensions = %v, want no reason code", entry.Extensions)
+				}
+			}
+		})
+	}
+}
+
+func TestSerialNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		certPEM []byte
+		wantErr bool
+	}{
+		{"valid", testOrganization(t).RootCert, false},
+		{"invalid", []byte("invalid"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SerialNumber(tt.certPEM)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SerialNumber() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && len(got) == 0 {
+				t.Error("SerialNumber() = empty serial number")
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestKeyToPEM(t *testing.T) {
+	tests := []struct {
+		keyType string
+		want    interface{}
+		bits    int
+	}{
+		{KeyTypeRSA2048, &rsa.PrivateKey{}, 2048},
+		{KeyTypeRSA3072, &rsa.PrivateKey{}, 3072},
+		{KeyTypeECDSAP256, &ecdsa.PrivateKey{}, 256},
+		{KeyTypeECDSAP384, &ecdsa.PrivateKey{}, 384},
+		{KeyTypeEd25519, ed25519.PrivateKey{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyType, func(t *testing.T) {
+			key, err := generateKey(tt.keyType)
+			if err != nil {
+				t.Fatalf("generateKey() error = %v", err)
+			}
+			keyPEM, err := keyToPEM(key)
+			if err != nil {
+				t.Fatalf("keyToPEM() error = %v", err)
+			}
+
+			block, _ := pem.Decode(keyPEM)
+			if block == nil || block.Type != "PRIVATE KEY" {
+				t.Fatalf("keyToPEM() = not a PKCS#8 PEM block")
+			}
+			got, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				t.Fatalf("keyToPEM() parse error = %v", err)
+			}
+
+			switch k := got.(type) {
+			case *rsa.PrivateKey:
+				if _, ok := tt.want.(*rsa.PrivateKey); !ok || k.N.BitLen() != tt.bits {
+					t.Errorf("keyToPEM() = RSA %d, want %s", k.N.BitLen(), tt.keyType)
+				}
+			case *ecdsa.PrivateKey:
+				if _, ok := tt.want.(*ecdsa.PrivateKey); !ok || k.Curve.Params().BitSize != tt.bits {
+					t.Errorf("keyToPEM() = ECDSA %d, want %s", k.Curve.Params().BitSize, tt.keyType)
+				}
+			case ed25519.PrivateKey:
+				if _, ok := tt.want.(ed25519.PrivateKey); !ok {
+					t.Errorf("keyToPEM() = Ed25519, want %s", tt.keyType)
+				}
+			default:
+				t.Errorf("keyToPEM() = unexpected key %T", got)
+			}
+		})
+	}
+}
+
+func TestValidKeyType(t *testing.T) {
+	for _, k := range KeyTypes {
+		if !ValidKeyType(k) {
+			t.Errorf("ValidKeyType(%s) = false, want true", k)
+		}
+	}
+	if ValidKeyType("dsa1024") || ValidKeyType("") {
+		t.Errorf("ValidKeyType() = true, want false")
+	}
+}
+
+// ecdsaRootCA writes a self-signed ECDSA root CA to the directory, with a SEC 1 key as created by openssl
+func ecdsaRootCA(t *testing.T, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Example Root CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(20, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("cannot create root CA: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot encode key: %v", err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(path.Join(dir, rootCA), certToPEM(der), 0600); err != nil {
+		t.Fatalf("cannot write root CA: %v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, rootCAKey), keyPEM, 0600); err != nil {
+		t.Fatalf("cannot write root CA key: %v", err)
+	}
+}
+
+func TestCreateClientCert_ECDSARoot(t *testing.T) {
+	certsPath, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("cannot create certs directory: %v", err)
+	}
+	defer os.RemoveAll(certsPath)
+	ecdsaRootCA(t, certsPath)
+
+	orgKey, orgCert, err := CreateOrganizationCert(certsPath, "Example PLC", KeyTypeECDSAP256)
+	if err != nil {
+		t.Fatalf("CreateOrganizationCert() error = %v", err)
+	}
+	org := &domain.Organization{Name: "Example PLC", RootCert: orgCert, RootKey: orgKey}
+
+	_, certPEM, err := CreateClientCert(org, certsPath, "abc123", KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("CreateClientCert() error = %v", err)
+	}
+	c, err := ParseCertificate(certPEM)
+	if err != nil {
+		t.Fatalf("CreateClientCert() parse error = %v", err)
+	}
+	if c.SignatureAlgorithm != x509.ECDSAWithSHA256 {
+		t.Errorf("CreateClientCert() signature = %v, want %v", c.SignatureAlgorithm, x509.ECDSAWithSHA256)
+	}
+
+	roots, err := RootCertPool(certsPath)
+	if err != nil {
+		t.Fatalf("RootCertPool() error = %v", err)
+	}
+	intermediates := x509.NewCertPool()
+	orgCA, _ := ParseCertificate(orgCert)
+	intermediates.AddCert(orgCA)
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if _, err := c.Verify(opts); err != nil {
+		t.Errorf("CreateClientCert() verify error = %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+	"golang.org/x/crypto/ocsp"
+)
+
+// oidOCSPNoCheck marks a delegated responder certificate that is not checked for revocation (RFC 6960)
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// OCSPSigner signs OCSP responses for the certificates issued by a CA, using a
+// delegated OCSP signing certificate
+type OCSPSigner struct {
+	Issuer      *x509.Certificate
+	Certificate *x509.Certificate
+	key         crypto.Signer
+}
+
+// IssuerCertificate returns the CA certificate that signs the device certificates of an organization.
+// It does not need the organization's CA key
+func IssuerCertificate(org *domain.Organization, certsPath string) (*x509.Certificate, error) {
+	orgCA, err := parseRootCertificate(org.RootCert)
+	if err == nil && orgCA.IsCA {
+		return orgCA, nil
+	}
+	_, issuer, err := getCertificateAuthority(certsPath)
+	return issuer, err
+}
+
+// NewOCSPSigner creates a delegated OCSP signing certificate for the CA that signs the
+// device certificates of an organization
+func NewOCSPSigner(org *domain.Organization, certsPath string, validity time.Duration) (*OCSPSigner, error) {
+	caKeyPair, caTemplate, err := getOrganizationAuthority(org, certsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := ocspTemplate(caTemplate, validity)
+	if err != nil {
+		return nil, err
+	}
+	// OCSP responses cannot be signed with Ed25519, so the responder always uses ECDSA
+	privateKey, der, err := createCertificate(template, caTemplate, caKeyPair, KeyTypeECDSAP256)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create OCSP signing certificate: %v", err)
+	}
+	responder, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create OCSP signing certificate: %v", err)
+	}
+
+	return &OCSPSigner{
+		Issuer:      caTemplate,
+		Certificate: responder,
+		key:         privateKey,
+	}, nil
+}
+
+// Sign creates a DER-encoded OCSP response
+func (s *OCSPSigner) Sign(template ocsp.Response) ([]byte, error) {
+	template.Certificate = s.Certificate
+	return ocsp.CreateResponse(s.Issuer, s.Certificate, template, s.key)
+}
+
+// MatchesIssuer checks whether an OCSP request is for a certificate issued by the CA
+func MatchesIssuer(req *ocsp.Request, issuer *x509.Certificate) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return false
+	}
+
+	h := req.HashAlgorithm.New()
+	h.Write(publicKeyInfo.PublicKey.RightAlign())
+	if !bytes.Equal(h.Sum(nil), req.IssuerKeyHash) {
+		return false
+	}
+
+	h.Reset()
+	h.Write(issuer.RawSubject)
+	return bytes.Equal(h.Sum(nil), req.IssuerNameHash)
+}
+
+// ocspTemplate prepares the delegated signing certificate of a CA, which does not outlive the CA
+func ocspTemplate(issuer *x509.Certificate, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := randomNumber()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate certificate serial number: %v", err)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(issuer.NotAfter) {
+		notAfter = issuer.NotAfter
+	}
+
+	name := issuer.Subject.CommonName
+	if len(name) == 0 && len(issuer.Subject.Organization) > 0 {
+		name = issuer.Subject.Organization[0]
+	}
+
+	// Prepare certificate
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   name + " OCSP Responder",
+			Organization: issuer.Subject.Organization,
+		},
+		NotBefore:       now,
+		NotAfter:        notAfter,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: oidOCSPNoCheck, Value: asn1.NullBytes}},
+	}, nil
+}
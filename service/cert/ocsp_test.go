@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cert
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestNewOCSPSigner(t *testing.T) {
+	tests := []struct {
+		name      string
+		org       *domain.Organization
+		certsPath string
+		wantErr   bool
+	}{
+		{"valid", testOrganization(t), "../../datastore/test_data", false},
+		{"legacy-org", &domain.Organization{Name: "Example Inc"}, "../../datastore/test_data", false},
+		{"invalid-path", &domain.Organization{Name: "Example Inc"}, "invalid", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewOCSPSigner(tt.org, tt.certsPath, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewOCSPSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if err := got.Certificate.CheckSignatureFrom(got.Issuer); err != nil {
+				t.Errorf("NewOCSPSigner() certificate not signed by the issuer: %v", err)
+			}
+			if len(got.Certificate.ExtKeyUsage) != 1 || got.Certificate.ExtKeyUsage[0] != x509.ExtKeyUsageOCSPSigning {
+				t.Errorf("NewOCSPSigner() key usage = %v, want OCSP signing", got.Certificate.ExtKeyUsage)
+			}
+
+			// Sign a response for a device certificate of the CA
+			_, certPEM, _ := CreateClientCert(tt.org, tt.certsPath, "abc123", "")
+			device, _ := parseRootCertificate(certPEM)
+			resp, err := got.Sign(ocsp.Response{Status: ocsp.Good, SerialNumber: device.SerialNumber, ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("OCSPSigner.Sign() error = %v", err)
+			}
+			if _, err := ocsp.ParseResponseForCert(resp, device, got.Issuer); err != nil {
+				t.Errorf("OCSPSigner.Sign() parse error = %v", err)
+			}
+		})
+	}
+}
+
+func TestMatchesIssuer(t *testing.T) {
+	org := testOrganization(t)
+	issuer, _ := IssuerCertificate(org, "../../datastore/test_data")
+	other, _ := IssuerCertificate(testOrganization(t), "../../datastore/test_data")
+	_, certPEM, _ := CreateClientCert(org, "../../datastore/test_data", "abc123", "")
+	device, _ := parseRootCertificate(certPEM)
+
+	tests := []struct {
+		name   string
+		issuer *x509.Certificate
+		want   bool
+	}{
+		{"valid", issuer, true},
+		{"other-ca", other, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := ocsp.CreateRequest(device, issuer, nil)
+			if err != nil {
+				t.Fatalf("error creating OCSP request: %v", err)
+			}
+			req, _ := ocsp.ParseRequest(der)
+			if got := MatchesIssuer(req, tt.issuer); got != tt.want {
+				t.Errorf("MatchesIssuer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -20,22 +20,32 @@
 package cert
 
 import (
+	"bytes"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
+	"io/ioutil"
+	"path"
 	"time"
+
+	"github.com/canonical/iot-identity/domain"
 )
 
-// CreateOrganizationCert creates a signed organization certificate
-func CreateOrganizationCert(certsPath, orgName string) ([]byte, []byte, error) {
+// CreateOrganizationCert creates an intermediate CA for an organization, signed by the root CA.
+// The intermediate CA signs the device certificates of the organization
+func CreateOrganizationCert(certsPath, orgName, keyType string) ([]byte, []byte, error) {
 	// Get the parsed CA from the filesystem
 	caKeyPair, caTemplate, err := getCertificateAuthority(certsPath)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	template := orgTemplate(orgName)
-	privateKey, cert, err := createCertificate(template, caTemplate, caKeyPair)
+	template, err := orgTemplate(orgName, caTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey, cert, err := createCertificate(template, caTemplate, caKeyPair, keyType)
 	if err != nil {
 		return nil, nil, fmt.Errorf("cannot create certificate: %v", err)
 	}
@@ -44,27 +54,76 @@ func CreateOrganizationCert(certsPath, orgName string) ([]byte, []byte, error) {
 	certPEM := certToPEM(cert)
 
 	// Create plain text PEM for key
-	keyPEM := keyToPEM(privateKey)
+	keyPEM, err := keyToPEM(privateKey)
 
 	return keyPEM, certPEM, err
 }
 
-func orgTemplate(name string) *x509.Certificate {
+// orgTemplate prepares the CA of an organization, which does not outlive the root CA
+func orgTemplate(name string, root *x509.Certificate) (*x509.Certificate, error) {
 	serial, err := randomNumber()
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("cannot generate certificate serial number: %v", err)
+	}
+
+	now := time.Now()
+	notAfter := now.AddDate(10, 0, 0)
+	if notAfter.After(root.NotAfter) {
+		notAfter = root.NotAfter
 	}
 
-	// Prepare certificate
+	// Prepare certificate: the CA may only sign device certificates, not other CAs
 	return &x509.Certificate{
 		SerialNumber: serial,
 		Subject: pkix.Name{
+			CommonName:   name + " Devices CA",
 			Organization: []string{name},
 		},
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(10, 0, 0),
-		SubjectKeyId: []byte{1, 2, 3},
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:     x509.KeyUsageDigitalSignature,
+		NotBefore:             now,
+		NotAfter:              notAfter,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+	}, nil
+}
+
+// getOrganizationAuthority loads the intermediate CA of the organization. Organizations
+// created before they had an intermediate CA fall back to the root CA
+func getOrganizationAuthority(org *domain.Organization, certsPath string) (tls.Certificate, *x509.Certificate, error) {
+	orgCA, err := parseRootCertificate(org.RootCert)
+	if err != nil || !orgCA.IsCA {
+		return getCertificateAuthority(certsPath)
+	}
+
+	keyPair, err := tls.X509KeyPair(org.RootCert, org.RootKey)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("cannot read organization CA: %v", err)
 	}
+	return keyPair, orgCA, nil
+}
+
+// CertificateChain returns the PEM-encoded chain of a device certificate: the device
+// certificate, the organization CA that signed it and the root CA
+func CertificateChain(org *domain.Organization, certsPath string, certPEM []byte) ([]byte, error) {
+	deviceCert, err := parseRootCertificate(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read device certificate: %v", err)
+	}
+
+	rootPEM, err := ioutil.ReadFile(path.Join(certsPath, rootCA))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read root CA: %v", err)
+	}
+
+	chain := [][]byte{bytes.TrimSpace(certPEM)}
+	orgCA, err := parseRootCertificate(org.RootCert)
+	if err == nil && orgCA.IsCA && deviceCert.CheckSignatureFrom(orgCA) == nil {
+		chain = append(chain, bytes.TrimSpace(org.RootCert))
+	}
+	chain = append(chain, bytes.TrimSpace(rootPEM))
+
+	return append(bytes.Join(chain, []byte("\n")), '\n'), nil
 }
@@ -20,25 +20,30 @@
 package cert
 
 import (
+	"crypto/x509"
 	"testing"
+
+	"github.com/canonical/iot-identity/domain"
 )
 
 func TestCreateOrganizationCert(t *testing.T) {
 	type args struct {
 		certsPath string
 		orgName   string
+		keyType   string
 	}
 	tests := []struct {
 		name    string
 		args    args
 		wantErr bool
 	}{
-		{"valid", args{"../../datastore/test_data", "Example PLC"}, false},
-		{"invalid-path", args{"invalid", "Example PLC"}, true},
+		{"valid", args{"../../datastore/test_data", "Example PLC", ""}, false},
+		{"valid-ecdsa", args{"../../datastore/test_data", "Example PLC", KeyTypeECDSAP384}, false},
+		{"invalid-path", args{"invalid", "Example PLC", ""}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, err := CreateOrganizationCert(tt.args.certsPath, tt.args.orgName)
+			got, got1, err := CreateOrganizationCert(tt.args.certsPath, tt.args.orgName, tt.args.keyType)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CreateOrganizationCert() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -49,6 +54,76 @@ func TestCreateOrganizationCert(t *testing.T) {
 			if got1 == nil && !tt.wantErr {
 				t.Errorf("CreateOrganizationCert() got1 = %v, want certificate", got1)
 			}
+			if tt.wantErr {
+				return
+			}
+
+			c, err := parseRootCertificate(got1)
+			if err != nil {
+				t.Fatalf("CreateOrganizationCert() parse error = %v", err)
+			}
+			if !c.BasicConstraintsValid || !c.IsCA || c.MaxPathLen != 0 || !c.MaxPathLenZero {
+				t.Errorf("CreateOrganizationCert() = not an intermediate CA with path length 0")
+			}
+			if c.KeyUsage&x509.KeyUsageCertSign == 0 {
+				t.Errorf("CreateOrganizationCert() = missing the certificate signing key usage")
+			}
+		})
+	}
+}
+
+func testOrganization(t *testing.T) *domain.Organization {
+	key, cert, err := CreateOrganizationCert("../../datastore/test_data", "Example PLC", "")
+	if err != nil {
+		t.Fatalf("error creating organization CA: %v", err)
+	}
+	return &domain.Organization{Name: "Example PLC", RootCert: cert, RootKey: key}
+}
+
+func TestCertificateChain(t *testing.T) {
+	org := testOrganization(t)
+	_, orgCert, err := CreateClientCert(org, "../../datastore/test_data", "abc123", "")
+	if err != nil {
+		t.Fatalf("error creating client certificate: %v", err)
+	}
+	legacy := &domain.Organization{Name: "Example Inc"}
+	_, legacyCert, err := CreateClientCert(legacy, "../../datastore/test_data", "abc123", "")
+	if err != nil {
+		t.Fatalf("error creating client certificate: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		org       *domain.Organization
+		certsPath string
+		certPEM   []byte
+		want      int
+		wantErr   bool
+	}{
+		{"valid", org, "../../datastore/test_data", orgCert, 3, false},
+		{"legacy-org", legacy, "../../datastore/test_data", legacyCert, 2, false},
+		{"other-org", testOrganization(t), "../../datastore/test_data", orgCert, 2, false},
+		{"invalid-path", org, "invalid", orgCert, 0, true},
+		{"invalid-cert", org, "../../datastore/test_data", []byte("invalid"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CertificateChain(tt.org, tt.certsPath, tt.certPEM)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CertificateChain() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(got) {
+				t.Fatalf("CertificateChain() = cannot parse %s", got)
+			}
+			if len(pool.Subjects()) != tt.want {
+				t.Errorf("CertificateChain() got %d certificates, want %d", len(pool.Subjects()), tt.want)
+			}
 		})
 	}
 }
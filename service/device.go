@@ -20,24 +20,55 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"github.com/canonical/iot-identity/datastore"
 	"github.com/canonical/iot-identity/domain"
 	"github.com/canonical/iot-identity/service/cert"
+	"log"
+	"time"
 )
 
-// DeviceList fetches the registered devices
-func (id IdentityService) DeviceList(orgID string) ([]domain.Enrollment, error) {
-	return id.DB.DeviceList(orgID)
+// DeviceList fetches a page of the registered devices of an organization
+func (id IdentityService) DeviceList(query datastore.DeviceQuery) (*datastore.DevicePage, error) {
+	return id.DB.DeviceListPage(query)
 }
 
-// DeviceGet fetches a device registration
+// DeviceGet fetches a device registration of an organization
 func (id IdentityService) DeviceGet(orgID, deviceID string) (*domain.Enrollment, error) {
-	return id.DB.DeviceGetByID(deviceID)
+	device, err := id.DB.DeviceGetByOrgID(orgID, deviceID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, fmt.Errorf("%w: `%s` in organization `%s`", ErrDeviceNotFound, deviceID, orgID)
+	}
+	return device, err
+}
+
+// DeviceHistory fetches the status transitions of a device of an organization, oldest first
+func (id IdentityService) DeviceHistory(orgID, deviceID string) ([]domain.StatusChange, error) {
+	if _, err := id.DeviceGet(orgID, deviceID); err != nil {
+		return nil, err
+	}
+	return id.DB.DeviceHistory(deviceID)
 }
 
 // RegisterDevice registers a new device with the service
-func (id IdentityService) RegisterDevice(req *RegisterDeviceRequest) (string, error) {
+func (id IdentityService) RegisterDevice(caller *domain.Caller, req *RegisterDeviceRequest) (string, error) {
+	deviceID, err := id.registerDevice(req)
+	event := domain.AuditEvent{
+		Action:         domain.ActionDeviceRegister,
+		OrganizationID: req.OrganizationID,
+		DeviceID:       deviceID,
+		Details:        fmt.Sprintf("%s/%s/%s", req.Brand, req.Model, req.SerialNumber),
+	}
+	if err == nil {
+		event.After = domain.StatusWaiting
+	}
+	id.audit(caller, event, err)
+	return deviceID, err
+}
+
+// registerDevice validates the request and creates the device registration
+func (id IdentityService) registerDevice(req *RegisterDeviceRequest) (string, error) {
 	// Validate fields
 	for k, v := range map[string]string{
 		"organization ID": req.OrganizationID,
@@ -61,13 +92,35 @@ func (id IdentityService) RegisterDevice(req *RegisterDeviceRequest) (string, er
 		return "", fmt.Errorf("the device `%s/%s/%s` is already registered", req.Brand, req.Model, req.SerialNumber)
 	}
 
-	// Create a signed certificate
+	// A deleted device is only registered again deliberately
+	tombstone, err := id.DB.TombstoneGet(req.Brand, req.Model, req.SerialNumber)
+	if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		return "", err
+	}
+	if err == nil && !req.Reregister {
+		return "", fmt.Errorf("the device `%s/%s/%s` was deleted at %s. Set `reregister` to register it again",
+			req.Brand, req.Model, req.SerialNumber, tombstone.Deleted.UTC().Format(time.RFC3339))
+	}
+
+	// The credentials connect to the broker of the organization
 	deviceID := datastore.GenerateID()
-	keyPEM, certPEM, err := cert.CreateClientCert(org, id.Settings.RootCertsDir, deviceID)
+	mqtt, err := id.brokerCredentials(org, &domain.Enrollment{
+		ID:     deviceID,
+		Device: domain.Device{Brand: req.Brand, Model: req.Model, SerialNumber: req.SerialNumber},
+	})
 	if err != nil {
 		return "", err
 	}
 
+	// Create a signed certificate, unless the device will request one when it enrolls
+	var keyPEM, certPEM []byte
+	if org.KeyMode != domain.KeyModeCSR {
+		keyPEM, certPEM, err = cert.CreateClientCert(org, id.Settings.RootCertsDir, deviceID, id.keyType(org.KeyType))
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// Create registration
 	d := datastore.DeviceNewRequest{
 		ID:             deviceID,
@@ -75,14 +128,11 @@ func (id IdentityService) RegisterDevice(req *RegisterDeviceRequest) (string, er
 		Brand:          req.Brand,
 		Model:          req.Model,
 		SerialNumber:   req.SerialNumber,
-		Credentials: domain.Credentials{
-			PrivateKey:  keyPEM,
-			Certificate: certPEM,
-			MQTTURL:     id.Settings.MQTTUrl, // Using a default URL for all devices
-			MQTTPort:    id.Settings.MQTTPort,
-		},
-		DeviceData: req.DeviceData,
+		Credentials:    mqtt,
+		DeviceData:     req.DeviceData,
 	}
+	d.Credentials.PrivateKey = keyPEM
+	d.Credentials.Certificate = certPEM
 	return id.DB.DeviceNew(d)
 }
 
@@ -93,11 +143,26 @@ func (id IdentityService) RegisterDevice(req *RegisterDeviceRequest) (string, er
 // - Waiting => Disabled
 // - Disabled => Waiting
 // If a device has enrolled:
-// - Enrolled => Disabled (TODO: needs to trigger the removal of credentials from MQTT broker or device or both)
+// - Enrolled => Disabled
 // - Enrolled => Waiting
-func (id IdentityService) DeviceUpdate(orgID, deviceID string, req *DeviceUpdateRequest) error {
+// Disabling a device revokes its certificate, so it is issued a new one if it is enrolled again.
+func (id IdentityService) DeviceUpdate(caller *domain.Caller, orgID, deviceID string, req *DeviceUpdateRequest) error {
+	event := domain.AuditEvent{
+		Action:         domain.ActionDeviceUpdate,
+		OrganizationID: orgID,
+		DeviceID:       deviceID,
+		Before:         id.deviceStatus(orgID, deviceID),
+	}
+	err := id.deviceUpdate(orgID, deviceID, req)
+	event.After = id.deviceStatus(orgID, deviceID)
+	id.audit(caller, event, err)
+	return err
+}
+
+// deviceUpdate applies the changes to the status and data of a device
+func (id IdentityService) deviceUpdate(orgID, deviceID string, req *DeviceUpdateRequest) error {
 	// Get the device and check the current status
-	device, err := id.DB.DeviceGetByID(deviceID)
+	device, err := id.DeviceGet(orgID, deviceID)
 	if err != nil {
 		return err
 	}
@@ -128,12 +193,102 @@ func (id IdentityService) DeviceUpdate(orgID, deviceID string, req *DeviceUpdate
 		device.Status = domain.StatusWaiting
 	case domain.StatusEnrolled:
 		if req.Status == int(domain.StatusDisabled) {
-			// TODO: trigger the removal of credentials from MQTT broker or device or both
 			device.Status = domain.StatusDisabled
 		} else {
 			device.Status = domain.StatusWaiting
 		}
 	}
 
-	return id.DB.DeviceUpdate(device.ID, device.Status, req.DeviceData)
+	// The certificate is only revoked once the device is disabled, so a failed update does not
+	// leave an active device with a revoked certificate
+	if err := id.DB.DeviceUpdate(device.ID, device.Status, req.DeviceData); err != nil {
+		return err
+	}
+
+	// The status of the device certificate may have changed
+	id.ocsp.reset()
+	return id.revokeDisabled(device)
+}
+
+// revokeDisabled revokes the certificate of a disabled device, so that it cannot be used,
+// unless it is revoked already
+func (id IdentityService) revokeDisabled(device *domain.Enrollment) error {
+	if device.Status != domain.StatusDisabled || len(device.Credentials.Certificate) == 0 {
+		return nil
+	}
+	revoked, err := id.isRevoked(device)
+	if err != nil || revoked {
+		return err
+	}
+	return id.revokeCertificate(device, domain.ReasonCessationOfOperation)
+}
+
+// DeviceDelete deletes a device registration of an organization, revoking its certificate.
+// A tombstone of the device is kept, so that its serial number is only registered again deliberately
+func (id IdentityService) DeviceDelete(caller *domain.Caller, orgID, deviceID string) error {
+	device, err := id.DeviceGet(orgID, deviceID)
+	if err != nil {
+		id.audit(caller, domain.AuditEvent{Action: domain.ActionDeviceDelete, OrganizationID: orgID, DeviceID: deviceID}, err)
+		return err
+	}
+
+	revoked, err := id.revokedSerials(orgID)
+	if err != nil {
+		return err
+	}
+	if err := id.deleteDevice(caller, device, revoked); err != nil {
+		return err
+	}
+
+	if len(device.Credentials.Certificate) > 0 {
+		if _, err := id.RefreshCRL(orgID); err != nil {
+			log.Printf("Error creating revocation list for organization `%s`: %v\n", orgID, err)
+		}
+	}
+	return nil
+}
+
+// deleteDevice revokes the certificate of a device, unless it is one of the revoked serial
+// numbers, and replaces the device with its tombstone
+func (id IdentityService) deleteDevice(caller *domain.Caller, en *domain.Enrollment, revoked map[string]bool) error {
+	err := id.replaceWithTombstone(caller, en, revoked)
+	id.audit(caller, domain.AuditEvent{
+		Action:         domain.ActionDeviceDelete,
+		OrganizationID: en.Organization.ID,
+		DeviceID:       en.ID,
+		Before:         en.Status,
+		Details:        fmt.Sprintf("%s/%s/%s", en.Device.Brand, en.Device.Model, en.Device.SerialNumber),
+	}, err)
+	return err
+}
+
+// replaceWithTombstone revokes the certificate of a device and records its tombstone
+func (id IdentityService) replaceWithTombstone(caller *domain.Caller, en *domain.Enrollment, revoked map[string]bool) error {
+	if len(en.Credentials.Certificate) > 0 {
+		serial, err := cert.SerialNumber(en.Credentials.Certificate)
+		if err != nil {
+			return err
+		}
+		if !revoked[serial] {
+			if err := id.recordRevocation(en, serial, domain.ReasonCessationOfOperation); err != nil {
+				return err
+			}
+			revoked[serial] = true
+		}
+	}
+
+	t := domain.Tombstone{
+		DeviceID:       en.ID,
+		OrganizationID: en.Organization.ID,
+		Brand:          en.Device.Brand,
+		Model:          en.Device.Model,
+		SerialNumber:   en.Device.SerialNumber,
+		Deleted:        time.Now(),
+		DeletedBy:      actor(caller),
+	}
+	err := id.DB.DeviceDelete(t)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return fmt.Errorf("%w: `%s` in organization `%s`", ErrDeviceNotFound, en.ID, en.Organization.ID)
+	}
+	return err
 }
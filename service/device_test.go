@@ -20,10 +20,13 @@
 package service
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore"
 	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
 )
 
 var _ = func() bool {
@@ -33,7 +36,7 @@ var _ = func() bool {
 
 func TestIdentityService_DeviceList(t *testing.T) {
 	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
-	db := memory.NewStore()
+	db := memory.NewStore(memory.WithFixtures())
 	type args struct {
 		orgID string
 	}
@@ -48,14 +51,14 @@ func TestIdentityService_DeviceList(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			id := NewIdentityService(settings, db)
-			got, err := id.DeviceList(tt.args.orgID)
+			id := NewIdentityService(settings, db, nil)
+			got, err := id.DeviceList(datastore.DeviceQuery{OrganizationID: tt.args.orgID})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("IdentityService.DeviceList() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if len(got) != tt.want {
-				t.Errorf("IdentityService.DeviceList() = %v, want %v", len(got), tt.want)
+			if len(got.Devices) != tt.want || got.Total != tt.want {
+				t.Errorf("IdentityService.DeviceList() = %v, want %v", len(got.Devices), tt.want)
 			}
 		})
 	}
@@ -63,7 +66,7 @@ func TestIdentityService_DeviceList(t *testing.T) {
 
 func TestIdentityService_DeviceGet(t *testing.T) {
 	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
-	db := memory.NewStore()
+	db := memory.NewStore(memory.WithFixtures())
 	type args struct {
 		orgID    string
 		deviceID string
@@ -74,15 +77,23 @@ func TestIdentityService_DeviceGet(t *testing.T) {
 		wantErr bool
 	}{
 		{"valid", args{"abc", "a111"}, false},
+		{"other-org", args{"def", "a111"}, true},
+		{"invalid-device", args{"abc", "invalid"}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			id := NewIdentityService(settings, db)
+			id := NewIdentityService(settings, db, nil)
 			got, err := id.DeviceGet(tt.args.orgID, tt.args.deviceID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("IdentityService.DeviceGet() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrDeviceNotFound) {
+					t.Errorf("IdentityService.DeviceGet() error = %v, want ErrDeviceNotFound", err)
+				}
+				return
+			}
 			if got.ID != tt.args.deviceID {
 				t.Errorf("IdentityService.DeviceGet() = %v, want %v", got.ID, tt.args.deviceID)
 			}
@@ -104,6 +115,7 @@ func TestIdentityService_DeviceUpdate(t *testing.T) {
 	}{
 		{"valid", args{"abc", "a111", &DeviceUpdateRequest{Status: 3, DeviceData: "abc"}}, false},
 		{"invalid-device", args{"abc", "invalid", &DeviceUpdateRequest{Status: 3, DeviceData: "abc"}}, true},
+		{"invalid-other-org", args{"def", "c333", &DeviceUpdateRequest{Status: 3, DeviceData: "abc"}}, true},
 		{"invalid-enrolled", args{"abc", "a111", &DeviceUpdateRequest{Status: 2, DeviceData: "abc"}}, true},
 		{"valid-waiting-disabled", args{"abc", "c333", &DeviceUpdateRequest{Status: 3, DeviceData: "abc"}}, false},
 		{"valid-waiting-unchanged", args{"abc", "c333", &DeviceUpdateRequest{Status: 1, DeviceData: "abc"}}, false},
@@ -114,11 +126,121 @@ func TestIdentityService_DeviceUpdate(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			db := memory.NewStore()
-			id := NewIdentityService(settings, db)
-			if err := id.DeviceUpdate(tt.args.orgID, tt.args.deviceID, tt.args.req); (err != nil) != tt.wantErr {
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(settings, db, nil)
+			if err := id.DeviceUpdate(nil, tt.args.orgID, tt.args.deviceID, tt.args.req); (err != nil) != tt.wantErr {
 				t.Errorf("IdentityService.DeviceUpdate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestIdentityService_DeviceHistory(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	tests := []struct {
+		name     string
+		orgID    string
+		deviceID string
+		want     []domain.Status
+		wantErr  error
+	}{
+		{"valid", "abc", "b222", []domain.Status{domain.StatusDisabled, domain.StatusWaiting}, nil},
+		{"unchanged", "abc", "c333", []domain.Status{}, nil},
+		{"invalid-device", "abc", "invalid", nil, ErrDeviceNotFound},
+		{"invalid-other-org", "def", "b222", nil, ErrDeviceNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(settings, db, nil)
+			for _, status := range tt.want {
+				if err := id.DeviceUpdate(nil, tt.orgID, tt.deviceID, &DeviceUpdateRequest{Status: int(status)}); err != nil {
+					t.Fatalf("IdentityService.DeviceUpdate() error = %v", err)
+				}
+			}
+
+			got, err := id.DeviceHistory(tt.orgID, tt.deviceID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IdentityService.DeviceHistory() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("IdentityService.DeviceHistory() = %v, want %v changes", got, len(tt.want))
+			}
+			for i, status := range tt.want {
+				if got[i].To != status {
+					t.Errorf("IdentityService.DeviceHistory() change %d = %v, want %v", i, got[i].To, status)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityService_DeviceDelete(t *testing.T) {
+	caller := &domain.Caller{Subject: "admin-test", Role: domain.RoleSuperuser}
+	tests := []struct {
+		name     string
+		orgID    string
+		deviceID string
+		revoked  bool
+		wantErr  error
+	}{
+		{"valid", "", "", false, nil},
+		{"already-revoked", "", "", true, nil},
+		{"other-org", "abc", "", false, ErrDeviceNotFound},
+		{"unknown", "", "unknown", false, ErrDeviceNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, db, deviceID := registeredDevice(t, domain.KeyModeServer, domain.StatusEnrolled)
+			orgID := db.Orgs[len(db.Orgs)-1].ID
+			if len(tt.orgID) > 0 {
+				orgID = tt.orgID
+			}
+			if len(tt.deviceID) > 0 {
+				deviceID = tt.deviceID
+			}
+			if tt.revoked {
+				dev, _ := db.DeviceGetByID(deviceID)
+				if err := id.revokeCertificate(dev, domain.ReasonKeyCompromise); err != nil {
+					t.Fatalf("IdentityService.revokeCertificate() error = %v", err)
+				}
+			}
+
+			err := id.DeviceDelete(caller, orgID, deviceID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IdentityService.DeviceDelete() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(db.Revocations) > 0 || len(db.Tombstones) > 0 {
+					t.Error("IdentityService.DeviceDelete() = device changed for a failed deletion")
+				}
+				return
+			}
+
+			// The certificate is revoked once, and the device is replaced by its tombstone
+			if len(db.Revocations) != 1 {
+				t.Errorf("IdentityService.DeviceDelete() revoked = %v, want 1", len(db.Revocations))
+			}
+			if _, err := db.DeviceGetByID(deviceID); err == nil {
+				t.Error("IdentityService.DeviceDelete() = device not deleted")
+			}
+			tombstone, err := db.TombstoneGet("example", "drone-2000", "DR2000E555")
+			if err != nil || tombstone.DeviceID != deviceID || tombstone.DeletedBy != caller.Subject {
+				t.Errorf("IdentityService.DeviceDelete() tombstone = %v, %v", tombstone, err)
+			}
+
+			// The device is only registered again deliberately
+			req := &RegisterDeviceRequest{OrganizationID: orgID, Brand: "example", Model: "drone-2000", SerialNumber: "DR2000E555"}
+			if _, err := id.RegisterDevice(nil, req); err == nil {
+				t.Error("IdentityService.RegisterDevice() expected error for a deleted device")
+			}
+			req.Reregister = true
+			if _, err := id.RegisterDevice(nil, req); err != nil {
+				t.Errorf("IdentityService.RegisterDevice() error = %v", err)
+			}
+		})
+	}
+}
@@ -20,24 +20,122 @@
 package factory
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+
 	"github.com/canonical/iot-identity/config"
 	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/encrypted"
 	"github.com/canonical/iot-identity/datastore/memory"
 	"github.com/canonical/iot-identity/datastore/postgres"
+	"github.com/canonical/iot-identity/datastore/sqlite"
+	"github.com/canonical/iot-identity/service/broker"
+	"github.com/canonical/iot-identity/service/broker/dynsec"
+	"github.com/canonical/iot-identity/service/outbox"
+	"github.com/canonical/iot-identity/service/webhook"
 )
 
-// CreateDataStore is the factory method to create a data store
+// CreateDataStore is the factory method to create a data store, which encrypts the
+// private keys that it stores with the key secret
 func CreateDataStore(settings *config.Settings) (datastore.DataStore, error) {
 	var db datastore.DataStore
 	switch settings.Driver {
 	case "memory":
-		db = memory.NewStore()
+		if len(settings.DataSource) == 0 {
+			db = memory.NewStore()
+			break
+		}
+		store, err := memory.OpenStore(settings.DataSource)
+		if err != nil {
+			return nil, err
+		}
+		db = store
 	case "postgres":
 		db = postgres.OpenStore(settings.Driver, settings.DataSource)
+	case "sqlite":
+		store, err := sqlite.OpenStore(settings.DataSource)
+		if err != nil {
+			return nil, err
+		}
+		db = store
 	default:
 		return nil, fmt.Errorf("unknown data store driver: %v", settings.Driver)
 	}
 
-	return db, nil
+	return encrypted.NewStore(db, settings.KeySecret, settings.OldSecrets...)
+}
+
+// FlushDataStore writes the changes that the data store holds back, before the process
+// exits. Only a persisted memory store writes its changes after a delay
+func FlushDataStore(db datastore.DataStore) error {
+	if store, ok := db.(*encrypted.Store); ok {
+		db = store.DataStore
+	}
+	if store, ok := db.(*memory.Store); ok {
+		return store.Flush()
+	}
+	return nil
+}
+
+// CreateProvisioner is the factory method to create the provisioner of device clients in the
+// MQTT broker of the service. No provisioner is created when the dynamic security plugin is
+// not configured
+func CreateProvisioner(settings *config.Settings) (broker.Provisioner, error) {
+	if len(settings.DynSecURL) == 0 {
+		return nil, nil
+	}
+
+	var tlsConfig *tls.Config
+	if len(settings.DynSecCA) > 0 {
+		data, err := ioutil.ReadFile(settings.DynSecCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no CA certificates found in `%s`", settings.DynSecCA)
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return dynsec.NewProvisioner(settings.DynSecURL, settings.DynSecRole, tlsConfig), nil
+}
+
+// CreateDispatcher is the factory method to create the dispatcher of the outbox events of the
+// data store, which queues them for the webhooks of the organizations, delivers them to the
+// sinks of the service, and to the MQTT broker when the provisioner is configured. The sinks
+// that only write to the data store come first to not be held back by the broker
+func CreateDispatcher(settings *config.Settings, db datastore.DataStore, sinks ...outbox.Sink) (*outbox.Dispatcher, error) {
+	d := outbox.NewDispatcher(db, append([]outbox.Sink{webhook.Sink{Store: db}}, sinks...)...)
+	if settings.OutboxPoll > 0 {
+		d.Interval = settings.OutboxPoll
+	}
+	if settings.OutboxTries > 0 {
+		d.MaxAttempts = settings.OutboxTries
+	}
+
+	p, err := CreateProvisioner(settings)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		d.Sinks = append(d.Sinks, outbox.BrokerSink{Provisioner: p, Host: settings.MQTTUrl})
+	}
+	return d, nil
+}
+
+// CreateWebhookDispatcher is the factory method to create the dispatcher that posts the
+// queued deliveries to the webhooks, with the delivery settings of the outbox
+func CreateWebhookDispatcher(settings *config.Settings, db datastore.DataStore) *webhook.Dispatcher {
+	d := webhook.NewDispatcher(db)
+	d.Client = webhook.NewClient(settings.WebhookAllowPrivate)
+	if settings.OutboxPoll > 0 {
+		d.Interval = settings.OutboxPoll
+	}
+	if settings.OutboxTries > 0 {
+		d.MaxAttempts = settings.OutboxTries
+	}
+	return d
 }
@@ -0,0 +1,216 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// DefaultWSSPort is the port of a websockets broker that is configured without one
+const DefaultWSSPort = "443"
+
+// validateBroker checks the MQTT broker of an organization. An empty broker uses the broker
+// of the service, but the other fields need the host of the broker
+func validateBroker(broker *domain.Broker) error {
+	if len(broker.Host) == 0 {
+		if len(broker.Port) > 0 || len(broker.Protocol) > 0 || len(broker.CABundle) > 0 {
+			return fmt.Errorf("the broker host is required to set the port, protocol or CA bundle")
+		}
+	}
+	if strings.ContainsAny(broker.Host, ":/ ") {
+		return fmt.Errorf("the broker host `%s` must be a host name or address, without a scheme or port", broker.Host)
+	}
+
+	switch broker.Protocol {
+	case "", domain.ProtocolMQTTS, domain.ProtocolWSS:
+	default:
+		return fmt.Errorf("the broker protocol `%s` must be one of: %s, %s", broker.Protocol, domain.ProtocolMQTTS, domain.ProtocolWSS)
+	}
+
+	if len(broker.Port) > 0 {
+		if port, err := strconv.Atoi(broker.Port); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("the broker port `%s` must be from 1 to 65535", broker.Port)
+		}
+	}
+
+	if len(broker.CABundle) > 0 && !x509.NewCertPool().AppendCertsFromPEM(broker.CABundle) {
+		return fmt.Errorf("the broker CA bundle must hold PEM-encoded certificates")
+	}
+
+	known := aclValues(&domain.Organization{}, &domain.Enrollment{})
+	for _, p := range aclPlaceholder.FindAllString(broker.ClientIDFormat, -1) {
+		if _, ok := known[p]; !ok {
+			return fmt.Errorf("the client ID format `%s` has an unknown placeholder `%s`", broker.ClientIDFormat, p)
+		}
+	}
+	return nil
+}
+
+// brokerCredentials returns the MQTT broker details of the credentials of a device, from the
+// broker of its organization or the service. The client ID is rendered from the format of the
+// organization, or is the device ID
+func (id IdentityService) brokerCredentials(org *domain.Organization, dev *domain.Enrollment) (domain.Credentials, error) {
+	broker := org.Broker
+	creds := domain.Credentials{
+		MQTTURL:      broker.Host,
+		MQTTPort:     broker.Port,
+		MQTTProtocol: broker.Protocol,
+		MQTTCABundle: broker.CABundle,
+		ClientID:     dev.ID,
+	}
+	if len(creds.MQTTURL) == 0 {
+		creds.MQTTURL = id.Settings.MQTTUrl
+	}
+	if len(creds.MQTTProtocol) == 0 {
+		creds.MQTTProtocol = domain.ProtocolMQTTS
+	}
+	if len(creds.MQTTPort) == 0 {
+		creds.MQTTPort = id.Settings.MQTTPort
+		if creds.MQTTProtocol == domain.ProtocolWSS {
+			creds.MQTTPort = DefaultWSSPort
+		}
+	}
+
+	if len(broker.ClientIDFormat) > 0 {
+		values := aclValues(org, dev)
+		for _, p := range aclPlaceholder.FindAllString(broker.ClientIDFormat, -1) {
+			if len(values[p]) == 0 {
+				return creds, fmt.Errorf("the %s of the device cannot be used in the client ID format `%s`", strings.Trim(p, "{}"), broker.ClientIDFormat)
+			}
+		}
+		creds.ClientID = aclPlaceholder.ReplaceAllStringFunc(broker.ClientIDFormat, func(p string) string {
+			return values[p]
+		})
+	}
+	return creds, nil
+}
+
+// OrganizationUpdate updates the MQTT broker of an organization. The credentials of its
+// devices keep the previous broker until they are reissued
+func (id IdentityService) OrganizationUpdate(caller *domain.Caller, orgID string, req *OrganizationUpdateRequest) error {
+	err := id.organizationUpdate(orgID, req)
+	id.audit(caller, domain.AuditEvent{
+		Action:         domain.ActionOrganizationUpdate,
+		OrganizationID: orgID,
+		Details:        fmt.Sprintf("broker `%s:%s`", req.Broker.Host, req.Broker.Port),
+	}, err)
+	return err
+}
+
+// organizationUpdate validates the request and updates the organization
+func (id IdentityService) organizationUpdate(orgID string, req *OrganizationUpdateRequest) error {
+	if _, err := id.DB.OrganizationGet(orgID); err != nil {
+		return fmt.Errorf("%w: `%s`", ErrOrganizationNotFound, orgID)
+	}
+	if err := validateBroker(&req.Broker); err != nil {
+		return err
+	}
+	return id.DB.OrganizationUpdateBroker(orgID, req.Broker)
+}
+
+// OrganizationReissue queues the reissue of the MQTT broker details in the credentials of the
+// devices of an organization, after its broker has changed, returning the number of devices
+// queued. The outbox reissues each device on its own, so enrolled devices receive the new
+// details when they renew their certificates, and waiting devices when they enroll
+func (id IdentityService) OrganizationReissue(caller *domain.Caller, orgID string) (int, error) {
+	queued, err := id.organizationReissue(orgID)
+	id.audit(caller, domain.AuditEvent{
+		Action:         domain.ActionOrganizationReissue,
+		OrganizationID: orgID,
+		Details:        fmt.Sprintf("%d devices queued for reissue", queued),
+	}, err)
+	return queued, err
+}
+
+// organizationReissue queues a reissue event for each device, returning the number of devices
+func (id IdentityService) organizationReissue(orgID string) (int, error) {
+	if _, err := id.DB.OrganizationGet(orgID); err != nil {
+		return 0, fmt.Errorf("%w: `%s`", ErrOrganizationNotFound, orgID)
+	}
+
+	queued := 0
+	query := datastore.DeviceQuery{OrganizationID: orgID, Sort: datastore.SortSerial, Limit: datastore.MaxPageLimit}
+	for {
+		page, err := id.DB.DeviceListPage(query)
+		if err != nil {
+			return queued, err
+		}
+		for _, dev := range page.Devices {
+			if err := id.DB.OutboxNew(domain.EventDeviceReissue, dev.ID); err != nil {
+				return queued, fmt.Errorf("error queuing the reissue of device `%s`: %v", dev.ID, err)
+			}
+			queued++
+		}
+		if len(page.NextCursor) == 0 {
+			return queued, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// reissueDevice updates the broker details of a device from the broker of its organization.
+// A device that has since been deleted is not reissued
+func (id IdentityService) reissueDevice(deviceID string) error {
+	dev, err := id.DB.DeviceGetByID(deviceID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	org, err := id.DB.OrganizationGet(dev.Organization.ID)
+	if err != nil {
+		return err
+	}
+
+	creds, err := id.brokerCredentials(org, dev)
+	if err != nil {
+		return err
+	}
+	if err := id.DB.DeviceUpdateBroker(dev.ID, creds); err != nil {
+		return fmt.Errorf("error reissuing device `%s`: %v", dev.ID, err)
+	}
+	return nil
+}
+
+// ReissueSink reissues the broker details of the devices of the reissue events in the outbox
+type ReissueSink struct {
+	Identity IdentityService
+}
+
+// Name identifies the sink in the errors of failed deliveries
+func (s ReissueSink) Name() string {
+	return "reissue"
+}
+
+// Deliver reissues the device of a reissue event, and ignores the other events
+func (s ReissueSink) Deliver(event domain.OutboxEvent) error {
+	if event.Type != domain.EventDeviceReissue {
+		return nil
+	}
+	return s.Identity.reissueDevice(event.DeviceID)
+}
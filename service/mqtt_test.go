@@ -0,0 +1,197 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/outbox"
+)
+
+func TestValidateBroker(t *testing.T) {
+	ca, err := ioutil.ReadFile("../datastore/test_data/ca.crt")
+	if err != nil {
+		t.Fatalf("error reading the CA: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		broker  domain.Broker
+		wantErr bool
+	}{
+		{"default", domain.Broker{}, false},
+		{"default-client-id", domain.Broker{ClientIDFormat: "{orgid}-{serial}"}, false},
+		{"valid", domain.Broker{Host: "mqtt.example.com", Port: "8884", Protocol: domain.ProtocolMQTTS, CABundle: ca}, false},
+		{"valid-wss", domain.Broker{Host: "mqtt.example.com", Protocol: domain.ProtocolWSS}, false},
+		{"invalid-no-host", domain.Broker{Port: "8884"}, true},
+		{"invalid-host-scheme", domain.Broker{Host: "ssl://mqtt.example.com"}, true},
+		{"invalid-host-port", domain.Broker{Host: "mqtt.example.com:8883"}, true},
+		{"invalid-protocol", domain.Broker{Host: "mqtt.example.com", Protocol: "tcp"}, true},
+		{"invalid-port", domain.Broker{Host: "mqtt.example.com", Port: "mqtts"}, true},
+		{"invalid-port-range", domain.Broker{Host: "mqtt.example.com", Port: "65536"}, true},
+		{"invalid-ca", domain.Broker{Host: "mqtt.example.com", CABundle: []byte("not a certificate")}, true},
+		{"invalid-client-id", domain.Broker{ClientIDFormat: "{device}"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateBroker(&tt.broker); (err != nil) != tt.wantErr {
+				t.Errorf("validateBroker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBrokerCredentials(t *testing.T) {
+	settings := &config.Settings{MQTTUrl: "mqtt.service.com", MQTTPort: "8883"}
+	tests := []struct {
+		name    string
+		broker  domain.Broker
+		serial  string
+		want    domain.Credentials
+		wantErr bool
+	}{
+		{"default", domain.Broker{}, "DR1000A111", domain.Credentials{MQTTURL: "mqtt.service.com", MQTTPort: "8883", MQTTProtocol: domain.ProtocolMQTTS, ClientID: "a111"}, false},
+		{"organization", domain.Broker{Host: "mqtt.abc.com", Port: "8884", CABundle: []byte("ca")}, "DR1000A111", domain.Credentials{MQTTURL: "mqtt.abc.com", MQTTPort: "8884", MQTTProtocol: domain.ProtocolMQTTS, MQTTCABundle: []byte("ca"), ClientID: "a111"}, false},
+		{"organization-wss", domain.Broker{Host: "mqtt.abc.com", Protocol: domain.ProtocolWSS}, "DR1000A111", domain.Credentials{MQTTURL: "mqtt.abc.com", MQTTPort: DefaultWSSPort, MQTTProtocol: domain.ProtocolWSS, ClientID: "a111"}, false},
+		{"client-id", domain.Broker{ClientIDFormat: "{orgid}-{model}-{serial}"}, "DR1000A111", domain.Credentials{MQTTURL: "mqtt.service.com", MQTTPort: "8883", MQTTProtocol: domain.ProtocolMQTTS, ClientID: "abc-drone-1000-DR1000A111"}, false},
+		{"client-id-empty", domain.Broker{ClientIDFormat: "{serial}"}, "", domain.Credentials{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := NewIdentityService(settings, nil, nil)
+			org := &domain.Organization{ID: "abc", Broker: tt.broker}
+			dev := &domain.Enrollment{ID: "a111", Device: domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: tt.serial}}
+
+			got, err := id.brokerCredentials(org, dev)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IdentityService.brokerCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.MQTTURL != tt.want.MQTTURL || got.MQTTPort != tt.want.MQTTPort || got.MQTTProtocol != tt.want.MQTTProtocol ||
+				!bytes.Equal(got.MQTTCABundle, tt.want.MQTTCABundle) || got.ClientID != tt.want.ClientID {
+				t.Errorf("IdentityService.brokerCredentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OrganizationBroker(t *testing.T) {
+	id, db, deviceID := registeredDevice(t, domain.KeyModeServer, domain.StatusWaiting)
+	id.Settings.MQTTUrl, id.Settings.MQTTPort = "mqtt.service.com", "8883"
+	dev, _ := db.DeviceGetByID(deviceID)
+	orgID := dev.Organization.ID
+
+	// The organization's broker is used when the device enrolls
+	update := &OrganizationUpdateRequest{Broker: domain.Broker{Host: "mqtt.plc.com", Protocol: domain.ProtocolWSS, ClientIDFormat: "plc-{serial}"}}
+	if err := id.OrganizationUpdate(nil, orgID, update); err != nil {
+		t.Fatalf("IdentityService.OrganizationUpdate() error = %v", err)
+	}
+	en, err := id.enroll(&datastore.DeviceEnrollRequest{Brand: "example", Model: "drone-2000", SerialNumber: "DR2000E555", StoreID: "example-store", DeviceKey: "AAAAAAAA"}, nil)
+	if err != nil {
+		t.Fatalf("IdentityService.Enroll() error = %v", err)
+	}
+	if en.Credentials.MQTTURL != "mqtt.plc.com" || en.Credentials.MQTTPort != DefaultWSSPort || en.Credentials.ClientID != "plc-DR2000E555" {
+		t.Errorf("IdentityService.Enroll() MQTT = %v:%v/%v", en.Credentials.MQTTURL, en.Credentials.MQTTPort, en.Credentials.ClientID)
+	}
+
+	// The device keeps the broker until its credentials are reissued
+	update = &OrganizationUpdateRequest{Broker: domain.Broker{Host: "mqtt.plc.net", Port: "8884"}}
+	if err := id.OrganizationUpdate(nil, orgID, update); err != nil {
+		t.Fatalf("IdentityService.OrganizationUpdate() error = %v", err)
+	}
+	if dev, _ = db.DeviceGetByID(deviceID); dev.Credentials.MQTTURL != "mqtt.plc.com" {
+		t.Errorf("IdentityService.OrganizationUpdate() MQTT = %v, want the previous broker", dev.Credentials.MQTTURL)
+	}
+	queued, err := id.OrganizationReissue(nil, orgID)
+	if err != nil || queued != 1 {
+		t.Fatalf("IdentityService.OrganizationReissue() = %v, %v", queued, err)
+	}
+
+	// The device is reissued when the outbox delivers the event
+	if dev, _ = db.DeviceGetByID(deviceID); dev.Credentials.MQTTURL != "mqtt.plc.com" {
+		t.Errorf("IdentityService.OrganizationReissue() MQTT = %v, want the previous broker until the event is delivered", dev.Credentials.MQTTURL)
+	}
+	if _, err := outbox.NewDispatcher(db, ReissueSink{Identity: *id}).Dispatch(time.Now()); err != nil {
+		t.Fatalf("Dispatcher.Dispatch() error = %v", err)
+	}
+	dev, _ = db.DeviceGetByID(deviceID)
+	if dev.Credentials.MQTTURL != "mqtt.plc.net" || dev.Credentials.MQTTPort != "8884" || dev.Credentials.MQTTProtocol != domain.ProtocolMQTTS || dev.Credentials.ClientID != deviceID {
+		t.Errorf("IdentityService.OrganizationReissue() MQTT = %v:%v/%v/%v", dev.Credentials.MQTTURL, dev.Credentials.MQTTPort, dev.Credentials.MQTTProtocol, dev.Credentials.ClientID)
+	}
+	if len(dev.Credentials.Certificate) == 0 {
+		t.Error("IdentityService.OrganizationReissue() removed the certificate")
+	}
+
+	// The changes are audited
+	page, err := db.AuditList(datastore.AuditQuery{OrganizationID: orgID})
+	if err != nil {
+		t.Fatalf("AuditList() error = %v", err)
+	}
+	actions := map[string]int{}
+	for _, e := range page.Events {
+		actions[e.Action]++
+	}
+	if actions[domain.ActionOrganizationUpdate] != 2 || actions[domain.ActionOrganizationReissue] != 1 {
+		t.Errorf("IdentityService audit = %v", actions)
+	}
+
+	// Invalid updates are rejected
+	if err := id.OrganizationUpdate(nil, orgID, &OrganizationUpdateRequest{Broker: domain.Broker{Host: "mqtt.plc.net", Protocol: "tcp"}}); err == nil {
+		t.Error("IdentityService.OrganizationUpdate() expected error for an invalid protocol")
+	}
+	if err := id.OrganizationUpdate(nil, "invalid", update); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Errorf("IdentityService.OrganizationUpdate() error = %v, want %v", err, ErrOrganizationNotFound)
+	}
+	if _, err := id.OrganizationReissue(nil, "invalid"); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Errorf("IdentityService.OrganizationReissue() error = %v, want %v", err, ErrOrganizationNotFound)
+	}
+}
+
+func TestIdentityService_RegisterOrganizationBroker(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data", MQTTUrl: "mqtt.service.com", MQTTPort: "8883"}
+	id, db, _ := registeredDevice(t, domain.KeyModeServer, domain.StatusWaiting)
+	id.Settings = settings
+
+	if _, err := id.RegisterOrganization(nil, &RegisterOrganizationRequest{Name: "Invalid Broker", Broker: domain.Broker{Protocol: domain.ProtocolWSS}}); err == nil {
+		t.Error("IdentityService.RegisterOrganization() expected error for a broker without a host")
+	}
+
+	orgID, err := id.RegisterOrganization(nil, &RegisterOrganizationRequest{Name: "Example Broker", Broker: domain.Broker{Host: "mqtt.broker.com", ClientIDFormat: "{orgid}/{deviceid}"}})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterOrganization() error = %v", err)
+	}
+	deviceID, err := id.RegisterDevice(nil, &RegisterDeviceRequest{OrganizationID: orgID, Brand: "example", Model: "drone-3000", SerialNumber: "DR3000G777"})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterDevice() error = %v", err)
+	}
+	dev, _ := db.DeviceGetByID(deviceID)
+	if dev.Credentials.MQTTURL != "mqtt.broker.com" || dev.Credentials.MQTTPort != "8883" || dev.Credentials.ClientID != orgID+"/"+deviceID {
+		t.Errorf("IdentityService.RegisterDevice() MQTT = %v:%v/%v", dev.Credentials.MQTTURL, dev.Credentials.MQTTPort, dev.Credentials.ClientID)
+	}
+}
@@ -0,0 +1,231 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspSignerValidity is the minimum validity of a delegated OCSP signing certificate
+const ocspSignerValidity = 30 * 24 * time.Hour
+
+// ocspCacheSize is the maximum number of cached responses
+const ocspCacheSize = 10000
+
+// ocspCache holds the delegated OCSP signers of each CA and the signed responses
+type ocspCache struct {
+	sync.Mutex
+	signers   map[string]*cert.OCSPSigner
+	responses map[string]ocspCachedResponse
+}
+
+type ocspCachedResponse struct {
+	response []byte
+	expires  time.Time
+}
+
+func newOCSPCache() *ocspCache {
+	return &ocspCache{
+		signers:   map[string]*cert.OCSPSigner{},
+		responses: map[string]ocspCachedResponse{},
+	}
+}
+
+// reset removes the cached responses, as the status of a certificate has changed
+func (c *ocspCache) reset() {
+	c.Lock()
+	c.responses = map[string]ocspCachedResponse{}
+	c.Unlock()
+}
+
+// store caches a response. When the cache is full, the expired responses are removed,
+// and all of them are if it is still full
+func (c *ocspCache) store(key string, response ocspCachedResponse, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.responses) >= ocspCacheSize {
+		for k, r := range c.responses {
+			if !now.Before(r.expires) {
+				delete(c.responses, k)
+			}
+		}
+	}
+	if len(c.responses) >= ocspCacheSize {
+		c.responses = map[string]ocspCachedResponse{}
+	}
+	c.responses[key] = response
+}
+
+// OCSPResponse answers a DER-encoded OCSP request for a device certificate (RFC 6960)
+func (id IdentityService) OCSPResponse(request []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOCSPMalformed, err)
+	}
+
+	// Responses are cached for half their validity, so they are never close to expiry. They
+	// are looked up by the issuer hashes of the request before the CA is found. The serial
+	// number comes from the caller, so unknown certificates are not cached
+	key := fmt.Sprintf("%x/%x/%d/%s", req.IssuerNameHash, req.IssuerKeyHash, req.HashAlgorithm, req.SerialNumber)
+	now := time.Now()
+	id.ocsp.Lock()
+	cached, ok := id.ocsp.responses[key]
+	id.ocsp.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.response, nil
+	}
+
+	// Find the CA and the organizations whose devices it issues certificates for
+	issuer, orgs, err := id.ocspIssuer(req)
+	if err != nil {
+		return nil, err
+	}
+	issuerID := fmt.Sprintf("%x", sha256.Sum256(issuer.Raw))
+
+	template, err := id.ocspStatus(orgs, req.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	template.IssuerHash = req.HashAlgorithm
+	template.ThisUpdate = now
+	template.NextUpdate = now.Add(id.ocspValidity())
+
+	signer, err := id.ocspSigner(issuerID, &orgs[0])
+	if err != nil {
+		return nil, err
+	}
+	resp, err := signer.Sign(template)
+	if err != nil {
+		return nil, err
+	}
+
+	if template.Status != ocsp.Unknown {
+		id.ocsp.store(key, ocspCachedResponse{response: resp, expires: now.Add(id.ocspValidity() / 2)}, now)
+	}
+	return resp, nil
+}
+
+// ocspIssuer finds the CA of the request, and the organizations that it signs device certificates for.
+// Organizations without their own CA share the root CA
+func (id IdentityService) ocspIssuer(req *ocsp.Request) (*x509.Certificate, []domain.Organization, error) {
+	list, err := id.DB.OrganizationList()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var issuer *x509.Certificate
+	orgs := []domain.Organization{}
+	for _, o := range list {
+		ca, err := cert.IssuerCertificate(&o, id.Settings.RootCertsDir)
+		if err != nil || !cert.MatchesIssuer(req, ca) {
+			continue
+		}
+		issuer = ca
+		orgs = append(orgs, o)
+	}
+	if len(orgs) == 0 {
+		return nil, nil, fmt.Errorf("%w: the certificate issuer is not known", ErrOCSPUnauthorized)
+	}
+	return issuer, orgs, nil
+}
+
+// ocspStatus looks up the revocation record, or the device registration, of a certificate
+// of the organizations by its serial number
+func (id IdentityService) ocspStatus(orgs []domain.Organization, serialNumber *big.Int) (ocsp.Response, error) {
+	serial := serialNumber.String()
+	for _, o := range orgs {
+		r, err := id.DB.RevocationGet(o.ID, serial)
+		if err == nil {
+			return ocsp.Response{Status: ocsp.Revoked, SerialNumber: serialNumber, RevokedAt: r.Revoked, RevocationReason: int(r.Reason)}, nil
+		}
+		if !errors.Is(err, datastore.ErrNotFound) {
+			return ocsp.Response{}, err
+		}
+	}
+
+	unknown := ocsp.Response{Status: ocsp.Unknown, SerialNumber: serialNumber}
+	d, err := id.DB.DeviceGetByCertificate(serial)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return unknown, nil
+	}
+	if err != nil {
+		return ocsp.Response{}, err
+	}
+	for _, o := range orgs {
+		if o.ID != d.Organization.ID {
+			continue
+		}
+
+		// A device that was disabled before revocations were recorded
+		if d.Status == domain.StatusDisabled {
+			return ocsp.Response{Status: ocsp.Revoked, SerialNumber: serialNumber, RevokedAt: time.Now(), RevocationReason: int(domain.ReasonCessationOfOperation)}, nil
+		}
+		return ocsp.Response{Status: ocsp.Good, SerialNumber: serialNumber}, nil
+	}
+	return unknown, nil
+}
+
+// ocspSigner fetches the delegated signer for a CA, creating a new signing certificate
+// when the current one would expire before the response
+func (id IdentityService) ocspSigner(issuerID string, org *domain.Organization) (*cert.OCSPSigner, error) {
+	id.ocsp.Lock()
+	defer id.ocsp.Unlock()
+
+	signer, ok := id.ocsp.signers[issuerID]
+	if ok && time.Now().Add(id.ocspValidity()).Before(signer.Certificate.NotAfter) {
+		return signer, nil
+	}
+
+	validity := ocspSignerValidity
+	if 2*id.ocspValidity() > validity {
+		validity = 2 * id.ocspValidity()
+	}
+	// The organization list does not include the CA key
+	org, err := id.DB.OrganizationGet(org.ID)
+	if err != nil {
+		return nil, err
+	}
+	signer, err = cert.NewOCSPSigner(org, id.Settings.RootCertsDir, validity)
+	if err != nil {
+		return nil, err
+	}
+	id.ocsp.signers[issuerID] = signer
+	return signer, nil
+}
+
+func (id IdentityService) ocspValidity() time.Duration {
+	if id.Settings.OCSPValidity <= 0 {
+		return config.DefaultOCSPValidity
+	}
+	return id.Settings.OCSPValidity
+}
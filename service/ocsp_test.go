@@ -0,0 +1,198 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+	"golang.org/x/crypto/ocsp"
+)
+
+func parseCert(t *testing.T, certPEM []byte) *x509.Certificate {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatalf("error decoding certificate PEM")
+	}
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return c
+}
+
+func TestIdentityService_OCSPResponse(t *testing.T) {
+	id, db, deviceID := registeredDevice(t, domain.KeyModeServer, domain.StatusEnrolled)
+	en, _ := db.DeviceGetByID(deviceID)
+	org, _ := db.OrganizationGet(en.Organization.ID)
+	issuer := parseCert(t, org.RootCert)
+	device := parseCert(t, en.Credentials.Certificate)
+
+	// A device of an organization without its own CA, signed by the root CA
+	rootPEM, _ := ioutil.ReadFile("../datastore/test_data/ca.crt")
+	root := parseCert(t, rootPEM)
+	_, legacyPEM, _ := cert.CreateClientCert(&db.Orgs[0], "../datastore/test_data", "b222", "")
+	if err := db.DeviceRenew("b222", legacyPEM, nil); err != nil {
+		t.Fatalf("Store.DeviceRenew() error = %v", err)
+	}
+	legacy := parseCert(t, legacyPEM)
+
+	// Certificates that the service has no record of
+	_, unknownPEM, _ := cert.CreateClientCert(org, "../datastore/test_data", "unknown", "")
+	unknown := parseCert(t, unknownPEM)
+	otherKey, otherPEM, _ := cert.CreateOrganizationCert("../datastore/test_data", "Other PLC", "")
+	other := &domain.Organization{Name: "Other PLC", RootCert: otherPEM, RootKey: otherKey}
+	_, foreignPEM, _ := cert.CreateClientCert(other, "../datastore/test_data", "foreign", "")
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		issuer  *x509.Certificate
+		disable bool
+		status  int
+		wantErr error
+	}{
+		{"good", device, issuer, false, ocsp.Good, nil},
+		{"legacy-good", legacy, root, false, ocsp.Good, nil},
+		{"unknown", unknown, issuer, false, ocsp.Unknown, nil},
+		{"revoked", device, issuer, true, ocsp.Revoked, nil},
+		{"unknown-issuer", parseCert(t, foreignPEM), parseCert(t, otherPEM), false, 0, ErrOCSPUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.disable {
+				if err := id.DeviceUpdate(nil, org.ID, deviceID, &DeviceUpdateRequest{Status: int(domain.StatusDisabled)}); err != nil {
+					t.Fatalf("IdentityService.DeviceUpdate() error = %v", err)
+				}
+			}
+
+			req, err := ocsp.CreateRequest(tt.cert, tt.issuer, nil)
+			if err != nil {
+				t.Fatalf("error creating OCSP request: %v", err)
+			}
+			got, err := id.OCSPResponse(req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("IdentityService.OCSPResponse() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("IdentityService.OCSPResponse() error = %v", err)
+			}
+
+			// The response is signed by a delegated responder of the issuer
+			resp, err := ocsp.ParseResponseForCert(got, tt.cert, tt.issuer)
+			if err != nil {
+				t.Fatalf("IdentityService.OCSPResponse() parse error = %v", err)
+			}
+			if resp.Status != tt.status {
+				t.Errorf("IdentityService.OCSPResponse() status = %v, want %v", resp.Status, tt.status)
+			}
+			if resp.Certificate == nil || resp.Certificate.ExtKeyUsage[0] != x509.ExtKeyUsageOCSPSigning {
+				t.Error("IdentityService.OCSPResponse() = not signed by a delegated OCSP responder")
+			}
+
+			// The response is cached, unless the certificate is unknown
+			cached, _ := id.OCSPResponse(req)
+			if bytes.Equal(got, cached) != (tt.status != ocsp.Unknown) {
+				t.Errorf("IdentityService.OCSPResponse() cached = %v, want %v", bytes.Equal(got, cached), tt.status != ocsp.Unknown)
+			}
+		})
+	}
+}
+
+// listCounter counts the organization lists of the data store
+type listCounter struct {
+	datastore.DataStore
+	lists int
+}
+
+func (s *listCounter) OrganizationList() ([]domain.Organization, error) {
+	s.lists++
+	return s.DataStore.OrganizationList()
+}
+
+func TestIdentityService_OCSPResponseCached(t *testing.T) {
+	id, db, deviceID := registeredDevice(t, domain.KeyModeServer, domain.StatusEnrolled)
+	counter := &listCounter{DataStore: db}
+	id.DB = counter
+	en, _ := db.DeviceGetByID(deviceID)
+	org, _ := db.OrganizationGet(en.Organization.ID)
+	req, err := ocsp.CreateRequest(parseCert(t, en.Credentials.Certificate), parseCert(t, org.RootCert), nil)
+	if err != nil {
+		t.Fatalf("error creating OCSP request: %v", err)
+	}
+
+	// The cached response is found without looking up the CA of the request
+	for i := 0; i < 3; i++ {
+		if _, err := id.OCSPResponse(req); err != nil {
+			t.Fatalf("IdentityService.OCSPResponse() error = %v", err)
+		}
+	}
+	if counter.lists != 1 {
+		t.Errorf("IdentityService.OCSPResponse() listed the organizations %d times, want 1", counter.lists)
+	}
+}
+
+func TestIdentityService_OCSPResponseMalformed(t *testing.T) {
+	id, _, _ := registeredDevice(t, domain.KeyModeServer, domain.StatusEnrolled)
+	if _, err := id.OCSPResponse([]byte("invalid")); !errors.Is(err, ErrOCSPMalformed) {
+		t.Errorf("IdentityService.OCSPResponse() error = %v, want malformed", err)
+	}
+}
+
+func TestOCSPCache_Store(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		expired int
+		want    int
+	}{
+		{"expired-removed", ocspCacheSize / 2, ocspCacheSize/2 + 1},
+		{"full", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newOCSPCache()
+			for i := 0; i < ocspCacheSize; i++ {
+				expires := now.Add(time.Hour)
+				if i < tt.expired {
+					expires = now.Add(-time.Hour)
+				}
+				c.responses[fmt.Sprint(i)] = ocspCachedResponse{expires: expires}
+			}
+
+			c.store("new", ocspCachedResponse{expires: now.Add(time.Hour)}, now)
+			if _, ok := c.responses["new"]; !ok || len(c.responses) != tt.want {
+				t.Errorf("ocspCache.store() count = %v, want %v", len(c.responses), tt.want)
+			}
+		})
+	}
+}
@@ -21,42 +21,160 @@ package service
 
 import (
 	"fmt"
+	"log"
+	"strings"
+
 	"github.com/canonical/iot-identity/datastore"
 	"github.com/canonical/iot-identity/domain"
 	"github.com/canonical/iot-identity/service/cert"
 )
 
 // RegisterOrganization registers a new organization with the service
-func (id IdentityService) RegisterOrganization(req *RegisterOrganizationRequest) (string, error) {
+func (id IdentityService) RegisterOrganization(caller *domain.Caller, req *RegisterOrganizationRequest) (string, error) {
+	orgID, err := id.registerOrganization(req)
+	id.audit(caller, domain.AuditEvent{
+		Action:         domain.ActionOrganizationRegister,
+		OrganizationID: orgID,
+		Details:        fmt.Sprintf("organization `%s`", req.Name),
+	}, err)
+	return orgID, err
+}
+
+// registerOrganization validates the request and creates the organization with its CA
+func (id IdentityService) registerOrganization(req *RegisterOrganizationRequest) (string, error) {
 	// Validate fields
 	if err := validateNotEmpty("organization name", req.Name); err != nil {
 		return "", err
 	}
 
+	// Default to the service generating the device keys
+	keyMode := domain.KeyMode(req.KeyMode)
+	switch keyMode {
+	case 0:
+		keyMode = domain.KeyModeServer
+	case domain.KeyModeServer, domain.KeyModeCSR:
+	default:
+		return "", fmt.Errorf("the key mode `%d` is invalid", req.KeyMode)
+	}
+
+	// Device certificates cannot outlive the organization's CA
+	if req.CertValidityDays < 0 || req.CertValidityDays > cert.DefaultValidityDays {
+		return "", fmt.Errorf("the certificate validity must be from 1 to %d days, or 0 for the default", cert.DefaultValidityDays)
+	}
+
+	// An empty key type uses the service default
+	if req.KeyType != "" && !cert.ValidKeyType(req.KeyType) {
+		return "", fmt.Errorf("the key type must be one of: %s", strings.Join(cert.KeyTypes, ", "))
+	}
+
+	// Without templates, the devices get the default topic ACLs
+	templates := req.ACLTemplates
+	if len(templates) == 0 {
+		templates = DefaultACLTemplates
+	}
+	if err := validateACLTemplates(templates); err != nil {
+		return "", err
+	}
+	if err := validateBroker(&req.Broker); err != nil {
+		return "", err
+	}
+
 	// Check that the organization isn't registered i.e. no error with the 'get'
 	if _, err := id.DB.OrganizationGetByName(req.Name); err == nil {
 		return "", fmt.Errorf("the organization '%s' has already been registered", req.Name)
 	}
 
 	// Create server certificate for the organization
-	serverPEM, serverCA, err := cert.CreateOrganizationCert(id.Settings.RootCertsDir, req.Name)
+	serverPEM, serverCA, err := cert.CreateOrganizationCert(id.Settings.RootCertsDir, req.Name, id.keyType(req.KeyType))
 	if err != nil {
 		return "", err
 	}
 
 	// Create registration
 	o := datastore.OrganizationNewRequest{
-		Name:        req.Name,
-		CountryName: req.CountryName,
-		ServerKey:   serverPEM,
-		ServerCert:  serverCA,
+		Name:             req.Name,
+		CountryName:      req.CountryName,
+		ServerKey:        serverPEM,
+		ServerCert:       serverCA,
+		KeyMode:          keyMode,
+		CertValidityDays: req.CertValidityDays,
+		KeyType:          req.KeyType,
+		ACLTemplates:     templates,
+		Broker:           req.Broker,
 	}
 
 	// Register the organization
 	return id.DB.OrganizationNew(o)
 }
 
+// keyType resolves the key algorithm from the organization's override or the service default
+func (id IdentityService) keyType(orgKeyType string) string {
+	if orgKeyType != "" {
+		return orgKeyType
+	}
+	if id.Settings.KeyType != "" {
+		return id.Settings.KeyType
+	}
+	return cert.DefaultKeyType
+}
+
 // OrganizationList fetches the existing organizations
 func (id IdentityService) OrganizationList() ([]domain.Organization, error) {
 	return id.DB.OrganizationList()
 }
+
+// OrganizationDelete deletes an organization and its API tokens. An organization with devices
+// is only deleted when it is forced, which deletes the devices and revokes their certificates
+func (id IdentityService) OrganizationDelete(caller *domain.Caller, orgID string, force bool) error {
+	deleted, err := id.organizationDelete(caller, orgID, force)
+	id.audit(caller, domain.AuditEvent{
+		Action:         domain.ActionOrganizationDelete,
+		OrganizationID: orgID,
+		Details:        fmt.Sprintf("force=%v, %d devices deleted", force, deleted),
+	}, err)
+	return err
+}
+
+// organizationDelete deletes an organization, returning the number of devices that were deleted
+func (id IdentityService) organizationDelete(caller *domain.Caller, orgID string, force bool) (int, error) {
+	if _, err := id.DB.OrganizationGet(orgID); err != nil {
+		return 0, fmt.Errorf("%w: `%s`", ErrOrganizationNotFound, orgID)
+	}
+
+	query := datastore.DeviceQuery{OrganizationID: orgID, Limit: datastore.MaxPageLimit}
+	page, err := id.DB.DeviceListPage(query)
+	if err != nil {
+		return 0, err
+	}
+	if page.Total > 0 && !force {
+		return 0, fmt.Errorf("%w: `%s` has %d devices", ErrOrganizationHasDevices, orgID, page.Total)
+	}
+
+	revoked, err := id.revokedSerials(orgID)
+	if err != nil {
+		return 0, err
+	}
+
+	// The deleted devices leave the listing, so the first page always holds the next ones
+	deleted := 0
+	for len(page.Devices) > 0 {
+		for i := range page.Devices {
+			if err := id.deleteDevice(caller, &page.Devices[i], revoked); err != nil {
+				return deleted, fmt.Errorf("error deleting device `%s`: %v", page.Devices[i].ID, err)
+			}
+			deleted++
+		}
+		if page, err = id.DB.DeviceListPage(query); err != nil {
+			return deleted, err
+		}
+	}
+
+	// Publish the revocations before the organization's CA is removed
+	if deleted > 0 {
+		if _, err := id.RefreshCRL(orgID); err != nil {
+			log.Printf("Error creating revocation list for organization `%s`: %v\n", orgID, err)
+		}
+	}
+
+	return deleted, id.DB.OrganizationDelete(orgID)
+}
@@ -20,8 +20,10 @@
 package service
 
 import (
+	"errors"
 	"github.com/canonical/iot-identity/config"
 	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
 	"testing"
 )
 
@@ -38,7 +40,7 @@ func TestIdentityService_OrganizationList(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			id := NewIdentityService(settings, memory.NewStore())
+			id := NewIdentityService(settings, memory.NewStore(memory.WithFixtures()), nil)
 			got, err := id.OrganizationList()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("IdentityService.OrganizationList() error = %v, wantErr %v", err, tt.wantErr)
@@ -50,3 +52,63 @@ func TestIdentityService_OrganizationList(t *testing.T) {
 		})
 	}
 }
+
+func TestIdentityService_OrganizationDelete(t *testing.T) {
+	caller := &domain.Caller{Subject: "admin-test", Role: domain.RoleSuperuser}
+	tests := []struct {
+		name    string
+		orgID   string
+		devices bool
+		force   bool
+		wantErr error
+	}{
+		{"empty", "", false, false, nil},
+		{"has-devices", "", true, false, ErrOrganizationHasDevices},
+		{"forced", "", true, true, nil},
+		{"unknown", "unknown", false, true, ErrOrganizationNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, db, deviceID := registeredDevice(t, domain.KeyModeServer, domain.StatusEnrolled)
+			orgID := db.Orgs[len(db.Orgs)-1].ID
+			if !tt.devices {
+				dev, _ := db.DeviceGetByID(deviceID)
+				if err := id.DeviceDelete(caller, orgID, dev.ID); err != nil {
+					t.Fatalf("IdentityService.DeviceDelete() error = %v", err)
+				}
+			}
+			if len(tt.orgID) > 0 {
+				orgID = tt.orgID
+			}
+			revoked := len(db.Revocations)
+
+			err := id.OrganizationDelete(caller, orgID, tt.force)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IdentityService.OrganizationDelete() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if _, err := db.OrganizationGet(db.Orgs[len(db.Orgs)-1].ID); err != nil {
+					t.Errorf("IdentityService.OrganizationDelete() = organization deleted for a failed deletion")
+				}
+				return
+			}
+
+			// The devices are deleted and their certificates revoked, but other organizations are not affected
+			if _, err := db.OrganizationGet(orgID); err == nil {
+				t.Error("IdentityService.OrganizationDelete() = organization not deleted")
+			}
+			if _, err := db.OrganizationGet("abc"); err != nil {
+				t.Errorf("IdentityService.OrganizationDelete() = other organization deleted: %v", err)
+			}
+			if _, err := db.DeviceGetByID(deviceID); err == nil {
+				t.Error("IdentityService.OrganizationDelete() = device not deleted")
+			}
+			if tt.force && len(db.Revocations) != revoked+1 {
+				t.Errorf("IdentityService.OrganizationDelete() revoked = %v, want %v", len(db.Revocations), revoked+1)
+			}
+			if len(db.Tombstones) != 1 {
+				t.Errorf("IdentityService.OrganizationDelete() tombstones = %v, want 1", len(db.Tombstones))
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// OutboxList fetches the events of the outbox, oldest first
+func (id IdentityService) OutboxList(query datastore.OutboxQuery) ([]domain.OutboxEvent, error) {
+	return id.DB.OutboxList(query)
+}
+
+// OutboxReplay queues a dead-lettered event of the outbox for delivery again, with its
+// attempts reset. The replay is recorded in the audit log
+func (id IdentityService) OutboxReplay(caller *domain.Caller, eventID string) (*domain.OutboxEvent, error) {
+	event, err := id.outboxReplay(eventID)
+
+	audit := domain.AuditEvent{Action: domain.ActionOutboxReplay, Details: eventID}
+	if event != nil {
+		audit.OrganizationID, audit.DeviceID, audit.Details = event.OrganizationID, event.DeviceID, fmt.Sprintf("%s %s", event.Type, eventID)
+	}
+	id.audit(caller, audit, err)
+	return event, err
+}
+
+func (id IdentityService) outboxReplay(eventID string) (*domain.OutboxEvent, error) {
+	event, err := id.DB.OutboxGet(eventID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %v", ErrEventNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if event.Status != domain.OutboxFailed {
+		return event, ErrEventNotFailed
+	}
+
+	event.Status, event.Attempts, event.NextAttempt, event.LastError = domain.OutboxPending, 0, time.Now(), ""
+	if err := id.DB.OutboxUpdate(*event); err != nil {
+		return event, err
+	}
+	return event, nil
+}
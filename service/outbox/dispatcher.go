@@ -0,0 +1,178 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package outbox delivers the events of the changes to devices, which the data store
+// records in its outbox, to the sinks that act on them, such as the MQTT broker
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// Default delivery settings
+const (
+	DefaultInterval    = 10 * time.Second
+	DefaultMaxAttempts = 10
+	DefaultBackoff     = 30 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultRetention   = 7 * 24 * time.Hour
+	DefaultLease       = 5 * time.Minute
+)
+
+// Sink acts on the events of the outbox. Delivery is at least once: an event is delivered
+// again when it, or another sink, fails, so sinks must be idempotent
+type Sink interface {
+	Name() string
+	Deliver(event domain.OutboxEvent) error
+}
+
+// Store is the part of the data store that holds the outbox
+type Store interface {
+	OutboxClaim(now time.Time, lease time.Duration, limit int) ([]domain.OutboxEvent, error)
+	OutboxUpdate(event domain.OutboxEvent) error
+	OutboxPrune(before time.Time) (int, error)
+}
+
+// Dispatcher delivers the pending events of the outbox to its sinks. The events of a
+// device are delivered in order, so a failed event holds back the later events of its
+// device until it is delivered or dead-lettered. Failed deliveries are retried with a
+// backoff that doubles from Backoff up to MaxBackoff, and the event is dead-lettered
+// after MaxAttempts. Delivered events are pruned after the Retention period. The dispatcher
+// claims the events that it delivers for the Lease period, so several dispatchers can share
+// the outbox, and the events of a dispatcher that stops are delivered once the lease ends
+type Dispatcher struct {
+	Store       Store
+	Sinks       []Sink
+	Interval    time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Retention   time.Duration
+	Lease       time.Duration
+}
+
+// NewDispatcher creates a dispatcher of the outbox with the default delivery settings
+func NewDispatcher(store Store, sinks ...Sink) *Dispatcher {
+	return &Dispatcher{
+		Store:       store,
+		Sinks:       sinks,
+		Interval:    DefaultInterval,
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		Retention:   DefaultRetention,
+		Lease:       DefaultLease,
+	}
+}
+
+// Run delivers the events of the outbox on the configured schedule. It does not return
+func (d *Dispatcher) Run() {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.Dispatch(time.Now()); err != nil {
+			log.Println("Error dispatching the outbox events:", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Dispatch delivers the pending events that are due, and prunes the delivered events that
+// are past the retention period. It returns the number of events that were delivered
+func (d *Dispatcher) Dispatch(now time.Time) (int, error) {
+	events, err := d.Store.OutboxClaim(now, d.Lease, datastore.MaxPageLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	// An event that is held back by a failure of its device is updated without a change,
+	// to end its claim
+	delivered := 0
+	blocked := map[string]bool{}
+	for _, e := range events {
+		if !blocked[e.DeviceID] {
+			if d.attempt(&e, now) {
+				delivered++
+			} else {
+				blocked[e.DeviceID] = e.Status == domain.OutboxPending
+			}
+		}
+		// Another dispatcher has claimed the event since its lease ended, and records its delivery
+		err := d.Store.OutboxUpdate(e)
+		if errors.Is(err, datastore.ErrLeaseLost) {
+			log.Printf("Error recording the delivery of outbox event `%s`: %v\n", e.ID, err)
+			continue
+		}
+		if err != nil {
+			return delivered, err
+		}
+	}
+
+	if d.Retention > 0 {
+		if _, err := d.Store.OutboxPrune(now.Add(-d.Retention)); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// attempt delivers an event to every sink, updating its delivery state
+func (d *Dispatcher) attempt(e *domain.OutboxEvent, now time.Time) bool {
+	e.Attempts++
+	for _, s := range d.Sinks {
+		if err := s.Deliver(*e); err != nil {
+			e.LastError = fmt.Sprintf("%s: %v", s.Name(), err)
+			if e.Attempts >= d.MaxAttempts {
+				log.Printf("Error delivering outbox event `%s` after %d attempts: %s\n", e.ID, e.Attempts, e.LastError)
+				e.Status = domain.OutboxFailed
+			} else {
+				e.NextAttempt = now.Add(d.backoff(e.Attempts))
+			}
+			return false
+		}
+	}
+
+	e.Status, e.Delivered, e.LastError = domain.OutboxDelivered, now, ""
+	return true
+}
+
+// backoff returns the delay before the next attempt after a number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	return RetryDelay(attempts, d.Backoff, d.MaxBackoff)
+}
+
+// RetryDelay returns the delay before the next attempt after a number of failed attempts,
+// which doubles from the backoff up to the maximum
+func RetryDelay(attempts int, backoff, maxBackoff time.Duration) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
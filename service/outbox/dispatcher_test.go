@@ -0,0 +1,224 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// mockSink records the events that it is given, failing the events of the devices in fail
+type mockSink struct {
+	events []string
+	fail   map[string]bool
+}
+
+func (s *mockSink) Name() string { return "mock" }
+
+func (s *mockSink) Deliver(event domain.OutboxEvent) error {
+	s.events = append(s.events, event.ID)
+	if s.fail[event.DeviceID] {
+		return fmt.Errorf("MOCK error delivering `%s`", event.ID)
+	}
+	return nil
+}
+
+func pendingEvent(eventID, deviceID string, attempts int, due time.Time) domain.OutboxEvent {
+	return domain.OutboxEvent{ID: eventID, Type: domain.EventDeviceEnroll, DeviceID: deviceID, Status: domain.OutboxPending, Attempts: attempts, NextAttempt: due, Created: due}
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name          string
+		events        []domain.OutboxEvent
+		fail          map[string]bool
+		wantDelivered []string // events that the sink is given, in order
+		wantCount     int
+		wantStatus    []domain.OutboxStatus
+		wantAttempts  []int
+	}{
+		{"delivered", []domain.OutboxEvent{pendingEvent("e1", "a111", 0, now), pendingEvent("e2", "b222", 0, now), pendingEvent("e3", "a111", 0, now)},
+			nil, []string{"e1", "e2", "e3"}, 3,
+			[]domain.OutboxStatus{domain.OutboxDelivered, domain.OutboxDelivered, domain.OutboxDelivered}, []int{1, 1, 1}},
+		{"failed-holds-device", []domain.OutboxEvent{pendingEvent("e1", "a111", 0, now), pendingEvent("e2", "b222", 0, now), pendingEvent("e3", "a111", 0, now)},
+			map[string]bool{"a111": true}, []string{"e1", "e2"}, 1,
+			[]domain.OutboxStatus{domain.OutboxPending, domain.OutboxDelivered, domain.OutboxPending}, []int{1, 1, 0}},
+		{"not-due-holds-device", []domain.OutboxEvent{pendingEvent("e1", "a111", 1, now.Add(time.Minute)), pendingEvent("e2", "a111", 0, now), pendingEvent("e3", "b222", 0, now)},
+			nil, []string{"e3"}, 1,
+			[]domain.OutboxStatus{domain.OutboxPending, domain.OutboxPending, domain.OutboxDelivered}, []int{1, 0, 1}},
+		{"dead-lettered", []domain.OutboxEvent{pendingEvent("e1", "a111", 2, now), pendingEvent("e2", "a111", 0, now)},
+			map[string]bool{"a111": true}, []string{"e1", "e2"}, 0,
+			[]domain.OutboxStatus{domain.OutboxFailed, domain.OutboxPending}, []int{3, 1}},
+		{"skips-failed", []domain.OutboxEvent{{ID: "e1", DeviceID: "a111", Status: domain.OutboxFailed, Attempts: 3}, pendingEvent("e2", "a111", 0, now)},
+			nil, []string{"e2"}, 1,
+			[]domain.OutboxStatus{domain.OutboxFailed, domain.OutboxDelivered}, []int{3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore()
+			db.Outbox = append([]domain.OutboxEvent{}, tt.events...)
+			sink := &mockSink{fail: tt.fail}
+			d := NewDispatcher(db, sink)
+			d.MaxAttempts = 3
+
+			got, err := d.Dispatch(now)
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			if got != tt.wantCount {
+				t.Errorf("Dispatch() = %d, want %d", got, tt.wantCount)
+			}
+			if !reflect.DeepEqual(sink.events, tt.wantDelivered) {
+				t.Errorf("Dispatch() delivered = %v, want %v", sink.events, tt.wantDelivered)
+			}
+			for i, e := range db.Outbox {
+				if e.Status != tt.wantStatus[i] || e.Attempts != tt.wantAttempts[i] {
+					t.Errorf("Dispatch() event %s = %d/%d attempts, want %d/%d attempts", e.ID, e.Status, e.Attempts, tt.wantStatus[i], tt.wantAttempts[i])
+				}
+				switch {
+				case e.Status == domain.OutboxDelivered && (!e.Delivered.Equal(now) || len(e.LastError) > 0):
+					t.Errorf("Dispatch() event %s = %+v, want it delivered now", e.ID, e)
+				case e.Status != domain.OutboxDelivered && e.Attempts > tt.events[i].Attempts && len(e.LastError) == 0:
+					t.Errorf("Dispatch() event %s has no error for the failed attempt", e.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcher_DispatchRetry(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	db := memory.NewStore()
+	db.Outbox = []domain.OutboxEvent{pendingEvent("e1", "a111", 0, now)}
+	sink := &mockSink{fail: map[string]bool{"a111": true}}
+	d := NewDispatcher(db, sink)
+
+	if _, err := d.Dispatch(now); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if e := db.Outbox[0]; !e.NextAttempt.Equal(now.Add(d.Backoff)) || e.LastError != "mock: MOCK error delivering `e1`" {
+		t.Fatalf("Dispatch() event = %+v, want a retry after the backoff", e)
+	}
+
+	// The event is not attempted again until it is due
+	sink.fail = nil
+	if got, _ := d.Dispatch(now.Add(d.Backoff / 2)); got != 0 {
+		t.Errorf("Dispatch() = %d, want no delivery before the retry", got)
+	}
+	if got, _ := d.Dispatch(now.Add(d.Backoff)); got != 1 {
+		t.Errorf("Dispatch() = %d, want the retry delivered", got)
+	}
+
+	// The delivered event is pruned after the retention period
+	if _, err := d.Dispatch(now.Add(d.Backoff + d.Retention + time.Second)); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if len(db.Outbox) != 0 {
+		t.Errorf("Dispatch() outbox = %v, want the delivered event pruned", db.Outbox)
+	}
+}
+
+func TestDispatcher_DispatchClaimed(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	db := memory.NewStore()
+	db.Outbox = []domain.OutboxEvent{pendingEvent("e1", "a111", 0, now), pendingEvent("e2", "b222", 0, now)}
+	sink := &mockSink{}
+	d := NewDispatcher(db, sink)
+
+	// Another dispatcher has claimed the first event, which it has not delivered
+	claimed, err := db.OutboxClaim(now, d.Lease, 1)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("OutboxClaim() = %v, %v, want the first event", claimed, err)
+	}
+	if got, _ := d.Dispatch(now); got != 1 || !reflect.DeepEqual(sink.events, []string{"e2"}) {
+		t.Errorf("Dispatch() = %d/%v, want the unclaimed event delivered", got, sink.events)
+	}
+
+	// The event is delivered once the claim ends
+	if got, _ := d.Dispatch(now.Add(d.Lease - time.Second)); got != 0 {
+		t.Errorf("Dispatch() = %d, want no delivery while the event is claimed", got)
+	}
+	if got, _ := d.Dispatch(now.Add(d.Lease)); got != 1 || !reflect.DeepEqual(sink.events, []string{"e2", "e1"}) {
+		t.Errorf("Dispatch() = %d/%v, want the event delivered after the lease", got, sink.events)
+	}
+
+	// The other dispatcher cannot record the event once its lease has ended
+	if err := db.OutboxUpdate(claimed[0]); !errors.Is(err, datastore.ErrLeaseLost) {
+		t.Errorf("OutboxUpdate() error = %v, want ErrLeaseLost", err)
+	}
+}
+
+// claimSink claims the events again while they are delivered, as another dispatcher does
+// when the delivery outlasts the lease
+type claimSink struct {
+	db *memory.Store
+	at time.Time
+}
+
+func (s *claimSink) Name() string { return "claim" }
+
+func (s *claimSink) Deliver(event domain.OutboxEvent) error {
+	_, err := s.db.OutboxClaim(s.at, DefaultLease, datastore.MaxPageLimit)
+	return err
+}
+
+func TestDispatcher_DispatchLeaseLost(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	db := memory.NewStore()
+	db.Outbox = []domain.OutboxEvent{pendingEvent("e1", "a111", 0, now)}
+	d := NewDispatcher(db, &claimSink{db: db, at: now.Add(DefaultLease)})
+
+	// The delivery is left to the dispatcher that holds the claim
+	if _, err := d.Dispatch(now); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if e := db.Outbox[0]; e.Status != domain.OutboxPending || e.Attempts != 0 || len(e.LeaseID) == 0 {
+		t.Errorf("Dispatch() event = %+v, want it pending in the other claim", e)
+	}
+}
+
+func TestDispatcher_backoff(t *testing.T) {
+	d := &Dispatcher{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempts), func(t *testing.T) {
+			if got := d.backoff(tt.attempts); got != tt.want {
+				t.Errorf("backoff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package outbox
+
+import (
+	"log"
+
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/broker"
+)
+
+// BrokerSink provisions the clients of devices in the MQTT broker of the service, so that a
+// device can only connect while it is enrolled. Host is the broker of the device credentials
+// that the provisioner manages. The service does not have the admin credentials of the
+// brokers of organizations, so the clients of devices that use them are not created
+type BrokerSink struct {
+	Provisioner broker.Provisioner
+	Host        string
+}
+
+// Name identifies the sink in the errors of failed deliveries
+func (s BrokerSink) Name() string {
+	return "mqtt-broker"
+}
+
+// Deliver applies the change of a device to its client in the MQTT broker. A renewal keeps
+// the client, as it is bound to the device ID rather than the certificate. A device that
+// uses another broker is still disabled and removed, as it may have used the broker of the
+// service before its credentials were reissued
+func (s BrokerSink) Deliver(event domain.OutboxEvent) error {
+	switch event.Type {
+	case domain.EventDeviceEnroll:
+		if event.Device.Credentials.MQTTURL != s.Host {
+			log.Printf("Not provisioning device `%s` in the broker `%s`, which is not the broker of the service\n", event.DeviceID, event.Device.Credentials.MQTTURL)
+			return nil
+		}
+		return s.Provisioner.Register(event.Device)
+	case domain.EventDeviceDisable:
+		return s.Provisioner.Disable(event.Device)
+	case domain.EventDeviceDelete:
+		return s.Provisioner.Remove(event.Device)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package outbox
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// mockProvisioner records the calls to the MQTT broker, failing the action in fail
+type mockProvisioner struct {
+	calls []string
+	fail  string
+}
+
+func (p *mockProvisioner) call(action string, en domain.Enrollment) error {
+	p.calls = append(p.calls, action+" "+en.ID)
+	if action == p.fail {
+		return fmt.Errorf("MOCK error %s `%s`", action, en.ID)
+	}
+	return nil
+}
+
+func (p *mockProvisioner) Register(en domain.Enrollment) error { return p.call("register", en) }
+func (p *mockProvisioner) Disable(en domain.Enrollment) error  { return p.call("disable", en) }
+func (p *mockProvisioner) Remove(en domain.Enrollment) error   { return p.call("remove", en) }
+
+func TestBrokerSink_Deliver(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType domain.EventType
+		host      string
+		fail      string
+		wantCalls []string
+		wantErr   bool
+	}{
+		{"enroll", domain.EventDeviceEnroll, "mqtt.example.com", "", []string{"register a111"}, false},
+		{"disable", domain.EventDeviceDisable, "mqtt.example.com", "", []string{"disable a111"}, false},
+		{"delete", domain.EventDeviceDelete, "mqtt.example.com", "", []string{"remove a111"}, false},
+		{"renew", domain.EventDeviceRenew, "mqtt.example.com", "", nil, false},
+		{"enroll-failed", domain.EventDeviceEnroll, "mqtt.example.com", "register", []string{"register a111"}, true},
+		{"enroll-org-broker", domain.EventDeviceEnroll, "mqtt.example.org", "", nil, false},
+		{"disable-org-broker", domain.EventDeviceDisable, "mqtt.example.org", "", []string{"disable a111"}, false},
+		{"delete-org-broker", domain.EventDeviceDelete, "mqtt.example.org", "", []string{"remove a111"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &mockProvisioner{fail: tt.fail}
+			device := domain.Enrollment{ID: "a111", Credentials: domain.Credentials{MQTTURL: tt.host}}
+			event := domain.OutboxEvent{ID: "e1", Type: tt.eventType, DeviceID: "a111", Device: device}
+
+			err := BrokerSink{Provisioner: p, Host: "mqtt.example.com"}.Deliver(event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BrokerSink.Deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(p.calls, tt.wantCalls) {
+				t.Errorf("BrokerSink.Deliver() calls = %v, want %v", p.calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestIdentityService_OutboxEvents(t *testing.T) {
+	caller := &domain.Caller{Subject: "admin-test", Role: domain.RoleSuperuser}
+	enroll := func(id *IdentityService, orgID, deviceID string) error {
+		req := &datastore.DeviceEnrollRequest{Brand: "example", Model: "drone-2000", SerialNumber: "DR2000E555", StoreID: "example-store", DeviceKey: "AAAAAAAA"}
+		_, err := id.enroll(req, nil)
+		return err
+	}
+	update := func(status domain.Status) func(id *IdentityService, orgID, deviceID string) error {
+		return func(id *IdentityService, orgID, deviceID string) error {
+			return id.DeviceUpdate(caller, orgID, deviceID, &DeviceUpdateRequest{Status: int(status)})
+		}
+	}
+	remove := func(id *IdentityService, orgID, deviceID string) error {
+		return id.DeviceDelete(caller, orgID, deviceID)
+	}
+
+	tests := []struct {
+		name       string
+		status     domain.Status
+		action     func(id *IdentityService, orgID, deviceID string) error
+		wantEvents []domain.EventType
+		wantErr    bool
+	}{
+		{"enroll", domain.StatusWaiting, enroll, []domain.EventType{domain.EventDeviceRegister, domain.EventDeviceEnroll}, false},
+		{"enroll-enrolled", domain.StatusEnrolled, enroll, []domain.EventType{domain.EventDeviceRegister}, true},
+		{"enrolled-disabled", domain.StatusEnrolled, update(domain.StatusDisabled), []domain.EventType{domain.EventDeviceRegister, domain.EventDeviceDisable}, false},
+		{"enrolled-waiting", domain.StatusEnrolled, update(domain.StatusWaiting), []domain.EventType{domain.EventDeviceRegister, domain.EventDeviceDisable}, false},
+		{"waiting-disabled", domain.StatusWaiting, update(domain.StatusDisabled), []domain.EventType{domain.EventDeviceRegister, domain.EventDeviceDisable}, false},
+		{"disabled-waiting", domain.StatusDisabled, update(domain.StatusWaiting), []domain.EventType{domain.EventDeviceRegister}, false},
+		{"delete", domain.StatusEnrolled, remove, []domain.EventType{domain.EventDeviceRegister, domain.EventDeviceDelete}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, db, deviceID := registeredDevice(t, domain.KeyModeServer, tt.status)
+			orgID := db.Orgs[len(db.Orgs)-1].ID
+
+			err := tt.action(id, orgID, deviceID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IdentityService error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			events, err := id.OutboxList(datastore.OutboxQuery{DeviceID: deviceID})
+			if err != nil {
+				t.Fatalf("IdentityService.OutboxList() error = %v", err)
+			}
+			var got []domain.EventType
+			for _, e := range events {
+				got = append(got, e.Type)
+			}
+			if !reflect.DeepEqual(got, tt.wantEvents) {
+				t.Errorf("IdentityService outbox events = %v, want %v", got, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OutboxReplay(t *testing.T) {
+	caller := &domain.Caller{Subject: "admin-test", Role: domain.RoleSuperuser}
+	tests := []struct {
+		name    string
+		status  domain.OutboxStatus
+		eventID string
+		wantErr error
+	}{
+		{"failed", domain.OutboxFailed, "e1", nil},
+		{"pending", domain.OutboxPending, "e1", ErrEventNotFailed},
+		{"delivered", domain.OutboxDelivered, "e1", ErrEventNotFailed},
+		{"invalid", domain.OutboxFailed, "invalid", ErrEventNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, db, deviceID := registeredDevice(t, domain.KeyModeServer, domain.StatusEnrolled)
+			db.Outbox = []domain.OutboxEvent{{ID: "e1", Type: domain.EventDeviceEnroll, DeviceID: deviceID, Status: tt.status, Attempts: 10, LastError: "mqtt-broker: connection refused"}}
+
+			got, err := id.OutboxReplay(caller, tt.eventID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IdentityService.OutboxReplay() error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored := db.Outbox[0]
+			if tt.wantErr == nil {
+				if got.Status != domain.OutboxPending || stored.Status != domain.OutboxPending || stored.Attempts != 0 || len(stored.LastError) > 0 || stored.NextAttempt.IsZero() {
+					t.Errorf("IdentityService.OutboxReplay() = %+v, want a pending event", stored)
+				}
+			} else if stored.Status != tt.status || stored.Attempts != 10 {
+				t.Errorf("IdentityService.OutboxReplay() changed the event to %+v", stored)
+			}
+
+			// The replay is audited, with the device of the event
+			page, _ := db.AuditList(datastore.AuditQuery{Action: domain.ActionOutboxReplay})
+			if len(page.Events) != 1 || page.Events[0].Actor != caller.Subject || page.Events[0].Success != (tt.wantErr == nil) {
+				t.Fatalf("IdentityService.OutboxReplay() audit = %+v", page.Events)
+			}
+			if tt.eventID == "e1" && page.Events[0].DeviceID != deviceID {
+				t.Errorf("IdentityService.OutboxReplay() audit device = %s, want %s", page.Events[0].DeviceID, deviceID)
+			}
+		})
+	}
+}
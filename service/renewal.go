@@ -0,0 +1,115 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+)
+
+// RenewCertificate issues a new certificate to an enrolled device, which authenticates with its
+// current certificate. The new certificate is signed from a certificate request, if one is provided.
+// The previous certificate is revoked as superseded
+func (id IdentityService) RenewCertificate(req *RenewCertificateRequest) (*domain.Credentials, error) {
+	event := domain.AuditEvent{Actor: domain.ActorDevice, Action: domain.ActionDeviceRenew}
+	if req.ClientCert != nil {
+		event.DeviceID = req.ClientCert.Subject.CommonName
+		if dev, err := id.DB.DeviceGetByID(event.DeviceID); err == nil {
+			event.OrganizationID, event.Before, event.After = dev.Organization.ID, dev.Status, dev.Status
+		}
+	}
+
+	credentials, err := id.renewCertificate(req)
+	id.recordEvent(event, err)
+	return credentials, err
+}
+
+// renewCertificate checks the client certificate and issues the new certificate
+func (id IdentityService) renewCertificate(req *RenewCertificateRequest) (*domain.Credentials, error) {
+	if req.ClientCert == nil {
+		return nil, fmt.Errorf("%w: no client certificate", ErrRenewUnauthorized)
+	}
+
+	// The device ID is the common name of the certificate
+	dev, err := id.DB.DeviceGetByID(req.ClientCert.Subject.CommonName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRenewUnauthorized, err)
+	}
+	if dev.Status != domain.StatusEnrolled {
+		return nil, fmt.Errorf("%w: the device is not enrolled", ErrRenewUnauthorized)
+	}
+
+	// Only the current, unrevoked certificate of the device can be renewed
+	current, err := cert.ParseCertificate(dev.Credentials.Certificate)
+	if err != nil || !bytes.Equal(current.Raw, req.ClientCert.Raw) {
+		return nil, fmt.Errorf("%w: not the current certificate of the device", ErrRenewUnauthorized)
+	}
+	if time.Now().After(current.NotAfter) {
+		return nil, fmt.Errorf("%w: the certificate has expired", ErrRenewUnauthorized)
+	}
+	revoked, err := id.isRevoked(dev)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: the certificate has been revoked", ErrRenewUnauthorized)
+	}
+
+	org, err := id.DB.OrganizationGet(dev.Organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Issue the new certificate
+	var keyPEM, certPEM []byte
+	switch {
+	case len(req.CSR) > 0:
+		certPEM, err = id.signCSR(dev, org, []byte(req.CSR))
+	case org.KeyMode == domain.KeyModeCSR:
+		return nil, fmt.Errorf("a certificate request is required to renew the certificate")
+	default:
+		keyPEM, certPEM, err = cert.CreateClientCert(org, id.Settings.RootCertsDir, dev.ID, id.keyType(org.KeyType))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := id.DB.DeviceRenew(dev.ID, certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+	if err := id.revokeCertificate(dev, domain.ReasonSuperseded); err != nil {
+		return nil, err
+	}
+
+	chain, err := cert.CertificateChain(org, id.Settings.RootCertsDir, certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := dev.Credentials
+	credentials.PrivateKey = keyPEM
+	credentials.Certificate = certPEM
+	credentials.CertificateChain = chain
+	return &credentials, nil
+}
@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+)
+
+func TestIdentityService_RenewCertificate(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyMode  domain.KeyMode
+		status   domain.Status
+		stale    bool
+		noCert   bool
+		csr      bool
+		wantKey  bool
+		wantErr  bool
+		wantAuth bool
+	}{
+		{"valid-server", domain.KeyModeServer, domain.StatusEnrolled, false, false, false, true, false, false},
+		{"valid-server-csr", domain.KeyModeServer, domain.StatusEnrolled, false, false, true, false, false, false},
+		{"valid-csr", domain.KeyModeCSR, domain.StatusEnrolled, false, false, true, false, false, false},
+		{"csr-missing", domain.KeyModeCSR, domain.StatusEnrolled, false, false, false, false, true, false},
+		{"no-client-cert", domain.KeyModeServer, domain.StatusEnrolled, false, true, false, false, true, true},
+		{"stale-cert", domain.KeyModeServer, domain.StatusEnrolled, true, false, false, false, true, true},
+		{"not-enrolled", domain.KeyModeServer, domain.StatusWaiting, false, false, false, false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, db, deviceID := registeredDevice(t, tt.keyMode, tt.status)
+			dev, _ := db.DeviceGetByID(deviceID)
+			org, _ := db.OrganizationGet(dev.Organization.ID)
+
+			req := &RenewCertificateRequest{}
+			switch {
+			case tt.stale:
+				_, stalePEM, _ := cert.CreateClientCert(org, "../datastore/test_data", deviceID, "")
+				req.ClientCert = parseCert(t, stalePEM)
+			case !tt.noCert:
+				req.ClientCert = parseCert(t, dev.Credentials.Certificate)
+			}
+			if tt.csr {
+				req.CSR = string(testCSR(t, deviceID))
+			}
+
+			got, err := id.RenewCertificate(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IdentityService.RenewCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrRenewUnauthorized) != tt.wantAuth {
+				t.Errorf("IdentityService.RenewCertificate() error = %v, want unauthorized %v", err, tt.wantAuth)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// The new certificate is stored and the previous one is superseded
+			updated, _ := db.DeviceGetByID(deviceID)
+			if string(updated.Credentials.Certificate) != string(got.Certificate) || string(got.Certificate) == string(dev.Credentials.Certificate) {
+				t.Error("IdentityService.RenewCertificate() = certificate was not replaced")
+			}
+			if (len(got.PrivateKey) > 0) != tt.wantKey {
+				t.Errorf("IdentityService.RenewCertificate() private key = %v, want %v", len(got.PrivateKey) > 0, tt.wantKey)
+			}
+			if len(got.CertificateChain) == 0 {
+				t.Error("IdentityService.RenewCertificate() = no certificate chain")
+			}
+			if len(db.Revocations) != 1 || db.Revocations[0].Reason != domain.ReasonSuperseded {
+				t.Errorf("IdentityService.RenewCertificate() revocations = %v, want superseded", db.Revocations)
+			}
+
+			// The previous certificate cannot be renewed again
+			if _, err := id.RenewCertificate(req); !errors.Is(err, ErrRenewUnauthorized) {
+				t.Errorf("IdentityService.RenewCertificate() renewed twice: %v", err)
+			}
+		})
+	}
+}
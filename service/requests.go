@@ -19,12 +19,43 @@
 
 package service
 
-import "github.com/snapcore/snapd/asserts"
+import (
+	"crypto/x509"
+
+	"github.com/canonical/iot-identity/domain"
+	"github.com/snapcore/snapd/asserts"
+)
 
 // RegisterOrganizationRequest is the request to create a new organization
 type RegisterOrganizationRequest struct {
-	Name        string `json:"name"`
-	CountryName string `json:"country"`
+	Name             string            `json:"name"`
+	CountryName      string            `json:"country"`
+	KeyMode          int               `json:"keyMode"`
+	CertValidityDays int               `json:"certValidityDays"`
+	KeyType          string            `json:"keyType"`
+	ACLTemplates     []domain.TopicACL `json:"aclTemplates"`
+	Broker           domain.Broker     `json:"broker"`
+}
+
+// OrganizationUpdateRequest is the request to update the MQTT broker of an organization
+type OrganizationUpdateRequest struct {
+	Broker domain.Broker `json:"broker"`
+}
+
+// WebhookRegisterRequest is the request to subscribe a URL to the events of the devices
+// of an organization. No events subscribes to every event, and a secret is generated
+// when none is given
+type WebhookRegisterRequest struct {
+	URL    string             `json:"url"`
+	Events []domain.EventType `json:"events"`
+	Secret string             `json:"secret"`
+}
+
+// RegisterTokenRequest is the request to create an API token for the admin API
+type RegisterTokenRequest struct {
+	Name           string      `json:"name"`
+	OrganizationID string      `json:"orgid"`
+	Role           domain.Role `json:"role"`
 }
 
 // RegisterDeviceRequest is the request to create a new device
@@ -34,12 +65,32 @@ type RegisterDeviceRequest struct {
 	Model          string `json:"model"`
 	SerialNumber   string `json:"serial"`
 	DeviceData     string `json:"deviceData"`
+	Reregister     bool   `json:"reregister"` // registers a device that was deleted
+}
+
+// RenewCertificateRequest is the request to renew a device certificate. The client
+// certificate has been verified by the TLS connection. The PEM-encoded certificate
+// request is optional, unless the organization uses the CSR key mode
+type RenewCertificateRequest struct {
+	ClientCert *x509.Certificate `json:"-"`
+	CSR        string            `json:"csr"`
 }
 
-// EnrollDeviceRequest is the request to enroll a device via assertions
+// EnrollDeviceRequest is the request to enroll a device via assertions.
+// The session request is signed by the device-key to prove possession of the key.
+// The PEM-encoded certificate request is needed when the organization uses the CSR key mode
 type EnrollDeviceRequest struct {
-	Model  asserts.Assertion
-	Serial asserts.Assertion
+	Model          asserts.Assertion
+	Serial         asserts.Assertion
+	SessionRequest asserts.Assertion
+	CSR            []byte
+}
+
+// DeviceNonceRequest is the request for a nonce to sign when enrolling a device
+type DeviceNonceRequest struct {
+	Brand        string `json:"brand"`
+	Model        string `json:"model"`
+	SerialNumber string `json:"serial"`
 }
 
 // DeviceUpdateRequest holds request to update a device registration
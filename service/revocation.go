@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+)
+
+// crlCache holds the latest signed revocation list of each organization
+type crlCache struct {
+	sync.RWMutex
+	crls map[string][]byte
+}
+
+func newCRLCache() *crlCache {
+	return &crlCache{crls: map[string][]byte{}}
+}
+
+// OrganizationCRL fetches the DER-encoded certificate revocation list of an organization
+func (id IdentityService) OrganizationCRL(orgID string) ([]byte, error) {
+	id.crls.RLock()
+	crl, ok := id.crls.crls[orgID]
+	id.crls.RUnlock()
+	if ok {
+		return crl, nil
+	}
+	return id.RefreshCRL(orgID)
+}
+
+// RefreshCRL regenerates and signs the certificate revocation list of an organization
+func (id IdentityService) RefreshCRL(orgID string) ([]byte, error) {
+	org, err := id.DB.OrganizationGet(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := id.DB.RevocationList(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The list stays valid until a second refresh is missed
+	crl, err := cert.CreateCRL(org, id.Settings.RootCertsDir, revoked, time.Now().Add(2*id.crlInterval()))
+	if err != nil {
+		return nil, err
+	}
+
+	id.crls.Lock()
+	id.crls.crls[orgID] = crl
+	id.crls.Unlock()
+	return crl, nil
+}
+
+// PublishCRLs regenerates the certificate revocation lists of all organizations on the
+// configured schedule. It does not return
+func (id IdentityService) PublishCRLs() {
+	ticker := time.NewTicker(id.crlInterval())
+	defer ticker.Stop()
+
+	for {
+		orgs, err := id.DB.OrganizationList()
+		if err != nil {
+			log.Println("Error fetching organizations for revocation lists:", err)
+		}
+		for _, o := range orgs {
+			if _, err := id.RefreshCRL(o.ID); err != nil {
+				log.Printf("Error creating revocation list for organization `%s`: %v\n", o.ID, err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+func (id IdentityService) crlInterval() time.Duration {
+	if id.Settings.CRLInterval <= 0 {
+		return config.DefaultCRLInterval
+	}
+	return id.Settings.CRLInterval
+}
+
+// revokeCertificate records the revocation of a device's certificate and regenerates the
+// revocation list of its organization
+func (id IdentityService) revokeCertificate(en *domain.Enrollment, reason domain.RevocationReason) error {
+	serial, err := cert.SerialNumber(en.Credentials.Certificate)
+	if err != nil {
+		return err
+	}
+	if err := id.recordRevocation(en, serial, reason); err != nil {
+		return err
+	}
+
+	if _, err := id.RefreshCRL(en.Organization.ID); err != nil {
+		log.Printf("Error creating revocation list for organization `%s`: %v\n", en.Organization.ID, err)
+	}
+	return nil
+}
+
+// recordRevocation records the revocation of a device's certificate, without regenerating
+// the revocation list, so that many certificates can be revoked at once
+func (id IdentityService) recordRevocation(en *domain.Enrollment, serial string, reason domain.RevocationReason) error {
+	r := domain.Revocation{
+		OrganizationID: en.Organization.ID,
+		DeviceID:       en.ID,
+		SerialNumber:   serial,
+		Reason:         reason,
+		Revoked:        time.Now(),
+	}
+	if err := id.DB.RevocationNew(r); err != nil {
+		return fmt.Errorf("error revoking certificate: %v", err)
+	}
+	id.ocsp.reset()
+	return nil
+}
+
+// revokedSerials fetches the serial numbers of the revoked certificates of an organization
+func (id IdentityService) revokedSerials(orgID string) (map[string]bool, error) {
+	revoked, err := id.DB.RevocationList(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	serials := map[string]bool{}
+	for _, r := range revoked {
+		serials[r.SerialNumber] = true
+	}
+	return serials, nil
+}
+
+// isRevoked checks whether a device's certificate has been revoked
+func (id IdentityService) isRevoked(en *domain.Enrollment) (bool, error) {
+	serial, err := cert.SerialNumber(en.Credentials.Certificate)
+	if err != nil {
+		return false, err
+	}
+
+	revoked, err := id.DB.RevocationList(en.Organization.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range revoked {
+		if r.SerialNumber == serial {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+)
+
+// registeredDevice registers an organization with its own CA and a device, with the given status
+func registeredDevice(t *testing.T, keyMode domain.KeyMode, status domain.Status) (*IdentityService, *memory.Store, string) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	db := memory.NewStore(memory.WithFixtures())
+	id := NewIdentityService(settings, db, nil)
+
+	orgID, err := id.RegisterOrganization(nil, &RegisterOrganizationRequest{Name: "Example PLC", KeyMode: int(keyMode)})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterOrganization() error = %v", err)
+	}
+	deviceID, err := id.RegisterDevice(nil, &RegisterDeviceRequest{OrganizationID: orgID, Brand: "example", Model: "drone-2000", SerialNumber: "DR2000E555"})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterDevice() error = %v", err)
+	}
+
+	for i := range db.Roll {
+		if db.Roll[i].ID == deviceID {
+			db.Roll[i].Status = status
+			if keyMode == domain.KeyModeCSR {
+				db.Roll[i].Credentials.Certificate, _ = cert.CreateClientCertFromCSR(&db.Roll[i].Organization, settings.RootCertsDir, deviceID, testCSR(t, ""))
+			}
+		}
+	}
+	return id, db, deviceID
+}
+
+func TestIdentityService_DeviceUpdateRevoke(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  domain.Status
+		req     *DeviceUpdateRequest
+		revoked int
+	}{
+		{"enrolled-disabled", domain.StatusEnrolled, &DeviceUpdateRequest{Status: int(domain.StatusDisabled)}, 1},
+		{"waiting-disabled", domain.StatusWaiting, &DeviceUpdateRequest{Status: int(domain.StatusDisabled)}, 1},
+		{"enrolled-waiting", domain.StatusEnrolled, &DeviceUpdateRequest{Status: int(domain.StatusWaiting)}, 0},
+		{"disabled-unchanged", domain.StatusDisabled, &DeviceUpdateRequest{Status: int(domain.StatusDisabled)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, db, deviceID := registeredDevice(t, domain.KeyModeServer, tt.status)
+			orgID := db.Orgs[len(db.Orgs)-1].ID
+			if err := id.DeviceUpdate(nil, orgID, deviceID, tt.req); err != nil {
+				t.Fatalf("IdentityService.DeviceUpdate() error = %v", err)
+			}
+			if len(db.Revocations) != tt.revoked {
+				t.Fatalf("IdentityService.DeviceUpdate() revoked = %v, want %v", len(db.Revocations), tt.revoked)
+			}
+			if tt.revoked == 0 {
+				return
+			}
+
+			// The revocation list of the organization includes the certificate
+			en, _ := db.DeviceGetByID(deviceID)
+			serial, _ := cert.SerialNumber(en.Credentials.Certificate)
+			der, err := id.OrganizationCRL(en.Organization.ID)
+			if err != nil {
+				t.Fatalf("IdentityService.OrganizationCRL() error = %v", err)
+			}
+			crl, err := x509.ParseDERCRL(der)
+			if err != nil {
+				t.Fatalf("IdentityService.OrganizationCRL() parse error = %v", err)
+			}
+			entries := crl.TBSCertList.RevokedCertificates
+			if len(entries) != 1 || entries[0].SerialNumber.String() != serial {
+				t.Fatalf("IdentityService.OrganizationCRL() = %v, want serial %v", entries, serial)
+			}
+			if reason := cert.RevocationReason(entries[0]); reason != domain.ReasonCessationOfOperation {
+				t.Errorf("IdentityService.OrganizationCRL() reason = %v, want %v", reason, domain.ReasonCessationOfOperation)
+			}
+		})
+	}
+}
+
+// failingUpdates is a data store whose device updates fail
+type failingUpdates struct {
+	datastore.DataStore
+}
+
+func (s failingUpdates) DeviceUpdate(deviceID string, status domain.Status, deviceData string) error {
+	return errors.New("MOCK error updating the device")
+}
+
+func TestIdentityService_DeviceUpdateFailed(t *testing.T) {
+	id, db, deviceID := registeredDevice(t, domain.KeyModeServer, domain.StatusEnrolled)
+	id.DB = failingUpdates{DataStore: db}
+	orgID := db.Orgs[len(db.Orgs)-1].ID
+
+	// The certificate of a device that is still enrolled is not revoked
+	if err := id.DeviceUpdate(nil, orgID, deviceID, &DeviceUpdateRequest{Status: int(domain.StatusDisabled)}); err == nil {
+		t.Fatal("IdentityService.DeviceUpdate() expected an error")
+	}
+	if len(db.Revocations) != 0 {
+		t.Errorf("IdentityService.DeviceUpdate() revoked = %v, want none", len(db.Revocations))
+	}
+}
+
+func TestIdentityService_EnrollRevoked(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyMode domain.KeyMode
+		revoke  bool
+		reissue bool
+		revoked int
+	}{
+		{"server-current", domain.KeyModeServer, false, false, 0},
+		{"server-revoked", domain.KeyModeServer, true, true, 1},
+		{"csr-rekey", domain.KeyModeCSR, false, true, 1},
+		{"csr-revoked", domain.KeyModeCSR, true, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, db, deviceID := registeredDevice(t, tt.keyMode, domain.StatusWaiting)
+			dev, _ := db.DeviceGetByID(deviceID)
+			if tt.revoke {
+				if err := id.revokeCertificate(dev, domain.ReasonCessationOfOperation); err != nil {
+					t.Fatalf("IdentityService.revokeCertificate() error = %v", err)
+				}
+			}
+
+			var csr []byte
+			if tt.keyMode == domain.KeyModeCSR {
+				csr = testCSR(t, "")
+			}
+			en, err := id.enroll(&datastore.DeviceEnrollRequest{Brand: "example", Model: "drone-2000", SerialNumber: "DR2000E555", DeviceKey: "AAAAAAAA"}, csr)
+			if err != nil {
+				t.Fatalf("IdentityService.Enroll() error = %v", err)
+			}
+
+			reissued := string(en.Credentials.Certificate) != string(dev.Credentials.Certificate)
+			if reissued != tt.reissue {
+				t.Errorf("IdentityService.Enroll() reissued = %v, want %v", reissued, tt.reissue)
+			}
+			if len(db.Revocations) != tt.revoked {
+				t.Errorf("IdentityService.Enroll() revoked = %v, want %v", len(db.Revocations), tt.revoked)
+			}
+		})
+	}
+}
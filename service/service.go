@@ -20,56 +20,194 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"time"
 
 	"github.com/canonical/iot-identity/config"
 	"github.com/canonical/iot-identity/datastore"
 	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/auth"
+	"github.com/canonical/iot-identity/service/cert"
+	"github.com/canonical/iot-identity/service/trust"
+	"github.com/canonical/iot-identity/service/webhook"
 	"github.com/snapcore/snapd/asserts"
 )
 
+// ErrUntrustedAssertion is returned when the signatures of the enrollment assertions cannot be verified
+var ErrUntrustedAssertion = errors.New("the assertions are not signed by a trusted account-key")
+
+// ErrInvalidSessionRequest is returned when a device has not proved that it holds its device-key
+var ErrInvalidSessionRequest = errors.New("the device-session-request is not valid")
+
+// ErrOCSPMalformed is returned when an OCSP request cannot be parsed
+var ErrOCSPMalformed = errors.New("the OCSP request is malformed")
+
+// ErrOCSPUnauthorized is returned when an OCSP request is for a certificate that the service did not issue
+var ErrOCSPUnauthorized = errors.New("the OCSP request is not for a certificate issued by the service")
+
+// ErrRenewUnauthorized is returned when a device does not authenticate with its current certificate
+var ErrRenewUnauthorized = errors.New("the client certificate cannot be used to renew the device certificate")
+
+// ErrUnauthorized is returned when an admin API caller cannot be authenticated
+var ErrUnauthorized = errors.New("the caller is not authenticated")
+
+// ErrForbidden is returned when an admin API caller does not have the role for an action
+var ErrForbidden = errors.New("the caller is not allowed to perform the action")
+
+// ErrDeviceNotFound is returned when a device is not registered, or belongs to another organization
+var ErrDeviceNotFound = errors.New("the device cannot be found")
+
+// ErrOrganizationNotFound is returned when an organization is not registered
+var ErrOrganizationNotFound = errors.New("the organization cannot be found")
+
+// ErrOrganizationHasDevices is returned when an organization with devices is deleted without forcing it
+var ErrOrganizationHasDevices = errors.New("the organization has registered devices")
+
+// ErrEventNotFound is returned when an outbox event does not exist, or has been pruned
+var ErrEventNotFound = errors.New("the outbox event cannot be found")
+
+// ErrEventNotFailed is returned when an outbox event that has not been dead-lettered is replayed
+var ErrEventNotFailed = errors.New("only failed outbox events can be replayed")
+
+// ErrWebhookNotFound is returned when a webhook does not exist, or belongs to another organization
+var ErrWebhookNotFound = errors.New("the webhook cannot be found")
+
+// NonceExpiry is the time that a device has to sign and return a nonce
+const NonceExpiry = 5 * time.Minute
+
 // Identity interface for the service
 type Identity interface {
-	RegisterOrganization(req *RegisterOrganizationRequest) (string, error)
-	RegisterDevice(req *RegisterDeviceRequest) (string, error)
+	RegisterOrganization(caller *domain.Caller, req *RegisterOrganizationRequest) (string, error)
+	RegisterDevice(caller *domain.Caller, req *RegisterDeviceRequest) (string, error)
 	OrganizationList() ([]domain.Organization, error)
-	DeviceList(orgID string) ([]domain.Enrollment, error)
+	DeviceList(query datastore.DeviceQuery) (*datastore.DevicePage, error)
 	DeviceGet(orgID, deviceID string) (*domain.Enrollment, error)
-	DeviceUpdate(orgID, deviceID string, req *DeviceUpdateRequest) error
+	DeviceHistory(orgID, deviceID string) ([]domain.StatusChange, error)
+	DeviceUpdate(caller *domain.Caller, orgID, deviceID string, req *DeviceUpdateRequest) error
+	DeviceDelete(caller *domain.Caller, orgID, deviceID string) error
+	OrganizationUpdate(caller *domain.Caller, orgID string, req *OrganizationUpdateRequest) error
+	OrganizationReissue(caller *domain.Caller, orgID string) (int, error)
+	OrganizationDelete(caller *domain.Caller, orgID string, force bool) error
+	OrganizationCRL(orgID string) ([]byte, error)
+	OrganizationACL(orgID, format string) ([]byte, error)
+	OCSPResponse(request []byte) ([]byte, error)
 
+	DeviceNonce(req *DeviceNonceRequest) (*domain.Nonce, error)
 	EnrollDevice(req *EnrollDeviceRequest) (*domain.Enrollment, error)
+	RenewCertificate(req *RenewCertificateRequest) (*domain.Credentials, error)
+
+	Authenticate(token string) (*domain.Caller, error)
+	RegisterToken(caller *domain.Caller, req *RegisterTokenRequest) (string, string, error)
+	AuditList(query datastore.AuditQuery) (*datastore.AuditPage, error)
+
+	OutboxList(query datastore.OutboxQuery) ([]domain.OutboxEvent, error)
+	OutboxReplay(caller *domain.Caller, eventID string) (*domain.OutboxEvent, error)
+	WebhookRegister(caller *domain.Caller, orgID string, req *WebhookRegisterRequest) (string, string, error)
+	WebhookList(orgID string) ([]domain.Webhook, error)
+	WebhookDelete(caller *domain.Caller, orgID, webhookID string) error
+	WebhookDeliveries(query datastore.WebhookDeliveryQuery) ([]domain.WebhookDelivery, error)
+	WebhookTest(caller *domain.Caller, orgID, webhookID string) (*domain.WebhookDelivery, error)
 }
 
 // IdentityService implementation of the identity use cases
 type IdentityService struct {
 	Settings *config.Settings
 	DB       datastore.DataStore
+	Trusted  *trust.Database
+	Verifier *auth.Verifier
+
+	// WebhookClient posts the test payloads to the webhooks
+	WebhookClient *http.Client
+
+	crls *crlCache
+	ocsp *ocspCache
 }
 
 // NewIdentityService creates an implementation of the identity use cases
-func NewIdentityService(settings *config.Settings, db datastore.DataStore) *IdentityService {
+func NewIdentityService(settings *config.Settings, db datastore.DataStore, trusted *trust.Database) *IdentityService {
 	return &IdentityService{
-		Settings: settings,
-		DB:       db,
+		Settings:      settings,
+		DB:            db,
+		Trusted:       trusted,
+		WebhookClient: webhook.NewClient(settings.WebhookAllowPrivate),
+		crls:          newCRLCache(),
+		ocsp:          newOCSPCache(),
 	}
 }
 
-// EnrollDevice connects an IoT device with the service
+// EnrollDevice connects an IoT device with the service. Every attempt is recorded in the
+// audit log, with the reason when it is rejected, and the failed attempts of registered
+// devices with trusted assertions are also recorded in the outbox for the webhooks. The
+// headers of untrusted assertions can name any device, so they are not
 func (id IdentityService) EnrollDevice(req *EnrollDeviceRequest) (*domain.Enrollment, error) {
+	event := domain.AuditEvent{Actor: domain.ActorDevice, Action: domain.ActionDeviceEnroll}
+	if req.Serial != nil {
+		brand, model, serial := headerString(req.Serial, "brand-id"), headerString(req.Serial, "model"), headerString(req.Serial, "serial")
+		event.Details = fmt.Sprintf("%s/%s/%s", brand, model, serial)
+		if dev, err := id.DB.DeviceGet(brand, model, serial); err == nil {
+			event.OrganizationID, event.DeviceID, event.Before = dev.Organization.ID, dev.ID, dev.Status
+		}
+	}
+
+	var en *domain.Enrollment
+	untrusted := id.verifyAssertions(req)
+	err := untrusted
+	if err == nil {
+		en, err = id.enrollDevice(req)
+	}
+	if err == nil {
+		event.After = en.Status
+	}
+	id.recordEvent(event, err)
+	if err != nil && untrusted == nil && len(event.DeviceID) > 0 {
+		if e := id.DB.OutboxNew(domain.EventDeviceEnrollFailed, event.DeviceID); e != nil {
+			log.Printf("Error recording the failed enrollment of `%s`: %v\n", event.DeviceID, e)
+		}
+	}
+	return en, err
+}
+
+// headerString returns a text header of an assertion, or empty if it is missing
+func headerString(a asserts.Assertion, name string) string {
+	value, _ := a.Header(name).(string)
+	return value
+}
+
+// verifyAssertions checks the model and serial assertions of an enrollment request, and
+// their signatures, before their contents are trusted
+func (id IdentityService) verifyAssertions(req *EnrollDeviceRequest) error {
 	// Validate fields
 	if req.Model.Type().Name != asserts.ModelType.Name {
-		return nil, fmt.Errorf("the model assertion is an unexpected type")
+		return fmt.Errorf("the model assertion is an unexpected type")
 	}
 	if req.Serial.Type().Name != asserts.SerialType.Name {
-		return nil, fmt.Errorf("the serial assertion is an unexpected type")
+		return fmt.Errorf("the serial assertion is an unexpected type")
 	}
 
 	if req.Model.Header("brand-id") != req.Serial.Header("brand-id") {
-		return nil, fmt.Errorf("the brand-id of the model and serial assertion do not match")
+		return fmt.Errorf("the brand-id of the model and serial assertion do not match")
 	}
 	if req.Model.Header("model") != req.Serial.Header("model") {
-		return nil, fmt.Errorf("the model name of the model and serial assertion do not match")
+		return fmt.Errorf("the model name of the model and serial assertion do not match")
+	}
+
+	if id.Trusted == nil {
+		return fmt.Errorf("%w: no trusted assertions are configured", ErrUntrustedAssertion)
+	}
+	if err := id.Trusted.VerifyEnrollment(req.Model, req.Serial); err != nil {
+		return fmt.Errorf("%w: %v", ErrUntrustedAssertion, err)
+	}
+	return nil
+}
+
+// enrollDevice enrolls the device of an enrollment request with trusted assertions
+func (id IdentityService) enrollDevice(req *EnrollDeviceRequest) (*domain.Enrollment, error) {
+	// Check that the device holds the private key of the device-key
+	if err := id.verifySessionRequest(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSessionRequest, err)
 	}
 
 	// Create the enrollment request
@@ -83,11 +221,85 @@ func (id IdentityService) EnrollDevice(req *EnrollDeviceRequest) (*domain.Enroll
 		enroll.StoreID = req.Model.Header("store").(string)
 	}
 
-	return id.enroll(&enroll)
+	return id.enroll(&enroll, req.CSR)
+}
+
+// DeviceNonce issues a single-use nonce for a registered device to sign when it enrolls
+func (id IdentityService) DeviceNonce(req *DeviceNonceRequest) (*domain.Nonce, error) {
+	// Validate fields
+	for k, v := range map[string]string{
+		"brand":         req.Brand,
+		"model name":    req.Model,
+		"serial number": req.SerialNumber,
+	} {
+		if err := validateNotEmpty(k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only devices that are waiting to enroll need a nonce
+	dev, err := id.DB.DeviceGet(req.Brand, req.Model, req.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	if dev.Status != domain.StatusWaiting {
+		return nil, fmt.Errorf("the device `%s/%s/%s` is not waiting to enroll", req.Brand, req.Model, req.SerialNumber)
+	}
+
+	value, err := cert.CreateSecret(32)
+	if err != nil {
+		return nil, fmt.Errorf("error creating nonce: %v", err)
+	}
+
+	nonce := domain.Nonce{
+		Value:        value,
+		Brand:        req.Brand,
+		Model:        req.Model,
+		SerialNumber: req.SerialNumber,
+		Expires:      time.Now().Add(NonceExpiry),
+	}
+	if err := id.DB.NonceNew(nonce); err != nil {
+		return nil, err
+	}
+	return &nonce, nil
+}
+
+// verifySessionRequest checks that the device-session-request is signed by the device-key
+// from the serial assertion and that it contains an unused nonce issued to the device
+func (id IdentityService) verifySessionRequest(req *EnrollDeviceRequest) error {
+	if req.SessionRequest == nil || req.SessionRequest.Type().Name != asserts.DeviceSessionRequestType.Name {
+		return fmt.Errorf("a device-session-request assertion is required")
+	}
+	serial, ok := req.Serial.(*asserts.Serial)
+	if !ok {
+		return fmt.Errorf("the serial assertion is an unexpected type")
+	}
+	session, ok := req.SessionRequest.(*asserts.DeviceSessionRequest)
+	if !ok {
+		return fmt.Errorf("the device-session-request is an unexpected type")
+	}
+
+	if session.BrandID() != serial.BrandID() || session.Model() != serial.Model() || session.Serial() != serial.Serial() {
+		return fmt.Errorf("the device-session-request does not match the serial assertion")
+	}
+
+	if err := asserts.SignatureCheck(session, serial.DeviceKey()); err != nil {
+		return fmt.Errorf("the device-session-request is not signed by the device-key: %v", err)
+	}
+
+	// Using the nonce removes it, so it cannot be replayed
+	nonce, err := id.DB.NonceUse(session.Nonce(), serial.BrandID(), serial.Model(), serial.Serial())
+	if err != nil {
+		return err
+	}
+	if time.Now().After(nonce.Expires) {
+		return fmt.Errorf("the nonce has expired")
+	}
+	return nil
 }
 
 // Enroll connects an IoT device with the service
-func (id IdentityService) enroll(enroll *datastore.DeviceEnrollRequest) (*domain.Enrollment, error) {
+func (id IdentityService) enroll(enroll *datastore.DeviceEnrollRequest, csr []byte) (*domain.Enrollment, error) {
 	// Get the registration for the device
 	dev, err := id.DB.DeviceGet(enroll.Brand, enroll.Model, enroll.SerialNumber)
 	if err != nil {
@@ -107,15 +319,92 @@ func (id IdentityService) enroll(enroll *datastore.DeviceEnrollRequest) (*domain
 		return nil, fmt.Errorf("the device registration for `%s/%s/%s` is invalid", enroll.Brand, enroll.Model, enroll.SerialNumber)
 	}
 
-	// Enroll the device
+	// Get the organization, which holds the CA for the device certificate
+	org, err := id.DB.OrganizationGet(dev.Organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check whether the device holds a certificate that is still valid
+	current := len(dev.Credentials.Certificate) > 0
+	if current {
+		revoked, err := id.isRevoked(dev)
+		if err != nil {
+			return nil, err
+		}
+		current = !revoked
+	}
+
+	// Sign the device's certificate request, when the organization does not use server-generated keys.
+	// Otherwise, replace the certificate if it has been revoked
+	switch {
+	case org.KeyMode == domain.KeyModeCSR:
+		if len(csr) == 0 {
+			return nil, fmt.Errorf("a certificate request is required to enroll the device")
+		}
+		certPEM, err := id.signCSR(dev, org, csr)
+		if err != nil {
+			return nil, err
+		}
+		enroll.Certificate = certPEM
+	case len(csr) > 0:
+		return nil, fmt.Errorf("the organization does not accept certificate requests")
+	case !current:
+		keyPEM, certPEM, err := cert.CreateClientCert(org, id.Settings.RootCertsDir, dev.ID, id.keyType(org.KeyType))
+		if err != nil {
+			return nil, err
+		}
+		enroll.Certificate = certPEM
+		enroll.PrivateKey = keyPEM
+	}
+
+	// Render the topics that the device may use, and the current broker of the organization
+	if enroll.ACLs, err = renderACLs(org, dev); err != nil {
+		return nil, err
+	}
+	if enroll.MQTT, err = id.brokerCredentials(org, dev); err != nil {
+		return nil, err
+	}
+
+	// Enroll the device. The data store records the enrollment in its outbox, so the
+	// client of the device is provisioned in the MQTT broker out-of-band
 	en, err := id.DB.DeviceEnroll(*enroll)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: Register the device in the MQTT broker
-	// (Best to do this out-of-band by submitting a message to a queue for processing)
+	// The device has been re-keyed, so its previous certificate is superseded
+	if current && len(enroll.Certificate) > 0 {
+		if err := id.revokeCertificate(dev, domain.ReasonSuperseded); err != nil {
+			return nil, err
+		}
+	}
+
+	// Include the CA certificates, so the device can present the full chain
+	if len(en.Credentials.Certificate) > 0 {
+		chain, err := cert.CertificateChain(org, id.Settings.RootCertsDir, en.Credentials.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		en.Credentials.CertificateChain = chain
+	}
 
 	// Return the MQTT credentials to the device (which includes the unique ID of the device)
 	return en, nil
 }
+
+// signCSR checks that a certificate request is for the registered device and signs it
+func (id IdentityService) signCSR(dev *domain.Enrollment, org *domain.Organization, csr []byte) ([]byte, error) {
+	req, err := cert.ParseCSR(csr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The subject is replaced, but a conflicting name indicates the wrong request
+	cn := req.Subject.CommonName
+	if len(cn) > 0 && cn != dev.ID && cn != dev.Device.SerialNumber {
+		return nil, fmt.Errorf("the certificate request common name `%s` does not match the device", cn)
+	}
+
+	return cert.CreateClientCertFromCSR(org, id.Settings.RootCertsDir, dev.ID, csr)
+}
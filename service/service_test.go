@@ -20,13 +20,26 @@
 package service
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
 
 	"github.com/canonical/iot-identity/config"
 	"github.com/canonical/iot-identity/datastore"
 	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+	"github.com/canonical/iot-identity/service/trust"
 )
 
 const model1 = `type: model
@@ -127,11 +140,78 @@ FG2TYLmQ06MPmRuqVRaAdjfhnZ9YtFBDhI+obn99q/OmG2e7d4WNU3JPG1h5arIQGNeR9kVzBER1
 iO0V3iYjD0DxOsd2QVOdI/o8HqCRfycTMo/7TydVdWKXKpKdzeezfz/df2LRDCE712NVFhY0hDC6
 BvV4mMoqS17K7OMHfDohh0DFfp0yFl9oYfLY55G5HA==`
 
-const ignoreError1 = "the device `canonical/canonical/d75f7300-abbf-4c11-bf0a-8b7103038490` is not registered"
+// testDevice holds the signed assertions of a device and its trusted database
+type testDevice struct {
+	trusted   *trust.Database
+	model     string
+	serial    string
+	deviceKey asserts.PrivateKey
+}
+
+// signedAssertions creates a trusted database and a model and serial assertion signed by its keys
+func signedAssertions(brand, model, serial string) testDevice {
+	store := assertstest.NewStoreStack(brand, nil)
+	db, err := trust.NewDatabase(store.Trusted, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	m, err := store.RootSigning.Sign(asserts.ModelType, map[string]interface{}{
+		"series":       "16",
+		"brand-id":     brand,
+		"model":        model,
+		"architecture": "amd64",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	if err != nil {
+		panic(err)
+	}
+
+	deviceKey, _ := assertstest.GenerateKey(752)
+	encodedKey, err := asserts.EncodePublicKey(deviceKey.PublicKey())
+	if err != nil {
+		panic(err)
+	}
+	s, err := store.RootSigning.Sign(asserts.SerialType, map[string]interface{}{
+		"brand-id":            brand,
+		"model":               model,
+		"serial":              serial,
+		"device-key":          string(encodedKey),
+		"device-key-sha3-384": deviceKey.PublicKey().ID(),
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}, nil, "")
+	if err != nil {
+		panic(err)
+	}
+
+	return testDevice{db, string(asserts.Encode(m)), string(asserts.Encode(s)), deviceKey}
+}
+
+// sessionRequest creates a device-session-request for the nonce, signed by the key
+func (d testDevice) sessionRequest(nonce string, key asserts.PrivateKey) asserts.Assertion {
+	s, err := asserts.Decode([]byte(d.serial))
+	if err != nil {
+		panic(err)
+	}
+
+	a, err := asserts.SignWithoutAuthority(asserts.DeviceSessionRequestType, map[string]interface{}{
+		"brand-id":  s.Header("brand-id"),
+		"model":     s.Header("model"),
+		"serial":    s.Header("serial"),
+		"nonce":     nonce,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}, nil, key)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
 
 func TestIdentityService_RegisterOrganization(t *testing.T) {
 	settings.RootCertsDir = "../datastore/test_data"
-	db := memory.NewStore()
+	db := memory.NewStore(memory.WithFixtures())
 	req1 := RegisterOrganizationRequest{
 		Name:        "Example PLC",
 		CountryName: "United Kingdom",
@@ -144,6 +224,32 @@ func TestIdentityService_RegisterOrganization(t *testing.T) {
 		Name:        "Example Inc",
 		CountryName: "United Kingdom",
 	}
+	req4 := RegisterOrganizationRequest{
+		Name:        "Example CSR",
+		CountryName: "United Kingdom",
+		KeyMode:     int(domain.KeyModeCSR),
+	}
+	req5 := RegisterOrganizationRequest{
+		Name:        "Example Invalid",
+		CountryName: "United Kingdom",
+		KeyMode:     9,
+	}
+	req6 := RegisterOrganizationRequest{
+		Name:             "Example Short-lived",
+		CertValidityDays: 7,
+	}
+	req7 := RegisterOrganizationRequest{
+		Name:             "Example Invalid Validity",
+		CertValidityDays: -1,
+	}
+	req8 := RegisterOrganizationRequest{
+		Name:    "Example ECDSA",
+		KeyType: cert.KeyTypeECDSAP256,
+	}
+	req9 := RegisterOrganizationRequest{
+		Name:    "Example Invalid Key",
+		KeyType: "dsa1024",
+	}
 
 	tests := []struct {
 		name    string
@@ -153,11 +259,17 @@ func TestIdentityService_RegisterOrganization(t *testing.T) {
 		{"valid", req1, false},
 		{"invalid", req2, true},
 		{"duplicate", req3, true},
+		{"valid-csr", req4, false},
+		{"invalid-key-mode", req5, true},
+		{"valid-validity", req6, false},
+		{"invalid-validity", req7, true},
+		{"valid-key-type", req8, false},
+		{"invalid-key-type", req9, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			id := NewIdentityService(settings, db)
-			got, err := id.RegisterOrganization(&tt.req)
+			id := NewIdentityService(settings, db, nil)
+			got, err := id.RegisterOrganization(nil, &tt.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("IdentityService.RegisterOrganization() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -173,7 +285,7 @@ func TestIdentityService_RegisterOrganization(t *testing.T) {
 
 func TestIdentityService_RegisterDevice(t *testing.T) {
 	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
-	db := memory.NewStore()
+	db := memory.NewStore(memory.WithFixtures())
 	req1 := RegisterDeviceRequest{
 		OrganizationID: "abc",
 		Brand:          "example",
@@ -214,8 +326,8 @@ func TestIdentityService_RegisterDevice(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			id := NewIdentityService(settings, db)
-			got, err := id.RegisterDevice(&tt.args.req)
+			id := NewIdentityService(settings, db, nil)
+			got, err := id.RegisterDevice(nil, &tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("IdentityService.RegisterDevice() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -231,7 +343,7 @@ func TestIdentityService_RegisterDevice(t *testing.T) {
 
 func TestIdentityService_Enroll(t *testing.T) {
 	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
-	db := memory.NewStore()
+	db := memory.NewStore(memory.WithFixtures())
 	req1 := datastore.DeviceEnrollRequest{
 		Brand:        "canonical",
 		Model:        "ubuntu-core-18-amd64",
@@ -276,8 +388,8 @@ func TestIdentityService_Enroll(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			id := NewIdentityService(settings, db)
-			got, err := id.enroll(&tt.args.req)
+			id := NewIdentityService(settings, db, nil)
+			got, err := id.enroll(&tt.args.req, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("IdentityService.Enroll() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -295,9 +407,171 @@ func TestIdentityService_Enroll(t *testing.T) {
 	}
 }
 
+func TestIdentityService_RegisterDeviceCSR(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	db := memory.NewStore(memory.WithFixtures())
+	db.Orgs[0].KeyMode = domain.KeyModeCSR
+	id := NewIdentityService(settings, db, nil)
+
+	deviceID, err := id.RegisterDevice(nil, &RegisterDeviceRequest{OrganizationID: "abc", Brand: "example", Model: "drone-2000", SerialNumber: "DR2000C333"})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterDevice() error = %v", err)
+	}
+	en, err := db.DeviceGetByID(deviceID)
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterDevice() fetch error = %v", err)
+	}
+	if len(en.Credentials.PrivateKey) > 0 || len(en.Credentials.Certificate) > 0 {
+		t.Error("IdentityService.RegisterDevice() = credentials generated for a CSR organization")
+	}
+}
+
+func TestIdentityService_RegisterDeviceKeyType(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		org      string
+		want     string
+	}{
+		{"default", "", "", "*rsa.PrivateKey"},
+		{"service", cert.KeyTypeECDSAP256, "", "*ecdsa.PrivateKey"},
+		{"organization", cert.KeyTypeECDSAP256, cert.KeyTypeEd25519, "ed25519.PrivateKey"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := &config.Settings{RootCertsDir: "../datastore/test_data", KeyType: tt.settings}
+			db := memory.NewStore(memory.WithFixtures())
+			db.Orgs[0].KeyType = tt.org
+			id := NewIdentityService(settings, db, nil)
+
+			deviceID, err := id.RegisterDevice(nil, &RegisterDeviceRequest{OrganizationID: "abc", Brand: "example", Model: "drone-2000", SerialNumber: "DR2000C333"})
+			if err != nil {
+				t.Fatalf("IdentityService.RegisterDevice() error = %v", err)
+			}
+			en, err := db.DeviceGetByID(deviceID)
+			if err != nil {
+				t.Fatalf("IdentityService.RegisterDevice() fetch error = %v", err)
+			}
+			block, _ := pem.Decode(en.Credentials.PrivateKey)
+			if block == nil {
+				t.Fatal("IdentityService.RegisterDevice() = no private key")
+			}
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				t.Fatalf("IdentityService.RegisterDevice() key error = %v", err)
+			}
+			if got := fmt.Sprintf("%T", key); got != tt.want {
+				t.Errorf("IdentityService.RegisterDevice() key = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testCSR(t *testing.T, commonName string) []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}, key)
+	if err != nil {
+		t.Fatalf("error creating certificate request: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestIdentityService_EnrollCSR(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	serial := "d75f7300-abbf-4c11-bf0a-8b7103038490"
+
+	tests := []struct {
+		name    string
+		keyMode domain.KeyMode
+		csr     []byte
+		wantErr bool
+	}{
+		{"valid-serial", domain.KeyModeCSR, testCSR(t, serial), false},
+		{"valid-device-id", domain.KeyModeCSR, testCSR(t, "c333"), false},
+		{"valid-no-name", domain.KeyModeCSR, testCSR(t, ""), false},
+		{"missing-csr", domain.KeyModeCSR, nil, true},
+		{"invalid-csr", domain.KeyModeCSR, []byte("invalid"), true},
+		{"wrong-name", domain.KeyModeCSR, testCSR(t, "DR1000A111"), true},
+		{"server-mode", domain.KeyModeServer, testCSR(t, serial), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore(memory.WithFixtures())
+			db.Orgs[0].KeyMode = tt.keyMode
+			id := NewIdentityService(settings, db, nil)
+
+			req := datastore.DeviceEnrollRequest{
+				Brand:        "canonical",
+				Model:        "ubuntu-core-18-amd64",
+				SerialNumber: serial,
+				StoreID:      "example-store",
+				DeviceKey:    "AAAAAAAA",
+			}
+			got, err := id.enroll(&req, tt.csr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IdentityService.Enroll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				if len(got.Credentials.Certificate) == 0 {
+					t.Error("IdentityService.Enroll() = certificate is not populated")
+				}
+				if len(got.Credentials.PrivateKey) > 0 {
+					t.Error("IdentityService.Enroll() = private key should not be held by the service")
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityService_EnrollChain(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	id := NewIdentityService(settings, memory.NewStore(memory.WithFixtures()), nil)
+
+	orgID, err := id.RegisterOrganization(nil, &RegisterOrganizationRequest{Name: "Example PLC", CountryName: "United Kingdom"})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterOrganization() error = %v", err)
+	}
+	if _, err := id.RegisterDevice(nil, &RegisterDeviceRequest{OrganizationID: orgID, Brand: "example", Model: "drone-2000", SerialNumber: "DR2000D444"}); err != nil {
+		t.Fatalf("IdentityService.RegisterDevice() error = %v", err)
+	}
+
+	en, err := id.enroll(&datastore.DeviceEnrollRequest{Brand: "example", Model: "drone-2000", SerialNumber: "DR2000D444", DeviceKey: "AAAAAAAA"}, nil)
+	if err != nil {
+		t.Fatalf("IdentityService.Enroll() error = %v", err)
+	}
+
+	// The chain is the device certificate, the organization CA and the root CA
+	var certs []*x509.Certificate
+	rest := en.Credentials.CertificateChain
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("IdentityService.Enroll() chain error = %v", err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) != 3 {
+		t.Fatalf("IdentityService.Enroll() chain has %d certificates, want 3", len(certs))
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certs[2])
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(certs[1])
+	if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("IdentityService.Enroll() chain verify error = %v", err)
+	}
+}
+
 func TestIdentityService_EnrollDevice(t *testing.T) {
 	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
-	db := memory.NewStore()
+	dev := signedAssertions("canonical", "ubuntu-core-18-amd64", "d75f7300-abbf-4c11-bf0a-8b7103038490")
 
 	type args struct {
 		model  string
@@ -307,13 +581,15 @@ func TestIdentityService_EnrollDevice(t *testing.T) {
 		name      string
 		args      args
 		wantErr   bool
-		ignoreErr string
+		untrusted bool
 	}{
-		{"valid", args{model1, serial1}, false, ignoreError1},
-		{"no-serial", args{model1, model1}, true, ignoreError1},
-		{"no-model", args{serial1, serial1}, true, ignoreError1},
-		{"mismatch-brand", args{model2, serial1}, true, ignoreError1},
-		{"mismatch-model", args{model3, serial1}, true, ignoreError1},
+		{"valid", args{dev.model, dev.serial}, false, false},
+		{"no-serial", args{dev.model, dev.model}, true, false},
+		{"no-model", args{dev.serial, dev.serial}, true, false},
+		{"mismatch-brand", args{model2, dev.serial}, true, false},
+		{"mismatch-model", args{model3, dev.serial}, true, false},
+		{"untrusted", args{model1, serial1}, true, true},
+		{"untrusted-serial", args{dev.model, serial1}, true, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -326,18 +602,26 @@ func TestIdentityService_EnrollDevice(t *testing.T) {
 				t.Errorf("IdentityService.EnrollDevice() serial error = %v", err)
 			}
 
+			id := NewIdentityService(settings, memory.NewStore(memory.WithFixtures()), dev.trusted)
+			nonce, err := id.DeviceNonce(&DeviceNonceRequest{Brand: "canonical", Model: "ubuntu-core-18-amd64", SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490"})
+			if err != nil {
+				t.Errorf("IdentityService.EnrollDevice() nonce error = %v", err)
+				return
+			}
+
 			req := &EnrollDeviceRequest{
-				Model:  m,
-				Serial: s,
+				Model:          m,
+				Serial:         s,
+				SessionRequest: dev.sessionRequest(nonce.Value, dev.deviceKey),
 			}
 
-			id := NewIdentityService(settings, db)
 			got, err := id.EnrollDevice(req)
-			if err != nil && !tt.wantErr {
-				if err.Error() != tt.ignoreErr {
-					t.Errorf("IdentityService.EnrollDevice() error = %v, wantErr %v", err, tt.wantErr)
-					return
-				}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IdentityService.EnrollDevice() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if errors.Is(err, ErrUntrustedAssertion) != tt.untrusted {
+				t.Errorf("IdentityService.EnrollDevice() error = %v, untrusted %v", err, tt.untrusted)
 			}
 			if !tt.wantErr {
 				if got == nil {
@@ -347,3 +631,147 @@ func TestIdentityService_EnrollDevice(t *testing.T) {
 		})
 	}
 }
+
+func TestIdentityService_EnrollDeviceNoTrust(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	dev := signedAssertions("canonical", "ubuntu-core-18-amd64", "d75f7300-abbf-4c11-bf0a-8b7103038490")
+
+	m, _ := asserts.Decode([]byte(dev.model))
+	s, _ := asserts.Decode([]byte(dev.serial))
+
+	id := NewIdentityService(settings, memory.NewStore(memory.WithFixtures()), nil)
+	_, err := id.EnrollDevice(&EnrollDeviceRequest{Model: m, Serial: s, SessionRequest: dev.sessionRequest("abc", dev.deviceKey)})
+	if !errors.Is(err, ErrUntrustedAssertion) {
+		t.Errorf("IdentityService.EnrollDevice() error = %v, want untrusted", err)
+	}
+}
+
+func TestIdentityService_EnrollDeviceSessionRequest(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	dev := signedAssertions("canonical", "ubuntu-core-18-amd64", "d75f7300-abbf-4c11-bf0a-8b7103038490")
+	other := signedAssertions("canonical", "ubuntu-core-18-amd64", "d75f7300-abbf-4c11-bf0a-8b7103038490")
+	m, _ := asserts.Decode([]byte(dev.model))
+	s, _ := asserts.Decode([]byte(dev.serial))
+
+	tests := []struct {
+		name    string
+		nonce   string
+		key     asserts.PrivateKey
+		expired bool
+		missing bool
+		wantErr bool
+	}{
+		{"valid", "", dev.deviceKey, false, false, false},
+		{"missing", "", dev.deviceKey, false, true, true},
+		{"wrong-key", "", other.deviceKey, false, false, true},
+		{"unknown-nonce", "invalid", dev.deviceKey, false, false, true},
+		{"expired-nonce", "", dev.deviceKey, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(settings, db, dev.trusted)
+			nonce, err := id.DeviceNonce(&DeviceNonceRequest{Brand: "canonical", Model: "ubuntu-core-18-amd64", SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490"})
+			if err != nil {
+				t.Errorf("IdentityService.EnrollDevice() nonce error = %v", err)
+				return
+			}
+			if tt.expired {
+				db.Nonces[0].Expires = time.Now().Add(-time.Minute)
+			}
+			value := nonce.Value
+			if len(tt.nonce) > 0 {
+				value = tt.nonce
+			}
+
+			req := &EnrollDeviceRequest{Model: m, Serial: s}
+			if !tt.missing {
+				req.SessionRequest = dev.sessionRequest(value, tt.key)
+			}
+
+			_, err = id.EnrollDevice(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IdentityService.EnrollDevice() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && !errors.Is(err, ErrInvalidSessionRequest) {
+				t.Errorf("IdentityService.EnrollDevice() error = %v, want invalid session request", err)
+			}
+
+			// The nonce can only be used once
+			if !tt.wantErr {
+				if _, err := id.EnrollDevice(req); err == nil {
+					t.Error("IdentityService.EnrollDevice() replayed nonce was accepted")
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityService_DeviceNonce(t *testing.T) {
+	settings := &config.Settings{RootCertsDir: "../datastore/test_data"}
+	tests := []struct {
+		name    string
+		req     DeviceNonceRequest
+		wantErr bool
+	}{
+		{"valid", DeviceNonceRequest{"canonical", "ubuntu-core-18-amd64", "d75f7300-abbf-4c11-bf0a-8b7103038490"}, false},
+		{"empty", DeviceNonceRequest{"", "ubuntu-core-18-amd64", "d75f7300-abbf-4c11-bf0a-8b7103038490"}, true},
+		{"not-registered", DeviceNonceRequest{"canonical", "ubuntu-core-18-amd64", "invalid"}, true},
+		{"enrolled", DeviceNonceRequest{"example", "drone-1000", "DR1000B222"}, true},
+		{"disabled", DeviceNonceRequest{"example", "drone-1000", "DR1000A111"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := NewIdentityService(settings, memory.NewStore(memory.WithFixtures()), nil)
+			got, err := id.DeviceNonce(&tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IdentityService.DeviceNonce() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				if len(got.Value) == 0 || got.Expires.Before(time.Now()) {
+					t.Errorf("IdentityService.DeviceNonce() = %v, want valid nonce", got)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityService_CrossOrganization(t *testing.T) {
+	id, db, deviceID := registeredDevice(t, domain.KeyModeServer, domain.StatusEnrolled)
+	ownerID := db.Orgs[len(db.Orgs)-1].ID
+	otherID, err := id.RegisterOrganization(nil, &RegisterOrganizationRequest{Name: "Other PLC"})
+	if err != nil {
+		t.Fatalf("IdentityService.RegisterOrganization() error = %v", err)
+	}
+
+	// The device cannot be fetched through another organization
+	if _, err := id.DeviceGet(otherID, deviceID); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("IdentityService.DeviceGet() error = %v, want ErrDeviceNotFound", err)
+	}
+	if _, err := id.DeviceGet(ownerID, deviceID); err != nil {
+		t.Errorf("IdentityService.DeviceGet() error = %v", err)
+	}
+
+	// The device cannot be updated through another organization
+	err = id.DeviceUpdate(nil, otherID, deviceID, &DeviceUpdateRequest{Status: int(domain.StatusDisabled), DeviceData: "changed"})
+	if !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("IdentityService.DeviceUpdate() error = %v, want ErrDeviceNotFound", err)
+	}
+	en, _ := db.DeviceGetByID(deviceID)
+	if en.Status != domain.StatusEnrolled || en.DeviceData == "changed" || len(db.Revocations) > 0 {
+		t.Errorf("IdentityService.DeviceUpdate() = device changed by another organization")
+	}
+
+	// The device is not listed for another organization
+	page, err := id.DeviceList(datastore.DeviceQuery{OrganizationID: otherID})
+	if err != nil {
+		t.Fatalf("IdentityService.DeviceList() error = %v", err)
+	}
+	for _, d := range page.Devices {
+		if d.ID == deviceID {
+			t.Errorf("IdentityService.DeviceList() = device listed for another organization")
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"fmt"
+
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/auth"
+)
+
+// Authenticate identifies the caller of the admin API from a bearer token, which is
+// either an API token or a JWT from the configured identity provider
+func (id IdentityService) Authenticate(token string) (*domain.Caller, error) {
+	if len(token) == 0 {
+		return nil, fmt.Errorf("%w: no bearer token", ErrUnauthorized)
+	}
+
+	if auth.IsJWT(token) {
+		if id.Verifier == nil {
+			return nil, fmt.Errorf("%w: JWT authentication is not configured", ErrUnauthorized)
+		}
+		caller, err := id.Verifier.Verify(token)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+		return caller, nil
+	}
+
+	t, err := id.DB.TokenGetByHash(auth.HashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown API token", ErrUnauthorized)
+	}
+	return &domain.Caller{
+		Subject:        t.Name,
+		OrganizationID: t.OrganizationID,
+		Role:           t.Role,
+	}, nil
+}
+
+// RegisterToken creates an API token, returning its ID and value. The value is only
+// available now, as the service stores its hash
+func (id IdentityService) RegisterToken(caller *domain.Caller, req *RegisterTokenRequest) (string, string, error) {
+	tokenID, token, err := id.registerToken(caller, req)
+	id.audit(caller, domain.AuditEvent{
+		Action:         domain.ActionTokenRegister,
+		OrganizationID: req.OrganizationID,
+		Details:        fmt.Sprintf("token `%s` (%s) with ID `%s`", req.Name, req.Role, tokenID),
+	}, err)
+	return tokenID, token, err
+}
+
+// registerToken checks that the caller can create the token, and creates it
+func (id IdentityService) registerToken(caller *domain.Caller, req *RegisterTokenRequest) (string, string, error) {
+	// Validate fields
+	if err := validateNotEmpty("token name", req.Name); err != nil {
+		return "", "", err
+	}
+	if !auth.ValidRole(req.Role) {
+		return "", "", fmt.Errorf("the role `%s` is invalid", req.Role)
+	}
+
+	// Org admins can create tokens for their own organization only
+	required := domain.RoleOrgAdmin
+	if req.Role == domain.RoleSuperuser {
+		required = domain.RoleSuperuser
+		req.OrganizationID = ""
+	}
+	if !auth.Allowed(caller, req.OrganizationID, required) {
+		return "", "", fmt.Errorf("%w: cannot create a `%s` token", ErrForbidden, req.Role)
+	}
+	if required != domain.RoleSuperuser {
+		if _, err := id.DB.OrganizationGet(req.OrganizationID); err != nil {
+			return "", "", fmt.Errorf("cannot find the organization `%s`", req.OrganizationID)
+		}
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		return "", "", fmt.Errorf("cannot generate token: %v", err)
+	}
+	tokenID, err := id.DB.TokenNew(domain.Token{
+		Name:           req.Name,
+		OrganizationID: req.OrganizationID,
+		Role:           req.Role,
+		Hash:           auth.HashToken(token),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return tokenID, token, nil
+}
@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/auth"
+)
+
+func TestIdentityService_Authenticate(t *testing.T) {
+	db := memory.NewStore(memory.WithFixtures())
+	if _, err := db.TokenNew(domain.Token{Name: "viewer", OrganizationID: "abc", Role: domain.RoleReadOnly, Hash: auth.HashToken("secret")}); err != nil {
+		t.Fatalf("TokenNew() error = %v", err)
+	}
+	id := NewIdentityService(&config.Settings{}, db, nil)
+
+	tests := []struct {
+		name    string
+		token   string
+		want    *domain.Caller
+		wantErr bool
+	}{
+		{"valid", "secret", &domain.Caller{Subject: "viewer", OrganizationID: "abc", Role: domain.RoleReadOnly}, false},
+		{"unknown", "invalid", nil, true},
+		{"empty", "", nil, true},
+		{"jwt-not-configured", "aaa.bbb.ccc", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := id.Authenticate(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IdentityService.Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Errorf("IdentityService.Authenticate() error = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if *got != *tt.want {
+				t.Errorf("IdentityService.Authenticate() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityService_RegisterToken(t *testing.T) {
+	superuser := &domain.Caller{Subject: "admin", Role: domain.RoleSuperuser}
+	admin := &domain.Caller{Subject: "admin-abc", OrganizationID: "abc", Role: domain.RoleOrgAdmin}
+	viewer := &domain.Caller{Subject: "viewer-abc", OrganizationID: "abc", Role: domain.RoleReadOnly}
+
+	tests := []struct {
+		name      string
+		caller    *domain.Caller
+		req       RegisterTokenRequest
+		wantErr   bool
+		forbidden bool
+	}{
+		{"superuser", superuser, RegisterTokenRequest{Name: "root", Role: domain.RoleSuperuser}, false, false},
+		{"superuser-org", superuser, RegisterTokenRequest{Name: "ops", OrganizationID: "abc", Role: domain.RoleOrgAdmin}, false, false},
+		{"org-admin-own-org", admin, RegisterTokenRequest{Name: "ci", OrganizationID: "abc", Role: domain.RoleReadOnly}, false, false},
+		{"org-admin-other-org", admin, RegisterTokenRequest{Name: "ci", OrganizationID: "def", Role: domain.RoleReadOnly}, true, true},
+		{"org-admin-superuser", admin, RegisterTokenRequest{Name: "root", Role: domain.RoleSuperuser}, true, true},
+		{"read-only", viewer, RegisterTokenRequest{Name: "ci", OrganizationID: "abc", Role: domain.RoleReadOnly}, true, true},
+		{"invalid-org", superuser, RegisterTokenRequest{Name: "ci", OrganizationID: "invalid", Role: domain.RoleReadOnly}, true, false},
+		{"invalid-role", superuser, RegisterTokenRequest{Name: "ci", OrganizationID: "abc", Role: "owner"}, true, false},
+		{"no-name", superuser, RegisterTokenRequest{OrganizationID: "abc", Role: domain.RoleReadOnly}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(&config.Settings{}, db, nil)
+
+			tokenID, token, err := id.RegisterToken(tt.caller, &tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IdentityService.RegisterToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if errors.Is(err, ErrForbidden) != tt.forbidden {
+				t.Errorf("IdentityService.RegisterToken() error = %v, forbidden %v", err, tt.forbidden)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// The new token authenticates with its role
+			caller, err := id.Authenticate(token)
+			if err != nil {
+				t.Fatalf("IdentityService.Authenticate() error = %v", err)
+			}
+			if caller.Role != tt.req.Role || caller.OrganizationID != tt.req.OrganizationID {
+				t.Errorf("IdentityService.Authenticate() got = %v, want %v", caller, tt.req)
+			}
+			if len(tokenID) == 0 || db.Tokens[0].Hash == token {
+				t.Error("IdentityService.RegisterToken() = token not stored by its hash")
+			}
+		})
+	}
+}
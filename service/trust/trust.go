@@ -0,0 +1,180 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package trust verifies the signatures of the assertions that are provided
+// by a device when it enrolls with the service.
+package trust
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/sysdb"
+)
+
+const assertionFileExt = ".assert"
+
+// Database holds the trusted assertions that device assertions are verified against
+type Database struct {
+	db *asserts.Database
+}
+
+// NewDatabase creates an assertion database founded on the trusted account and
+// account-key assertions. The other assertions, such as the account-keys of brands,
+// are only added after they have been verified against the trusted assertions.
+func NewDatabase(trusted, others []asserts.Assertion) (*Database, error) {
+	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
+		Backstore: asserts.NewMemoryBackstore(),
+		Trusted:   trusted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open assertion database: %v", err)
+	}
+
+	if err := addAll(db, others); err != nil {
+		return nil, err
+	}
+	return &Database{db: db}, nil
+}
+
+// LoadDatabase creates an assertion database with the system-wide trusted assertions
+// and the assertions stored in the `*.assert` files of the directory. Self-signed
+// account-keys, and the accounts of their authorities, in the directory are trusted
+func LoadDatabase(dir string) (*Database, error) {
+	trusted := sysdb.Trusted()
+	seen := map[string]bool{}
+	for _, a := range trusted {
+		seen[a.Ref().Unique()] = true
+	}
+
+	assertions, err := readAssertions(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	others := []asserts.Assertion{}
+	for _, a := range assertions {
+		if seen[a.Ref().Unique()] {
+			// Already known e.g. the system-wide trusted assertions
+			continue
+		}
+		seen[a.Ref().Unique()] = true
+
+		if isRootAssertion(a) {
+			trusted = append(trusted, a)
+		} else {
+			others = append(others, a)
+		}
+	}
+
+	return NewDatabase(trusted, others)
+}
+
+// VerifyEnrollment checks that the model and serial assertion have been signed
+// by keys that chain up to the trusted account-keys
+func (d *Database) VerifyEnrollment(model, serial asserts.Assertion) error {
+	// Use a temporary layer so the model can be checked by the serial
+	// assertion without adding it to the shared database
+	db := d.db.WithStackedBackstore(asserts.NewMemoryBackstore())
+
+	if err := db.Add(model); err != nil {
+		return fmt.Errorf("cannot verify model assertion: %v", err)
+	}
+	if err := db.Check(serial); err != nil {
+		return fmt.Errorf("cannot verify serial assertion: %v", err)
+	}
+	return nil
+}
+
+// addAll adds the assertions to the database, repeating the process until all the
+// prerequisites of each assertion are available
+func addAll(db *asserts.Database, assertions []asserts.Assertion) error {
+	pending := assertions
+	for len(pending) > 0 {
+		failed := []asserts.Assertion{}
+		var lastErr error
+		for _, a := range pending {
+			if err := db.Add(a); err != nil {
+				failed = append(failed, a)
+				lastErr = err
+			}
+		}
+
+		if len(failed) == len(pending) {
+			return fmt.Errorf("cannot add %s assertion %v: %v", failed[0].Type().Name, failed[0].Ref().PrimaryKey, lastErr)
+		}
+		pending = failed
+	}
+	return nil
+}
+
+// isRootAssertion checks for a self-signed account-key or the account of an authority
+func isRootAssertion(a asserts.Assertion) bool {
+	switch v := a.(type) {
+	case *asserts.AccountKey:
+		return v.AccountID() == v.AuthorityID() && v.PublicKeyID() == v.SignKeyID()
+	case *asserts.Account:
+		return v.AccountID() == v.AuthorityID()
+	}
+	return false
+}
+
+// readAssertions decodes the assertion files in a directory
+func readAssertions(dir string) ([]asserts.Assertion, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		log.Printf("No trusted assertions directory `%s`, using the system-wide trusted assertions\n", dir)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read trusted assertions: %v", err)
+	}
+
+	assertions := []asserts.Assertion{}
+	for _, f := range files {
+		if f.IsDir() || path.Ext(f.Name()) != assertionFileExt {
+			continue
+		}
+
+		r, err := os.Open(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read trusted assertions: %v", err)
+		}
+
+		dec := asserts.NewDecoder(r)
+		for {
+			a, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				r.Close()
+				return nil, fmt.Errorf("cannot decode assertion in `%s`: %v", f.Name(), err)
+			}
+			assertions = append(assertions, a)
+		}
+		r.Close()
+	}
+	return assertions, nil
+}
@@ -0,0 +1,208 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package trust
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+)
+
+type testChain struct {
+	store    *assertstest.StoreStack
+	accounts *assertstest.SigningAccounts
+}
+
+func newTestChain(keys *assertstest.StoreKeys) *testChain {
+	store := assertstest.NewStoreStack("canonical", keys)
+	accounts := assertstest.NewSigningAccounts(store)
+	brandKey, _ := assertstest.GenerateKey(752)
+	accounts.Register("example", brandKey, nil)
+	return &testChain{store, accounts}
+}
+
+// newStoreKeys generates a different root of trust to the default test keys
+func newStoreKeys() *assertstest.StoreKeys {
+	root, _ := assertstest.GenerateKey(1024)
+	store, _ := assertstest.GenerateKey(752)
+	generic, _ := assertstest.GenerateKey(752)
+	genericModels, _ := assertstest.GenerateKey(752)
+	return &assertstest.StoreKeys{Root: root, Store: store, Generic: generic, GenericModels: genericModels}
+}
+
+// chain returns the assertions that link the brand account-key to the trusted root
+func (c *testChain) chain(brand string) []asserts.Assertion {
+	return append([]asserts.Assertion{c.store.StoreAccountKey("")}, c.accounts.AccountsAndKeys(brand)...)
+}
+
+func (c *testChain) model(brand, model string) asserts.Assertion {
+	return c.accounts.Model(brand, model, map[string]interface{}{
+		"architecture": "amd64",
+		"gadget":       "pc",
+		"kernel":       "pc-kernel",
+	})
+}
+
+func (c *testChain) serial(brand, model, serial string) asserts.Assertion {
+	deviceKey, _ := assertstest.GenerateKey(752)
+	encodedKey, err := asserts.EncodePublicKey(deviceKey.PublicKey())
+	if err != nil {
+		panic(err)
+	}
+
+	a, err := c.accounts.Signing(brand).Sign(asserts.SerialType, map[string]interface{}{
+		"brand-id":            brand,
+		"model":               model,
+		"serial":              serial,
+		"device-key":          string(encodedKey),
+		"device-key-sha3-384": deviceKey.PublicKey().ID(),
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}, nil, "")
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func TestDatabase_VerifyEnrollment(t *testing.T) {
+	chain := newTestChain(nil)
+	other := newTestChain(newStoreKeys())
+
+	db, err := NewDatabase(chain.store.Trusted, chain.chain("example"))
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+
+	model := chain.model("example", "drone-1000")
+	serial := chain.serial("example", "drone-1000", "DR1000A111")
+	otherModel := other.model("example", "drone-1000")
+	otherSerial := other.serial("example", "drone-1000", "DR1000A111")
+	rootModel := chain.model("canonical", "ubuntu-core-18-amd64")
+	rootSerial := chain.serial("canonical", "ubuntu-core-18-amd64", "d75f7300")
+
+	tests := []struct {
+		name    string
+		model   asserts.Assertion
+		serial  asserts.Assertion
+		wantErr bool
+	}{
+		{"valid", model, serial, false},
+		{"valid-root", rootModel, rootSerial, false},
+		{"untrusted-model", otherModel, serial, true},
+		{"untrusted-serial", model, otherSerial, true},
+		{"untrusted-both", otherModel, otherSerial, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.VerifyEnrollment(tt.model, tt.serial); (err != nil) != tt.wantErr {
+				t.Errorf("Database.VerifyEnrollment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewDatabase(t *testing.T) {
+	chain := newTestChain(nil)
+	other := newTestChain(newStoreKeys())
+
+	tests := []struct {
+		name    string
+		trusted []asserts.Assertion
+		others  []asserts.Assertion
+		wantErr bool
+	}{
+		{"valid", chain.store.Trusted, chain.chain("example"), false},
+		{"valid-reversed", chain.store.Trusted, []asserts.Assertion{chain.accounts.AccountKey("example"), chain.accounts.Account("example"), chain.store.StoreAccountKey("")}, false},
+		{"untrusted", chain.store.Trusted, other.chain("example"), true},
+		{"invalid-trusted", []asserts.Assertion{chain.model("example", "drone-1000")}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDatabase(tt.trusted, tt.others); (err != nil) != tt.wantErr {
+				t.Errorf("NewDatabase() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadDatabase(t *testing.T) {
+	chain := newTestChain(nil)
+
+	dir, err := ioutil.TempDir("", "trusted")
+	if err != nil {
+		t.Fatalf("LoadDatabase() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := path.Join(dir, "valid")
+	invalid := path.Join(dir, "invalid")
+	_ = os.Mkdir(valid, 0700)
+	_ = os.Mkdir(invalid, 0700)
+
+	assertions := append(chain.store.Trusted, chain.chain("example")...)
+	data := []byte{}
+	for _, a := range assertions {
+		data = append(data, asserts.Encode(a)...)
+		data = append(data, '\n')
+	}
+	_ = ioutil.WriteFile(path.Join(valid, "chain.assert"), data, 0600)
+	_ = ioutil.WriteFile(path.Join(valid, "README"), []byte("ignored"), 0600)
+	_ = ioutil.WriteFile(path.Join(invalid, "bad.assert"), []byte("invalid"), 0600)
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"not-found", path.Join(dir, "not-found"), false},
+		{"invalid", invalid, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadDatabase(tt.dir)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadDatabase() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got == nil {
+				t.Errorf("LoadDatabase() = %v, want database", got)
+			}
+		})
+	}
+
+	db, err := LoadDatabase(valid)
+	if err != nil {
+		t.Fatalf("LoadDatabase() error = %v", err)
+	}
+	model := chain.model("example", "drone-1000")
+	serial := chain.serial("example", "drone-1000", "DR1000A111")
+	if err := db.VerifyEnrollment(model, serial); err != nil {
+		t.Errorf("LoadDatabase() verify error = %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/cert"
+	"github.com/canonical/iot-identity/service/webhook"
+)
+
+// Lengths of the shared secrets of webhooks
+const (
+	webhookSecretLength    = 32
+	webhookSecretMinLength = 16
+)
+
+// validateWebhook checks the URL and event types of a webhook subscription. Unless private
+// addresses are allowed, a URL with the address of a host that is not public is refused here,
+// and the webhook client also refuses to connect to one, for host names that resolve to them
+func validateWebhook(req *WebhookRegisterRequest, allowPrivate bool) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("the webhook URL `%s` must be an absolute http or https URL", req.URL)
+	}
+	if ip := net.ParseIP(u.Hostname()); !allowPrivate && (u.Hostname() == "localhost" || (ip != nil && !webhook.PublicAddress(ip))) {
+		return fmt.Errorf("the webhook URL `%s` must be for a public host", req.URL)
+	}
+	for _, t := range req.Events {
+		if !knownEventType(t) {
+			return fmt.Errorf("the event type `%s` is invalid", t)
+		}
+	}
+	if len(req.Secret) > 0 && len(req.Secret) < webhookSecretMinLength {
+		return fmt.Errorf("the webhook secret must be at least %d characters", webhookSecretMinLength)
+	}
+	return nil
+}
+
+func knownEventType(eventType domain.EventType) bool {
+	for _, t := range domain.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookRegister subscribes a URL to the events of the devices of an organization,
+// returning the ID and shared secret of the webhook. A generated secret is only
+// available now
+func (id IdentityService) WebhookRegister(caller *domain.Caller, orgID string, req *WebhookRegisterRequest) (string, string, error) {
+	webhookID, secret, err := id.webhookRegister(orgID, req)
+	id.audit(caller, domain.AuditEvent{
+		Action:         domain.ActionWebhookRegister,
+		OrganizationID: orgID,
+		Details:        fmt.Sprintf("webhook `%s` for %s", webhookID, req.URL),
+	}, err)
+	return webhookID, secret, err
+}
+
+func (id IdentityService) webhookRegister(orgID string, req *WebhookRegisterRequest) (string, string, error) {
+	if err := validateWebhook(req, id.Settings.WebhookAllowPrivate); err != nil {
+		return "", "", err
+	}
+	if _, err := id.DB.OrganizationGet(orgID); err != nil {
+		return "", "", fmt.Errorf("%w: `%s`", ErrOrganizationNotFound, orgID)
+	}
+
+	secret := req.Secret
+	if len(secret) == 0 {
+		var err error
+		if secret, err = cert.CreateSecret(webhookSecretLength); err != nil {
+			return "", "", fmt.Errorf("cannot generate the webhook secret: %v", err)
+		}
+	}
+
+	webhookID, err := id.DB.WebhookNew(domain.Webhook{
+		OrganizationID: orgID,
+		URL:            req.URL,
+		Events:         req.Events,
+		Secret:         []byte(secret),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return webhookID, secret, nil
+}
+
+// WebhookList fetches the webhooks of an organization, oldest first
+func (id IdentityService) WebhookList(orgID string) ([]domain.Webhook, error) {
+	return id.DB.WebhookList(orgID)
+}
+
+// webhookGet fetches a webhook of an organization
+func (id IdentityService) webhookGet(orgID, webhookID string) (*domain.Webhook, error) {
+	w, err := id.DB.WebhookGet(orgID, webhookID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %v", ErrWebhookNotFound, err)
+	}
+	return w, err
+}
+
+// WebhookDelete removes a webhook of an organization, with its delivery history
+func (id IdentityService) WebhookDelete(caller *domain.Caller, orgID, webhookID string) error {
+	err := id.DB.WebhookDelete(orgID, webhookID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		err = fmt.Errorf("%w: %v", ErrWebhookNotFound, err)
+	}
+	id.audit(caller, domain.AuditEvent{
+		Action:         domain.ActionWebhookDelete,
+		OrganizationID: orgID,
+		Details:        fmt.Sprintf("webhook `%s`", webhookID),
+	}, err)
+	return err
+}
+
+// WebhookDeliveries fetches the delivery history of a webhook, oldest first. The query
+// must select the webhook and its organization
+func (id IdentityService) WebhookDeliveries(query datastore.WebhookDeliveryQuery) ([]domain.WebhookDelivery, error) {
+	if _, err := id.webhookGet(query.OrganizationID, query.WebhookID); err != nil {
+		return nil, err
+	}
+	return id.DB.WebhookDeliveryList(query)
+}
+
+// WebhookTest posts a test payload to a webhook straight away, returning the delivery with
+// the response of the webhook. The delivery is recorded in the history of the webhook, but
+// is not retried when the webhook does not accept it
+func (id IdentityService) WebhookTest(caller *domain.Caller, orgID, webhookID string) (*domain.WebhookDelivery, error) {
+	delivery, err := id.webhookTest(orgID, webhookID)
+
+	audit := domain.AuditEvent{Action: domain.ActionWebhookTest, OrganizationID: orgID, Details: fmt.Sprintf("webhook `%s`", webhookID)}
+	if delivery != nil {
+		audit.Details = fmt.Sprintf("webhook `%s` responded %d", webhookID, delivery.ResponseCode)
+	}
+	id.audit(caller, audit, err)
+	return delivery, err
+}
+
+func (id IdentityService) webhookTest(orgID, webhookID string) (*domain.WebhookDelivery, error) {
+	w, err := id.webhookGet(orgID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	delivery := domain.WebhookDelivery{
+		ID:             datastore.GenerateID(),
+		WebhookID:      w.ID,
+		OrganizationID: orgID,
+		Type:           domain.EventWebhookTest,
+		Attempts:       1,
+		NextAttempt:    now,
+		Created:        now,
+	}
+	if delivery.Payload, err = webhook.TestPayload(delivery.ID, orgID, now); err != nil {
+		return nil, err
+	}
+
+	delivery.ResponseCode, err = webhook.Send(id.WebhookClient, *w, delivery, now)
+	if err != nil {
+		delivery.Status, delivery.LastError = domain.OutboxFailed, err.Error()
+	} else {
+		delivery.Status, delivery.Delivered = domain.OutboxDelivered, now
+	}
+	if err := id.DB.WebhookDeliveryNew(delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// privateNetworks are the address ranges of private networks, which are not reachable from
+// the internet: this network (RFC 1122), RFC 1918, shared address space (RFC 6598) and IPv6
+// unique local addresses
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// DefaultClient is the HTTP client that posts the payloads. It only connects to public
+// addresses, and does not follow redirects, so that a webhook cannot be used to reach the
+// services on the network of the identity service
+var DefaultClient = NewClient(false)
+
+// NewClient creates the HTTP client that posts the payloads, which does not follow redirects.
+// It only connects to public addresses unless private addresses are allowed, for on-premises
+// deployments with receivers on the local network
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: DefaultTimeout}
+	if !allowPrivate {
+		dialer.Control = dialPublic
+	}
+	return &http.Client{
+		Timeout: DefaultTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: DefaultTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// dialPublic refuses a connection to an address that is not public. It checks the address
+// that is dialled, after the host name is resolved
+func dialPublic(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !PublicAddress(ip) {
+		return fmt.Errorf("the webhook address `%s` is not a public address", host)
+	}
+	return nil
+}
+
+// PublicAddress checks whether an IP address is reachable from the internet, rather than
+// being a loopback, link-local, private, multicast or unspecified address
+func PublicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := PublicAddress(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("PublicAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultClient_local(t *testing.T) {
+	rc, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	code, err := Send(DefaultClient, domain.Webhook{URL: server.URL}, domain.WebhookDelivery{Payload: "{}"}, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "not a public address") || code != 0 {
+		t.Errorf("Send() = %d, %v, want the local address refused", code, err)
+	}
+	if len(rc.requests) != 0 {
+		t.Errorf("Send() posted %d requests to a local address", len(rc.requests))
+	}
+}
+
+func TestNewClient_private(t *testing.T) {
+	rc, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	code, err := Send(NewClient(true), domain.Webhook{URL: server.URL}, domain.WebhookDelivery{Payload: "{}"}, time.Now())
+	if err != nil || code != http.StatusOK || len(rc.requests) != 1 {
+		t.Errorf("Send() = %d, %v, want the local address allowed", code, err)
+	}
+}
+
+func TestDefaultClient_redirect(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodPost, "https://hooks.example.com/identity", nil)
+	if err := DefaultClient.CheckRedirect(r, []*http.Request{r}); err != http.ErrUseLastResponse {
+		t.Errorf("DefaultClient.CheckRedirect() = %v, want the redirect not followed", err)
+	}
+}
@@ -0,0 +1,152 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/outbox"
+)
+
+// Store is the part of the data store that holds the webhooks and their deliveries
+type Store interface {
+	WebhookGet(orgID, webhookID string) (*domain.Webhook, error)
+	WebhookList(orgID string) ([]domain.Webhook, error)
+	WebhookDeliveryNew(delivery domain.WebhookDelivery) error
+	WebhookDeliveryList(query datastore.WebhookDeliveryQuery) ([]domain.WebhookDelivery, error)
+	WebhookDeliveryUpdate(delivery domain.WebhookDelivery) error
+	WebhookDeliveryClaim(now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error)
+	WebhookDeliveryPrune(before time.Time) (int, error)
+}
+
+// Dispatcher posts the pending deliveries to their webhooks. Failed deliveries are retried
+// with a backoff that doubles from Backoff up to MaxBackoff, and are dead-lettered after
+// MaxAttempts. Delivered deliveries are pruned after the Retention period, and the failed
+// ones are kept as the delivery history of the webhook. The dispatcher claims the deliveries
+// that it posts for the Lease period, so several dispatchers can share the deliveries
+type Dispatcher struct {
+	Store       Store
+	Client      *http.Client
+	Interval    time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Retention   time.Duration
+	Lease       time.Duration
+}
+
+// NewDispatcher creates a dispatcher of the webhook deliveries with the default delivery
+// settings of the outbox
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		Store:       store,
+		Client:      DefaultClient,
+		Interval:    outbox.DefaultInterval,
+		MaxAttempts: outbox.DefaultMaxAttempts,
+		Backoff:     outbox.DefaultBackoff,
+		MaxBackoff:  outbox.DefaultMaxBackoff,
+		Retention:   outbox.DefaultRetention,
+		Lease:       outbox.DefaultLease,
+	}
+}
+
+// Run posts the deliveries on the configured schedule. It does not return
+func (d *Dispatcher) Run() {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.Dispatch(time.Now()); err != nil {
+			log.Println("Error dispatching the webhook deliveries:", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Dispatch posts the pending deliveries that are due, and prunes the delivered ones that
+// are past the retention period. It returns the number of deliveries that were accepted
+func (d *Dispatcher) Dispatch(now time.Time) (int, error) {
+	deliveries, err := d.Store.WebhookDeliveryClaim(now, d.Lease, datastore.MaxPageLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	webhooks := map[string]*domain.Webhook{}
+	for _, dl := range deliveries {
+		w, ok := webhooks[dl.WebhookID]
+		if !ok {
+			w, err = d.Store.WebhookGet(dl.OrganizationID, dl.WebhookID)
+			if errors.Is(err, datastore.ErrNotFound) {
+				// The webhook was deleted with its deliveries
+				continue
+			}
+			if err != nil {
+				return delivered, err
+			}
+			webhooks[dl.WebhookID] = w
+		}
+
+		if d.attempt(*w, &dl, now) {
+			delivered++
+		}
+		// Another dispatcher has claimed the delivery since its lease ended, and records its outcome
+		err := d.Store.WebhookDeliveryUpdate(dl)
+		if errors.Is(err, datastore.ErrLeaseLost) {
+			log.Printf("Error recording the delivery `%s` to webhook `%s`: %v\n", dl.ID, w.ID, err)
+			continue
+		}
+		if err != nil {
+			return delivered, err
+		}
+	}
+
+	if d.Retention > 0 {
+		if _, err := d.Store.WebhookDeliveryPrune(now.Add(-d.Retention)); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// attempt posts a delivery to its webhook, updating its delivery state
+func (d *Dispatcher) attempt(w domain.Webhook, dl *domain.WebhookDelivery, now time.Time) bool {
+	dl.Attempts++
+	code, err := Send(d.Client, w, *dl, now)
+	dl.ResponseCode = code
+	if err != nil {
+		dl.LastError = err.Error()
+		if dl.Attempts >= d.MaxAttempts {
+			log.Printf("Error delivering to webhook `%s` after %d attempts: %s\n", w.ID, dl.Attempts, dl.LastError)
+			dl.Status = domain.OutboxFailed
+		} else {
+			dl.NextAttempt = now.Add(outbox.RetryDelay(dl.Attempts, d.Backoff, d.MaxBackoff))
+		}
+		return false
+	}
+
+	dl.Status, dl.Delivered, dl.LastError = domain.OutboxDelivered, now, ""
+	return true
+}
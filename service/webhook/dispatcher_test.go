@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+)
+
+func pendingDelivery(deliveryID, webhookID string, attempts int, due time.Time) domain.WebhookDelivery {
+	return domain.WebhookDelivery{ID: deliveryID, WebhookID: webhookID, OrganizationID: "abc", EventID: "e-" + deliveryID, Type: domain.EventDeviceEnroll,
+		Payload: `{"id":"e-` + deliveryID + `"}`, Status: domain.OutboxPending, Attempts: attempts, NextAttempt: due, Created: due}
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name         string
+		status       int
+		deliveries   []domain.WebhookDelivery
+		wantRequests int
+		wantCount    int
+		wantStatus   []domain.OutboxStatus
+		wantAttempts []int
+	}{
+		{"delivered", http.StatusOK, []domain.WebhookDelivery{pendingDelivery("d1", "w1", 0, now), pendingDelivery("d2", "w1", 0, now)},
+			2, 2, []domain.OutboxStatus{domain.OutboxDelivered, domain.OutboxDelivered}, []int{1, 1}},
+		{"failed", http.StatusServiceUnavailable, []domain.WebhookDelivery{pendingDelivery("d1", "w1", 0, now), pendingDelivery("d2", "w1", 0, now)},
+			2, 0, []domain.OutboxStatus{domain.OutboxPending, domain.OutboxPending}, []int{1, 1}},
+		{"not-due", http.StatusOK, []domain.WebhookDelivery{pendingDelivery("d1", "w1", 1, now.Add(time.Minute)), pendingDelivery("d2", "w1", 0, now)},
+			1, 1, []domain.OutboxStatus{domain.OutboxPending, domain.OutboxDelivered}, []int{1, 1}},
+		{"dead-lettered", http.StatusInternalServerError, []domain.WebhookDelivery{pendingDelivery("d1", "w1", 2, now)},
+			1, 0, []domain.OutboxStatus{domain.OutboxFailed}, []int{3}},
+		{"deleted-webhook", http.StatusOK, []domain.WebhookDelivery{pendingDelivery("d1", "invalid", 0, now), pendingDelivery("d2", "w1", 0, now)},
+			1, 1, []domain.OutboxStatus{domain.OutboxPending, domain.OutboxDelivered}, []int{0, 1}},
+		{"skips-failed", http.StatusOK, []domain.WebhookDelivery{{ID: "d1", WebhookID: "w1", OrganizationID: "abc", Status: domain.OutboxFailed, Attempts: 3}},
+			0, 0, []domain.OutboxStatus{domain.OutboxFailed}, []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, server := newReceiver(tt.status)
+			defer server.Close()
+
+			db := memory.NewStore()
+			db.Webhooks = []domain.Webhook{{ID: "w1", OrganizationID: "abc", URL: server.URL, Secret: []byte("secret")}}
+			db.Deliveries = append([]domain.WebhookDelivery{}, tt.deliveries...)
+			d := NewDispatcher(db)
+			d.Client = server.Client()
+			d.MaxAttempts = 3
+
+			got, err := d.Dispatch(now)
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			if got != tt.wantCount {
+				t.Errorf("Dispatch() = %d, want %d", got, tt.wantCount)
+			}
+			if len(rc.requests) != tt.wantRequests {
+				t.Errorf("Dispatch() made %d requests, want %d", len(rc.requests), tt.wantRequests)
+			}
+			for i, dl := range db.Deliveries {
+				if dl.Status != tt.wantStatus[i] || dl.Attempts != tt.wantAttempts[i] {
+					t.Errorf("Dispatch() delivery %s = %d/%d attempts, want %d/%d attempts", dl.ID, dl.Status, dl.Attempts, tt.wantStatus[i], tt.wantAttempts[i])
+				}
+				switch {
+				case dl.Status == domain.OutboxDelivered && (!dl.Delivered.Equal(now) || dl.ResponseCode != tt.status || len(dl.LastError) > 0):
+					t.Errorf("Dispatch() delivery %s = %+v, want it delivered now", dl.ID, dl)
+				case dl.Status != domain.OutboxDelivered && dl.Attempts > tt.deliveries[i].Attempts && (dl.ResponseCode != tt.status || len(dl.LastError) == 0):
+					t.Errorf("Dispatch() delivery %s = %+v, want the status and error of the failed attempt", dl.ID, dl)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcher_DispatchRetry(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rc, server := newReceiver(http.StatusBadGateway)
+	defer server.Close()
+
+	db := memory.NewStore()
+	db.Webhooks = []domain.Webhook{{ID: "w1", OrganizationID: "abc", URL: server.URL, Secret: []byte("secret")}}
+	db.Deliveries = []domain.WebhookDelivery{pendingDelivery("d1", "w1", 0, now)}
+	d := NewDispatcher(db)
+	d.Client = server.Client()
+
+	if _, err := d.Dispatch(now); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if dl := db.Deliveries[0]; !dl.NextAttempt.Equal(now.Add(d.Backoff)) || dl.LastError != "the response status is 502" {
+		t.Fatalf("Dispatch() delivery = %+v, want a retry after the backoff", dl)
+	}
+
+	// The delivery is not attempted again until it is due, and then backs off further
+	if got, _ := d.Dispatch(now.Add(d.Backoff / 2)); got != 0 || len(rc.requests) != 1 {
+		t.Errorf("Dispatch() = %d, want no attempt before the retry", got)
+	}
+	if _, err := d.Dispatch(now.Add(d.Backoff)); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if dl := db.Deliveries[0]; !dl.NextAttempt.Equal(now.Add(3*d.Backoff)) || dl.Attempts != 2 {
+		t.Fatalf("Dispatch() delivery = %+v, want the backoff doubled", dl)
+	}
+
+	rc.status = http.StatusAccepted
+	if got, _ := d.Dispatch(now.Add(3 * d.Backoff)); got != 1 {
+		t.Errorf("Dispatch() = %d, want the retry delivered", got)
+	}
+
+	// The delivered delivery is pruned after the retention period
+	if _, err := d.Dispatch(now.Add(3*d.Backoff + d.Retention + time.Second)); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if len(db.Deliveries) != 0 {
+		t.Errorf("Dispatch() deliveries = %v, want the delivered delivery pruned", db.Deliveries)
+	}
+}
+
+func TestDispatcher_DispatchClaimed(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rc, server := newReceiver(http.StatusOK)
+	defer server.Close()
+
+	db := memory.NewStore()
+	db.Webhooks = []domain.Webhook{{ID: "w1", OrganizationID: "abc", URL: server.URL, Secret: []byte("secret")}}
+	db.Deliveries = []domain.WebhookDelivery{pendingDelivery("d1", "w1", 0, now), pendingDelivery("d2", "w1", 0, now)}
+	d := NewDispatcher(db)
+	d.Client = server.Client()
+
+	// Another dispatcher has claimed the first delivery, which it has not posted
+	claimed, err := db.WebhookDeliveryClaim(now, d.Lease, 1)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("WebhookDeliveryClaim() = %v, %v, want the first delivery", claimed, err)
+	}
+	if got, _ := d.Dispatch(now); got != 1 || len(rc.requests) != 1 || db.Deliveries[1].Status != domain.OutboxDelivered {
+		t.Errorf("Dispatch() = %d, want the unclaimed delivery posted", got)
+	}
+
+	// The delivery is posted once the claim ends, and the other dispatcher cannot record it
+	if got, _ := d.Dispatch(now.Add(d.Lease - time.Second)); got != 0 {
+		t.Errorf("Dispatch() = %d, want no delivery while it is claimed", got)
+	}
+	if got, _ := d.Dispatch(now.Add(d.Lease)); got != 1 || len(rc.requests) != 2 {
+		t.Errorf("Dispatch() = %d, want the delivery posted after the lease", got)
+	}
+	if err := db.WebhookDeliveryUpdate(claimed[0]); !errors.Is(err, datastore.ErrLeaseLost) {
+		t.Errorf("WebhookDeliveryUpdate() error = %v, want ErrLeaseLost", err)
+	}
+}
@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// Sink queues the deliveries of the outbox events of an organization to the webhooks that
+// subscribe to them, which the dispatcher then posts and retries for each webhook on its
+// own, so that one failing webhook does not hold back the other sinks
+type Sink struct {
+	Store Store
+}
+
+// Name identifies the sink in the errors of failed deliveries
+func (s Sink) Name() string {
+	return "webhooks"
+}
+
+// Deliver queues a delivery of the event to every webhook that subscribes to it. An event
+// that is delivered again is only queued for the webhooks that do not have it yet
+func (s Sink) Deliver(event domain.OutboxEvent) error {
+	webhooks, err := s.Store.WebhookList(event.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	var payload string
+	for _, w := range webhooks {
+		if !w.Subscribed(event.Type) {
+			continue
+		}
+		queued, err := s.Store.WebhookDeliveryList(datastore.WebhookDeliveryQuery{WebhookID: w.ID, EventID: event.ID, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(queued) > 0 {
+			continue
+		}
+
+		if len(payload) == 0 {
+			if payload, err = EventPayload(event); err != nil {
+				return err
+			}
+		}
+		err = s.Store.WebhookDeliveryNew(domain.WebhookDelivery{
+			ID:             datastore.GenerateID(),
+			WebhookID:      w.ID,
+			OrganizationID: event.OrganizationID,
+			EventID:        event.ID,
+			Type:           event.Type,
+			Payload:        payload,
+			Status:         domain.OutboxPending,
+			NextAttempt:    event.Created,
+			Created:        event.Created,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestSink_Deliver(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	webhooks := []domain.Webhook{
+		{ID: "w1", OrganizationID: "abc", URL: "https://hooks.example.com/1"},
+		{ID: "w2", OrganizationID: "abc", URL: "https://hooks.example.com/2", Events: []domain.EventType{domain.EventDeviceEnrollFailed}},
+		{ID: "w3", OrganizationID: "def", URL: "https://hooks.example.com/3"},
+	}
+	tests := []struct {
+		name      string
+		event     domain.OutboxEvent
+		queued    []domain.WebhookDelivery
+		wantHooks []string
+	}{
+		{"every-event", domain.OutboxEvent{ID: "e1", Type: domain.EventDeviceEnroll, OrganizationID: "abc", DeviceID: "a111", Created: now},
+			nil, []string{"w1"}},
+		{"subscribed", domain.OutboxEvent{ID: "e1", Type: domain.EventDeviceEnrollFailed, OrganizationID: "abc", DeviceID: "a111", Created: now},
+			nil, []string{"w1", "w2"}},
+		{"redelivered", domain.OutboxEvent{ID: "e1", Type: domain.EventDeviceEnrollFailed, OrganizationID: "abc", DeviceID: "a111", Created: now},
+			[]domain.WebhookDelivery{{ID: "d1", WebhookID: "w1", OrganizationID: "abc", EventID: "e1", Status: domain.OutboxDelivered}}, []string{"w1", "w2"}},
+		{"reissue", domain.OutboxEvent{ID: "e1", Type: domain.EventDeviceReissue, OrganizationID: "abc", DeviceID: "a111", Created: now},
+			nil, []string{}},
+		{"no-webhooks", domain.OutboxEvent{ID: "e1", Type: domain.EventDeviceEnroll, OrganizationID: "ghi", DeviceID: "a111", Created: now},
+			nil, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore()
+			db.Webhooks = webhooks
+			db.Deliveries = append([]domain.WebhookDelivery{}, tt.queued...)
+
+			if err := (Sink{Store: db}).Deliver(tt.event); err != nil {
+				t.Fatalf("Deliver() error = %v", err)
+			}
+
+			got, _ := db.WebhookDeliveryList(datastore.WebhookDeliveryQuery{EventID: tt.event.ID})
+			hooks := []string{}
+			for _, d := range got {
+				hooks = append(hooks, d.WebhookID)
+				if d.ID == "d1" {
+					continue
+				}
+				if d.OrganizationID != tt.event.OrganizationID || d.Type != tt.event.Type || d.Status != domain.OutboxPending ||
+					!d.NextAttempt.Equal(now) || len(d.Payload) == 0 {
+					t.Errorf("Deliver() delivery = %+v, want it pending", d)
+				}
+			}
+			if !reflect.DeepEqual(hooks, tt.wantHooks) {
+				t.Errorf("Deliver() webhooks = %v, want %v", hooks, tt.wantHooks)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package webhook posts the events of the devices of an organization to the webhooks that
+// it subscribes, as JSON payloads that are signed with the shared secret of the webhook
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// Headers of the requests that deliver the payloads
+const (
+	HeaderSignature = "X-Identity-Signature"
+	HeaderTimestamp = "X-Identity-Timestamp"
+	HeaderEvent     = "X-Identity-Event"
+	HeaderDelivery  = "X-Identity-Delivery"
+)
+
+// SignaturePrefix names the algorithm of the signature header
+const SignaturePrefix = "sha256="
+
+// DefaultTimeout is the time that a webhook has to respond to a delivery
+const DefaultTimeout = 10 * time.Second
+
+// Payload is the JSON that is posted to a webhook. The ID is the outbox event, so that
+// receivers can ignore an event that is delivered again. A test payload has no device
+type Payload struct {
+	ID             string           `json:"id"`
+	Type           domain.EventType `json:"type"`
+	Created        time.Time        `json:"created"`
+	OrganizationID string           `json:"orgid"`
+	Device         *Device          `json:"device,omitempty"`
+}
+
+// Device is the summary of a device in a payload, which leaves out its credentials
+type Device struct {
+	ID         string        `json:"id"`
+	Brand      string        `json:"brand"`
+	Model      string        `json:"model"`
+	Serial     string        `json:"serial"`
+	Status     domain.Status `json:"status"`
+	DeviceData string        `json:"deviceData,omitempty"`
+	EnrolledAt time.Time     `json:"enrolledAt"`
+}
+
+// EventPayload encodes the payload of an outbox event
+func EventPayload(event domain.OutboxEvent) (string, error) {
+	en := event.Device
+	return encode(Payload{
+		ID:             event.ID,
+		Type:           event.Type,
+		Created:        event.Created,
+		OrganizationID: event.OrganizationID,
+		Device: &Device{
+			ID:         event.DeviceID,
+			Brand:      en.Device.Brand,
+			Model:      en.Device.Model,
+			Serial:     en.Device.SerialNumber,
+			Status:     en.Status,
+			DeviceData: en.DeviceData,
+			EnrolledAt: en.EnrolledAt,
+		},
+	})
+}
+
+// TestPayload encodes the payload of a test delivery to a webhook
+func TestPayload(deliveryID, orgID string, now time.Time) (string, error) {
+	return encode(Payload{ID: deliveryID, Type: domain.EventWebhookTest, Created: now, OrganizationID: orgID})
+}
+
+func encode(payload Payload) (string, error) {
+	data, err := json.Marshal(payload)
+	return string(data), err
+}
+
+// Sign returns the signature header of a payload: the HMAC-SHA256 of the timestamp and the
+// payload, joined by a dot, with the secret of the webhook
+func Sign(secret []byte, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + payload))
+	return SignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header of a payload, as the receiver of a webhook does
+func Verify(secret []byte, timestamp, payload, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, payload)), []byte(signature))
+}
+
+// Send posts the payload of a delivery to its webhook. It returns the HTTP status of the
+// response, with an error when the webhook cannot be reached or does not accept the payload
+func Send(client *http.Client, webhook domain.Webhook, delivery domain.WebhookDelivery, now time.Time) (int, error) {
+	r, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, delivery.Payload))
+	r.Header.Set(HeaderEvent, string(delivery.Type))
+	r.Header.Set(HeaderDelivery, delivery.ID)
+
+	w, err := client.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Body.Close()
+	// Read some of the body so that the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(w.Body, 4096))
+
+	if w.StatusCode < 200 || w.StatusCode > 299 {
+		return w.StatusCode, fmt.Errorf("the response status is %d", w.StatusCode)
+	}
+	return w.StatusCode, nil
+}
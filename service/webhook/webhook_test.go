@@ -0,0 +1,166 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+// receiver is a local webhook that records the requests it is given, responding with status
+type receiver struct {
+	status   int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, string(body))
+	w.WriteHeader(rc.status)
+}
+
+func newReceiver(status int) (*receiver, *httptest.Server) {
+	rc := &receiver{status: status}
+	return rc, httptest.NewServer(rc)
+}
+
+func TestSign(t *testing.T) {
+	secret := []byte("secret")
+	signature := Sign(secret, "1577934245", `{"id":"e1"}`)
+	if !strings.HasPrefix(signature, SignaturePrefix) || len(signature) != len(SignaturePrefix)+64 {
+		t.Fatalf("Sign() = %s, want a hex HMAC-SHA256", signature)
+	}
+
+	tests := []struct {
+		name      string
+		secret    []byte
+		timestamp string
+		payload   string
+		want      bool
+	}{
+		{"valid", secret, "1577934245", `{"id":"e1"}`, true},
+		{"invalid-secret", []byte("other"), "1577934245", `{"id":"e1"}`, false},
+		{"invalid-timestamp", secret, "1577934246", `{"id":"e1"}`, false},
+		{"invalid-payload", secret, "1577934245", `{"id":"e2"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.timestamp, tt.payload, signature); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventPayload(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := domain.OutboxEvent{
+		ID: "e1", Type: domain.EventDeviceEnroll, OrganizationID: "abc", DeviceID: "a111", Created: created,
+		Device: domain.Enrollment{
+			ID:          "a111",
+			Device:      domain.Device{Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", DeviceKey: "AAAAAAAAA"},
+			Credentials: domain.Credentials{Certificate: []byte("CERT")},
+			Status:      domain.StatusEnrolled,
+			EnrolledAt:  created,
+		},
+	}
+
+	got, err := EventPayload(event)
+	if err != nil {
+		t.Fatalf("EventPayload() error = %v", err)
+	}
+	if strings.Contains(got, "CERT") || strings.Contains(got, "AAAAAAAAA") {
+		t.Errorf("EventPayload() = %s, want the credentials left out", got)
+	}
+
+	payload := Payload{}
+	if err := json.Unmarshal([]byte(got), &payload); err != nil {
+		t.Fatalf("EventPayload() is not JSON: %v", err)
+	}
+	want := Device{ID: "a111", Brand: "example", Model: "drone-1000", Serial: "DR1000A111", Status: domain.StatusEnrolled, EnrolledAt: created}
+	if payload.ID != "e1" || payload.Type != domain.EventDeviceEnroll || payload.OrganizationID != "abc" || !payload.Created.Equal(created) ||
+		payload.Device == nil || *payload.Device != want {
+		t.Errorf("EventPayload() = %+v, want the event and device summary", payload)
+	}
+}
+
+func TestSend(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	payload, _ := TestPayload("d1", "abc", now)
+	delivery := domain.WebhookDelivery{ID: "d1", Type: domain.EventWebhookTest, Payload: payload}
+
+	tests := []struct {
+		name     string
+		status   int
+		wantCode int
+		wantErr  bool
+	}{
+		{"accepted", http.StatusNoContent, http.StatusNoContent, false},
+		{"rejected", http.StatusBadRequest, http.StatusBadRequest, true},
+		{"server-error", http.StatusInternalServerError, http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, server := newReceiver(tt.status)
+			defer server.Close()
+			webhook := domain.Webhook{ID: "w1", URL: server.URL + "/hook", Secret: []byte("secret")}
+
+			code, err := Send(server.Client(), webhook, delivery, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if code != tt.wantCode {
+				t.Errorf("Send() = %d, want %d", code, tt.wantCode)
+			}
+			if len(rc.requests) != 1 {
+				t.Fatalf("Send() made %d requests, want 1", len(rc.requests))
+			}
+
+			r := rc.requests[0]
+			if r.Method != http.MethodPost || r.URL.Path != "/hook" || r.Header.Get("Content-Type") != "application/json" ||
+				r.Header.Get(HeaderEvent) != string(domain.EventWebhookTest) || r.Header.Get(HeaderDelivery) != "d1" ||
+				r.Header.Get(HeaderTimestamp) != "1577934245" || rc.bodies[0] != payload {
+				t.Errorf("Send() request = %v %v %v, want the signed payload", r.Method, r.URL, r.Header)
+			}
+			if !Verify(webhook.Secret, r.Header.Get(HeaderTimestamp), rc.bodies[0], r.Header.Get(HeaderSignature)) {
+				t.Errorf("Send() signature = %s, want it to verify", r.Header.Get(HeaderSignature))
+			}
+		})
+	}
+}
+
+func TestSend_unreachable(t *testing.T) {
+	_, server := newReceiver(http.StatusOK)
+	server.Close()
+
+	code, err := Send(server.Client(), domain.Webhook{URL: server.URL}, domain.WebhookDelivery{Payload: "{}"}, time.Now())
+	if err == nil || code != 0 {
+		t.Errorf("Send() = %d, %v, want an error", code, err)
+	}
+}
@@ -0,0 +1,183 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canonical/iot-identity/config"
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/datastore/memory"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service/webhook"
+)
+
+func TestIdentityService_WebhookRegister(t *testing.T) {
+	caller := &domain.Caller{Subject: "admin-test", OrganizationID: "abc", Role: domain.RoleOrgAdmin}
+	tests := []struct {
+		name       string
+		orgID      string
+		req        *WebhookRegisterRequest
+		wantSecret string
+		wantErr    error
+	}{
+		{"valid", "abc", &WebhookRegisterRequest{URL: "https://hooks.example.com/identity", Events: []domain.EventType{domain.EventDeviceEnroll}, Secret: "0123456789abcdef"}, "0123456789abcdef", nil},
+		{"generated-secret", "abc", &WebhookRegisterRequest{URL: "http://hooks.example.com:8080/identity"}, "", nil},
+		{"invalid-localhost", "abc", &WebhookRegisterRequest{URL: "http://localhost:8080/identity"}, "", errors.New("")},
+		{"invalid-loopback", "abc", &WebhookRegisterRequest{URL: "http://127.0.0.1:8080/identity"}, "", errors.New("")},
+		{"invalid-link-local", "abc", &WebhookRegisterRequest{URL: "http://169.254.169.254/latest/meta-data"}, "", errors.New("")},
+		{"invalid-private", "abc", &WebhookRegisterRequest{URL: "https://[fd00::1]/identity"}, "", errors.New("")},
+		{"invalid-scheme", "abc", &WebhookRegisterRequest{URL: "ftp://hooks.example.com"}, "", errors.New("")},
+		{"invalid-relative", "abc", &WebhookRegisterRequest{URL: "/identity"}, "", errors.New("")},
+		{"invalid-event", "abc", &WebhookRegisterRequest{URL: "https://hooks.example.com", Events: []domain.EventType{"device-invalid"}}, "", errors.New("")},
+		{"invalid-test-event", "abc", &WebhookRegisterRequest{URL: "https://hooks.example.com", Events: []domain.EventType{domain.EventWebhookTest}}, "", errors.New("")},
+		{"short-secret", "abc", &WebhookRegisterRequest{URL: "https://hooks.example.com", Secret: "secret"}, "", errors.New("")},
+		{"invalid-org", "invalid", &WebhookRegisterRequest{URL: "https://hooks.example.com"}, "", ErrOrganizationNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(&config.Settings{}, db, nil)
+
+			webhookID, secret, err := id.WebhookRegister(caller, tt.orgID, tt.req)
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("IdentityService.WebhookRegister() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(tt.wantErr, ErrOrganizationNotFound) && !errors.Is(err, ErrOrganizationNotFound) {
+				t.Errorf("IdentityService.WebhookRegister() error = %v, want %v", err, tt.wantErr)
+			}
+
+			page, _ := db.AuditList(datastore.AuditQuery{Action: domain.ActionWebhookRegister})
+			if len(page.Events) != 1 || page.Events[0].Success != (err == nil) {
+				t.Errorf("IdentityService.WebhookRegister() audit = %+v", page.Events)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(tt.wantSecret) > 0 && secret != tt.wantSecret {
+				t.Errorf("IdentityService.WebhookRegister() secret = %s, want %s", secret, tt.wantSecret)
+			}
+			if len(secret) < webhookSecretMinLength {
+				t.Errorf("IdentityService.WebhookRegister() secret = %s, want a generated secret", secret)
+			}
+			got, err := db.WebhookGet(tt.orgID, webhookID)
+			if err != nil || got.URL != tt.req.URL || string(got.Secret) != secret {
+				t.Errorf("IdentityService.WebhookRegister() stored %+v, error = %v", got, err)
+			}
+		})
+	}
+}
+
+func TestIdentityService_WebhookDelete(t *testing.T) {
+	caller := &domain.Caller{Subject: "admin-test", Role: domain.RoleSuperuser}
+	tests := []struct {
+		name    string
+		orgID   string
+		wantErr error
+	}{
+		{"valid", "abc", nil},
+		{"other-org", "def", ErrWebhookNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(&config.Settings{}, db, nil)
+			webhookID, _, err := id.WebhookRegister(caller, "abc", &WebhookRegisterRequest{URL: "https://hooks.example.com"})
+			if err != nil {
+				t.Fatalf("IdentityService.WebhookRegister() error = %v", err)
+			}
+
+			if err := id.WebhookDelete(caller, tt.orgID, webhookID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IdentityService.WebhookDelete() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := id.WebhookDeliveries(datastore.WebhookDeliveryQuery{OrganizationID: tt.orgID, WebhookID: webhookID}); !errors.Is(err, ErrWebhookNotFound) {
+				t.Errorf("IdentityService.WebhookDeliveries() error = %v, want %v", err, ErrWebhookNotFound)
+			}
+			webhooks, _ := id.WebhookList("abc")
+			if (len(webhooks) == 0) != (tt.wantErr == nil) {
+				t.Errorf("IdentityService.WebhookList() = %v", webhooks)
+			}
+		})
+	}
+}
+
+func TestIdentityService_WebhookTest(t *testing.T) {
+	caller := &domain.Caller{Subject: "admin-test", Role: domain.RoleSuperuser}
+	tests := []struct {
+		name       string
+		status     int
+		webhookID  string
+		wantStatus domain.OutboxStatus
+		wantErr    error
+	}{
+		{"accepted", http.StatusOK, "", domain.OutboxDelivered, nil},
+		{"rejected", http.StatusUnauthorized, "", domain.OutboxFailed, nil},
+		{"invalid-webhook", http.StatusOK, "invalid", 0, ErrWebhookNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r *http.Request
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				r = req
+				body, _ = ioutil.ReadAll(req.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			// The local server is not a public address, which the settings allow
+			db := memory.NewStore(memory.WithFixtures())
+			id := NewIdentityService(&config.Settings{WebhookAllowPrivate: true}, db, nil)
+			secret := "0123456789abcdef"
+			webhookID, _, err := id.WebhookRegister(caller, "abc", &WebhookRegisterRequest{URL: server.URL, Secret: secret})
+			if err != nil {
+				t.Fatalf("IdentityService.WebhookRegister() error = %v", err)
+			}
+			if len(tt.webhookID) > 0 {
+				webhookID = tt.webhookID
+			}
+
+			got, err := id.WebhookTest(caller, "abc", webhookID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IdentityService.WebhookTest() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Status != tt.wantStatus || got.ResponseCode != tt.status || got.Type != domain.EventWebhookTest || got.Attempts != 1 {
+				t.Errorf("IdentityService.WebhookTest() = %+v, want %d from the webhook", got, tt.status)
+			}
+			if r == nil || !webhook.Verify([]byte(secret), r.Header.Get(webhook.HeaderTimestamp), string(body), r.Header.Get(webhook.HeaderSignature)) {
+				t.Errorf("IdentityService.WebhookTest() did not post a signed payload")
+			}
+
+			// The test is in the delivery history, and is not retried
+			history, err := id.WebhookDeliveries(datastore.WebhookDeliveryQuery{OrganizationID: "abc", WebhookID: webhookID})
+			if err != nil || len(history) != 1 || history[0].ID != got.ID || history[0].Status != tt.wantStatus {
+				t.Errorf("IdentityService.WebhookDeliveries() = %+v, error = %v", history, err)
+			}
+		})
+	}
+}
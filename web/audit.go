@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+)
+
+// AuditList fetches a page of the audit log. Superusers can view every event, and
+// organization admins can view the events of their own organization
+func (wb IdentityService) AuditList(w http.ResponseWriter, r *http.Request) {
+	query, err := auditQuery(r.URL.Query())
+	if err != nil {
+		formatStandardResponse("AuditList", err.Error(), w)
+		return
+	}
+	if !authorize(w, r, query.OrganizationID, domain.RoleOrgAdmin) {
+		return
+	}
+
+	page, err := wb.Identity.AuditList(query)
+	if err != nil {
+		log.Println("Error fetching audit events:", err)
+		formatStandardResponse("AuditList", err.Error(), w)
+		return
+	}
+	formatAuditResponse(page, w)
+}
+
+// auditQuery parses the filters and page of an audit log listing
+func auditQuery(values url.Values) (datastore.AuditQuery, error) {
+	query := datastore.AuditQuery{
+		OrganizationID: values.Get("orgid"),
+		DeviceID:       values.Get("deviceid"),
+		Actor:          values.Get("actor"),
+		Action:         values.Get("action"),
+		Cursor:         values.Get("cursor"),
+	}
+
+	if s := values.Get("limit"); len(s) > 0 {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("the page limit `%s` is invalid", s)
+		}
+		query.Limit = limit
+	}
+
+	times := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"after", &query.After},
+		{"before", &query.Before},
+	}
+	for _, t := range times {
+		s := values.Get(t.name)
+		if len(s) == 0 {
+			continue
+		}
+		value, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return query, fmt.Errorf("the %s time `%s` must be in RFC3339 format", t.name, s)
+		}
+		*t.value = value
+	}
+
+	return query, query.Normalize()
+}
@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"testing"
+)
+
+func TestIdentityService_AuditList(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+		total   int
+	}{
+		{"valid", "/v1/audit", "superuser", false, 200, "", 3},
+		{"valid-org", "/v1/audit?orgid=abc", "superuser", false, 200, "", 2},
+		{"valid-filters", "/v1/audit?orgid=abc&deviceid=a111&actor=admin-abc&action=device-register", "superuser", false, 200, "", 1},
+		{"valid-times", "/v1/audit?after=2026-01-02T00:00:00Z&before=2026-01-03T00:00:00Z", "superuser", false, 200, "", 1},
+		{"valid-org-admin", "/v1/audit?orgid=abc", "admin-abc", false, 200, "", 2},
+		{"other-org-admin", "/v1/audit?orgid=abc", "admin-def", false, 403, "Forbidden", 0},
+		{"org-admin-all", "/v1/audit", "admin-abc", false, 403, "Forbidden", 0},
+		{"viewer", "/v1/audit?orgid=abc", "viewer-abc", false, 403, "Forbidden", 0},
+		{"anonymous", "/v1/audit", "", false, 401, "Unauthorized", 0},
+		{"invalid", "/v1/audit?orgid=invalid", "superuser", true, 400, "AuditList", 0},
+		{"invalid-limit", "/v1/audit?limit=none", "superuser", false, 400, "AuditList", 0},
+		{"invalid-max-limit", "/v1/audit?limit=5000", "superuser", false, 400, "AuditList", 0},
+		{"invalid-time", "/v1/audit?after=yesterday", "superuser", false, 400, "AuditList", 0},
+		{"invalid-cursor", "/v1/audit?cursor=invalid", "superuser", false, 400, "AuditList", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("GET", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.AuditList() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseAuditResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.AuditList() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.AuditList() got = %v, want %v", resp.Code, tt.result)
+			}
+			if resp.Total != tt.total {
+				t.Errorf("Web.AuditList() total = %v, want %v", resp.Total, tt.total)
+			}
+		})
+	}
+}
+
+func TestIdentityService_AuditListPages(t *testing.T) {
+	wb := NewIdentityService(settings, &mockIdentity{})
+
+	w := sendRequest("GET", "/v1/audit?limit=2", nil, wb)
+	first, err := parseAuditResponse(w.Body)
+	if err != nil || len(first.Events) != 2 || len(first.NextCursor) == 0 {
+		t.Fatalf("Web.AuditList() first page = %v, error = %v", first, err)
+	}
+	if first.Events[0].ID != "e3" {
+		t.Errorf("Web.AuditList() first event = %v, want e3", first.Events[0].ID)
+	}
+
+	w = sendRequest("GET", "/v1/audit?limit=2&cursor="+first.NextCursor, nil, wb)
+	last, err := parseAuditResponse(w.Body)
+	if err != nil || len(last.Events) != 1 || len(last.NextCursor) != 0 {
+		t.Fatalf("Web.AuditList() last page = %v, error = %v", last, err)
+	}
+	if last.Events[0].ID != "e1" {
+		t.Errorf("Web.AuditList() last event = %v, want e1", last.Events[0].ID)
+	}
+}
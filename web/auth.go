@@ -0,0 +1,126 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service"
+	"github.com/canonical/iot-identity/service/auth"
+)
+
+// contextKey is the type of the request context keys of the web service
+type contextKey int
+
+// callerKey is the request context key of the authenticated admin API caller
+const callerKey contextKey = iota
+
+// Authenticated requires the admin API caller to authenticate with a bearer token
+func (wb IdentityService) Authenticated(inner http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+
+		caller, err := wb.Identity.Authenticate(token)
+		if err != nil {
+			log.Println("Error authenticating:", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="iot-identity"`)
+			formatErrorResponse(http.StatusUnauthorized, "Unauthorized", service.ErrUnauthorized.Error(), w)
+			return
+		}
+
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey, caller)))
+	})
+}
+
+// bearerToken gets the token from the authorization header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// getCaller gets the authenticated caller of a request
+func getCaller(r *http.Request) *domain.Caller {
+	caller, _ := r.Context().Value(callerKey).(*domain.Caller)
+	return caller
+}
+
+// authorize checks that the caller has the role for the organization, responding if not
+func authorize(w http.ResponseWriter, r *http.Request, orgID string, role domain.Role) bool {
+	caller := getCaller(r)
+	if auth.Allowed(caller, orgID, role) {
+		return true
+	}
+	if caller != nil {
+		log.Printf("Forbidden `%s` access to organization `%s` by `%s`\n", role, orgID, caller.Subject)
+	}
+	formatErrorResponse(http.StatusForbidden, "Forbidden", service.ErrForbidden.Error(), w)
+	return false
+}
+
+// RegisterToken creates an API token for the admin API
+func (wb IdentityService) RegisterToken(w http.ResponseWriter, r *http.Request) {
+	// Decode the JSON body
+	req, err := decodeTokenRequest(w, r)
+	if err != nil {
+		return
+	}
+
+	tokenID, token, err := wb.Identity.RegisterToken(getCaller(r), req)
+	if errors.Is(err, service.ErrForbidden) {
+		log.Println("Error registering token:", err)
+		formatErrorResponse(http.StatusForbidden, "Forbidden", err.Error(), w)
+		return
+	}
+	if err != nil {
+		log.Println("Error registering token:", err)
+		formatStandardResponse("RegToken", err.Error(), w)
+		return
+	}
+	formatTokenResponse(tokenID, token, w)
+}
+
+func decodeTokenRequest(w http.ResponseWriter, r *http.Request) (*service.RegisterTokenRequest, error) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := service.RegisterTokenRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		formatStandardResponse("NoData", "No data supplied.", w)
+		log.Println("No data supplied.")
+		// Check for parsing errors
+	case err != nil:
+		formatStandardResponse("BadData", err.Error(), w)
+		log.Println(err)
+	}
+	return &req, err
+}
@@ -0,0 +1,201 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/canonical/iot-identity/datastore/memory"
+)
+
+func TestIdentityService_Authenticated(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		token  string
+		code   int
+		result string
+	}{
+		{"anonymous", "GET", "/v1/organizations", "", "", 401, "Unauthorized"},
+		{"unknown-token", "GET", "/v1/devices/abc", "", "invalid", 401, "Unauthorized"},
+		{"superuser-org", "POST", "/v1/organization", `{"name":"Example AB"}`, "superuser", 200, ""},
+		{"org-admin-org", "POST", "/v1/organization", `{"name":"Example AB"}`, "admin-abc", 403, "Forbidden"},
+		{"org-admin-list", "GET", "/v1/devices/abc", "", "admin-abc", 200, ""},
+		{"org-admin-list-other-org", "GET", "/v1/devices/def", "", "admin-abc", 403, "Forbidden"},
+		{"org-admin-get-other-org", "GET", "/v1/devices/def/a111", "", "admin-abc", 403, "Forbidden"},
+		{"org-admin-update", "PUT", "/v1/devices/abc/a111", `{"status":3}`, "admin-abc", 200, ""},
+		{"org-admin-update-other-org", "PUT", "/v1/devices/def/a111", `{"status":3}`, "admin-abc", 403, "Forbidden"},
+		{"org-admin-register", "POST", "/v1/device", `{"orgid":"abc","brand":"example","model":"drone-1000","serial":"DR1000Z999"}`, "admin-abc", 200, ""},
+		{"org-admin-register-other-org", "POST", "/v1/device", `{"orgid":"def","brand":"example","model":"drone-1000","serial":"DR1000Z999"}`, "admin-abc", 403, "Forbidden"},
+		{"read-only-get", "GET", "/v1/devices/abc/a111", "", "viewer-abc", 200, ""},
+		{"read-only-update", "PUT", "/v1/devices/abc/a111", `{"status":3}`, "viewer-abc", 403, "Forbidden"},
+		{"read-only-register", "POST", "/v1/device", `{"orgid":"abc","brand":"example","model":"drone-1000","serial":"DR1000Z999"}`, "viewer-abc", 403, "Forbidden"},
+		{"device-enrollment-open", "POST", "/v1/device/nonce", `{"brand":"example","model":"drone-1000","serial":"DR1000A111"}`, "", 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+
+			w := sendRequestAs(tt.method, tt.url, bytes.NewReader([]byte(tt.body)), wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.Authenticated() got = %v, want %v", w.Code, tt.code)
+			}
+			if tt.code == 401 && len(w.Header().Get("WWW-Authenticate")) == 0 {
+				t.Error("Web.Authenticated() = no WWW-Authenticate header")
+			}
+			resp, err := parseRegisterResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.Authenticated() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.Authenticated() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OrganizationListScoped(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		count int
+	}{
+		{"superuser", "superuser", 1},
+		{"org-admin", "admin-abc", 1},
+		{"other-org-admin", "admin-def", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+
+			w := sendRequestAs("GET", "/v1/organizations", nil, wb, tt.token)
+			resp := OrganizationsResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Web.OrganizationList() got = %v", err)
+			}
+			if len(resp.Organizations) != tt.count {
+				t.Errorf("Web.OrganizationList() count = %v, want %v", len(resp.Organizations), tt.count)
+			}
+		})
+	}
+}
+
+func TestIdentityService_DeviceGetPrivateKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"org-admin", "admin-abc", true},
+		{"read-only", "viewer-abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+
+			w := sendRequestAs("GET", "/v1/devices/abc/a111", nil, wb, tt.token)
+			resp, err := parseEnrollResponse(w.Body)
+			if err != nil {
+				t.Fatalf("Web.DeviceGet() got = %v", err)
+			}
+			if got := len(resp.Enrollment.Credentials.PrivateKey) > 0; got != tt.want {
+				t.Errorf("Web.DeviceGet() private key = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityService_ResponsePrivateKeys(t *testing.T) {
+	urls := []string{
+		"/v1/organizations",
+		"/v1/organization/abc/acl",
+		"/v1/organization/abc/webhooks",
+		"/v1/devices/abc",
+		"/v1/devices/abc/a111",
+		"/v1/devices/abc/a111/history",
+		"/v1/audit",
+		"/v1/outbox",
+	}
+	for _, token := range []string{"superuser", "admin-abc", "viewer-abc"} {
+		for _, url := range urls {
+			t.Run(token+url, func(t *testing.T) {
+				wb := NewIdentityService(settings, &mockIdentity{})
+
+				w := sendRequestAs("GET", url, nil, wb, token)
+				body := w.Body.String()
+				if containsKey(body, memory.RootPEM) {
+					t.Errorf("Web %s response contains the organization CA key", url)
+				}
+				if token == "viewer-abc" && (strings.Contains(body, "PRIVATE KEY") || containsKey(body, mockDeviceKey)) {
+					t.Errorf("Web %s response contains a private key", url)
+				}
+			})
+		}
+	}
+}
+
+// containsKey checks if a response body contains a key, either as text or base64 encoded
+func containsKey(body, key string) bool {
+	return strings.Contains(body, key) || strings.Contains(body, base64.StdEncoding.EncodeToString([]byte(key)))
+}
+
+func TestIdentityService_RegisterToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		token  string
+		code   int
+		result string
+	}{
+		{"superuser", `{"name":"root","role":"superuser"}`, "superuser", 200, ""},
+		{"org-admin", `{"name":"ci","orgid":"abc","role":"read-only"}`, "admin-abc", 200, ""},
+		{"org-admin-superuser", `{"name":"root","role":"superuser"}`, "admin-abc", 403, "Forbidden"},
+		{"org-admin-other-org", `{"name":"ci","orgid":"def","role":"read-only"}`, "admin-abc", 403, "Forbidden"},
+		{"invalid", `{"orgid":"abc","role":"read-only"}`, "superuser", 400, "RegToken"},
+		{"no-data", ``, "superuser", 400, "NoData"},
+		{"anonymous", `{"name":"root","role":"superuser"}`, "", 401, "Unauthorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+
+			w := sendRequestAs("POST", "/v1/token", bytes.NewReader([]byte(tt.body)), wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.RegisterToken() got = %v, want %v", w.Code, tt.code)
+			}
+			resp := TokenResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Web.RegisterToken() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.RegisterToken() got = %v, want %v", resp.Code, tt.result)
+			}
+			if tt.code == 200 && len(resp.Token) == 0 {
+				t.Error("Web.RegisterToken() = no token returned")
+			}
+		})
+	}
+}
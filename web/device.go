@@ -21,51 +21,168 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
 	"github.com/canonical/iot-identity/service"
+	"github.com/canonical/iot-identity/service/auth"
 	"github.com/gorilla/mux"
 	"github.com/snapcore/snapd/asserts"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// maxEnrollFormSize is the memory limit for parsing a multipart enrollment request
+const maxEnrollFormSize = 1 << 20
+
 // DeviceList fetches device registrations
 func (wb IdentityService) DeviceList(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleReadOnly) {
+		return
+	}
+
+	query, err := deviceQuery(vars["orgid"], r.URL.Query())
+	if err != nil {
+		formatStandardResponse("DeviceList", err.Error(), w)
+		return
+	}
 
-	devices, err := wb.Identity.DeviceList(vars["orgid"])
+	// The pages do not include the private keys of the devices
+	page, err := wb.Identity.DeviceList(query)
 	if err != nil {
 		log.Println("Error fetching devices:", err)
 		formatStandardResponse("DeviceList", err.Error(), w)
 		return
 	}
-	formatDevicesResponse(devices, w)
+	formatDevicesResponse(page, w)
+}
+
+// deviceQuery parses the filters, sort order and page of a device listing.
+// A sort order that starts with `-` is descending
+func deviceQuery(orgID string, values url.Values) (datastore.DeviceQuery, error) {
+	query := datastore.DeviceQuery{
+		OrganizationID: orgID,
+		Brand:          values.Get("brand"),
+		Model:          values.Get("model"),
+		SerialPrefix:   values.Get("serial"),
+		DeviceData:     values.Get("deviceData"),
+		Sort:           strings.TrimPrefix(values.Get("sort"), "-"),
+		Descending:     strings.HasPrefix(values.Get("sort"), "-"),
+		Cursor:         values.Get("cursor"),
+	}
+
+	if s := values.Get("status"); len(s) > 0 {
+		status, err := strconv.Atoi(s)
+		if err != nil || status < int(domain.StatusWaiting) || status > int(domain.StatusDisabled) {
+			return query, fmt.Errorf("the status `%s` is invalid", s)
+		}
+		query.Status = domain.Status(status)
+	}
+	if s := values.Get("limit"); len(s) > 0 {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("the page limit `%s` is invalid", s)
+		}
+		query.Limit = limit
+	}
+
+	times := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"createdAfter", &query.CreatedAfter},
+		{"createdBefore", &query.CreatedBefore},
+		{"updatedAfter", &query.UpdatedAfter},
+		{"updatedBefore", &query.UpdatedBefore},
+	}
+	for _, t := range times {
+		s := values.Get(t.name)
+		if len(s) == 0 {
+			continue
+		}
+		value, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return query, fmt.Errorf("the %s time `%s` must be in RFC3339 format", t.name, s)
+		}
+		*t.value = value
+	}
+
+	return query, query.Normalize()
 }
 
 // DeviceGet fetches a device registration
 func (wb IdentityService) DeviceGet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleReadOnly) {
+		return
+	}
 
 	en, err := wb.Identity.DeviceGet(vars["orgid"], vars["device"])
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		log.Printf("Error fetching device `%s`: %v\n", vars["device"], err)
+		formatErrorResponse(http.StatusNotFound, "DeviceGet", err.Error(), w)
+		return
+	}
 	if err != nil {
 		log.Printf("Error fetching device `%s`: %v\n", vars["device"], err)
 		formatStandardResponse("DeviceGet", err.Error(), w)
 		return
 	}
+	// The organization's CA key is never returned, and only org admins see the device key
+	en.Organization.RootKey = nil
+	if !auth.Allowed(getCaller(r), vars["orgid"], domain.RoleOrgAdmin) {
+		en.Credentials.PrivateKey = nil
+	}
 	formatEnrollResponse(*en, w)
 }
 
+// DeviceHistory fetches the status transitions of a device
+func (wb IdentityService) DeviceHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleReadOnly) {
+		return
+	}
+
+	history, err := wb.Identity.DeviceHistory(vars["orgid"], vars["device"])
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		log.Printf("Error fetching the history of device `%s`: %v\n", vars["device"], err)
+		formatErrorResponse(http.StatusNotFound, "DeviceHistory", err.Error(), w)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching the history of device `%s`: %v\n", vars["device"], err)
+		formatStandardResponse("DeviceHistory", err.Error(), w)
+		return
+	}
+	formatHistoryResponse(history, w)
+}
+
 // DeviceUpdate updates a device registration
 func (wb IdentityService) DeviceUpdate(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
 
 	req, err := decodeDeviceUpdateRequest(w, r)
 	if err != nil {
 		return
 	}
 
-	err = wb.Identity.DeviceUpdate(vars["orgid"], vars["device"], req)
+	err = wb.Identity.DeviceUpdate(getCaller(r), vars["orgid"], vars["device"], req)
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		log.Printf("Error updating device `%s`: %v\n", vars["device"], err)
+		formatErrorResponse(http.StatusNotFound, "DeviceUpdate", err.Error(), w)
+		return
+	}
 	if err != nil {
 		log.Printf("Error updating device `%s`: %v\n", vars["device"], err)
 		formatStandardResponse("DeviceUpdate", err.Error(), w)
@@ -74,6 +191,27 @@ func (wb IdentityService) DeviceUpdate(w http.ResponseWriter, r *http.Request) {
 	formatStandardResponse("", "", w)
 }
 
+// DeviceDelete deletes a device registration, revoking its certificate
+func (wb IdentityService) DeviceDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
+
+	err := wb.Identity.DeviceDelete(getCaller(r), vars["orgid"], vars["device"])
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		log.Printf("Error deleting device `%s`: %v\n", vars["device"], err)
+		formatErrorResponse(http.StatusNotFound, "DeviceDelete", err.Error(), w)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting device `%s`: %v\n", vars["device"], err)
+		formatStandardResponse("DeviceDelete", err.Error(), w)
+		return
+	}
+	formatStandardResponse("", "", w)
+}
+
 // RegisterDevice registers a new device with the identity service
 func (wb IdentityService) RegisterDevice(w http.ResponseWriter, r *http.Request) {
 	// Decode the JSON body
@@ -81,8 +219,11 @@ func (wb IdentityService) RegisterDevice(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return
 	}
+	if !authorize(w, r, req.OrganizationID, domain.RoleOrgAdmin) {
+		return
+	}
 
-	id, err := wb.Identity.RegisterDevice(req)
+	id, err := wb.Identity.RegisterDevice(getCaller(r), req)
 	if err != nil {
 		log.Println("Error registering device:", err)
 		formatStandardResponse("RegDevice", err.Error(), w)
@@ -91,35 +232,62 @@ func (wb IdentityService) RegisterDevice(w http.ResponseWriter, r *http.Request)
 	formatRegisterResponse(id, w)
 }
 
+// DeviceNonce issues a nonce for a device to sign with its device-key when it enrolls
+func (wb IdentityService) DeviceNonce(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeDeviceNonceRequest(w, r)
+	if err != nil {
+		return
+	}
+
+	nonce, err := wb.Identity.DeviceNonce(req)
+	if err != nil {
+		log.Println("Error creating device nonce:", err)
+		formatStandardResponse("DeviceNonce", err.Error(), w)
+		return
+	}
+	formatNonceResponse(*nonce, w)
+}
+
 // EnrollDevice connects an IoT device with the identity service
 func (wb IdentityService) EnrollDevice(w http.ResponseWriter, r *http.Request) {
 	// Decode the assertions from the request
-	assertion1, assertion2, err := decodeEnrollRequest(r)
+	assertions, csr, err := decodeEnrollRequest(r)
 	if err != nil {
 		formatStandardResponse("EnrollDevice", err.Error(), w)
 		return
 	}
-	if assertion1 == nil || assertion2 == nil {
+	if len(assertions) < 2 {
 		formatStandardResponse("EnrollDevice", "A model and serial assertion is required", w)
 		return
 	}
 
-	req := service.EnrollDeviceRequest{}
-
-	if assertion1.Type().Name == asserts.ModelType.Name && assertion2.Type().Name == asserts.SerialType.Name {
-		req.Model = assertion1
-		req.Serial = assertion2
-	} else if assertion1.Type().Name == asserts.SerialType.Name && assertion2.Type().Name == asserts.ModelType.Name {
-		req.Model = assertion2
-		req.Serial = assertion1
+	req := service.EnrollDeviceRequest{CSR: csr}
+	for _, a := range assertions {
+		switch a.Type().Name {
+		case asserts.ModelType.Name:
+			req.Model = a
+		case asserts.SerialType.Name:
+			req.Serial = a
+		case asserts.DeviceSessionRequestType.Name:
+			req.SessionRequest = a
+		}
 	}
 	if req.Model == nil || req.Serial == nil {
-		log.Println("A model and serial assertion must be provided")
+		formatStandardResponse("EnrollDevice", "A model and serial assertion is required", w)
+		return
 	}
 
 	en, err := wb.Identity.EnrollDevice(&req)
 	if err != nil {
 		log.Println("Error enrolling device:", err)
+		if errors.Is(err, service.ErrUntrustedAssertion) {
+			formatStandardResponse("EnrollUntrusted", err.Error(), w)
+			return
+		}
+		if errors.Is(err, service.ErrInvalidSessionRequest) {
+			formatStandardResponse("EnrollProof", err.Error(), w)
+			return
+		}
 		formatStandardResponse("EnrollDevice", err.Error(), w)
 		return
 	}
@@ -127,6 +295,30 @@ func (wb IdentityService) EnrollDevice(w http.ResponseWriter, r *http.Request) {
 	formatEnrollResponse(*en, w)
 }
 
+// RenewCertificate issues a new certificate to an enrolled device, which authenticates
+// with its current client certificate
+func (wb IdentityService) RenewCertificate(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeRenewRequest(w, r)
+	if err != nil {
+		return
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		req.ClientCert = r.TLS.PeerCertificates[0]
+	}
+
+	credentials, err := wb.Identity.RenewCertificate(req)
+	if err != nil {
+		log.Println("Error renewing certificate:", err)
+		if errors.Is(err, service.ErrRenewUnauthorized) {
+			formatStandardResponse("RenewAuth", err.Error(), w)
+			return
+		}
+		formatStandardResponse("RenewCertificate", err.Error(), w)
+		return
+	}
+	formatCredentialsResponse(*credentials, w)
+}
+
 func decodeDeviceRequest(w http.ResponseWriter, r *http.Request) (*service.RegisterDeviceRequest, error) { // Decode the REST request
 	defer r.Body.Close()
 
@@ -146,33 +338,99 @@ func decodeDeviceRequest(w http.ResponseWriter, r *http.Request) (*service.Regis
 	return &dev, err
 }
 
-func decodeEnrollRequest(r *http.Request) (asserts.Assertion, asserts.Assertion, error) {
-	// Use snapd assertion module to decode the assertions in the request stream
-	dec := asserts.NewDecoder(r.Body)
-	assertion1, err := dec.Decode()
-	if err == io.EOF {
-		return nil, nil, fmt.Errorf("no data supplied")
+// decodeEnrollRequest decodes the enrollment request. The body is either the
+// assertion stream or a multipart form with the `assertions` stream and the
+// PEM-encoded `csr` of the device
+func decodeEnrollRequest(r *http.Request) ([]asserts.Assertion, []byte, error) {
+	defer r.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		assertions, err := decodeAssertions(r.Body)
+		return assertions, nil, err
 	}
+
+	if err := r.ParseMultipartForm(maxEnrollFormSize); err != nil {
+		return nil, nil, err
+	}
+	assertions, err := decodeAssertions(strings.NewReader(r.FormValue("assertions")))
 	if err != nil {
 		return nil, nil, err
 	}
+	return assertions, []byte(r.FormValue("csr")), nil
+}
 
-	// Decode the second assertion
-	assertion2, err := dec.Decode()
-	if err != nil && err != io.EOF {
-		return nil, nil, err
+// decodeAssertions decodes the model, serial and device-session-request
+// assertions, in any order, from the request stream
+func decodeAssertions(body io.Reader) ([]asserts.Assertion, error) {
+	// Use snapd assertion module to decode the assertions in the request stream
+	dec := asserts.NewDecoder(body)
+	assertion, err := dec.Decode()
+	if err == io.EOF {
+		return nil, fmt.Errorf("no data supplied")
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Stream must be ended now
-	_, err = dec.Decode()
-	if err != io.EOF {
-		if err == nil {
-			return nil, nil, fmt.Errorf("unexpected assertion in the request stream")
+	assertions := []asserts.Assertion{}
+	types := map[string]bool{}
+	for err != io.EOF {
+		if err != nil {
+			return nil, err
 		}
-		return nil, nil, err
+
+		// Each assertion type may only be provided once
+		name := assertion.Type().Name
+		if name != asserts.ModelType.Name && name != asserts.SerialType.Name && name != asserts.DeviceSessionRequestType.Name {
+			return nil, fmt.Errorf("unexpected %s assertion in the request stream", name)
+		}
+		if types[name] {
+			return nil, fmt.Errorf("unexpected assertion in the request stream")
+		}
+		types[name] = true
+		assertions = append(assertions, assertion)
+
+		assertion, err = dec.Decode()
 	}
 
-	return assertion1, assertion2, nil
+	return assertions, nil
+}
+
+// decodeRenewRequest decodes the optional certificate request to renew a certificate
+func decodeRenewRequest(w http.ResponseWriter, r *http.Request) (*service.RenewCertificateRequest, error) {
+	defer r.Body.Close()
+
+	// Decode the JSON body, which may be empty
+	req := service.RenewCertificateRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == io.EOF {
+		return &req, nil
+	}
+	if err != nil {
+		formatStandardResponse("BadData", err.Error(), w)
+		log.Println(err)
+	}
+	return &req, err
+}
+
+func decodeDeviceNonceRequest(w http.ResponseWriter, r *http.Request) (*service.DeviceNonceRequest, error) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := service.DeviceNonceRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		formatStandardResponse("NoData", "No data supplied.", w)
+		log.Println("No data supplied.")
+		// Check for parsing errors
+	case err != nil:
+		formatStandardResponse("BadData", err.Error(), w)
+		log.Println(err)
+	}
+	return &req, err
 }
 
 func decodeDeviceUpdateRequest(w http.ResponseWriter, r *http.Request) (*service.DeviceUpdateRequest, error) {
@@ -21,8 +21,19 @@ package web
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
 
 	"github.com/canonical/iot-identity/config"
 )
@@ -89,6 +100,22 @@ Azds7xIR91OzXGFMx/PO7ZwflxBRIZw7+iFXEXWzfhzVlrUFDLr8K++g1g563UzY9P86XwGDlS7l
 
 var settings = config.ParseArgs()
 
+// sessionRequest creates a device-session-request signed by a test key
+func sessionRequest() string {
+	key, _ := assertstest.GenerateKey(752)
+	a, err := asserts.SignWithoutAuthority(asserts.DeviceSessionRequestType, map[string]interface{}{
+		"brand-id":  "canonical",
+		"model":     "ubuntu-core-18-amd64",
+		"serial":    "d75f7300-abbf-4c11-bf0a-8b7103038490",
+		"nonce":     "nonce",
+		"timestamp": time.Now().Format(time.RFC3339),
+	}, nil, key)
+	if err != nil {
+		panic(err)
+	}
+	return strings.TrimSpace(string(asserts.Encode(a)))
+}
+
 func TestIdentityService_RegisterDevice(t *testing.T) {
 	req1 := []byte(`{"orgid":"abc", "brand":"example", "model":"drone-2000", "serial":"DR2000C333"}`)
 	req2 := []byte(``)
@@ -127,34 +154,41 @@ func TestIdentityService_RegisterDevice(t *testing.T) {
 }
 
 func TestIdentityService_EnrollDevice(t *testing.T) {
-	req1 := []byte(fmt.Sprintf("%s\n\n%s", model1, serial1))
+	session := sessionRequest()
+	req1 := []byte(fmt.Sprintf("%s\n\n%s\n\n%s", model1, serial1, session))
 	req2 := []byte("")
 	req3 := []byte(`\u000`)
 	req4 := []byte(fmt.Sprintf("%s\n\n%s\n\n%s", model1, serial1, serial1))
-	req5 := []byte(fmt.Sprintf("%s\n\n%s", serial1, model1))
+	req5 := []byte(fmt.Sprintf("%s\n\n%s\n\n%s", session, serial1, model1))
 	req6 := []byte(serial1)
 	req7 := []byte(fmt.Sprintf("%s\n\nbad-data", serial1))
+	req8 := []byte(fmt.Sprintf("%s\n\n%s", model1, serial1))
+	req9 := []byte(fmt.Sprintf("%s\n\n%s", serial1, session))
 
 	type args struct {
 		req []byte
 	}
 	tests := []struct {
-		name   string
-		args   args
-		code   int
-		result string
+		name    string
+		args    args
+		withErr bool
+		code    int
+		result  string
 	}{
-		{"valid1", args{req1}, 200, ""},
-		{"no-data", args{req2}, 400, "EnrollDevice"},
-		{"bad-data", args{req3}, 400, "EnrollDevice"},
-		{"extra-assert", args{req4}, 400, "EnrollDevice"},
-		{"valid2", args{req5}, 200, ""},
-		{"one-assert", args{req6}, 400, "EnrollDevice"},
-		{"one-assert-bad", args{req7}, 400, "EnrollDevice"},
+		{"valid1", args{req1}, false, 200, ""},
+		{"no-data", args{req2}, false, 400, "EnrollDevice"},
+		{"bad-data", args{req3}, false, 400, "EnrollDevice"},
+		{"extra-assert", args{req4}, false, 400, "EnrollDevice"},
+		{"valid2", args{req5}, false, 200, ""},
+		{"one-assert", args{req6}, false, 400, "EnrollDevice"},
+		{"one-assert-bad", args{req7}, false, 400, "EnrollDevice"},
+		{"untrusted", args{req1}, true, 400, "EnrollUntrusted"},
+		{"no-session", args{req8}, false, 400, "EnrollProof"},
+		{"no-model", args{req9}, false, 400, "EnrollDevice"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			wb := NewIdentityService(settings, &mockIdentity{})
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
 			w := sendRequest("POST", "/v1/device/enroll", bytes.NewReader(tt.args.req), wb)
 			if w.Code != tt.code {
 				t.Errorf("Web.EnrollDevice() got = %v, want %v", w.Code, tt.code)
@@ -170,6 +204,85 @@ func TestIdentityService_EnrollDevice(t *testing.T) {
 	}
 }
 
+func TestIdentityService_EnrollDeviceCSR(t *testing.T) {
+	assertions := fmt.Sprintf("%s\n\n%s\n\n%s", model1, serial1, sessionRequest())
+	tests := []struct {
+		name       string
+		assertions string
+		csr        string
+		code       int
+		result     string
+	}{
+		{"valid", assertions, "csr", 200, ""},
+		{"no-csr", assertions, "", 200, ""},
+		{"invalid-csr", assertions, "invalid", 400, "EnrollDevice"},
+		{"no-assertions", "", "csr", 400, "EnrollDevice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			mw := multipart.NewWriter(body)
+			_ = mw.WriteField("assertions", tt.assertions)
+			_ = mw.WriteField("csr", tt.csr)
+			_ = mw.Close()
+
+			wb := NewIdentityService(settings, &mockIdentity{})
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", "/v1/device/enroll", body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			wb.Router().ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("Web.EnrollDevice() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseEnrollResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.EnrollDevice() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.EnrollDevice() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
+func TestIdentityService_DeviceNonce(t *testing.T) {
+	req1 := []byte(`{"brand":"canonical", "model":"ubuntu-core-18-amd64", "serial":"d75f7300-abbf-4c11-bf0a-8b7103038490"}`)
+	req2 := []byte(``)
+	req3 := []byte(`\u000`)
+	req4 := []byte(`{"brand":"canonical", "model":"ubuntu-core-18-amd64", "serial":"invalid"}`)
+	tests := []struct {
+		name   string
+		body   []byte
+		code   int
+		result string
+	}{
+		{"valid", req1, 200, ""},
+		{"no-data", req2, 400, "NoData"},
+		{"bad-data", req3, 400, "BadData"},
+		{"invalid", req4, 400, "DeviceNonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+			w := sendRequest("POST", "/v1/device/nonce", bytes.NewReader(tt.body), wb)
+			if w.Code != tt.code {
+				t.Errorf("Web.DeviceNonce() got = %v, want %v", w.Code, tt.code)
+			}
+			resp := NonceResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Errorf("Web.DeviceNonce() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.DeviceNonce() got = %v, want %v", resp.Code, tt.result)
+			}
+			if tt.code == 200 && len(resp.Nonce.Value) == 0 {
+				t.Error("Web.DeviceNonce() got empty nonce")
+			}
+		})
+	}
+}
+
 func TestIdentityService_DeviceList(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -179,7 +292,15 @@ func TestIdentityService_DeviceList(t *testing.T) {
 		result  string
 	}{
 		{"valid", "/v1/devices/abc", false, 200, ""},
+		{"valid-query", "/v1/devices/abc?status=2&brand=example&serial=DR1000&sort=-serial&limit=2", false, 200, ""},
+		{"valid-times", "/v1/devices/abc?createdAfter=2020-01-01T00:00:00Z&updatedBefore=2030-01-01T00:00:00Z", false, 200, ""},
 		{"invalid", "/v1/devices/invalid", true, 400, "DeviceList"},
+		{"invalid-status", "/v1/devices/abc?status=9", false, 400, "DeviceList"},
+		{"invalid-limit", "/v1/devices/abc?limit=none", false, 400, "DeviceList"},
+		{"invalid-max-limit", "/v1/devices/abc?limit=5000", false, 400, "DeviceList"},
+		{"invalid-sort", "/v1/devices/abc?sort=brand", false, 400, "DeviceList"},
+		{"invalid-time", "/v1/devices/abc?createdAfter=yesterday", false, 400, "DeviceList"},
+		{"invalid-cursor", "/v1/devices/abc?cursor=invalid", false, 400, "DeviceList"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -200,6 +321,43 @@ func TestIdentityService_DeviceList(t *testing.T) {
 	}
 }
 
+func TestIdentityService_DeviceListPages(t *testing.T) {
+	wb := NewIdentityService(settings, &mockIdentity{})
+
+	// The fixtures have three devices for the organization
+	ids := map[string]bool{}
+	url := "/v1/devices/abc?sort=serial&limit=2"
+	for pages := 1; ; pages++ {
+		w := sendRequest("GET", url, nil, wb)
+		if w.Code != 200 {
+			t.Fatalf("Web.DeviceList() got = %v, want 200", w.Code)
+		}
+		resp, err := parseDevicesResponse(w.Body)
+		if err != nil {
+			t.Fatalf("Web.DeviceList() got = %v", err)
+		}
+		if resp.Total != 3 {
+			t.Errorf("Web.DeviceList() total = %v, want 3", resp.Total)
+		}
+		for _, d := range resp.Devices {
+			ids[d.ID] = true
+			if len(d.Credentials.PrivateKey) > 0 {
+				t.Errorf("Web.DeviceList() = private key included for %v", d.ID)
+			}
+		}
+		if len(resp.NextCursor) == 0 {
+			if pages != 2 {
+				t.Errorf("Web.DeviceList() pages = %v, want 2", pages)
+			}
+			break
+		}
+		url = "/v1/devices/abc?sort=serial&limit=2&cursor=" + resp.NextCursor
+	}
+	if len(ids) != 3 {
+		t.Errorf("Web.DeviceList() devices = %v, want 3", len(ids))
+	}
+}
+
 func TestIdentityService_DeviceGet(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -210,6 +368,7 @@ func TestIdentityService_DeviceGet(t *testing.T) {
 	}{
 		{"valid", "/v1/devices/abc/a111", false, 200, ""},
 		{"invalid", "/v1/devices/abc/invalid", true, 400, "DeviceGet"},
+		{"other-org", "/v1/devices/def/a111", false, 404, "DeviceGet"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -246,6 +405,7 @@ func TestIdentityService_DeviceUpdate(t *testing.T) {
 		{"invalid", "/v1/devices/abc/invalid", req1, true, 400, "DeviceUpdate"},
 		{"invalid-empty", "/v1/devices/abc/a111", req2, true, 400, "NoData"},
 		{"invalid-body", "/v1/devices/abc/a111", req3, true, 400, "BadData"},
+		{"other-org", "/v1/devices/def/a111", req1, false, 404, "DeviceUpdate"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -265,3 +425,151 @@ func TestIdentityService_DeviceUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestIdentityService_DeviceHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+		want    int
+	}{
+		{"valid", "/v1/devices/abc/b222/history", "superuser", false, 200, "", 1},
+		{"valid-viewer", "/v1/devices/abc/b222/history", "viewer-abc", false, 200, "", 1},
+		{"invalid", "/v1/devices/abc/invalid/history", "superuser", true, 400, "DeviceHistory", 0},
+		{"other-org", "/v1/devices/def/b222/history", "superuser", false, 404, "DeviceHistory", 0},
+		{"other-org-admin", "/v1/devices/abc/b222/history", "admin-def", false, 403, "Forbidden", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("GET", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.DeviceHistory() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseHistoryResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.DeviceHistory() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.DeviceHistory() got = %v, want %v", resp.Code, tt.result)
+			}
+			if len(resp.History) != tt.want {
+				t.Errorf("Web.DeviceHistory() history = %v, want %v", len(resp.History), tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityService_DeviceDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+	}{
+		{"valid", "/v1/devices/abc/a111", "superuser", false, 200, ""},
+		{"valid-org-admin", "/v1/devices/abc/a111", "admin-abc", false, 200, ""},
+		{"invalid", "/v1/devices/abc/invalid", "superuser", true, 400, "DeviceDelete"},
+		{"not-found", "/v1/devices/abc/unknown", "superuser", false, 404, "DeviceDelete"},
+		{"other-org", "/v1/devices/def/a111", "admin-def", false, 404, "DeviceDelete"},
+		{"forbidden-other-admin", "/v1/devices/abc/a111", "admin-def", false, 403, "Forbidden"},
+		{"forbidden-viewer", "/v1/devices/abc/a111", "viewer-abc", false, 403, "Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("DELETE", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.DeviceDelete() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseRegisterResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.DeviceDelete() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.DeviceDelete() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
+func TestIdentityService_DeviceCrossOrganization(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		code   int
+		result string
+	}{
+		{"get-own-org", "GET", "/v1/devices/def/a111", "", 404, "DeviceGet"},
+		{"update-own-org", "PUT", "/v1/devices/def/a111", `{"status":3}`, 404, "DeviceUpdate"},
+		{"get-other-org", "GET", "/v1/devices/abc/a111", "", 403, "Forbidden"},
+		{"update-other-org", "PUT", "/v1/devices/abc/a111", `{"status":3}`, 403, "Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+
+			// An admin of organization `def` cannot reach the devices of organization `abc`
+			w := sendRequestAs(tt.method, tt.url, bytes.NewReader([]byte(tt.body)), wb, "admin-def")
+			if w.Code != tt.code {
+				t.Errorf("Web.DeviceCrossOrganization() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseEnrollResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.DeviceCrossOrganization() got = %v", err)
+			}
+			if resp.Code != tt.result || len(resp.Enrollment.ID) > 0 {
+				t.Errorf("Web.DeviceCrossOrganization() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
+func TestIdentityService_RenewCertificate(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       []byte
+		clientCert bool
+		withErr    bool
+		code       int
+		result     string
+	}{
+		{"valid", []byte(`{"csr":"csr"}`), true, false, 200, ""},
+		{"valid-no-body", nil, true, false, 200, ""},
+		{"no-client-cert", nil, false, false, 400, "RenewAuth"},
+		{"bad-data", []byte(`\u000`), true, false, 400, "BadData"},
+		{"invalid-csr", []byte(`{"csr":"invalid"}`), true, false, 400, "RenewCertificate"},
+		{"error", nil, true, true, 400, "RenewCertificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", "/v1/device/renew", bytes.NewReader(tt.body))
+			if tt.clientCert {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+			}
+			wb.Router().ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("Web.RenewCertificate() got = %v, want %v", w.Code, tt.code)
+			}
+			resp := CredentialsResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Errorf("Web.RenewCertificate() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.RenewCertificate() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
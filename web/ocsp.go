@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/canonical/iot-identity/service"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspPath is the URL path of the OCSP responder
+const ocspPath = "/v1/ocsp"
+
+// maxOCSPRequestSize is the size limit of a DER-encoded OCSP request
+const maxOCSPRequestSize = 10 * 1024
+
+// OCSP answers an OCSP request for a device certificate (RFC 6960). The request is
+// sent as the body of a POST, or base64-encoded in the URL of a GET
+func (wb IdentityService) OCSP(w http.ResponseWriter, r *http.Request) {
+	request, err := decodeOCSPRequest(r)
+	if err != nil {
+		log.Println("Error decoding OCSP request:", err)
+		formatOCSPResponse(ocsp.MalformedRequestErrorResponse, 0, w)
+		return
+	}
+
+	resp, err := wb.Identity.OCSPResponse(request)
+	if err != nil {
+		log.Println("Error creating OCSP response:", err)
+		switch {
+		case errors.Is(err, service.ErrOCSPMalformed):
+			formatOCSPResponse(ocsp.MalformedRequestErrorResponse, 0, w)
+		case errors.Is(err, service.ErrOCSPUnauthorized):
+			formatOCSPResponse(ocsp.UnauthorizedErrorResponse, 0, w)
+		default:
+			formatOCSPResponse(ocsp.InternalErrorErrorResponse, 0, w)
+		}
+		return
+	}
+
+	// Allow HTTP caches to hold responses to GET requests while the service caches them
+	maxAge := 0
+	if r.Method == http.MethodGet {
+		maxAge = int(wb.Settings.OCSPValidity.Seconds()) / 2
+	}
+	formatOCSPResponse(resp, maxAge, w)
+}
+
+func decodeOCSPRequest(r *http.Request) ([]byte, error) {
+	if r.Method != http.MethodGet {
+		defer r.Body.Close()
+		return ioutil.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	}
+
+	// The base64 encoding may itself be URL-encoded
+	encoded, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), ocspPath+"/"))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// formatOCSPResponse returns a DER-encoded OCSP response
+func formatOCSPResponse(resp []byte, maxAge int, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+	}
+	_, _ = w.Write(resp)
+}
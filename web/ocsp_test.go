@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestIdentityService_OCSP(t *testing.T) {
+	slashes := base64.StdEncoding.EncodeToString([]byte{0xff, 0xff, 0xff})
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		body         []byte
+		withErr      bool
+		want         []byte
+		cacheControl bool
+	}{
+		{"valid-post", "POST", "/v1/ocsp", []byte("valid"), false, []byte("MOCK ocsp"), false},
+		{"valid-get", "GET", "/v1/ocsp/" + base64.StdEncoding.EncodeToString([]byte("valid")), nil, false, []byte("MOCK ocsp"), true},
+		{"valid-get-escaped", "GET", "/v1/ocsp/" + url.PathEscape(slashes), nil, false, []byte("MOCK ocsp"), true},
+		{"valid-get-slashes", "GET", "/v1/ocsp/" + slashes, nil, false, []byte("MOCK ocsp"), true},
+		{"invalid-base64", "GET", "/v1/ocsp/invalid!", nil, false, ocsp.MalformedRequestErrorResponse, false},
+		{"malformed", "POST", "/v1/ocsp", []byte("invalid"), false, ocsp.MalformedRequestErrorResponse, false},
+		{"unauthorized", "POST", "/v1/ocsp", []byte("unknown"), false, ocsp.UnauthorizedErrorResponse, false},
+		{"error", "POST", "/v1/ocsp", []byte("valid"), true, ocsp.InternalErrorErrorResponse, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+			w := sendRequest(tt.method, tt.url, bytes.NewReader(tt.body), wb)
+			if w.Code != 200 {
+				t.Errorf("Web.OCSP() got = %v, want %v", w.Code, 200)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/ocsp-response" {
+				t.Errorf("Web.OCSP() content type = %v", got)
+			}
+			if !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Errorf("Web.OCSP() got = %v, want %v", w.Body.Bytes(), tt.want)
+			}
+			if got := len(w.Header().Get("Cache-Control")) > 0; got != tt.cacheControl {
+				t.Errorf("Web.OCSP() cache control = %v, want %v", got, tt.cacheControl)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OCSPCleanPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		code     int
+		location string
+	}{
+		{"ocsp-get", "GET", "/v1/ocsp//w==", 200, ""},
+		{"ocsp-post", "POST", "/v1//ocsp", 301, "/v1/ocsp"},
+		{"admin", "GET", "/v1/organization/abc/../def/acl", 301, "/v1/organization/def/acl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+			w := sendRequest(tt.method, tt.url, nil, wb)
+			if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+				t.Errorf("Web.Handler() got = %v %v, want %v %v", w.Code, w.Header().Get("Location"), tt.code, tt.location)
+			}
+		})
+	}
+}
@@ -21,22 +21,31 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/canonical/iot-identity/domain"
 	"github.com/canonical/iot-identity/service"
+	"github.com/canonical/iot-identity/service/auth"
+	"github.com/gorilla/mux"
 )
 
 // RegisterOrganization registers a new organization with the identity service
 func (wb IdentityService) RegisterOrganization(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, "", domain.RoleSuperuser) {
+		return
+	}
+
 	// Decode the JSON body
 	req, err := decodeOrganizationRequest(w, r)
 	if err != nil {
 		return
 	}
 
-	id, err := wb.Identity.RegisterOrganization(req)
+	id, err := wb.Identity.RegisterOrganization(getCaller(r), req)
 	if err != nil {
 		log.Println("Error registering organization:", err)
 		formatStandardResponse("RegOrg", err.Error(), w)
@@ -53,7 +62,130 @@ func (wb IdentityService) OrganizationList(w http.ResponseWriter, r *http.Reques
 		formatStandardResponse("OrgList", err.Error(), w)
 		return
 	}
-	formatOrganizationsResponse(orgs, w)
+
+	// Only list the organizations that the caller can view
+	caller := getCaller(r)
+	visible := []domain.Organization{}
+	for _, o := range orgs {
+		if auth.Allowed(caller, o.ID, domain.RoleReadOnly) {
+			o.RootKey = nil
+			visible = append(visible, o)
+		}
+	}
+	formatOrganizationsResponse(visible, w)
+}
+
+// OrganizationDelete deletes an organization. An organization with devices is only
+// deleted when the `force` query parameter is true
+func (wb IdentityService) OrganizationDelete(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, "", domain.RoleSuperuser) {
+		return
+	}
+	vars := mux.Vars(r)
+
+	force, err := strconv.ParseBool(r.URL.Query().Get("force"))
+	if err != nil && len(r.URL.Query().Get("force")) > 0 {
+		formatStandardResponse("OrgDelete", "the force parameter must be true or false", w)
+		return
+	}
+
+	err = wb.Identity.OrganizationDelete(getCaller(r), vars["orgid"], force)
+	switch {
+	case errors.Is(err, service.ErrOrganizationNotFound):
+		log.Printf("Error deleting organization `%s`: %v\n", vars["orgid"], err)
+		formatErrorResponse(http.StatusNotFound, "OrgDelete", err.Error(), w)
+	case errors.Is(err, service.ErrOrganizationHasDevices):
+		log.Printf("Error deleting organization `%s`: %v\n", vars["orgid"], err)
+		formatErrorResponse(http.StatusConflict, "OrgDelete", err.Error(), w)
+	case err != nil:
+		log.Printf("Error deleting organization `%s`: %v\n", vars["orgid"], err)
+		formatStandardResponse("OrgDelete", err.Error(), w)
+	default:
+		formatStandardResponse("", "", w)
+	}
+}
+
+// OrganizationUpdate updates the MQTT broker of an organization
+func (wb IdentityService) OrganizationUpdate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
+
+	// Decode the JSON body
+	req, err := decodeOrganizationUpdateRequest(w, r)
+	if err != nil {
+		return
+	}
+
+	err = wb.Identity.OrganizationUpdate(getCaller(r), vars["orgid"], req)
+	switch {
+	case errors.Is(err, service.ErrOrganizationNotFound):
+		log.Printf("Error updating organization `%s`: %v\n", vars["orgid"], err)
+		formatErrorResponse(http.StatusNotFound, "OrgUpdate", err.Error(), w)
+	case err != nil:
+		log.Printf("Error updating organization `%s`: %v\n", vars["orgid"], err)
+		formatStandardResponse("OrgUpdate", err.Error(), w)
+	default:
+		formatStandardResponse("", "", w)
+	}
+}
+
+// OrganizationReissue queues the reissue of the MQTT broker details in the credentials of the
+// devices of an organization, returning the number of devices queued
+func (wb IdentityService) OrganizationReissue(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
+
+	devices, err := wb.Identity.OrganizationReissue(getCaller(r), vars["orgid"])
+	switch {
+	case errors.Is(err, service.ErrOrganizationNotFound):
+		log.Printf("Error reissuing the credentials of organization `%s`: %v\n", vars["orgid"], err)
+		formatErrorResponse(http.StatusNotFound, "OrgReissue", err.Error(), w)
+	case err != nil:
+		log.Printf("Error reissuing the credentials of organization `%s`: %v\n", vars["orgid"], err)
+		formatStandardResponse("OrgReissue", err.Error(), w)
+	default:
+		formatReissueResponse(devices, w)
+	}
+}
+
+// OrganizationCRL fetches the certificate revocation list of an organization
+func (wb IdentityService) OrganizationCRL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	crl, err := wb.Identity.OrganizationCRL(vars["orgid"])
+	if err != nil {
+		log.Println("Error fetching revocation list:", err)
+		formatStandardResponse("OrgCRL", err.Error(), w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	_, _ = w.Write(crl)
+}
+
+// OrganizationACL exports the topic ACLs of the enrolled devices of an organization. The
+// `format` query parameter selects a mosquitto acl_file (the default) or EMQX rules
+func (wb IdentityService) OrganizationACL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleReadOnly) {
+		return
+	}
+
+	acl, err := wb.Identity.OrganizationACL(vars["orgid"], r.URL.Query().Get("format"))
+	switch {
+	case errors.Is(err, service.ErrOrganizationNotFound):
+		log.Printf("Error exporting the ACLs of organization `%s`: %v\n", vars["orgid"], err)
+		formatErrorResponse(http.StatusNotFound, "OrgACL", err.Error(), w)
+	case err != nil:
+		log.Printf("Error exporting the ACLs of organization `%s`: %v\n", vars["orgid"], err)
+		formatStandardResponse("OrgACL", err.Error(), w)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write(acl)
+	}
 }
 
 func decodeOrganizationRequest(w http.ResponseWriter, r *http.Request) (*service.RegisterOrganizationRequest, error) { // Decode the REST request
@@ -74,3 +206,22 @@ func decodeOrganizationRequest(w http.ResponseWriter, r *http.Request) (*service
 	}
 	return &org, err
 }
+
+func decodeOrganizationUpdateRequest(w http.ResponseWriter, r *http.Request) (*service.OrganizationUpdateRequest, error) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := service.OrganizationUpdateRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		formatStandardResponse("NoData", "No data supplied.", w)
+		log.Println("No data supplied.")
+		// Check for parsing errors
+	case err != nil:
+		formatStandardResponse("BadData", err.Error(), w)
+		log.Println(err)
+	}
+	return &req, err
+}
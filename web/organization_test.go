@@ -94,3 +94,167 @@ func TestIdentityService_OrganizationList(t *testing.T) {
 		})
 	}
 }
+
+func TestIdentityService_OrganizationDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+	}{
+		{"valid-force", "/v1/organization/abc?force=true", "superuser", false, 200, ""},
+		{"has-devices", "/v1/organization/abc", "superuser", false, 409, "OrgDelete"},
+		{"not-found", "/v1/organization/unknown?force=true", "superuser", false, 404, "OrgDelete"},
+		{"invalid-force", "/v1/organization/abc?force=always", "superuser", false, 400, "OrgDelete"},
+		{"invalid", "/v1/organization/abc?force=true", "superuser", true, 400, "OrgDelete"},
+		{"forbidden-org-admin", "/v1/organization/abc?force=true", "admin-abc", false, 403, "Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("DELETE", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.OrganizationDelete() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseRegisterResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.OrganizationDelete() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.OrganizationDelete() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OrganizationUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		body    string
+		token   string
+		withErr bool
+		code    int
+		result  string
+	}{
+		{"valid", "/v1/organization/abc", `{"broker":{"host":"mqtt.abc.example.com","protocol":"wss"}}`, "admin-abc", false, 200, ""},
+		{"valid-superuser", "/v1/organization/abc", `{"broker":{}}`, "superuser", false, 200, ""},
+		{"invalid-broker", "/v1/organization/abc", `{"broker":{"protocol":"invalid"}}`, "admin-abc", false, 400, "OrgUpdate"},
+		{"not-found", "/v1/organization/unknown", `{"broker":{}}`, "superuser", false, 404, "OrgUpdate"},
+		{"invalid", "/v1/organization/abc", `{"broker":{}}`, "admin-abc", true, 400, "OrgUpdate"},
+		{"no-data", "/v1/organization/abc", ``, "admin-abc", false, 400, "NoData"},
+		{"bad-data", "/v1/organization/abc", `\u000`, "admin-abc", false, 400, "BadData"},
+		{"forbidden-other-org", "/v1/organization/abc", `{"broker":{}}`, "admin-def", false, 403, "Forbidden"},
+		{"forbidden-viewer", "/v1/organization/abc", `{"broker":{}}`, "viewer-abc", false, 403, "Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("PUT", tt.url, bytes.NewReader([]byte(tt.body)), wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.OrganizationUpdate() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseRegisterResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.OrganizationUpdate() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.OrganizationUpdate() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OrganizationReissue(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+		devices int
+	}{
+		{"valid", "/v1/organization/abc/reissue", "admin-abc", false, 202, "", 3},
+		{"not-found", "/v1/organization/unknown/reissue", "superuser", false, 404, "OrgReissue", 0},
+		{"invalid", "/v1/organization/abc/reissue", "superuser", true, 400, "OrgReissue", 0},
+		{"forbidden-other-org", "/v1/organization/abc/reissue", "admin-def", false, 403, "Forbidden", 0},
+		{"forbidden-viewer", "/v1/organization/abc/reissue", "viewer-abc", false, 403, "Forbidden", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("POST", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.OrganizationReissue() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseReissueResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.OrganizationReissue() got = %v", err)
+			}
+			if resp.Code != tt.result || resp.Devices != tt.devices {
+				t.Errorf("Web.OrganizationReissue() got = %v/%v, want %v/%v", resp.Code, resp.Devices, tt.result, tt.devices)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OrganizationACL(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		token       string
+		code        int
+		contentType string
+	}{
+		{"valid", "/v1/organization/abc/acl", "superuser", 200, "text/plain; charset=utf-8"},
+		{"valid-emqx", "/v1/organization/abc/acl?format=emqx", "superuser", 200, "text/plain; charset=utf-8"},
+		{"valid-viewer", "/v1/organization/abc/acl", "viewer-abc", 200, "text/plain; charset=utf-8"},
+		{"other-org", "/v1/organization/abc/acl", "admin-def", 403, JSONHeader},
+		{"unknown", "/v1/organization/unknown/acl", "superuser", 404, JSONHeader},
+		{"invalid-format", "/v1/organization/abc/acl?format=invalid", "superuser", 400, JSONHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+
+			w := sendRequestAs("GET", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.OrganizationACL() got = %v, want %v", w.Code, tt.code)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Web.OrganizationACL() content type = %v, want %v", got, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OrganizationCRL(t *testing.T) {
+	tests := []struct {
+		name        string
+		orgID       string
+		code        int
+		contentType string
+	}{
+		{"valid", "abc", 200, "application/pkix-crl"},
+		{"invalid", "invalid", 400, JSONHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{})
+
+			w := sendRequest("GET", "/v1/organization/"+tt.orgID+"/crl", nil, wb)
+			if w.Code != tt.code {
+				t.Errorf("Web.OrganizationCRL() got = %v, want %v", w.Code, tt.code)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Web.OrganizationCRL() content type = %v, want %v", got, tt.contentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service"
+	"github.com/gorilla/mux"
+)
+
+// outboxStatus are the names of the delivery states of outbox events in the query string
+var outboxStatus = map[string]domain.OutboxStatus{
+	"pending":   domain.OutboxPending,
+	"delivered": domain.OutboxDelivered,
+	"failed":    domain.OutboxFailed,
+}
+
+// OutboxList fetches the events of the outbox, oldest first, for superusers to inspect
+// the delivery of device changes to the MQTT broker and other sinks
+func (wb IdentityService) OutboxList(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, "", domain.RoleSuperuser) {
+		return
+	}
+	query, err := outboxQuery(r.URL.Query())
+	if err != nil {
+		formatStandardResponse("OutboxList", err.Error(), w)
+		return
+	}
+
+	events, err := wb.Identity.OutboxList(query)
+	if err != nil {
+		log.Println("Error fetching outbox events:", err)
+		formatStandardResponse("OutboxList", err.Error(), w)
+		return
+	}
+	formatOutboxResponse(events, w)
+}
+
+// outboxQuery parses the filters of an outbox listing
+func outboxQuery(values url.Values) (datastore.OutboxQuery, error) {
+	query := datastore.OutboxQuery{DeviceID: values.Get("deviceid")}
+
+	var err error
+	if query.Status, query.Limit, err = deliveryFilters(values); err != nil {
+		return query, err
+	}
+	return query, query.Normalize()
+}
+
+// deliveryFilters parses the delivery status and page size of a listing of outbox events
+// or webhook deliveries
+func deliveryFilters(values url.Values) (domain.OutboxStatus, int, error) {
+	var status domain.OutboxStatus
+	if s := values.Get("status"); len(s) > 0 {
+		var ok bool
+		if status, ok = outboxStatus[s]; !ok {
+			return 0, 0, fmt.Errorf("the status `%s` must be pending, delivered or failed", s)
+		}
+	}
+
+	limit := 0
+	if s := values.Get("limit"); len(s) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("the limit `%s` is invalid", s)
+		}
+	}
+	return status, limit, nil
+}
+
+// OutboxReplay queues a failed outbox event for delivery again
+func (wb IdentityService) OutboxReplay(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, "", domain.RoleSuperuser) {
+		return
+	}
+	vars := mux.Vars(r)
+
+	event, err := wb.Identity.OutboxReplay(getCaller(r), vars["id"])
+	switch {
+	case errors.Is(err, service.ErrEventNotFound):
+		log.Printf("Error replaying outbox event `%s`: %v\n", vars["id"], err)
+		formatErrorResponse(http.StatusNotFound, "OutboxReplay", err.Error(), w)
+	case errors.Is(err, service.ErrEventNotFailed):
+		log.Printf("Error replaying outbox event `%s`: %v\n", vars["id"], err)
+		formatErrorResponse(http.StatusConflict, "OutboxReplay", err.Error(), w)
+	case err != nil:
+		log.Printf("Error replaying outbox event `%s`: %v\n", vars["id"], err)
+		formatStandardResponse("OutboxReplay", err.Error(), w)
+	default:
+		formatOutboxResponse([]domain.OutboxEvent{*event}, w)
+	}
+}
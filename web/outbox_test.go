@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/iot-identity/domain"
+)
+
+func TestIdentityService_OutboxList(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+		events  []string
+	}{
+		{"valid", "/v1/outbox", "superuser", false, 200, "", []string{"e1", "e2", "e3"}},
+		{"valid-status", "/v1/outbox?status=failed", "superuser", false, 200, "", []string{"e2"}},
+		{"valid-device", "/v1/outbox?deviceid=c333&status=pending", "superuser", false, 200, "", []string{"e3"}},
+		{"valid-limit", "/v1/outbox?limit=2", "superuser", false, 200, "", []string{"e1", "e2"}},
+		{"org-admin", "/v1/outbox", "admin-abc", false, 403, "Forbidden", nil},
+		{"viewer", "/v1/outbox", "viewer-abc", false, 403, "Forbidden", nil},
+		{"anonymous", "/v1/outbox", "", false, 401, "Unauthorized", nil},
+		{"invalid-status", "/v1/outbox?status=lost", "superuser", false, 400, "OutboxList", nil},
+		{"invalid-limit", "/v1/outbox?limit=none", "superuser", false, 400, "OutboxList", nil},
+		{"invalid-max-limit", "/v1/outbox?limit=5000", "superuser", false, 400, "OutboxList", nil},
+		{"invalid", "/v1/outbox", "superuser", true, 400, "OutboxList", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("GET", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.OutboxList() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseOutboxResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.OutboxList() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.OutboxList() got = %v, want %v", resp.Code, tt.result)
+			}
+			var ids []string
+			for _, e := range resp.Events {
+				ids = append(ids, e.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.events) {
+				t.Errorf("Web.OutboxList() events = %v, want %v", ids, tt.events)
+			}
+		})
+	}
+}
+
+func TestIdentityService_OutboxReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+	}{
+		{"valid", "/v1/outbox/e2/replay", "superuser", false, 200, ""},
+		{"not-failed", "/v1/outbox/e1/replay", "superuser", false, 409, "OutboxReplay"},
+		{"not-found", "/v1/outbox/invalid/replay", "superuser", false, 404, "OutboxReplay"},
+		{"org-admin", "/v1/outbox/e2/replay", "admin-abc", false, 403, "Forbidden"},
+		{"anonymous", "/v1/outbox/e2/replay", "", false, 401, "Unauthorized"},
+		{"invalid", "/v1/outbox/e2/replay", "superuser", true, 400, "OutboxReplay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("POST", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.OutboxReplay() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseOutboxResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.OutboxReplay() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.OutboxReplay() got = %v, want %v", resp.Code, tt.result)
+			}
+			if tt.code == 200 && (len(resp.Events) != 1 || resp.Events[0].Status != domain.OutboxPending) {
+				t.Errorf("Web.OutboxReplay() events = %v, want the pending event", resp.Events)
+			}
+		})
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/canonical/iot-identity/datastore"
 	"github.com/canonical/iot-identity/domain"
 )
 
@@ -45,7 +46,17 @@ type OrganizationsResponse struct {
 // DevicesResponse is the JSON response from a device list API method
 type DevicesResponse struct {
 	StandardResponse
-	Devices []domain.Enrollment `json:"devices"`
+	Devices    []domain.Enrollment `json:"devices"`
+	NextCursor string              `json:"next,omitempty"`
+	Total      int                 `json:"total"`
+}
+
+// AuditResponse is the JSON response from the audit log API method
+type AuditResponse struct {
+	StandardResponse
+	Events     []domain.AuditEvent `json:"events"`
+	NextCursor string              `json:"next,omitempty"`
+	Total      int                 `json:"total"`
 }
 
 // RegisterResponse is the JSON response from a registration API method
@@ -60,6 +71,62 @@ type EnrollResponse struct {
 	Enrollment domain.Enrollment `json:"enrollment"`
 }
 
+// HistoryResponse is the JSON response from the device history API method
+type HistoryResponse struct {
+	StandardResponse
+	History []domain.StatusChange `json:"history"`
+}
+
+// OutboxResponse is the JSON response from the outbox API methods
+type OutboxResponse struct {
+	StandardResponse
+	Events []domain.OutboxEvent `json:"events"`
+}
+
+// ReissueResponse is the JSON response from the credentials reissue API method
+type ReissueResponse struct {
+	StandardResponse
+	Devices int `json:"devices"`
+}
+
+// WebhookResponse is the JSON response from the webhook registration API method
+type WebhookResponse struct {
+	StandardResponse
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// WebhooksResponse is the JSON response from the webhook list API method
+type WebhooksResponse struct {
+	StandardResponse
+	Webhooks []domain.Webhook `json:"webhooks"`
+}
+
+// DeliveriesResponse is the JSON response from the webhook delivery API methods
+type DeliveriesResponse struct {
+	StandardResponse
+	Deliveries []domain.WebhookDelivery `json:"deliveries"`
+}
+
+// NonceResponse is the JSON response from a device nonce API method
+type NonceResponse struct {
+	StandardResponse
+	Nonce domain.Nonce `json:"nonce"`
+}
+
+// CredentialsResponse is the JSON response from a certificate renewal API method
+type CredentialsResponse struct {
+	StandardResponse
+	Credentials domain.Credentials `json:"credentials"`
+}
+
+// TokenResponse is the JSON response from an API token registration method
+type TokenResponse struct {
+	StandardResponse
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
 // formatStandardResponse returns a JSON response from an API method, indicating success or failure
 func formatStandardResponse(code, message string, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", JSONHeader)
@@ -73,6 +140,17 @@ func formatStandardResponse(code, message string, w http.ResponseWriter) {
 	encodeResponse(w, response)
 }
 
+// formatErrorResponse returns a JSON error response with a specific HTTP status
+func formatErrorResponse(status int, code, message string, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := StandardResponse{Code: code, Message: message}
+
+	w.WriteHeader(status)
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
 // formatOrganizationsResponse returns a JSON response from an organizations API method
 func formatOrganizationsResponse(orgs []domain.Organization, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", JSONHeader)
@@ -82,10 +160,19 @@ func formatOrganizationsResponse(orgs []domain.Organization, w http.ResponseWrit
 	encodeResponse(w, response)
 }
 
-// formatDevicesResponse returns a JSON response from an organizations API method
-func formatDevicesResponse(items []domain.Enrollment, w http.ResponseWriter) {
+// formatDevicesResponse returns a JSON response from a device list API method
+func formatDevicesResponse(page *datastore.DevicePage, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := DevicesResponse{StandardResponse{}, page.Devices, page.NextCursor, page.Total}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatAuditResponse returns a JSON response from the audit log API method
+func formatAuditResponse(page *datastore.AuditPage, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", JSONHeader)
-	response := DevicesResponse{StandardResponse{}, items}
+	response := AuditResponse{StandardResponse{}, page.Events, page.NextCursor, page.Total}
 
 	// Encode the response as JSON
 	encodeResponse(w, response)
@@ -107,6 +194,24 @@ func encodeResponse(w http.ResponseWriter, response interface{}) {
 	}
 }
 
+// formatNonceResponse returns a JSON response from a device nonce API method
+func formatNonceResponse(nonce domain.Nonce, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := NonceResponse{StandardResponse{}, nonce}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatCredentialsResponse returns a JSON response from a certificate renewal API method
+func formatCredentialsResponse(credentials domain.Credentials, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := CredentialsResponse{StandardResponse{}, credentials}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
 // formatEnrollResponse returns a JSON response from a register API method
 func formatEnrollResponse(en domain.Enrollment, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", JSONHeader)
@@ -115,3 +220,68 @@ func formatEnrollResponse(en domain.Enrollment, w http.ResponseWriter) {
 	// Encode the response as JSON
 	encodeResponse(w, response)
 }
+
+// formatHistoryResponse returns a JSON response from the device history API method
+func formatHistoryResponse(history []domain.StatusChange, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := HistoryResponse{StandardResponse{}, history}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatOutboxResponse returns a JSON response from the outbox API methods
+func formatOutboxResponse(events []domain.OutboxEvent, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := OutboxResponse{StandardResponse{}, events}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatReissueResponse returns an accepted JSON response from the credentials reissue API
+// method, with the number of devices queued for reissue
+func formatReissueResponse(devices int, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	w.WriteHeader(http.StatusAccepted)
+	response := ReissueResponse{StandardResponse{}, devices}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatTokenResponse returns a JSON response from an API token registration method
+func formatTokenResponse(id, token string, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := TokenResponse{StandardResponse{}, id, token}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatWebhookResponse returns a JSON response from the webhook registration API method
+func formatWebhookResponse(id, secret string, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := WebhookResponse{StandardResponse{}, id, secret}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatWebhooksResponse returns a JSON response from the webhook list API method
+func formatWebhooksResponse(webhooks []domain.Webhook, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := WebhooksResponse{StandardResponse{}, webhooks}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatDeliveriesResponse returns a JSON response from the webhook delivery API methods
+func formatDeliveriesResponse(deliveries []domain.WebhookDelivery, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := DeliveriesResponse{StandardResponse{}, deliveries}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
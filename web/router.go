@@ -22,26 +22,62 @@ package web
 import (
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// Handler returns the handler of the web service. OCSP GET requests are base64-encoded, which
+// may include `//` in the path, so they are served before the router cleans the path
+func (wb IdentityService) Handler() http.Handler {
+	router := wb.Router()
+	ocspGet := Middleware(http.HandlerFunc(wb.OCSP))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasPrefix(r.URL.EscapedPath(), ocspPath+"/") {
+			ocspGet.ServeHTTP(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
 // Router returns the application router
 func (wb IdentityService) Router() *mux.Router {
 	// Start the web service router
 	router := mux.NewRouter()
 
 	// Admin
-	router.Handle("/v1/organization", Middleware(http.HandlerFunc(wb.RegisterOrganization))).Methods("POST")
-	router.Handle("/v1/organizations", Middleware(http.HandlerFunc(wb.OrganizationList))).Methods("GET")
-	router.Handle("/v1/device", Middleware(http.HandlerFunc(wb.RegisterDevice))).Methods("POST")
-	router.Handle("/v1/devices/{orgid}", Middleware(http.HandlerFunc(wb.DeviceList))).Methods("GET")
-	router.Handle("/v1/devices/{orgid}/{device}", Middleware(http.HandlerFunc(wb.DeviceGet))).Methods("GET")
-	router.Handle("/v1/devices/{orgid}/{device}", Middleware(http.HandlerFunc(wb.DeviceUpdate))).Methods("PUT")
+	router.Handle("/v1/organization", Middleware(wb.Authenticated(wb.RegisterOrganization))).Methods("POST")
+	router.Handle("/v1/organizations", Middleware(wb.Authenticated(wb.OrganizationList))).Methods("GET")
+	router.Handle("/v1/organization/{orgid}", Middleware(wb.Authenticated(wb.OrganizationUpdate))).Methods("PUT")
+	router.Handle("/v1/organization/{orgid}", Middleware(wb.Authenticated(wb.OrganizationDelete))).Methods("DELETE")
+	router.Handle("/v1/organization/{orgid}/reissue", Middleware(wb.Authenticated(wb.OrganizationReissue))).Methods("POST")
+	router.Handle("/v1/organization/{orgid}/acl", Middleware(wb.Authenticated(wb.OrganizationACL))).Methods("GET")
+	router.Handle("/v1/organization/{orgid}/webhooks", Middleware(wb.Authenticated(wb.WebhookRegister))).Methods("POST")
+	router.Handle("/v1/organization/{orgid}/webhooks", Middleware(wb.Authenticated(wb.WebhookList))).Methods("GET")
+	router.Handle("/v1/organization/{orgid}/webhooks/{id}", Middleware(wb.Authenticated(wb.WebhookDelete))).Methods("DELETE")
+	router.Handle("/v1/organization/{orgid}/webhooks/{id}/deliveries", Middleware(wb.Authenticated(wb.WebhookDeliveries))).Methods("GET")
+	router.Handle("/v1/organization/{orgid}/webhooks/{id}/test", Middleware(wb.Authenticated(wb.WebhookTest))).Methods("POST")
+	router.Handle("/v1/device", Middleware(wb.Authenticated(wb.RegisterDevice))).Methods("POST")
+	router.Handle("/v1/devices/{orgid}", Middleware(wb.Authenticated(wb.DeviceList))).Methods("GET")
+	router.Handle("/v1/devices/{orgid}/{device}", Middleware(wb.Authenticated(wb.DeviceGet))).Methods("GET")
+	router.Handle("/v1/devices/{orgid}/{device}", Middleware(wb.Authenticated(wb.DeviceUpdate))).Methods("PUT")
+	router.Handle("/v1/devices/{orgid}/{device}", Middleware(wb.Authenticated(wb.DeviceDelete))).Methods("DELETE")
+	router.Handle("/v1/devices/{orgid}/{device}/history", Middleware(wb.Authenticated(wb.DeviceHistory))).Methods("GET")
+	router.Handle("/v1/token", Middleware(wb.Authenticated(wb.RegisterToken))).Methods("POST")
+	router.Handle("/v1/audit", Middleware(wb.Authenticated(wb.AuditList))).Methods("GET")
+	router.Handle("/v1/outbox", Middleware(wb.Authenticated(wb.OutboxList))).Methods("GET")
+	router.Handle("/v1/outbox/{id}/replay", Middleware(wb.Authenticated(wb.OutboxReplay))).Methods("POST")
 
 	// Device enrollment
+	router.Handle("/v1/device/nonce", Middleware(http.HandlerFunc(wb.DeviceNonce))).Methods("POST")
 	router.Handle("/v1/device/enroll", Middleware(http.HandlerFunc(wb.EnrollDevice))).Methods("POST")
+	router.Handle("/v1/device/renew", Middleware(http.HandlerFunc(wb.RenewCertificate))).Methods("POST")
+
+	// Certificate revocation
+	router.Handle("/v1/organization/{orgid}/crl", Middleware(http.HandlerFunc(wb.OrganizationCRL))).Methods("GET")
+	router.Handle(ocspPath, Middleware(http.HandlerFunc(wb.OCSP))).Methods("POST")
 
 	return router
 }
@@ -20,24 +20,48 @@
 package web
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
 
 	"github.com/canonical/iot-identity/config"
 	"github.com/canonical/iot-identity/service"
+	"github.com/canonical/iot-identity/service/cert"
 	"github.com/gorilla/mux"
 )
 
 // Web is the interface for the web API
 type Web interface {
 	Run() error
+	Handler() http.Handler
 	Router() *mux.Router
 	RegisterOrganization(w http.ResponseWriter, r *http.Request)
 	RegisterDevice(w http.ResponseWriter, r *http.Request)
 	OrganizationList(w http.ResponseWriter, r *http.Request)
+	OrganizationUpdate(w http.ResponseWriter, r *http.Request)
+	OrganizationDelete(w http.ResponseWriter, r *http.Request)
+	OrganizationReissue(w http.ResponseWriter, r *http.Request)
+	OrganizationACL(w http.ResponseWriter, r *http.Request)
+	OrganizationCRL(w http.ResponseWriter, r *http.Request)
+	OCSP(w http.ResponseWriter, r *http.Request)
+	WebhookRegister(w http.ResponseWriter, r *http.Request)
+	WebhookList(w http.ResponseWriter, r *http.Request)
+	WebhookDelete(w http.ResponseWriter, r *http.Request)
+	WebhookDeliveries(w http.ResponseWriter, r *http.Request)
+	WebhookTest(w http.ResponseWriter, r *http.Request)
 	DeviceList(w http.ResponseWriter, r *http.Request)
+	DeviceGet(w http.ResponseWriter, r *http.Request)
+	DeviceUpdate(w http.ResponseWriter, r *http.Request)
+	DeviceDelete(w http.ResponseWriter, r *http.Request)
+	DeviceHistory(w http.ResponseWriter, r *http.Request)
+	RegisterToken(w http.ResponseWriter, r *http.Request)
+	AuditList(w http.ResponseWriter, r *http.Request)
+	OutboxList(w http.ResponseWriter, r *http.Request)
+	OutboxReplay(w http.ResponseWriter, r *http.Request)
 
+	DeviceNonce(w http.ResponseWriter, r *http.Request)
 	EnrollDevice(w http.ResponseWriter, r *http.Request)
+	RenewCertificate(w http.ResponseWriter, r *http.Request)
 }
 
 // IdentityService is the implementation of the web API
@@ -54,8 +78,25 @@ func NewIdentityService(settings *config.Settings, id service.Identity) *Identit
 	}
 }
 
-// Run starts the web service
+// Run starts the web service. With a TLS certificate, devices may authenticate with their
+// client certificates, which are verified against the root CA
 func (wb IdentityService) Run() error {
 	fmt.Printf("Starting service on port :%s\n", wb.Settings.Port)
-	return http.ListenAndServe(":"+wb.Settings.Port, wb.Router())
+	if len(wb.Settings.TLSCert) == 0 {
+		return http.ListenAndServe(":"+wb.Settings.Port, wb.Handler())
+	}
+
+	roots, err := cert.RootCertPool(wb.Settings.RootCertsDir)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:    ":" + wb.Settings.Port,
+		Handler: wb.Handler(),
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  roots,
+		},
+	}
+	return srv.ListenAndServeTLS(wb.Settings.TLSCert, wb.Settings.TLSKey)
 }
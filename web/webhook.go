@@ -0,0 +1,173 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/canonical/iot-identity/datastore"
+	"github.com/canonical/iot-identity/domain"
+	"github.com/canonical/iot-identity/service"
+	"github.com/gorilla/mux"
+)
+
+// WebhookRegister subscribes a URL to the events of the devices of an organization. The
+// response has the shared secret of the webhook, which is only available now
+func (wb IdentityService) WebhookRegister(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
+
+	// Decode the JSON body
+	req, err := decodeWebhookRequest(w, r)
+	if err != nil {
+		return
+	}
+
+	webhookID, secret, err := wb.Identity.WebhookRegister(getCaller(r), vars["orgid"], req)
+	switch {
+	case errors.Is(err, service.ErrOrganizationNotFound):
+		log.Printf("Error registering webhook for organization `%s`: %v\n", vars["orgid"], err)
+		formatErrorResponse(http.StatusNotFound, "WebhookReg", err.Error(), w)
+	case err != nil:
+		log.Printf("Error registering webhook for organization `%s`: %v\n", vars["orgid"], err)
+		formatStandardResponse("WebhookReg", err.Error(), w)
+	default:
+		formatWebhookResponse(webhookID, secret, w)
+	}
+}
+
+// WebhookList fetches the webhooks of an organization
+func (wb IdentityService) WebhookList(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
+
+	webhooks, err := wb.Identity.WebhookList(vars["orgid"])
+	if err != nil {
+		log.Printf("Error fetching the webhooks of organization `%s`: %v\n", vars["orgid"], err)
+		formatStandardResponse("WebhookList", err.Error(), w)
+		return
+	}
+	formatWebhooksResponse(webhooks, w)
+}
+
+// WebhookDelete removes a webhook of an organization
+func (wb IdentityService) WebhookDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
+
+	err := wb.Identity.WebhookDelete(getCaller(r), vars["orgid"], vars["id"])
+	switch {
+	case errors.Is(err, service.ErrWebhookNotFound):
+		log.Printf("Error deleting webhook `%s`: %v\n", vars["id"], err)
+		formatErrorResponse(http.StatusNotFound, "WebhookDelete", err.Error(), w)
+	case err != nil:
+		log.Printf("Error deleting webhook `%s`: %v\n", vars["id"], err)
+		formatStandardResponse("WebhookDelete", err.Error(), w)
+	default:
+		formatStandardResponse("", "", w)
+	}
+}
+
+// WebhookDeliveries fetches the delivery history of a webhook, oldest first
+func (wb IdentityService) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
+	query, err := webhookDeliveryQuery(vars["orgid"], vars["id"], r.URL.Query())
+	if err != nil {
+		formatStandardResponse("WebhookDeliveries", err.Error(), w)
+		return
+	}
+
+	deliveries, err := wb.Identity.WebhookDeliveries(query)
+	switch {
+	case errors.Is(err, service.ErrWebhookNotFound):
+		log.Printf("Error fetching the deliveries of webhook `%s`: %v\n", vars["id"], err)
+		formatErrorResponse(http.StatusNotFound, "WebhookDeliveries", err.Error(), w)
+	case err != nil:
+		log.Printf("Error fetching the deliveries of webhook `%s`: %v\n", vars["id"], err)
+		formatStandardResponse("WebhookDeliveries", err.Error(), w)
+	default:
+		formatDeliveriesResponse(deliveries, w)
+	}
+}
+
+// webhookDeliveryQuery parses the filters of the delivery history of a webhook
+func webhookDeliveryQuery(orgID, webhookID string, values url.Values) (datastore.WebhookDeliveryQuery, error) {
+	query := datastore.WebhookDeliveryQuery{OrganizationID: orgID, WebhookID: webhookID}
+
+	var err error
+	if query.Status, query.Limit, err = deliveryFilters(values); err != nil {
+		return query, err
+	}
+	return query, query.Normalize()
+}
+
+// WebhookTest posts a test payload to a webhook, responding with the delivery. A webhook
+// that does not accept the payload is a failed delivery rather than an error
+func (wb IdentityService) WebhookTest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !authorize(w, r, vars["orgid"], domain.RoleOrgAdmin) {
+		return
+	}
+
+	delivery, err := wb.Identity.WebhookTest(getCaller(r), vars["orgid"], vars["id"])
+	switch {
+	case errors.Is(err, service.ErrWebhookNotFound):
+		log.Printf("Error testing webhook `%s`: %v\n", vars["id"], err)
+		formatErrorResponse(http.StatusNotFound, "WebhookTest", err.Error(), w)
+	case err != nil:
+		log.Printf("Error testing webhook `%s`: %v\n", vars["id"], err)
+		formatStandardResponse("WebhookTest", err.Error(), w)
+	default:
+		formatDeliveriesResponse([]domain.WebhookDelivery{*delivery}, w)
+	}
+}
+
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (*service.WebhookRegisterRequest, error) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	req := service.WebhookRegisterRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		formatStandardResponse("NoData", "No data supplied.", w)
+		log.Println("No data supplied.")
+		// Check for parsing errors
+	case err != nil:
+		formatStandardResponse("BadData", err.Error(), w)
+		log.Println(err)
+	}
+	return &req, err
+}
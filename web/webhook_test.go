@@ -0,0 +1,216 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIdentityService_WebhookRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		body    string
+		token   string
+		withErr bool
+		code    int
+		result  string
+	}{
+		{"valid", "/v1/organization/abc/webhooks", `{"url":"https://hooks.example.com", "events":["device-enroll"]}`, "admin-abc", false, 200, ""},
+		{"valid-superuser", "/v1/organization/abc/webhooks", `{"url":"https://hooks.example.com"}`, "superuser", false, 200, ""},
+		{"invalid-url", "/v1/organization/abc/webhooks", `{"url":"invalid"}`, "admin-abc", false, 400, "WebhookReg"},
+		{"not-found", "/v1/organization/unknown/webhooks", `{"url":"https://hooks.example.com"}`, "superuser", false, 404, "WebhookReg"},
+		{"no-data", "/v1/organization/abc/webhooks", ``, "admin-abc", false, 400, "NoData"},
+		{"bad-data", "/v1/organization/abc/webhooks", `\u000`, "admin-abc", false, 400, "BadData"},
+		{"invalid", "/v1/organization/abc/webhooks", `{"url":"https://hooks.example.com"}`, "admin-abc", true, 400, "WebhookReg"},
+		{"forbidden-other-org", "/v1/organization/abc/webhooks", `{"url":"https://hooks.example.com"}`, "admin-def", false, 403, "Forbidden"},
+		{"forbidden-viewer", "/v1/organization/abc/webhooks", `{"url":"https://hooks.example.com"}`, "viewer-abc", false, 403, "Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("POST", tt.url, strings.NewReader(tt.body), wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.WebhookRegister() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseWebhookResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.WebhookRegister() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.WebhookRegister() got = %v, want %v", resp.Code, tt.result)
+			}
+			if tt.code == 200 && (resp.ID != "w222" || resp.Secret != "secret") {
+				t.Errorf("Web.WebhookRegister() got = %+v, want the ID and secret", resp)
+			}
+		})
+	}
+}
+
+func TestIdentityService_WebhookList(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+		count   int
+	}{
+		{"valid", "/v1/organization/abc/webhooks", "admin-abc", false, 200, "", 1},
+		{"valid-none", "/v1/organization/def/webhooks", "admin-def", false, 200, "", 0},
+		{"invalid", "/v1/organization/abc/webhooks", "admin-abc", true, 400, "WebhookList", 0},
+		{"forbidden-other-org", "/v1/organization/abc/webhooks", "admin-def", false, 403, "Forbidden", 0},
+		{"forbidden-viewer", "/v1/organization/abc/webhooks", "viewer-abc", false, 403, "Forbidden", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("GET", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.WebhookList() got = %v, want %v", w.Code, tt.code)
+			}
+			body := w.Body.String()
+			resp, err := parseWebhooksResponse(strings.NewReader(body))
+			if err != nil {
+				t.Errorf("Web.WebhookList() got = %v", err)
+			}
+			if resp.Code != tt.result || len(resp.Webhooks) != tt.count {
+				t.Errorf("Web.WebhookList() got = %v/%d, want %v/%d", resp.Code, len(resp.Webhooks), tt.result, tt.count)
+			}
+			if strings.Contains(body, "secret") {
+				t.Errorf("Web.WebhookList() got = %s, want the secrets left out", body)
+			}
+		})
+	}
+}
+
+func TestIdentityService_WebhookDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+	}{
+		{"valid", "/v1/organization/abc/webhooks/w111", "admin-abc", false, 200, ""},
+		{"not-found", "/v1/organization/abc/webhooks/invalid", "admin-abc", false, 404, "WebhookDelete"},
+		{"other-org", "/v1/organization/def/webhooks/w111", "admin-def", false, 404, "WebhookDelete"},
+		{"invalid", "/v1/organization/abc/webhooks/w111", "admin-abc", true, 400, "WebhookDelete"},
+		{"forbidden-other-org", "/v1/organization/abc/webhooks/w111", "admin-def", false, 403, "Forbidden"},
+		{"forbidden-viewer", "/v1/organization/abc/webhooks/w111", "viewer-abc", false, 403, "Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("DELETE", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.WebhookDelete() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseRegisterResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.WebhookDelete() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.WebhookDelete() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
+func TestIdentityService_WebhookDeliveries(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+		count   int
+	}{
+		{"valid", "/v1/organization/abc/webhooks/w111/deliveries", "admin-abc", false, 200, "", 2},
+		{"valid-failed", "/v1/organization/abc/webhooks/w111/deliveries?status=failed", "admin-abc", false, 200, "", 1},
+		{"valid-limit", "/v1/organization/abc/webhooks/w111/deliveries?limit=1", "admin-abc", false, 200, "", 1},
+		{"invalid-status", "/v1/organization/abc/webhooks/w111/deliveries?status=lost", "admin-abc", false, 400, "WebhookDeliveries", 0},
+		{"invalid-limit", "/v1/organization/abc/webhooks/w111/deliveries?limit=0", "admin-abc", false, 400, "WebhookDeliveries", 0},
+		{"not-found", "/v1/organization/abc/webhooks/invalid/deliveries", "admin-abc", false, 404, "WebhookDeliveries", 0},
+		{"invalid", "/v1/organization/abc/webhooks/w111/deliveries", "admin-abc", true, 400, "WebhookDeliveries", 0},
+		{"forbidden-other-org", "/v1/organization/abc/webhooks/w111/deliveries", "admin-def", false, 403, "Forbidden", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("GET", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.WebhookDeliveries() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseDeliveriesResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.WebhookDeliveries() got = %v", err)
+			}
+			if resp.Code != tt.result || len(resp.Deliveries) != tt.count {
+				t.Errorf("Web.WebhookDeliveries() got = %v/%d, want %v/%d", resp.Code, len(resp.Deliveries), tt.result, tt.count)
+			}
+		})
+	}
+}
+
+func TestIdentityService_WebhookTest(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		withErr bool
+		code    int
+		result  string
+	}{
+		{"valid", "/v1/organization/abc/webhooks/w111/test", "admin-abc", false, 200, ""},
+		{"not-found", "/v1/organization/abc/webhooks/invalid/test", "admin-abc", false, 404, "WebhookTest"},
+		{"invalid", "/v1/organization/abc/webhooks/w111/test", "admin-abc", true, 400, "WebhookTest"},
+		{"forbidden-other-org", "/v1/organization/abc/webhooks/w111/test", "admin-def", false, 403, "Forbidden"},
+		{"forbidden-viewer", "/v1/organization/abc/webhooks/w111/test", "viewer-abc", false, 403, "Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewIdentityService(settings, &mockIdentity{tt.withErr})
+
+			w := sendRequestAs("POST", tt.url, nil, wb, tt.token)
+			if w.Code != tt.code {
+				t.Errorf("Web.WebhookTest() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseDeliveriesResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.WebhookTest() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.WebhookTest() got = %v, want %v", resp.Code, tt.result)
+			}
+			if tt.code == 200 && (len(resp.Deliveries) != 1 || resp.Deliveries[0].ResponseCode != 204) {
+				t.Errorf("Web.WebhookTest() got = %+v, want the test delivery", resp.Deliveries)
+			}
+		})
+	}
+}